@@ -0,0 +1,628 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Roles of back-office operators. Support can look at orders, payments and
+// the outbox and inbox backlogs and refund payments; admin can also change
+// them. Warehouse staff work the fulfilment queue.
+const (
+	roleSupport   = "support"
+	roleAdmin     = "admin"
+	roleWarehouse = "warehouse"
+)
+
+// operator is a back-office user, known by the bearer token they send.
+type operator struct {
+	Name  string `json:"name"`
+	Role  string `json:"role"`
+	token string
+}
+
+// parseOperators reads ADMIN_TOKENS: "name:role:token" entries separated by
+// commas. An empty list leaves the back office closed.
+func parseOperators(s string) ([]operator, error) {
+	var ops []operator
+	for _, e := range strings.Split(s, ",") {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		parts := strings.SplitN(e, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("admin token %q: want name:role:token", e)
+		}
+		switch parts[1] {
+		case roleSupport, roleAdmin, roleWarehouse:
+		default:
+			return nil, fmt.Errorf("admin token %q: role should be %s, %s or %s", e, roleSupport, roleAdmin, roleWarehouse)
+		}
+		ops = append(ops, operator{Name: parts[0], Role: parts[1], token: parts[2]})
+	}
+	return ops, nil
+}
+
+// operator finds who sent r by its bearer token.
+func (f *Front) operator(r *http.Request) (operator, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return operator{}, false
+	}
+	for _, op := range f.operators {
+		if subtle.ConstantTimeCompare([]byte(op.token), []byte(token)) == 1 {
+			return op, true
+		}
+	}
+	return operator{}, false
+}
+
+// admin lets through the operators having role (admin has every role).
+func (f *Front) admin(role string, h func(http.ResponseWriter, *http.Request, operator)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		op, ok := f.operator(r)
+		if !ok {
+			writeJSON(w, http.StatusUnauthorized, errResp{Error: "unknown or missing admin token"})
+			return
+		}
+		if op.Role != role && op.Role != roleAdmin {
+			writeJSON(w, http.StatusForbidden, errResp{Error: "needs the " + role + " role"})
+			return
+		}
+		h(w, r, op)
+	}
+}
+
+// postAs calls a backend for r on behalf of actor and returns its status
+// and body.
+func (f *Front) postAs(r *http.Request, target, actor string, payload any) (int, []byte, error) {
+	body, _ := json.Marshal(payload)
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if actor != "" {
+		req.Header.Set("X-Admin-Actor", actor)
+	}
+	f.forwardSource(req, r)
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, b, err
+}
+
+type adminOrder struct {
+	ID     string          `json:"id"`
+	UserID string          `json:"user_id"`
+	Status string          `json:"status"`
+	Amount json.RawMessage `json:"amount"`
+	raw    json.RawMessage
+}
+
+// findOrder reads one order from the orders back-office search.
+func (f *Front) findOrder(r *http.Request, id string) (adminOrder, int, error) {
+	code, b, err := f.postAs(r, f.ordersURL+"/admin/orders/search", "", map[string]any{"order_id": id, "limit": 1})
+	if err != nil {
+		return adminOrder{}, http.StatusBadGateway, err
+	}
+	if code != http.StatusOK {
+		return adminOrder{}, code, fmt.Errorf("orders: %s", bytes.TrimSpace(b))
+	}
+	var resp struct {
+		Orders []json.RawMessage `json:"orders"`
+	}
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return adminOrder{}, http.StatusBadGateway, err
+	}
+	if len(resp.Orders) == 0 {
+		return adminOrder{}, http.StatusNotFound, fmt.Errorf("no order")
+	}
+	var o adminOrder
+	if err := json.Unmarshal(resp.Orders[0], &o); err != nil {
+		return adminOrder{}, http.StatusBadGateway, err
+	}
+	o.raw = resp.Orders[0]
+	return o, http.StatusOK, nil
+}
+
+// handleAdminOrder shows an order together with its payment, which is null
+// while payments has not seen the order.
+func (f *Front) handleAdminOrder(w http.ResponseWriter, r *http.Request, _ operator) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, errResp{Error: "method not allowed"})
+		return
+	}
+	o, code, err := f.findOrder(r, r.PathValue("id"))
+	if err != nil {
+		writeJSON(w, code, errResp{Error: err.Error()})
+		return
+	}
+
+	out := struct {
+		Order        json.RawMessage `json:"order"`
+		Payment      json.RawMessage `json:"payment"`
+		PaymentError string          `json:"payment_error,omitempty"`
+	}{Order: o.raw, Payment: json.RawMessage("null")}
+
+	resp, err := f.client.Get(f.paymentsURL + "/admin/payments/" + url.PathEscape(o.ID))
+	if err != nil {
+		out.PaymentError = "payments request failed: " + err.Error()
+		writeJSON(w, http.StatusOK, out)
+		return
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	switch resp.StatusCode {
+	case http.StatusOK:
+		out.Payment = b
+	case http.StatusNotFound:
+	default:
+		out.PaymentError = string(bytes.TrimSpace(b))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// handleAdminCancel cancels a stuck order. A NEW order's payment is voided
+// in payments first, so a payment request still on its way can not charge
+// it; a paid order stops here with the 409 payments gives.
+func (f *Front) handleAdminCancel(w http.ResponseWriter, r *http.Request, op operator) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, errResp{Error: "method not allowed"})
+		return
+	}
+	var req struct {
+		OrderID string `json:"order_id"`
+		Reason  string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, errResp{Error: "bad json: " + err.Error()})
+		return
+	}
+	o, code, err := f.findOrder(r, req.OrderID)
+	if err != nil {
+		writeJSON(w, code, errResp{Error: err.Error()})
+		return
+	}
+
+	out := struct {
+		Order   json.RawMessage `json:"order"`
+		Payment json.RawMessage `json:"payment,omitempty"`
+	}{}
+	if o.Status == "NEW" {
+		var amount struct {
+			Value    string `json:"value"`
+			Currency string `json:"currency"`
+		}
+		_ = json.Unmarshal(o.Amount, &amount)
+		code, b, err := f.postAs(r, f.paymentsURL+"/admin/payments/void", op.Name, map[string]any{
+			"order_id": o.ID,
+			"user_id":  o.UserID,
+			"amount":   amount.Value,
+			"currency": amount.Currency,
+			"reason":   req.Reason,
+		})
+		if err != nil {
+			writeJSON(w, http.StatusBadGateway, errResp{Error: "payments request failed: " + err.Error()})
+			return
+		}
+		if code != http.StatusOK {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(code)
+			_, _ = w.Write(b)
+			return
+		}
+		out.Payment = b
+	}
+
+	code, b, err := f.postAs(r, f.ordersURL+"/admin/orders/cancel", op.Name, req)
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, errResp{Error: "orders request failed: " + err.Error()})
+		return
+	}
+	if code != http.StatusOK {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		_, _ = w.Write(b)
+		return
+	}
+	out.Order = b
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (f *Front) handleAdminPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write([]byte(adminHTML))
+}
+
+// registerAdmin mounts the back office: the /admin page and the /api/admin
+// calls it makes, each checked against the operator's role.
+func (f *Front) registerAdmin(mux *http.ServeMux) {
+	mux.HandleFunc("/admin", f.handleAdminPage)
+	mux.HandleFunc("/api/admin/whoami", f.admin(roleSupport, func(w http.ResponseWriter, r *http.Request, op operator) {
+		writeJSON(w, http.StatusOK, op)
+	}))
+	// metrics are the gateway's expvars, rate limiting counters among them.
+	mux.HandleFunc("/api/admin/metrics", f.admin(roleSupport, func(w http.ResponseWriter, r *http.Request, _ operator) {
+		expvar.Handler().ServeHTTP(w, r)
+	}))
+	mux.HandleFunc("/api/admin/orders/{id}", f.admin(roleSupport, f.handleAdminOrder))
+	mux.HandleFunc("/api/admin/orders/cancel", f.admin(roleAdmin, f.handleAdminCancel))
+	mux.HandleFunc("/api/admin/orders/export", f.admin(roleSupport, func(w http.ResponseWriter, r *http.Request, _ operator) {
+		f.proxyExport(w, r, f.ordersURL+"/admin/orders/export")
+	}))
+
+	for _, p := range []struct {
+		path, target, role string
+	}{
+		{"/api/admin/orders/search", f.ordersURL + "/admin/orders/search", roleSupport},
+		{"/api/admin/orders/outbox", f.ordersURL + "/admin/outbox", roleSupport},
+		{"/api/admin/orders/outbox/republish", f.ordersURL + "/admin/outbox/republish", roleAdmin},
+		{"/api/admin/payments/outbox", f.paymentsURL + "/admin/outbox", roleSupport},
+		{"/api/admin/payments/outbox/republish", f.paymentsURL + "/admin/outbox/republish", roleAdmin},
+		{"/api/admin/payments/inbox", f.paymentsURL + "/admin/inbox", roleSupport},
+		{"/api/admin/payments/refunds", f.paymentsURL + "/refunds", roleSupport},
+		{"/api/admin/payments/refunds/cancel", f.paymentsURL + "/admin/refunds/cancel", roleAdmin},
+		{"/api/admin/accounts/adjust", f.paymentsURL + "/admin/accounts/adjust", roleAdmin},
+		{"/api/admin/bonuses/grant", f.paymentsURL + "/bonuses/grant", roleAdmin},
+		{"/api/admin/accounts/freeze", f.paymentsURL + "/accounts/freeze", roleAdmin},
+		{"/api/admin/accounts/unfreeze", f.paymentsURL + "/accounts/unfreeze", roleAdmin},
+		{"/api/admin/accounts/block", f.paymentsURL + "/accounts/block", roleAdmin},
+		{"/api/admin/accounts/unblock", f.paymentsURL + "/accounts/unblock", roleAdmin},
+		{"/api/admin/payments/reviews", f.paymentsURL + "/admin/reviews", roleSupport},
+		{"/api/admin/payments/reviews/approve", f.paymentsURL + "/admin/reviews/approve", roleAdmin},
+		{"/api/admin/payments/reviews/reject", f.paymentsURL + "/admin/reviews/reject", roleAdmin},
+		{"/api/admin/withdrawals", f.paymentsURL + "/admin/withdrawals/list", roleSupport},
+		{"/api/admin/withdrawals/approve", f.paymentsURL + "/admin/withdrawals/approve", roleAdmin},
+		{"/api/admin/withdrawals/reject", f.paymentsURL + "/admin/withdrawals/reject", roleAdmin},
+		{"/api/admin/promotions/list", f.ordersURL + "/promotions/list", roleSupport},
+		{"/api/admin/promotions/create", f.ordersURL + "/promotions/create", roleAdmin},
+		{"/api/admin/promotions/disable", f.ordersURL + "/promotions/disable", roleAdmin},
+		{"/api/admin/webhooks/list", f.ordersURL + "/webhooks/list", roleSupport},
+		{"/api/admin/webhooks/deliveries", f.ordersURL + "/webhooks/deliveries", roleSupport},
+		{"/api/admin/webhooks/create", f.ordersURL + "/webhooks/create", roleAdmin},
+		{"/api/admin/webhooks/disable", f.ordersURL + "/webhooks/disable", roleAdmin},
+		{"/api/admin/webhooks/replay", f.ordersURL + "/webhooks/replay", roleAdmin},
+		{"/api/admin/orders/audit", f.ordersURL + "/admin/audit", roleAdmin},
+		{"/api/admin/payments/audit", f.paymentsURL + "/admin/audit", roleAdmin},
+		{"/api/admin/fulfilment/queue", f.ordersURL + "/fulfilment/queue", roleWarehouse},
+		{"/api/admin/fulfilment/pick", f.ordersURL + "/fulfilment/pick", roleWarehouse},
+		{"/api/admin/fulfilment/ship", f.ordersURL + "/fulfilment/ship", roleWarehouse},
+		{"/api/admin/fulfilment/deliver", f.ordersURL + "/fulfilment/deliver", roleWarehouse},
+	} {
+		target := p.target
+		mux.HandleFunc(p.path, f.admin(p.role, func(w http.ResponseWriter, r *http.Request, op operator) {
+			f.proxyPostAs(w, r, target, op.Name)
+		}))
+	}
+}
+
+const adminHTML = `<!doctype html>
+<html>
+<head>
+  <meta charset="utf-8" />
+  <title>Gozon back office</title>
+  <style>
+    body { font-family: Arial, sans-serif; margin: 24px; max-width: 900px; }
+    .row { display: flex; gap: 16px; flex-wrap: wrap; }
+    .card { border: 1px solid #ddd; border-radius: 10px; padding: 14px; width: 500px; }
+    input, textarea, select { width: 90%; padding: 8px; margin: 6px 0; }
+    button { padding: 8px 12px; cursor: pointer; }
+    pre { background: #f7f7f7; padding: 10px; border-radius: 8px; overflow: auto; }
+    .small { color: #666; font-size: 12px; }
+  </style>
+</head>
+<body>
+  <h2>Бэк-офис</h2>
+
+  <div class="row">
+    <div class="card">
+      <h3>Вход</h3>
+      <input id="a_token" type="password" placeholder="токен оператора" />
+      <button onclick="login()">Войти</button>
+      <pre id="out_whoami"></pre>
+      <div class="small">Роль support смотрит и делает возвраты; admin может отменять заказы, решать по платежам на проверке и выводам средств, править балансы, начислять бонусы, замораживать и блокировать счета и переотправлять сообщения, вести промокоды и подписки webhooks; warehouse работает с очередью склада.</div>
+    </div>
+
+    <div class="card">
+      <h3>Поиск заказов</h3>
+      <input id="s_id" placeholder="order id" />
+      <input id="s_user" placeholder="user_id" />
+      <select id="s_status">
+        <option value="">любой статус</option>
+        <option>NEW</option><option>FINISHED</option><option>CANCELLED</option>
+        <option>PARTIALLY_REFUNDED</option><option>REFUNDED</option>
+      </select>
+      <button onclick="adminPost('/api/admin/orders/search', {order_id: val('s_id'), user_id: val('s_user'), status: val('s_status')}, 'out_search')">Найти</button>
+      <pre id="out_search"></pre>
+    </div>
+
+    <div class="card">
+      <h3>Экспорт заказов</h3>
+      <input id="e_user" placeholder="user_id (пусто — все пользователи)" />
+      <input id="e_from" placeholder="с (YYYY-MM-DD, необязательно)" />
+      <input id="e_to" placeholder="по (YYYY-MM-DD, необязательно)" />
+      <button onclick="exportOrders('csv')">CSV</button>
+      <button onclick="exportOrders('ndjson')">NDJSON</button>
+      <pre id="out_export"></pre>
+    </div>
+
+    <div class="card">
+      <h3>Заказ и платёж</h3>
+      <input id="d_id" placeholder="order id" />
+      <button onclick="adminGet('/api/admin/orders/' + encodeURIComponent(val('d_id')), 'out_detail')">Показать</button>
+      <pre id="out_detail"></pre>
+    </div>
+
+    <div class="card">
+      <h3>Отменить зависший заказ</h3>
+      <input id="c_id" placeholder="order id (статус NEW)" />
+      <input id="c_reason" placeholder="причина (обязательно)" />
+      <button onclick="adminPost('/api/admin/orders/cancel', {order_id: val('c_id'), reason: val('c_reason')}, 'out_cancel')">Отменить</button>
+      <pre id="out_cancel"></pre>
+    </div>
+
+    <div class="card">
+      <h3>Возврат платежа</h3>
+      <input id="rf_order" placeholder="order id" />
+      <input id="rf_amount" placeholder="сумма (пусто — весь остаток)" />
+      <input id="rf_reason" placeholder="причина" />
+      <button onclick="refund()">Вернуть</button>
+      <button onclick="adminPost('/api/admin/payments/refunds/cancel', {order_id: val('rf_order'), reason: val('rf_reason')}, 'out_refund')">Отменить зависший возврат на карту</button>
+      <div class="small">Отмена (только admin) переводит возврат в PENDING в FAILED. Сначала убедитесь в шлюзе, что он не выплатил его, иначе карта получит деньги дважды.</div>
+      <pre id="out_refund"></pre>
+    </div>
+
+    <div class="card">
+      <h3>Корректировка баланса</h3>
+      <input id="b_user" placeholder="user_id" />
+      <input id="b_amount" placeholder="сумма, отрицательная — списание (например -100.50)" />
+      <input id="b_cur" placeholder="currency (необязательно)" />
+      <input id="b_reason" placeholder="причина (обязательно)" />
+      <button onclick="adjustBalance()">Применить</button>
+      <pre id="out_adjust"></pre>
+    </div>
+
+    <div class="card">
+      <h3>Начислить бонусы</h3>
+      <input id="g_user" placeholder="user_id" />
+      <input id="g_amount" placeholder="сумма (например 50)" />
+      <input id="g_cur" placeholder="currency (необязательно)" />
+      <input id="g_source" placeholder="кампания (например spring-2026)" />
+      <input id="g_days" placeholder="действует дней (по умолчанию 30)" />
+      <button onclick="grantBonus()">Начислить</button>
+      <div class="small">Бонусы тратятся раньше денег, сначала те, что сгорают раньше.</div>
+      <pre id="out_bonus"></pre>
+    </div>
+
+    <div class="card">
+      <h3>Заморозка и блокировка</h3>
+      <input id="fb_user" placeholder="user_id" />
+      <input id="fb_reason" placeholder="причина блокировки (обязательно для блокировки)" />
+      <button onclick="adminPost('/api/admin/accounts/freeze', {user_id: val('fb_user')}, 'out_flags')">Заморозить</button>
+      <button onclick="adminPost('/api/admin/accounts/unfreeze', {user_id: val('fb_user')}, 'out_flags')">Разморозить</button>
+      <button onclick="adminPost('/api/admin/accounts/block', {user_id: val('fb_user'), reason: val('fb_reason')}, 'out_flags')">Заблокировать</button>
+      <button onclick="adminPost('/api/admin/accounts/unblock', {user_id: val('fb_user')}, 'out_flags')">Разблокировать</button>
+      <pre id="out_flags"></pre>
+    </div>
+
+    <div class="card">
+      <h3>Outbox</h3>
+      <select id="ob_service"><option value="orders">orders</option><option value="payments">payments</option></select>
+      <label class="small"><input id="ob_pending" type="checkbox" style="width:auto" /> только неотправленные</label><br/>
+      <button onclick="adminPost('/api/admin/' + val('ob_service') + '/outbox', {pending_only: document.getElementById('ob_pending').checked}, 'out_outbox')">Показать</button>
+      <input id="ob_msg" placeholder="message_id для переотправки" />
+      <input id="ob_reason" placeholder="причина (обязательно)" />
+      <button onclick="adminPost('/api/admin/' + val('ob_service') + '/outbox/republish', {message_id: val('ob_msg'), reason: val('ob_reason')}, 'out_outbox')">Переотправить</button>
+      <pre id="out_outbox"></pre>
+    </div>
+
+    <div class="card">
+      <h3>Антифрод: платежи на проверке</h3>
+      <button onclick="adminPost('/api/admin/payments/reviews', {}, 'out_reviews')">Показать</button>
+      <input id="r_order" placeholder="order id" />
+      <input id="r_reason" placeholder="причина (для отклонения)" />
+      <button onclick="adminPost('/api/admin/payments/reviews/approve', {order_id: val('r_order')}, 'out_reviews')">Одобрить</button>
+      <button onclick="adminPost('/api/admin/payments/reviews/reject', {order_id: val('r_order'), reason: val('r_reason')}, 'out_reviews')">Отклонить</button>
+      <pre id="out_reviews"></pre>
+    </div>
+
+    <div class="card">
+      <h3>Выводы средств</h3>
+      <button onclick="adminPost('/api/admin/withdrawals', {status: 'REQUESTED'}, 'out_withdrawals')">Очередь</button>
+      <input id="w_id" placeholder="withdrawal id" />
+      <input id="w_reason" placeholder="причина (обязательно для отказа)" />
+      <button onclick="adminPost('/api/admin/withdrawals/approve', {id: val('w_id')}, 'out_withdrawals')">Одобрить</button>
+      <button onclick="adminPost('/api/admin/withdrawals/reject', {id: val('w_id'), reason: val('w_reason')}, 'out_withdrawals')">Отклонить</button>
+      <pre id="out_withdrawals"></pre>
+    </div>
+
+    <div class="card">
+      <h3>Промокоды</h3>
+      <button onclick="adminPost('/api/admin/promotions/list', {}, 'out_promos')">Список</button>
+      <input id="pr_code" placeholder="код" />
+      <select id="pr_kind"><option>PERCENT</option><option>FIXED</option></select>
+      <input id="pr_value" placeholder="процент (1–99) или сумма скидки" />
+      <input id="pr_cur" placeholder="currency (необязательно)" />
+      <input id="pr_min" placeholder="минимальная сумма заказа (необязательно)" />
+      <input id="pr_user_limit" placeholder="использований на пользователя (0 — без ограничения)" />
+      <input id="pr_total_limit" placeholder="использований всего (0 — без ограничения)" />
+      <input id="pr_expires" placeholder="действует до (RFC3339, необязательно)" />
+      <label class="small"><input id="pr_first" type="checkbox" style="width:auto" /> только первый заказ</label><br/>
+      <button onclick="createPromotion()">Создать</button>
+      <button onclick="adminPost('/api/admin/promotions/disable', {code: val('pr_code')}, 'out_promos')">Отключить</button>
+      <pre id="out_promos"></pre>
+    </div>
+
+    <div class="card">
+      <h3>Webhooks</h3>
+      <button onclick="adminPost('/api/admin/webhooks/list', {}, 'out_webhooks')">Подписки</button>
+      <input id="wh_url" placeholder="https://... (только публичный адрес)" />
+      <input id="wh_types" placeholder="события через запятую (пусто — все)" />
+      <button onclick="adminPost('/api/admin/webhooks/create', {url: val('wh_url'), event_types: val('wh_types') ? val('wh_types').split(',').map(s => s.trim()) : []}, 'out_webhooks')">Подписать</button>
+      <input id="wh_id" placeholder="endpoint id" />
+      <button onclick="adminPost('/api/admin/webhooks/deliveries', {endpoint_id: val('wh_id')}, 'out_webhooks')">Доставки</button>
+      <button onclick="adminPost('/api/admin/webhooks/disable', {id: val('wh_id')}, 'out_webhooks')">Отключить</button>
+      <input id="wh_delivery" placeholder="delivery id" />
+      <button onclick="adminPost('/api/admin/webhooks/replay', {delivery_id: Number(val('wh_delivery'))}, 'out_webhooks')">Отправить заново</button>
+      <pre id="out_webhooks"></pre>
+    </div>
+
+    <div class="card">
+      <h3>Inbox (payments)</h3>
+      <button onclick="adminPost('/api/admin/payments/inbox', {}, 'out_inbox')">Показать</button>
+      <pre id="out_inbox"></pre>
+    </div>
+
+    <div class="card">
+      <h3>Склад</h3>
+      <select id="f_status">
+        <option value="">PENDING</option><option>PICKING</option><option>SHIPPED</option><option>DELIVERED</option>
+      </select>
+      <button onclick="adminPost('/api/admin/fulfilment/queue', {status: val('f_status')}, 'out_fulfilment')">Очередь</button>
+      <input id="f_order" placeholder="order id" />
+      <input id="f_carrier" placeholder="перевозчик (для отправки)" />
+      <input id="f_tracking" placeholder="трек-номер (для отправки)" />
+      <button onclick="adminPost('/api/admin/fulfilment/pick', {order_id: val('f_order')}, 'out_fulfilment')">Собрать</button>
+      <button onclick="adminPost('/api/admin/fulfilment/ship', {order_id: val('f_order'), carrier: val('f_carrier'), tracking_number: val('f_tracking')}, 'out_fulfilment')">Отправить</button>
+      <button onclick="adminPost('/api/admin/fulfilment/deliver', {order_id: val('f_order')}, 'out_fulfilment')">Доставлен</button>
+      <pre id="out_fulfilment"></pre>
+    </div>
+
+    <div class="card">
+      <h3>Журнал аудита</h3>
+      <select id="au_service"><option value="orders">orders</option><option value="payments">payments</option></select>
+      <input id="au_target" placeholder="объект, например order:&lt;id&gt; или account:&lt;user_id&gt;" />
+      <input id="au_actor" placeholder="actor, например admin:support" />
+      <input id="au_request" placeholder="request id" />
+      <button onclick="adminPost('/api/admin/' + val('au_service') + '/audit', {target: val('au_target'), actor: val('au_actor'), request_id: val('au_request')}, 'out_audit')">Показать</button>
+      <pre id="out_audit"></pre>
+    </div>
+  </div>
+
+<script>
+function val(id){ return document.getElementById(id).value.trim(); }
+
+document.getElementById("a_token").value = sessionStorage.getItem("admin_token") || "";
+
+function authHeaders(extra){
+  return Object.assign({"Authorization": "Bearer " + val("a_token")}, extra || {});
+}
+
+async function show(outId, req){
+  const out = document.getElementById(outId);
+  out.textContent = "loading...";
+  try {
+    const resp = await req;
+    const text = await resp.text();
+    out.textContent = resp.status + "\n" + text;
+    return resp.ok;
+  } catch(e){
+    out.textContent = "ERROR: " + e;
+    return false;
+  }
+}
+
+function adminGet(path, outId){
+  return show(outId, fetch(path, {headers: authHeaders()}));
+}
+
+function adminPost(path, payload, outId, headers){
+  return show(outId, fetch(path, {
+    method: "POST",
+    headers: authHeaders(Object.assign({"Content-Type": "application/json"}, headers || {})),
+    body: JSON.stringify(payload)
+  }));
+}
+
+function login(){
+  sessionStorage.setItem("admin_token", val("a_token"));
+  return adminGet("/api/admin/whoami", "out_whoami");
+}
+
+// exportOrders downloads through fetch, as a plain link cannot carry the
+// operator's token.
+async function exportOrders(format){
+  const out = document.getElementById("out_export");
+  const q = new URLSearchParams({format: format});
+  for (const [k, id] of [["user_id", "e_user"], ["from", "e_from"], ["to", "e_to"]]) {
+    if (val(id)) q.set(k, val(id));
+  }
+  out.textContent = "loading...";
+  try {
+    const resp = await fetch("/api/admin/orders/export?" + q, {headers: authHeaders()});
+    if (!resp.ok) {
+      out.textContent = resp.status + "\n" + await resp.text();
+      return;
+    }
+    const a = document.createElement("a");
+    a.href = URL.createObjectURL(await resp.blob());
+    a.download = "orders." + format;
+    a.click();
+    URL.revokeObjectURL(a.href);
+    out.textContent = "готово";
+  } catch(e){
+    out.textContent = "ERROR: " + e;
+  }
+}
+
+// One key per adjustment, so that a repeated click does not apply it twice.
+let adjustKey = crypto.randomUUID();
+
+async function adjustBalance(){
+  const ok = await adminPost("/api/admin/accounts/adjust", {
+    user_id: val("b_user"), amount: val("b_amount"), currency: val("b_cur"), reason: val("b_reason")
+  }, "out_adjust", {"Idempotency-Key": adjustKey});
+  if (ok) adjustKey = crypto.randomUUID();
+}
+
+// createPromotion leaves out the empty fields: amounts are JSON numbers to
+// the service, and an empty string is not one.
+function createPromotion(){
+  const req = {
+    code: val("pr_code"), kind: val("pr_kind"),
+    per_user_limit: Number(val("pr_user_limit")) || 0, total_limit: Number(val("pr_total_limit")) || 0,
+    first_order_only: document.getElementById("pr_first").checked
+  };
+  if (req.kind === "PERCENT") req.percent = Number(val("pr_value")) || 0;
+  else if (val("pr_value")) req.amount_off = val("pr_value");
+  for (const [k, id] of [["currency", "pr_cur"], ["min_order", "pr_min"], ["expires_at", "pr_expires"]]) {
+    if (val(id)) req[k] = val(id);
+  }
+  return adminPost("/api/admin/promotions/create", req, "out_promos");
+}
+
+let refundKey = crypto.randomUUID();
+
+// refund sends no amount for an empty field, which refunds the rest.
+async function refund(){
+  const req = {order_id: val("rf_order"), reason: val("rf_reason")};
+  if (val("rf_amount")) req.amount = val("rf_amount");
+  const ok = await adminPost("/api/admin/payments/refunds", req, "out_refund", {"Idempotency-Key": refundKey});
+  if (ok) refundKey = crypto.randomUUID();
+}
+
+let grantKey = crypto.randomUUID();
+
+async function grantBonus(){
+  const days = Number(val("g_days")) || 30;
+  const ok = await adminPost("/api/admin/bonuses/grant", {
+    user_id: val("g_user"), amount: val("g_amount"), currency: val("g_cur"),
+    source: val("g_source"), expires_at: new Date(Date.now() + days * 86400000).toISOString()
+  }, "out_bonus", {"Idempotency-Key": grantKey});
+  if (ok) grantKey = crypto.randomUUID();
+}
+</script>
+</body>
+</html>`
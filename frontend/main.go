@@ -2,10 +2,14 @@ package main
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"time"
 )
@@ -33,9 +37,55 @@ type Front struct {
 	ordersURL   string
 	paymentsURL string
 	client      *http.Client
+	// stream has no timeout: it is used for long-lived SSE connections.
+	stream *http.Client
+	// operators may use the back office; see ADMIN_TOKENS.
+	operators []operator
+	// serviceToken is SERVICE_TOKEN, sent to the backends on every call.
+	serviceToken string
+	limiter      *limiter
 }
 
 func (f *Front) proxyPostJSON(w http.ResponseWriter, r *http.Request, target string) {
+	f.proxyPostAs(w, r, target, "")
+}
+
+// withRequestID gives every request an X-Request-ID, the client's own if
+// it sent one, and returns it in the response. The services write it to
+// their audit logs with every change the request makes.
+func withRequestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" || len(id) > 128 {
+			b := make([]byte, 16)
+			_, _ = rand.Read(b)
+			id = hex.EncodeToString(b)
+			r.Header.Set("X-Request-ID", id)
+		}
+		w.Header().Set("X-Request-ID", id)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// forwardSource passes the request id and the client's address on to a
+// backend call. The frontend is the edge, so the address is the peer's and
+// an X-Forwarded-For sent by the client is dropped. The service token makes
+// the backend trust the address and the operator named with it.
+func (f *Front) forwardSource(req, r *http.Request) {
+	if f.serviceToken != "" {
+		req.Header.Set("X-Service-Token", f.serviceToken)
+	}
+	req.Header.Set("X-Request-ID", r.Header.Get("X-Request-ID"))
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	req.Header.Set("X-Forwarded-For", host)
+}
+
+// proxyPostAs is proxyPostJSON on behalf of a back-office operator, who is
+// named to the backend in the X-Admin-Actor header.
+func (f *Front) proxyPostAs(w http.ResponseWriter, r *http.Request, target, actor string) {
 	if r.Method != http.MethodPost {
 		writeJSON(w, http.StatusMethodNotAllowed, errResp{Error: "method not allowed"})
 		return
@@ -43,7 +93,7 @@ func (f *Front) proxyPostJSON(w http.ResponseWriter, r *http.Request, target str
 
 	body, err := mustReadBody(r)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, errResp{Error: "cannot read body: " + err.Error()})
+		bodyError(w, err)
 		return
 	}
 
@@ -53,6 +103,13 @@ func (f *Front) proxyPostJSON(w http.ResponseWriter, r *http.Request, target str
 		return
 	}
 	req.Header.Set("Content-Type", "application/json")
+	if k := r.Header.Get("Idempotency-Key"); k != "" {
+		req.Header.Set("Idempotency-Key", k)
+	}
+	if actor != "" {
+		req.Header.Set("X-Admin-Actor", actor)
+	}
+	f.forwardSource(req, r)
 
 	resp, err := f.client.Do(req)
 	if err != nil {
@@ -66,6 +123,177 @@ func (f *Front) proxyPostJSON(w http.ResponseWriter, r *http.Request, target str
 	_, _ = io.Copy(w, resp.Body)
 }
 
+// proxyEvents relays the orders service's SSE stream for one order,
+// flushing every chunk so the browser sees events as they arrive.
+func (f *Front) proxyEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, errResp{Error: "method not allowed"})
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, errResp{Error: "streaming unsupported"})
+		return
+	}
+
+	target := f.ordersURL + "/orders/" + url.PathEscape(r.PathValue("id")) + "/events"
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, target, nil)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errResp{Error: err.Error()})
+		return
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := f.stream.Do(req)
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, errResp{Error: "backend request failed: " + err.Error()})
+		return
+	}
+	defer resp.Body.Close()
+
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(resp.StatusCode)
+	flusher.Flush()
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return
+			}
+			flusher.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// proxyInvoice relays an order's invoice as is, keeping its content type so
+// the browser can show the PDF.
+func (f *Front) proxyInvoice(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, errResp{Error: "method not allowed"})
+		return
+	}
+	target := f.ordersURL + "/orders/" + url.PathEscape(r.PathValue("id")) + "/invoice"
+	if format := r.URL.Query().Get("format"); format != "" {
+		target += "?format=" + url.QueryEscape(format)
+	}
+	resp, err := f.client.Get(target)
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, errResp{Error: "backend request failed: " + err.Error()})
+		return
+	}
+	defer resp.Body.Close()
+
+	for _, h := range []string{"Content-Type", "Content-Disposition"} {
+		if v := resp.Header.Get(h); v != "" {
+			w.Header().Set(h, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, _ = io.Copy(w, resp.Body)
+}
+
+// proxyCardCharge relays the state of a card charge, polled while the card
+// holder passes a challenge.
+func (f *Front) proxyCardCharge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, errResp{Error: "method not allowed"})
+		return
+	}
+	resp, err := f.client.Get(f.paymentsURL + "/card-charges/" + url.PathEscape(r.PathValue("id")))
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, errResp{Error: "backend request failed: " + err.Error()})
+		return
+	}
+	defer resp.Body.Close()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.StatusCode)
+	_, _ = io.Copy(w, resp.Body)
+}
+
+// proxyStatement relays a wallet statement in whatever format was asked.
+func (f *Front) proxyStatement(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, errResp{Error: "method not allowed"})
+		return
+	}
+	target := f.paymentsURL + "/accounts/" + url.PathEscape(r.PathValue("id")) + "/statement?" + r.URL.RawQuery
+	resp, err := f.client.Get(target)
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, errResp{Error: "backend request failed: " + err.Error()})
+		return
+	}
+	defer resp.Body.Close()
+
+	for _, h := range []string{"Content-Type", "Content-Disposition"} {
+		if v := resp.Header.Get(h); v != "" {
+			w.Header().Set(h, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, _ = io.Copy(w, resp.Body)
+}
+
+// proxyExport relays an order export from target as it streams, so large
+// files reach the browser without being held here.
+func (f *Front) proxyExport(w http.ResponseWriter, r *http.Request, target string) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, errResp{Error: "method not allowed"})
+		return
+	}
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, target+"?"+r.URL.RawQuery, nil)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errResp{Error: err.Error()})
+		return
+	}
+	f.forwardSource(req, r)
+	resp, err := f.stream.Do(req)
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, errResp{Error: "backend request failed: " + err.Error()})
+		return
+	}
+	defer resp.Body.Close()
+
+	for _, h := range []string{"Content-Type", "Content-Disposition"} {
+		if v := resp.Header.Get(h); v != "" {
+			w.Header().Set(h, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, _ = io.Copy(w, resp.Body)
+}
+
+// proxyImport forwards an import file with its content type untouched.
+func (f *Front) proxyImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, errResp{Error: "method not allowed"})
+		return
+	}
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, f.ordersURL+"/orders/import?"+r.URL.RawQuery, r.Body)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errResp{Error: err.Error()})
+		return
+	}
+	req.Header.Set("Content-Type", r.Header.Get("Content-Type"))
+	f.forwardSource(req, r)
+	resp, err := f.stream.Do(req)
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, errResp{Error: "backend request failed: " + err.Error()})
+		return
+	}
+	defer resp.Body.Close()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.StatusCode)
+	_, _ = io.Copy(w, resp.Body)
+}
+
 func (f *Front) handleIndex(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != "/" {
 		http.NotFound(w, r)
@@ -85,26 +313,53 @@ func main() {
 		paymentsURL = "http://payments:8080"
 	}
 
+	operators, err := parseOperators(os.Getenv("ADMIN_TOKENS"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	f := &Front{
 		ordersURL:   ordersURL,
 		paymentsURL: paymentsURL,
 		client: &http.Client{
 			Timeout: 5 * time.Second,
 		},
+		stream:       &http.Client{},
+		operators:    operators,
+		serviceToken: os.Getenv("SERVICE_TOKEN"),
+		limiter:      newLimiter(),
 	}
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", f.handleIndex)
+	f.registerAdmin(mux)
 
 	mux.HandleFunc("/api/payments/create", func(w http.ResponseWriter, r *http.Request) {
 		f.proxyPostJSON(w, r, f.paymentsURL+"/create")
 	})
+	// Customers top up through the card provider; crediting a balance
+	// without one is for the back office.
 	mux.HandleFunc("/api/payments/topup", func(w http.ResponseWriter, r *http.Request) {
-		f.proxyPostJSON(w, r, f.paymentsURL+"/topup")
+		f.proxyPostJSON(w, r, f.paymentsURL+"/topup/card")
 	})
 	mux.HandleFunc("/api/payments/balance", func(w http.ResponseWriter, r *http.Request) {
 		f.proxyPostJSON(w, r, f.paymentsURL+"/balance")
 	})
+	mux.HandleFunc("/api/payments/accounts/{id}/statement", f.proxyStatement)
+	mux.HandleFunc("/api/payments/card-charges/{id}", f.proxyCardCharge)
+	mux.HandleFunc("/api/payments/exchange", func(w http.ResponseWriter, r *http.Request) {
+		f.proxyPostJSON(w, r, f.paymentsURL+"/exchange")
+	})
+	for _, p := range []string{
+		"/accounts/controls", "/accounts/controls/set",
+		"/topup/card", "/pay/card",
+		"/withdrawals", "/withdrawals/list",
+	} {
+		target := f.paymentsURL + p
+		mux.HandleFunc("/api/payments"+p, func(w http.ResponseWriter, r *http.Request) {
+			f.proxyPostJSON(w, r, target)
+		})
+	}
 
 	mux.HandleFunc("/api/orders/create", func(w http.ResponseWriter, r *http.Request) {
 		f.proxyPostJSON(w, r, f.ordersURL+"/create")
@@ -112,6 +367,23 @@ func main() {
 	mux.HandleFunc("/api/orders/list", func(w http.ResponseWriter, r *http.Request) {
 		f.proxyPostJSON(w, r, f.ordersURL+"/list")
 	})
+	mux.HandleFunc("/api/orders/{id}/events", f.proxyEvents)
+	mux.HandleFunc("/api/orders/{id}/invoice", f.proxyInvoice)
+	mux.HandleFunc("/api/orders/export", func(w http.ResponseWriter, r *http.Request) {
+		f.proxyExport(w, r, f.ordersURL+"/orders/export")
+	})
+	mux.HandleFunc("/api/orders/import", f.proxyImport)
+	for _, p := range []string{
+		"/cart", "/cart/items/add", "/cart/items/update", "/cart/items/remove", "/cart/checkout",
+		"/addresses/create", "/addresses/list", "/addresses/delete",
+		"/subscriptions/create", "/subscriptions/list", "/subscriptions/pause", "/subscriptions/resume",
+		"/subscriptions/cancel",
+	} {
+		target := f.ordersURL + p
+		mux.HandleFunc("/api"+p, func(w http.ResponseWriter, r *http.Request) {
+			f.proxyPostJSON(w, r, target)
+		})
+	}
 	mux.HandleFunc("/api/orders/status", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			writeJSON(w, http.StatusMethodNotAllowed, errResp{Error: "method not allowed"})
@@ -119,7 +391,7 @@ func main() {
 		}
 		body, err := mustReadBody(r)
 		if err != nil {
-			writeJSON(w, http.StatusBadRequest, errResp{Error: err.Error()})
+			bodyError(w, err)
 			return
 		}
 
@@ -148,7 +420,7 @@ func main() {
 	})
 
 	log.Println("frontend listening on :8080")
-	log.Fatal(http.ListenAndServe(":8080", mux))
+	log.Fatal(http.ListenAndServe(":8080", withRequestID(f.rateLimit(mux))))
 }
 
 const indexHTML = `<!doctype html>
@@ -173,16 +445,38 @@ const indexHTML = `<!doctype html>
     <div class="card">
       <h3>Payments: create account</h3>
       <input id="p_user_create" placeholder="user_id (например u1)" />
-      <button onclick="callApi('/api/payments/create', {user_id: val('p_user_create')})">Create</button>
+      <input id="p_cur_create" placeholder="currency (RUB / USD / EUR, по умолчанию RUB)" />
+      <button onclick="callApi('/api/payments/create', {user_id: val('p_user_create'), currency: val('p_cur_create')})">Create</button>
       <pre id="out_p_create"></pre>
     </div>
 
     <div class="card">
-      <h3>Payments: topup</h3>
-      <input id="p_user_topup" placeholder="user_id" />
-      <input id="p_amount_topup" placeholder="amount (например 100)" />
-      <button onclick="callApi('/api/payments/topup', {user_id: val('p_user_topup'), amount: num('p_amount_topup')})">TopUp</button>
-      <pre id="out_p_topup"></pre>
+      <h3>Payments: card</h3>
+      <div class="small">Sandbox: 4242 4242 4242 4242 — успех, 4000 0000 0000 0002 — отказ, 4000 0000 0000 3220 — 3-D Secure</div>
+      <input id="p_card_number" placeholder="card number" value="4242424242424242" />
+      <input id="p_card_exp" placeholder="MM/YY" value="12/30" />
+      <input id="p_card_cvc" placeholder="CVC" value="123" />
+      <input id="p_user_card" placeholder="user_id (для пополнения)" />
+      <input id="p_amount_card" placeholder="amount (для пополнения)" />
+      <input id="p_cur_card" placeholder="currency (необязательно)" />
+      <button onclick="cardTopUp()">TopUp by card</button>
+      <input id="p_order_card" placeholder="order_id (заказ с payment_method card)" />
+      <button onclick="cardPay()">Pay order by card</button>
+      <button onclick="cardChargeStatus()">Refresh</button>
+      <div class="small" id="p_card_challenge"></div>
+      <pre id="out_p_card"></pre>
+    </div>
+
+    <div class="card">
+      <h3>Payments: withdrawals</h3>
+      <input id="p_user_withdraw" placeholder="user_id" />
+      <input id="p_amount_withdraw" placeholder="amount (например 20)" />
+      <input id="p_cur_withdraw" placeholder="currency (необязательно)" />
+      <input id="p_dest_withdraw" placeholder="destination (счёт; FAIL... отклонит stub)" />
+      <button onclick="callApi('/api/payments/withdrawals', {user_id: val('p_user_withdraw'), amount: val('p_amount_withdraw'), currency: val('p_cur_withdraw'), destination: val('p_dest_withdraw')})">Request</button>
+      <button onclick="callApi('/api/payments/withdrawals/list', {user_id: val('p_user_withdraw')})">List</button>
+      <div class="small">Сумма снимается с баланса сразу и держится до выплаты; при отказе возвращается.</div>
+      <pre id="out_p_withdraw"></pre>
     </div>
 
     <div class="card">
@@ -192,29 +486,138 @@ const indexHTML = `<!doctype html>
       <pre id="out_p_balance"></pre>
     </div>
 
+    <div class="card">
+      <h3>Payments: statement</h3>
+      <input id="p_user_stmt" placeholder="user_id" />
+      <input id="p_cur_stmt" placeholder="currency (необязательно)" />
+      <input id="p_from_stmt" placeholder="from (YYYY-MM-DD, по умолчанию начало месяца)" />
+      <input id="p_to_stmt" placeholder="to (YYYY-MM-DD включительно, по умолчанию сейчас)" />
+      <button onclick="statement('json')">Show</button>
+      <button onclick="statement('csv')">CSV</button>
+      <button onclick="statement('pdf')">PDF</button>
+      <pre id="out_p_stmt"></pre>
+    </div>
+
+    <div class="card">
+      <h3>Payments: limits</h3>
+      <input id="p_user_ctl" placeholder="user_id" />
+      <input id="p_cur_ctl" placeholder="currency (необязательно)" />
+      <input id="p_daily_ctl" placeholder="daily limit (пусто = без лимита)" />
+      <input id="p_weekly_ctl" placeholder="weekly limit" />
+      <input id="p_monthly_ctl" placeholder="monthly limit" />
+      <input id="p_max_ctl" placeholder="max payment" />
+      <button onclick="callApi('/api/payments/accounts/controls', {user_id: val('p_user_ctl'), currency: val('p_cur_ctl')})">Show</button>
+      <button onclick="callApi('/api/payments/accounts/controls/set', {user_id: val('p_user_ctl'), currency: val('p_cur_ctl'), daily_limit: val('p_daily_ctl'), weekly_limit: val('p_weekly_ctl'), monthly_limit: val('p_monthly_ctl'), max_payment: val('p_max_ctl')})">Set limits</button>
+      <pre id="out_p_ctl"></pre>
+    </div>
+
+    <div class="card">
+      <h3>Payments: exchange</h3>
+      <input id="p_user_exchange" placeholder="user_id" />
+      <input id="p_amount_exchange" placeholder="amount (например 10)" />
+      <input id="p_from_exchange" placeholder="from (например USD)" />
+      <input id="p_to_exchange" placeholder="to (например RUB)" />
+      <button onclick="callApi('/api/payments/exchange', {user_id: val('p_user_exchange'), amount: val('p_amount_exchange'), from: val('p_from_exchange'), to: val('p_to_exchange')})">Exchange</button>
+      <pre id="out_p_exchange"></pre>
+    </div>
+
     <div class="card">
       <h3>Orders: create</h3>
       <input id="o_user_create" placeholder="user_id" />
-      <input id="o_amount_create" placeholder="amount (например 30)" />
+      <input id="o_amount_create" placeholder="amount (например 30.99)" />
+      <input id="o_cur_create" placeholder="currency (необязательно)" />
+      <input id="o_promo_create" placeholder="promo code (необязательно)" />
+      <input id="o_addr_create" placeholder="address_id (необязательно, для доставки)" />
+      <input id="o_cat_create" placeholder="category (необязательно, для налога)" />
+      <input id="o_method_create" placeholder="payment_method (balance / card, по умолчанию balance)" />
+      <input id="o_payers_create" placeholder="payers (необязательно: alice:1, bob:2)" />
       <textarea id="o_desc_create" placeholder="description (<=200 символов)"></textarea>
       <button onclick="createOrder()">Create order</button>
       <pre id="out_o_create"></pre>
-      <div class="small">После create — вставь order_id ниже, чтобы смотреть статус.</div>
+      <div class="small">Статус: <b id="o_live_status">—</b> (обновляется сам)</div>
+    </div>
+
+    <div class="card">
+      <h3>Cart</h3>
+      <input id="c_user" placeholder="user_id" />
+      <input id="c_sku" placeholder="sku (например book-1)" />
+      <input id="c_name" placeholder="name (необязательно)" />
+      <input id="c_price" placeholder="price за штуку (например 10.50)" />
+      <input id="c_cur" placeholder="currency (необязательно)" />
+      <input id="c_qty" placeholder="quantity (по умолчанию 1)" />
+      <input id="c_cat" placeholder="category (необязательно, например books)" />
+      <button onclick="cartAdd()">Add</button>
+      <button onclick="cartSetQty()">Set quantity</button>
+      <button onclick="callApi('/api/cart/items/remove', {user_id: val('c_user'), sku: val('c_sku')})">Remove</button>
+      <button onclick="callApi('/api/cart', {user_id: val('c_user')})">View</button>
+      <input id="c_promo" placeholder="promo code (необязательно)" />
+      <input id="c_addr" placeholder="address_id (необязательно, для доставки)" />
+      <button onclick="cartCheckout()">Checkout</button>
+      <pre id="out_cart"></pre>
+    </div>
+
+    <div class="card">
+      <h3>Addresses</h3>
+      <input id="a_user" placeholder="user_id" />
+      <input id="a_recipient" placeholder="recipient" />
+      <input id="a_line1" placeholder="line1" />
+      <input id="a_city" placeholder="city" />
+      <input id="a_postal" placeholder="postal_code" />
+      <input id="a_country" placeholder="country (например RU)" />
+      <button onclick="addressCreate()">Add</button>
+      <button onclick="callApi('/api/addresses/list', {user_id: val('a_user')})">List</button>
+      <input id="a_id" placeholder="address_id (для удаления)" />
+      <button onclick="callApi('/api/addresses/delete', {user_id: val('a_user'), id: val('a_id')})">Delete</button>
+      <pre id="out_addr"></pre>
+    </div>
+
+    <div class="card">
+      <h3>Subscriptions</h3>
+      <input id="s_user" placeholder="user_id" />
+      <input id="s_amount" placeholder="amount (например 499)" />
+      <input id="s_cur" placeholder="currency (необязательно)" />
+      <input id="s_desc" placeholder="description" />
+      <input id="s_schedule" placeholder="schedule (@monthly, 0 9 1 * *, @every 720h)" />
+      <button onclick="callApi('/api/subscriptions/create', {user_id: val('s_user'), amount: val('s_amount'), currency: val('s_cur'), description: val('s_desc'), schedule: val('s_schedule')})">Create</button>
+      <button onclick="callApi('/api/subscriptions/list', {user_id: val('s_user')})">List</button>
+      <input id="s_id" placeholder="subscription id" />
+      <button onclick="callApi('/api/subscriptions/pause', {user_id: val('s_user'), id: val('s_id')})">Pause</button>
+      <button onclick="callApi('/api/subscriptions/resume', {user_id: val('s_user'), id: val('s_id')})">Resume</button>
+      <button onclick="callApi('/api/subscriptions/cancel', {user_id: val('s_user'), id: val('s_id')})">Cancel</button>
+      <pre id="out_subs"></pre>
     </div>
 
     <div class="card">
       <h3>Orders: status</h3>
       <input id="o_id_status" placeholder="order_id (uuid)" />
       <button onclick="callApi('/api/orders/status', {id: val('o_id_status')})">Get status</button>
+      <button onclick="openInvoice('pdf')">Invoice PDF</button>
+      <button onclick="openInvoice('html')">Invoice HTML</button>
       <pre id="out_o_status"></pre>
     </div>
 
     <div class="card">
       <h3>Orders: list</h3>
       <input id="o_user_list" placeholder="user_id" />
-      <button onclick="callApi('/api/orders/list', {user_id: val('o_user_list')})">List</button>
+      <input id="o_status_list" placeholder="status (NEW / FINISHED / CANCELLED, необязательно)" />
+      <input id="o_limit_list" placeholder="limit (по умолчанию 50)" />
+      <button onclick="listOrders(false)">List</button>
+      <button id="o_next_list" onclick="listOrders(true)" disabled>Next page</button>
+      <button onclick="exportOrders('csv')">Export CSV</button>
+      <button onclick="exportOrders('ndjson')">Export NDJSON</button>
       <pre id="out_o_list"></pre>
     </div>
+
+    <div class="card">
+      <h3>Orders: import</h3>
+      <select id="i_format">
+        <option value="csv">CSV (первая строка: user_id,amount,currency,description,...)</option>
+        <option value="ndjson">NDJSON (по заказу на строку)</option>
+      </select>
+      <textarea id="i_body" rows="6" placeholder="user_id,amount,description&#10;u1,100,first"></textarea>
+      <button onclick="importOrders()">Import</button>
+      <pre id="out_import"></pre>
+    </div>
   </div>
 
 <script>
@@ -224,11 +627,31 @@ function num(id){ const v = val(id); return v === "" ? 0 : Number(v); }
 async function callApi(path, payload){
   const outId = {
     "/api/payments/create":"out_p_create",
-    "/api/payments/topup":"out_p_topup",
+    "/api/payments/topup":"out_p_card",
     "/api/payments/balance":"out_p_balance",
+    "/api/payments/exchange":"out_p_exchange",
     "/api/orders/create":"out_o_create",
     "/api/orders/status":"out_o_status",
-    "/api/orders/list":"out_o_list"
+    "/api/orders/list":"out_o_list",
+    "/api/cart":"out_cart",
+    "/api/cart/items/add":"out_cart",
+    "/api/cart/items/update":"out_cart",
+    "/api/cart/items/remove":"out_cart",
+    "/api/cart/checkout":"out_cart",
+    "/api/addresses/create":"out_addr",
+    "/api/addresses/list":"out_addr",
+    "/api/addresses/delete":"out_addr",
+    "/api/subscriptions/create":"out_subs",
+    "/api/subscriptions/list":"out_subs",
+    "/api/subscriptions/pause":"out_subs",
+    "/api/subscriptions/resume":"out_subs",
+    "/api/subscriptions/cancel":"out_subs",
+    "/api/payments/accounts/controls":"out_p_ctl",
+    "/api/payments/accounts/controls/set":"out_p_ctl",
+    "/api/payments/topup/card":"out_p_card",
+    "/api/payments/pay/card":"out_p_card",
+    "/api/payments/withdrawals":"out_p_withdraw",
+    "/api/payments/withdrawals/list":"out_p_withdraw"
   }[path];
 
   const out = document.getElementById(outId);
@@ -249,19 +672,213 @@ async function callApi(path, payload){
   }
 }
 
+function cartAdd(){
+  return callApi("/api/cart/items/add", {
+    user_id: val("c_user"), sku: val("c_sku"), name: val("c_name"),
+    price: val("c_price"), currency: val("c_cur"), quantity: num("c_qty"), category: val("c_cat")
+  });
+}
+
+function cartSetQty(){
+  return callApi("/api/cart/items/update", {user_id: val("c_user"), sku: val("c_sku"), quantity: num("c_qty")});
+}
+
+async function cartCheckout(){
+  const text = await callApi("/api/cart/checkout", {user_id: val("c_user"), promo_code: val("c_promo"), address_id: val("c_addr")});
+  try {
+    const obj = JSON.parse(text);
+    if (obj && obj.id) {
+      document.getElementById("o_id_status").value = obj.id;
+      watchOrder(obj.id);
+    }
+  } catch(e) {}
+}
+
+function openInvoice(format){
+  const id = val("o_id_status");
+  if (id) window.open("/api/orders/" + encodeURIComponent(id) + "/invoice?format=" + format, "_blank");
+}
+
+async function statement(format){
+  const q = new URLSearchParams({format: format});
+  for (const [k, id] of [["currency","p_cur_stmt"],["from","p_from_stmt"],["to","p_to_stmt"]]) {
+    if (val(id)) q.set(k, val(id));
+  }
+  const url = "/api/payments/accounts/" + encodeURIComponent(val("p_user_stmt")) + "/statement?" + q;
+  if (format !== "json") {
+    window.open(url, "_blank");
+    return;
+  }
+  const out = document.getElementById("out_p_stmt");
+  out.textContent = "loading...";
+  try {
+    const resp = await fetch(url);
+    out.textContent = await resp.text();
+  } catch(e){
+    out.textContent = "ERROR: " + e;
+  }
+}
+
+function exportOrders(format){
+  const q = new URLSearchParams({format: format, user_id: val("o_user_list")});
+  window.open("/api/orders/export?" + q, "_blank");
+}
+
+async function importOrders(){
+  const out = document.getElementById("out_import");
+  const format = document.getElementById("i_format").value;
+  out.textContent = "loading...";
+  try {
+    const resp = await fetch("/api/orders/import?format=" + format, {
+      method: "POST",
+      headers: {"Content-Type": format === "csv" ? "text/csv" : "application/x-ndjson"},
+      body: document.getElementById("i_body").value
+    });
+    out.textContent = await resp.text();
+  } catch(e){
+    out.textContent = "ERROR: " + e;
+  }
+}
+
+function addressCreate(){
+  return callApi("/api/addresses/create", {
+    user_id: val("a_user"), recipient: val("a_recipient"), line1: val("a_line1"),
+    city: val("a_city"), postal_code: val("a_postal"), country: val("a_country")
+  });
+}
+
+let listCursor = "";
+
+async function listOrders(next){
+  const payload = {
+    user_id: val("o_user_list"),
+    status: val("o_status_list"),
+    limit: num("o_limit_list")
+  };
+  if (next && listCursor) payload.cursor = listCursor;
+
+  const text = await callApi("/api/orders/list", payload);
+
+  listCursor = "";
+  try {
+    const obj = JSON.parse(text);
+    if (obj && obj.next_cursor) listCursor = obj.next_cursor;
+  } catch(e) {}
+  document.getElementById("o_next_list").disabled = !listCursor;
+}
+
+// "alice:1, bob:2" -> [{user_id:"alice", share:1}, {user_id:"bob", share:2}]; без доли — 1
+function parsePayers(s){
+  if (!s.trim()) return undefined;
+  return s.split(",").map(p => p.trim()).filter(p => p).map(p => {
+    const [user, share] = p.split(":");
+    return {user_id: user.trim(), share: Number(share) || 1};
+  });
+}
+
 async function createOrder(){
   const text = await callApi("/api/orders/create", {
     user_id: val("o_user_create"),
-    amount: num("o_amount_create"),
+    amount: val("o_amount_create"),
+    currency: val("o_cur_create"),
+    promo_code: val("o_promo_create"),
+    address_id: val("o_addr_create"),
+    category: val("o_cat_create"),
+    payment_method: val("o_method_create"),
+    payers: parsePayers(val("o_payers_create")),
     description: val("o_desc_create")
   });
 
-  // если ответ содержит id — подсунем в status
+  // если ответ содержит id — подсунем в status и подпишемся на изменения
   try {
     const obj = JSON.parse(text);
-    if (obj && obj.id) document.getElementById("o_id_status").value = obj.id;
+    if (obj && obj.id) {
+      document.getElementById("o_id_status").value = obj.id;
+      if (obj.payment_method === "card") document.getElementById("p_order_card").value = obj.id;
+      watchOrder(obj.id);
+    }
+  } catch(e) {}
+}
+
+function cardFromForm(){
+  const [m, y] = val("p_card_exp").split("/");
+  return {number: val("p_card_number").replace(/\s/g, ""), exp_month: Number(m), exp_year: 2000 + Number(y), cvc: val("p_card_cvc")};
+}
+
+let lastCardCharge = "";
+
+// showCardCharge remembers the charge for Refresh and links the challenge
+// page when the card asks for one.
+function showCardCharge(text){
+  const hint = document.getElementById("p_card_challenge");
+  hint.textContent = "";
+  try {
+    const c = JSON.parse(text);
+    if (c && c.id) lastCardCharge = c.id;
+    if (c && c.status === "CHALLENGE") {
+      const a = document.createElement("a");
+      a.href = c.challenge_url;
+      a.target = "_blank";
+      a.textContent = "Пройти 3-D Secure, затем нажмите Refresh";
+      hint.appendChild(a);
+    }
   } catch(e) {}
 }
+
+async function cardTopUp(){
+  showCardCharge(await callApi("/api/payments/topup", {
+    user_id: val("p_user_card"), amount: val("p_amount_card"), currency: val("p_cur_card"), card: cardFromForm()
+  }));
+}
+
+async function cardPay(){
+  showCardCharge(await callApi("/api/payments/pay/card", {order_id: val("p_order_card"), card: cardFromForm()}));
+}
+
+async function cardChargeStatus(){
+  if (!lastCardCharge) return;
+  const out = document.getElementById("out_p_card");
+  const resp = await fetch("/api/payments/card-charges/" + encodeURIComponent(lastCardCharge));
+  const text = await resp.text();
+  out.textContent = text;
+  showCardCharge(text);
+}
+
+let orderEvents = null;
+
+// What the user can do about an order cancelled for a payment reason.
+const cancelHints = {
+  INSUFFICIENT_FUNDS: "не хватает денег — пополните баланс",
+  NO_ACCOUNT: "нет платёжного аккаунта — создайте его",
+  NO_WALLET: "нет кошелька в валюте заказа — откройте его или обменяйте валюту",
+  LIMIT_EXCEEDED: "превышен лимит трат",
+  ACCOUNT_FROZEN: "аккаунт заморожен",
+  ACCOUNT_BLOCKED: "аккаунт заблокирован",
+  FRAUD_DENIED: "платёж отклонён антифрод-проверкой",
+  FRAUD_REJECTED: "платёж отклонён после ручной проверки",
+  CARD_DECLINED: "банк отклонил карту — попробуйте другую",
+  ADMIN_CANCELLED: "заказ отменён службой поддержки"
+};
+
+function watchOrder(id){
+  if (orderEvents) orderEvents.close();
+  const live = document.getElementById("o_live_status");
+  live.textContent = "...";
+
+  orderEvents = new EventSource("/api/orders/" + encodeURIComponent(id) + "/events");
+  orderEvents.addEventListener("status", (e) => {
+    const ev = JSON.parse(e.data);
+    live.textContent = ev.status;
+    if (ev.cancellation_reason) live.textContent += ": " + (cancelHints[ev.cancellation_reason] || ev.cancellation_message || ev.cancellation_reason);
+    const out = {status: ev.status};
+    if (ev.cancellation_reason) out.cancellation_reason = ev.cancellation_reason;
+    if (ev.cancellation_message) out.cancellation_message = ev.cancellation_message;
+    document.getElementById("out_o_status").textContent = JSON.stringify(out);
+  });
+  orderEvents.onerror = () => {
+    if (orderEvents.readyState === EventSource.CLOSED) live.textContent += " (disconnected)";
+  };
+}
 </script>
 </body>
 </html>`
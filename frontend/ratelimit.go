@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"io"
+	"math"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// limit is a token bucket: Rate requests a second on average and up to
+// Burst at once. The zero limit does not limit.
+type limit struct {
+	Rate  float64
+	Burst float64
+}
+
+func perMinute(n, burst int) limit {
+	return limit{Rate: float64(n) / 60, Burst: float64(burst)}
+}
+
+// routeLimit holds the limits of the paths matching pattern: an exact path,
+// or a prefix when it ends in "/". perUser applies to the user_id of a JSON
+// body and, on top of it, to the back-office operator making the call;
+// requests naming neither are limited by IP only.
+type routeLimit struct {
+	pattern string
+	perIP   limit
+	perUser limit
+	maxBody int64
+}
+
+const defaultMaxBody = 1 << 20
+
+// routeLimits are all the limits of the gateway. A path takes the longest
+// pattern matching it; "/" catches the rest.
+var routeLimits = []routeLimit{
+	{pattern: "/", perIP: perMinute(300, 100), perUser: perMinute(120, 60), maxBody: defaultMaxBody},
+	{pattern: "/api/orders/create", perIP: perMinute(60, 20), perUser: perMinute(20, 10), maxBody: defaultMaxBody},
+	{pattern: "/api/cart/checkout", perIP: perMinute(60, 20), perUser: perMinute(20, 10), maxBody: defaultMaxBody},
+	{pattern: "/api/orders/import", perIP: perMinute(6, 2), maxBody: 16 << 20},
+	{pattern: "/api/payments/create", perIP: perMinute(30, 10), perUser: perMinute(10, 5), maxBody: defaultMaxBody},
+	{pattern: "/api/payments/topup", perIP: perMinute(30, 10), perUser: perMinute(10, 5), maxBody: defaultMaxBody},
+	{pattern: "/api/payments/topup/card", perIP: perMinute(30, 10), perUser: perMinute(10, 5), maxBody: defaultMaxBody},
+	{pattern: "/api/payments/pay/card", perIP: perMinute(30, 10), perUser: perMinute(10, 5), maxBody: defaultMaxBody},
+	{pattern: "/api/payments/exchange", perIP: perMinute(30, 10), perUser: perMinute(10, 5), maxBody: defaultMaxBody},
+	{pattern: "/api/payments/withdrawals", perIP: perMinute(30, 10), perUser: perMinute(10, 5), maxBody: defaultMaxBody},
+	{pattern: "/api/admin/", perIP: perMinute(600, 200), perUser: perMinute(300, 100), maxBody: defaultMaxBody},
+}
+
+func matchLimit(path string) routeLimit {
+	var best routeLimit
+	for _, rl := range routeLimits {
+		ok := path == rl.pattern || strings.HasSuffix(rl.pattern, "/") && strings.HasPrefix(path, rl.pattern)
+		if ok && len(rl.pattern) > len(best.pattern) {
+			best = rl
+		}
+	}
+	return best
+}
+
+var (
+	rateLimited  = expvar.NewMap("ratelimit_rejected_total") // "<pattern> ip|user" -> count
+	rateAllowed  = expvar.NewMap("ratelimit_allowed_total")  // "<pattern>" -> count
+	bodyTooLarge = expvar.NewMap("body_too_large_total")     // "<pattern>" -> count
+)
+
+type bucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// limiter keeps a bucket per route and key. Buckets that have refilled are
+// dropped once a minute, so idle clients cost nothing.
+type limiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+func newLimiter() *limiter {
+	l := &limiter{buckets: make(map[string]*bucket)}
+	expvar.Publish("ratelimit_buckets", expvar.Func(func() any {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return len(l.buckets)
+	}))
+	return l
+}
+
+// take spends a token of key's bucket. When it is empty, it returns how
+// long until a token comes.
+func (l *limiter) take(key string, lim limit, now time.Time) (bool, time.Duration) {
+	if lim.Rate <= 0 {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.swept) > time.Minute {
+		for k, b := range l.buckets {
+			// Buckets of every limit refill within a minute or so; an
+			// older one is full and the same as a missing one.
+			if now.Sub(b.at) > 10*time.Minute {
+				delete(l.buckets, k)
+			}
+		}
+		l.swept = now
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: lim.Burst, at: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(lim.Burst, b.tokens+now.Sub(b.at).Seconds()*lim.Rate)
+	b.at = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / lim.Rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// rateLimit enforces routeLimits: it caps the body of a request and lets it
+// through only while its IP and each of its users have tokens left,
+// answering 429 with Retry-After otherwise.
+func (f *Front) rateLimit(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rl := matchLimit(r.URL.Path)
+		if r.ContentLength > rl.maxBody {
+			bodyTooLarge.Add(rl.pattern, 1)
+			writeJSON(w, http.StatusRequestEntityTooLarge, errResp{Error: "request body too large"})
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, rl.maxBody)
+
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		now := time.Now()
+		if ok, wait := f.limiter.take(rl.pattern+" ip:"+ip, rl.perIP, now); !ok {
+			tooManyRequests(w, rl.pattern+" ip", wait)
+			return
+		}
+		for _, user := range f.requestUsers(r) {
+			if ok, wait := f.limiter.take(rl.pattern+" user:"+user, rl.perUser, now); !ok {
+				tooManyRequests(w, rl.pattern+" user", wait)
+				return
+			}
+		}
+		rateAllowed.Add(rl.pattern, 1)
+		h.ServeHTTP(w, r)
+	})
+}
+
+func tooManyRequests(w http.ResponseWriter, metric string, wait time.Duration) {
+	rateLimited.Add(metric, 1)
+	w.Header().Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(wait.Seconds())))))
+	writeJSON(w, http.StatusTooManyRequests, errResp{Error: "too many requests"})
+}
+
+// requestUsers names who r is for: the user_id of a JSON body, which is
+// read and put back for the handler, and the operator of a back-office call.
+// The operator is counted as well as the user rather than instead, so that
+// neither a token nor a made-up user_id gets past the other's limit.
+func (f *Front) requestUsers(r *http.Request) []string {
+	var users []string
+	if op, ok := f.operator(r); ok {
+		users = append(users, "admin:"+op.Name)
+	}
+	if u := bodyUser(r); u != "" {
+		users = append(users, u)
+	}
+	return users
+}
+
+// bodyUser returns the user_id of a JSON POST body, if any.
+func bodyUser(r *http.Request) string {
+	if r.Method != http.MethodPost {
+		return ""
+	}
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct != "" && ct != "application/json" {
+		return ""
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		// The handler reads the same error; keep what was read for it.
+		r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), errReader{err}))
+		return ""
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	var req struct {
+		UserID string `json:"user_id"`
+	}
+	body = bytes.TrimPrefix(body, []byte("\xef\xbb\xbf"))
+	if json.Unmarshal(body, &req) != nil {
+		return ""
+	}
+	return req.UserID
+}
+
+type errReader struct{ err error }
+
+func (e errReader) Read([]byte) (int, error) { return 0, e.err }
+
+// bodyError is the response to a body that could not be read.
+func bodyError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeJSON(w, http.StatusRequestEntityTooLarge, errResp{Error: "request body too large"})
+		return
+	}
+	writeJSON(w, http.StatusBadRequest, errResp{Error: "cannot read body: " + err.Error()})
+}
@@ -0,0 +1,4 @@
+// Package ordersv1 holds the generated gRPC stubs for orders.proto.
+package ordersv1
+
+//go:generate protoc -I .. --go_out=.. --go_opt=paths=source_relative --go-grpc_out=.. --go-grpc_opt=paths=source_relative ordersv1/orders.proto
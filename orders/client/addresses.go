@@ -0,0 +1,62 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Address struct {
+	ID         uuid.UUID `json:"id"`
+	UserID     string    `json:"user_id"`
+	Recipient  string    `json:"recipient"`
+	Line1      string    `json:"line1"`
+	Line2      string    `json:"line2,omitempty"`
+	City       string    `json:"city"`
+	Region     string    `json:"region,omitempty"`
+	PostalCode string    `json:"postal_code"`
+	Country    string    `json:"country"`
+	Phone      string    `json:"phone,omitempty"`
+	IsDefault  bool      `json:"is_default"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type Fulfilment struct {
+	OrderID        uuid.UUID  `json:"order_id"`
+	Status         string     `json:"status"`
+	Carrier        string     `json:"carrier,omitempty"`
+	TrackingNumber string     `json:"tracking_number,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	PickedAt       *time.Time `json:"picked_at,omitempty"`
+	ShippedAt      *time.Time `json:"shipped_at,omitempty"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+}
+
+// CreateAddress adds a to the address book of a.UserID; ID and CreatedAt
+// are ignored.
+func (c *Client) CreateAddress(ctx context.Context, a Address) (Address, error) {
+	var out Address
+	err := c.do(ctx, http.MethodPost, "/addresses/create", "", a, &out)
+	return out, err
+}
+
+func (c *Client) ListAddresses(ctx context.Context, userID string) ([]Address, error) {
+	var resp struct {
+		Addresses []Address `json:"addresses"`
+	}
+	err := c.do(ctx, http.MethodPost, "/addresses/list", "", map[string]string{"user_id": userID}, &resp)
+	return resp.Addresses, err
+}
+
+func (c *Client) DeleteAddress(ctx context.Context, userID string, id uuid.UUID) error {
+	return c.do(ctx, http.MethodPost, "/addresses/delete", "",
+		map[string]string{"user_id": userID, "id": id.String()}, nil)
+}
+
+func (c *Client) GetFulfilment(ctx context.Context, orderID uuid.UUID) (Fulfilment, error) {
+	var f Fulfilment
+	err := c.do(ctx, http.MethodPost, "/fulfilment/get", "", map[string]string{"order_id": orderID.String()}, &f)
+	return f, err
+}
@@ -0,0 +1,44 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+type ImportRowResult struct {
+	Line    int        `json:"line"`
+	Status  string     `json:"status"` // CREATED, EXISTING or FAILED
+	OrderID *uuid.UUID `json:"order_id,omitempty"`
+	Error   string     `json:"error,omitempty"`
+}
+
+type ImportOrdersResponse struct {
+	Created  int               `json:"created"`
+	Existing int               `json:"existing"`
+	Failed   int               `json:"failed"`
+	Rows     []ImportRowResult `json:"rows"`
+}
+
+// ImportOrders creates orders in bulk; Rows[i] of the response reports
+// orders[i]. Each IdempotencyKey travels with its row and no key is made up,
+// so unlike single calls an import is not retried.
+func (c *Client) ImportOrders(ctx context.Context, orders []CreateOrderRequest) (ImportOrdersResponse, error) {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, o := range orders {
+		row := struct {
+			CreateOrderRequest
+			IdempotencyKey string `json:"idempotency_key,omitempty"`
+		}{o, o.IdempotencyKey}
+		if err := enc.Encode(row); err != nil {
+			return ImportOrdersResponse{}, err
+		}
+	}
+	var resp ImportOrdersResponse
+	err := c.once(ctx, http.MethodPost, "/orders/import?format=ndjson", "", body.Bytes(), &resp)
+	return resp, err
+}
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type CartItem struct {
+	SKU       string `json:"sku"`
+	Name      string `json:"name"`
+	UnitPrice Money  `json:"unit_price"`
+	Quantity  int    `json:"quantity"`
+	LineTotal Money  `json:"line_total"`
+	Category  string `json:"category,omitempty"`
+}
+
+type Cart struct {
+	UserID    string     `json:"user_id"`
+	Items     []CartItem `json:"items"`
+	ItemCount int        `json:"item_count"`
+	Total     Money      `json:"total"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+}
+
+type AddCartItemRequest struct {
+	UserID string `json:"user_id"`
+	SKU    string `json:"sku"`
+	Name   string `json:"name,omitempty"`
+	// Price is per unit, a decimal in major units of Currency.
+	Price    string `json:"price"`
+	Currency string `json:"currency,omitempty"`
+	Quantity int    `json:"quantity,omitempty"`
+	Category string `json:"category,omitempty"` // product category for tax
+}
+
+type CheckoutRequest struct {
+	UserID    string `json:"user_id"`
+	PromoCode string `json:"promo_code,omitempty"`
+	AddressID string `json:"address_id,omitempty"`
+	// PaymentMethod is "balance" (default) or "card".
+	PaymentMethod string `json:"payment_method,omitempty"`
+
+	// IdempotencyKey is sent as the Idempotency-Key header. A random key is
+	// used when empty; set it to deduplicate across separate calls.
+	IdempotencyKey string `json:"-"`
+}
+
+func (c *Client) GetCart(ctx context.Context, userID string) (Cart, error) {
+	var cart Cart
+	err := c.do(ctx, http.MethodPost, "/cart", "", map[string]string{"user_id": userID}, &cart)
+	return cart, err
+}
+
+func (c *Client) AddCartItem(ctx context.Context, req AddCartItemRequest) (Cart, error) {
+	var cart Cart
+	err := c.do(ctx, http.MethodPost, "/cart/items/add", "", req, &cart)
+	return cart, err
+}
+
+// SetCartItemQuantity sets the quantity of an item; 0 removes it.
+func (c *Client) SetCartItemQuantity(ctx context.Context, userID, sku string, quantity int) (Cart, error) {
+	req := struct {
+		UserID   string `json:"user_id"`
+		SKU      string `json:"sku"`
+		Quantity int    `json:"quantity"`
+	}{userID, sku, quantity}
+	var cart Cart
+	err := c.do(ctx, http.MethodPost, "/cart/items/update", "", req, &cart)
+	return cart, err
+}
+
+func (c *Client) Checkout(ctx context.Context, req CheckoutRequest) (Order, error) {
+	key := req.IdempotencyKey
+	if key == "" {
+		key = uuid.NewString()
+	}
+	var o Order
+	err := c.do(ctx, http.MethodPost, "/cart/checkout", key, req, &o)
+	return o, err
+}
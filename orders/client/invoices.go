@@ -0,0 +1,31 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Invoice struct {
+	Number   string    `json:"number"`
+	OrderID  uuid.UUID `json:"order_id"`
+	IssuedAt time.Time `json:"issued_at"`
+	Seller   string    `json:"seller"`
+	Payer    struct {
+		UserID  string   `json:"user_id"`
+		Address *Address `json:"address,omitempty"`
+	} `json:"payer"`
+	Description string       `json:"description"`
+	PromoCode   string       `json:"promo_code,omitempty"`
+	Tax         TaxBreakdown `json:"tax"`
+}
+
+// GetInvoice returns the invoice data of a paid order. The PDF is served at
+// /orders/{id}/invoice of the same base URL.
+func (c *Client) GetInvoice(ctx context.Context, orderID uuid.UUID) (Invoice, error) {
+	var inv Invoice
+	err := c.do(ctx, http.MethodGet, "/orders/"+orderID.String()+"/invoice?format=json", "", nil, &inv)
+	return inv, err
+}
@@ -0,0 +1,146 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Money is a decimal amount in major units, e.g. {"value":"10.99","currency":"USD"}.
+type Money struct {
+	Value    string `json:"value"`
+	Currency string `json:"currency"`
+}
+
+type Order struct {
+	ID          uuid.UUID `json:"id"`
+	UserID      string    `json:"user_id"`
+	Amount      Money     `json:"amount"`
+	Description string    `json:"description"`
+	Status      string    `json:"status"`
+	CreatedAt   time.Time `json:"created_at"`
+	// RefundedAmount is the sum of refunds reported by payments.
+	RefundedAmount Money `json:"refunded_amount"`
+	// OriginalAmount is the price before Discount; Amount is what is charged,
+	// ShippingCost included.
+	OriginalAmount  Money    `json:"original_amount"`
+	Discount        Money    `json:"discount"`
+	PromoCode       string   `json:"promo_code,omitempty"`
+	ShippingCost    Money    `json:"shipping_cost"`
+	ShippingAddress *Address `json:"shipping_address,omitempty"`
+	// Tax is nil for orders created before tax was computed.
+	Tax *TaxBreakdown `json:"tax,omitempty"`
+	// CancellationReason is why a CANCELLED order was not paid, e.g.
+	// INSUFFICIENT_FUNDS; CancellationMessage says it in words.
+	CancellationReason  string `json:"cancellation_reason,omitempty"`
+	CancellationMessage string `json:"cancellation_message,omitempty"`
+	// PaymentMethod is "balance" or "card".
+	PaymentMethod string `json:"payment_method"`
+	// Payers is set on a split order.
+	Payers []Payer `json:"payers,omitempty"`
+}
+
+// Payer is one user paying part of a split order: Amount is the order
+// amount in proportion to Share.
+type Payer struct {
+	UserID string `json:"user_id"`
+	Share  int    `json:"share"`
+	Amount Money  `json:"amount"`
+}
+
+// TaxLine is one taxed position: a product line or shipping.
+type TaxLine struct {
+	SKU      string `json:"sku,omitempty"`
+	Name     string `json:"name"`
+	Category string `json:"category,omitempty"`
+	Quantity int    `json:"quantity"`
+	Rate     string `json:"rate"` // percent
+	Discount Money  `json:"discount"`
+	Net      Money  `json:"net"`
+	Tax      Money  `json:"tax"`
+	Gross    Money  `json:"gross"`
+}
+
+type TaxBreakdown struct {
+	Region    string    `json:"region"`
+	Inclusive bool      `json:"inclusive"`
+	Lines     []TaxLine `json:"lines"`
+	Net       Money     `json:"net"`
+	Tax       Money     `json:"tax"`
+	Gross     Money     `json:"gross"`
+}
+
+type CreateOrderRequest struct {
+	UserID string `json:"user_id"`
+	// Amount is a decimal in major units of Currency, e.g. "10.99".
+	Amount      string `json:"amount"`
+	Currency    string `json:"currency,omitempty"`
+	Description string `json:"description"`
+	PromoCode   string `json:"promo_code,omitempty"`
+	AddressID   string `json:"address_id,omitempty"`
+	Category    string `json:"category,omitempty"` // product category for tax
+	// PaymentMethod is "balance" (default) or "card"; a card order is paid
+	// with the payments client's PayByCard.
+	PaymentMethod string `json:"payment_method,omitempty"`
+	// Payers split the order by Share (Amount is ignored); each pays from
+	// their balance and nobody is charged unless everyone can pay.
+	Payers []Payer `json:"payers,omitempty"`
+
+	// IdempotencyKey is sent as the Idempotency-Key header. A random key is
+	// used when empty; set it to deduplicate across separate calls.
+	IdempotencyKey string `json:"-"`
+}
+
+type ListOrdersRequest struct {
+	UserID      string `json:"user_id"`
+	Status      string `json:"status,omitempty"`
+	Currency    string `json:"currency,omitempty"`
+	MinAmount   string `json:"min_amount,omitempty"`
+	MaxAmount   string `json:"max_amount,omitempty"`
+	CreatedFrom string `json:"created_from,omitempty"`
+	CreatedTo   string `json:"created_to,omitempty"`
+	Sort        string `json:"sort,omitempty"`
+	Cursor      string `json:"cursor,omitempty"`
+	Limit       int    `json:"limit,omitempty"`
+}
+
+type ListOrdersResponse struct {
+	Orders     []Order `json:"orders"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+}
+
+func (c *Client) CreateOrder(ctx context.Context, req CreateOrderRequest) (Order, error) {
+	key := req.IdempotencyKey
+	if key == "" {
+		key = uuid.NewString()
+	}
+	var o Order
+	err := c.do(ctx, http.MethodPost, "/create", key, req, &o)
+	return o, err
+}
+
+// StatusResponse is the status of an order and, when it was cancelled, why.
+type StatusResponse struct {
+	Status              string `json:"status"`
+	CancellationReason  string `json:"cancellation_reason,omitempty"`
+	CancellationMessage string `json:"cancellation_message,omitempty"`
+}
+
+func (c *Client) GetStatus(ctx context.Context, orderID uuid.UUID) (string, error) {
+	resp, err := c.Status(ctx, orderID)
+	return resp.Status, err
+}
+
+func (c *Client) Status(ctx context.Context, orderID uuid.UUID) (StatusResponse, error) {
+	var resp StatusResponse
+	err := c.do(ctx, http.MethodPost, "/status", "", map[string]string{"id": orderID.String()}, &resp)
+	return resp, err
+}
+
+func (c *Client) ListOrders(ctx context.Context, req ListOrdersRequest) (ListOrdersResponse, error) {
+	var resp ListOrdersResponse
+	err := c.do(ctx, http.MethodPost, "/list", "", req, &resp)
+	return resp, err
+}
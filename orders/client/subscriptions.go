@@ -0,0 +1,82 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Subscription struct {
+	ID           uuid.UUID  `json:"id"`
+	UserID       string     `json:"user_id"`
+	Items        []CartItem `json:"items,omitempty"`
+	Amount       Money      `json:"amount"`
+	Description  string     `json:"description"`
+	Category     string     `json:"category,omitempty"`
+	AddressID    *uuid.UUID `json:"address_id,omitempty"`
+	Schedule     string     `json:"schedule"`
+	Status       string     `json:"status"`
+	Failures     int        `json:"failures"`
+	MaxFailures  int        `json:"max_failures"`
+	NextRunAt    time.Time  `json:"next_run_at"`
+	LastOrderID  *uuid.UUID `json:"last_order_id,omitempty"`
+	PausedReason string     `json:"paused_reason,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+type SubscriptionItem struct {
+	SKU      string `json:"sku"`
+	Name     string `json:"name,omitempty"`
+	Price    string `json:"price"` // per unit, a decimal in major units
+	Quantity int    `json:"quantity,omitempty"`
+	Category string `json:"category,omitempty"`
+}
+
+// CreateSubscriptionRequest takes either Items or Amount. Schedule is a
+// five field cron expression in UTC, "@every 720h" or "@monthly" and alike.
+type CreateSubscriptionRequest struct {
+	UserID      string             `json:"user_id"`
+	Items       []SubscriptionItem `json:"items,omitempty"`
+	Amount      string             `json:"amount,omitempty"`
+	Currency    string             `json:"currency,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Category    string             `json:"category,omitempty"`
+	AddressID   string             `json:"address_id,omitempty"`
+	Schedule    string             `json:"schedule"`
+	MaxFailures int                `json:"max_failures,omitempty"`
+	StartAt     *time.Time         `json:"start_at,omitempty"`
+}
+
+func (c *Client) CreateSubscription(ctx context.Context, req CreateSubscriptionRequest) (Subscription, error) {
+	var sub Subscription
+	err := c.do(ctx, http.MethodPost, "/subscriptions/create", "", req, &sub)
+	return sub, err
+}
+
+func (c *Client) ListSubscriptions(ctx context.Context, userID string) ([]Subscription, error) {
+	var resp struct {
+		Subscriptions []Subscription `json:"subscriptions"`
+	}
+	err := c.do(ctx, http.MethodPost, "/subscriptions/list", "", map[string]string{"user_id": userID}, &resp)
+	return resp.Subscriptions, err
+}
+
+func (c *Client) PauseSubscription(ctx context.Context, userID string, id uuid.UUID) (Subscription, error) {
+	return c.subscriptionStatus(ctx, "/subscriptions/pause", userID, id)
+}
+
+func (c *Client) ResumeSubscription(ctx context.Context, userID string, id uuid.UUID) (Subscription, error) {
+	return c.subscriptionStatus(ctx, "/subscriptions/resume", userID, id)
+}
+
+func (c *Client) CancelSubscription(ctx context.Context, userID string, id uuid.UUID) (Subscription, error) {
+	return c.subscriptionStatus(ctx, "/subscriptions/cancel", userID, id)
+}
+
+func (c *Client) subscriptionStatus(ctx context.Context, path, userID string, id uuid.UUID) (Subscription, error) {
+	var sub Subscription
+	err := c.do(ctx, http.MethodPost, path, "", map[string]string{"user_id": userID, "id": id.String()}, &sub)
+	return sub, err
+}
@@ -2,37 +2,114 @@ package app
 
 import (
 	"context"
+	"expvar"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"time"
 
+	"orders/internal/audit"
 	"orders/internal/db"
+	"orders/internal/events"
+	"orders/internal/fulfilment"
+	"orders/internal/grpcapi"
 	"orders/internal/httpapi"
+	"orders/internal/invoice"
 	"orders/internal/kafka"
 	"orders/internal/store"
+	"orders/internal/subscriptions"
+	"orders/internal/tax"
+	"orders/internal/webhooks"
 )
 
 func Run() {
-	db, err := db.OpenDB()
+	sqlDB, err := db.OpenDB()
 	if err != nil {
 		log.Fatal(err)
 	}
-	st := store.NewOrdersStore(db)
+	st := store.NewOrdersStore(sqlDB)
+	if v := os.Getenv("CART_TTL"); v != "" {
+		ttl, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("CART_TTL: %v", err)
+		}
+		st.SetCartTTL(ttl)
+	}
+	rates, err := store.LoadShippingRates()
+	if err != nil {
+		log.Fatalf("SHIPPING_RATES: %v", err)
+	}
+	st.SetShippingRates(rates)
+	taxRates, err := tax.Load()
+	if err != nil {
+		log.Fatalf("TAX_RATES: %v", err)
+	}
+	st.SetTaxRates(taxRates)
 
 	ctx := context.Background()
+	go st.RunCartSweeper(ctx, 10*time.Minute)
+
+	subs := subscriptions.NewStore(sqlDB, st)
+	go subs.RunScheduler(audit.WithSource(ctx, audit.Source{Actor: "job:subscriptions"}), time.Minute)
 
 	prod, err := kafka.NewSyncProducer()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	pub := kafka.NewOutboxPublisher(db, prod)
+	pub := kafka.NewOutboxPublisher(sqlDB, prod)
 	go pub.Run(ctx)
 
-	resCons := kafka.NewPaymentResultConsumer(db)
+	gen, err := invoice.LoadGenerator()
+	if err != nil {
+		log.Fatalf("INVOICE_FONT: %v", err)
+	}
+	resCons := kafka.NewPaymentResultConsumer(sqlDB, gen)
 	go resCons.Run(ctx)
 
+	listener, err := db.Listen(events.Channel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	broker := events.NewBroker()
+	go broker.Run(ctx, listener)
+
+	wh := webhooks.NewStore(sqlDB)
+	dispatcher := webhooks.NewDispatcher(sqlDB)
+	go dispatcher.Run(ctx)
+
+	ff := fulfilment.NewStore(sqlDB)
+	inv := invoice.NewStore(sqlDB)
+
+	grpcAddr := os.Getenv("GRPC_ADDR")
+	if grpcAddr == "" {
+		grpcAddr = ":9090"
+	}
+	lis, err := net.Listen("tcp", grpcAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	authToken := os.Getenv("GRPC_AUTH_TOKEN")
+	if authToken == "" {
+		if os.Getenv("GRPC_INSECURE") != "true" {
+			log.Fatal("GRPC_AUTH_TOKEN is empty; set it, or GRPC_INSECURE=true to serve gRPC without auth")
+		}
+		log.Println("GRPC_INSECURE is set, gRPC auth is disabled")
+	}
+	grpcSrv := grpcapi.NewServer(st, authToken)
+	go func() {
+		log.Printf("orders gRPC listening on %s", grpcAddr)
+		log.Fatal(grpcSrv.Serve(lis))
+	}()
+
 	mux := http.NewServeMux()
-	httpapi.RegisterRoutes(mux, st)
+	serviceToken := os.Getenv("SERVICE_TOKEN")
+	if serviceToken == "" {
+		log.Println("SERVICE_TOKEN is empty, back-office routes are closed")
+	}
+	httpapi.RegisterRoutes(mux, serviceToken, st, broker, wh, ff, inv, subs)
+	mux.Handle("/debug/vars", expvar.Handler())
 
 	log.Println("orders listening on :8080")
 	log.Fatal(http.ListenAndServe(":8080", mux))
@@ -0,0 +1,323 @@
+// Package audit keeps the append-only log of every change made to orders
+// data. Entries are written in the transaction of the change they describe
+// and chained by hash per target: each entry's hash covers its fields and
+// the hash of the previous entry of the same target, so editing, removing
+// or reordering entries breaks that target's chain from that point on (see
+// Verify). Changes to different targets do not wait for each other.
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"orders/internal/domain"
+)
+
+// Table is the audit log of this service. Postgres triggers refuse updates
+// and deletes on it.
+const Table = "orders_audit_log"
+
+// Actions recorded in the log.
+const (
+	OrderCreate        = "ORDER_CREATE"
+	OrderPaid          = "ORDER_PAID"
+	OrderCancel        = "ORDER_CANCEL"
+	OrderRefund        = "ORDER_REFUND"
+	CartItemAdd        = "CART_ITEM_ADD"
+	CartItemUpdate     = "CART_ITEM_UPDATE"
+	CartCheckout       = "CART_CHECKOUT"
+	AddressCreate      = "ADDRESS_CREATE"
+	AddressDelete      = "ADDRESS_DELETE"
+	PromotionCreate    = "PROMOTION_CREATE"
+	PromotionDisable   = "PROMOTION_DISABLE"
+	WebhookCreate      = "WEBHOOK_CREATE"
+	WebhookDisable     = "WEBHOOK_DISABLE"
+	DeliveryReplay     = "WEBHOOK_DELIVERY_REPLAY"
+	FulfilmentAdvance  = "FULFILMENT_ADVANCE"
+	SubscriptionCreate = "SUBSCRIPTION_CREATE"
+	SubscriptionStatus = "SUBSCRIPTION_STATUS"
+	OutboxRepublish    = "OUTBOX_REPUBLISH"
+)
+
+// Target names an object of the log, e.g. Target("order", id).
+func Target(kind string, id any) string {
+	return fmt.Sprintf("%s:%v", kind, id)
+}
+
+// genesis is the previous hash of the first entry.
+var genesis = strings.Repeat("0", 64)
+
+// Source is who a change is made for: an actor ("api", "admin:<name>",
+// "kafka:<topic>", ...), the request id and the caller's IP.
+type Source struct {
+	Actor     string
+	RequestID string
+	IP        string
+}
+
+type (
+	sourceKey struct{}
+	reasonKey struct{}
+)
+
+// WithSource makes ctx carry src for the entries recorded under it.
+func WithSource(ctx context.Context, src Source) context.Context {
+	return context.WithValue(ctx, sourceKey{}, src)
+}
+
+// WithReason makes ctx carry the reason an operator gave for a change, for
+// the entries recorded under it.
+func WithReason(ctx context.Context, reason string) context.Context {
+	return context.WithValue(ctx, reasonKey{}, reason)
+}
+
+// SourceFrom returns the source of ctx; changes made outside a request,
+// such as those of background jobs, are by "system".
+func SourceFrom(ctx context.Context) Source {
+	src, _ := ctx.Value(sourceKey{}).(Source)
+	if src.Actor == "" {
+		src.Actor = "system"
+	}
+	return src
+}
+
+// Record appends an entry for a change of target made in tx: what it was
+// before (nil for something new) and after (nil for something removed).
+// Appends to one target are serialized by a transaction lock held until tx
+// ends, so callers record their changes last, after taking any other locks.
+func Record(ctx context.Context, tx *sql.Tx, action, target string, before, after any) error {
+	src := SourceFrom(ctx)
+	reason, _ := ctx.Value(reasonKey{}).(string)
+	e := domain.AuditEntry{
+		CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+		Actor:     src.Actor,
+		Action:    action,
+		Target:    target,
+		Reason:    reason,
+		RequestID: src.RequestID,
+		SourceIP:  src.IP,
+	}
+	var err error
+	if e.Before, err = value(before); err != nil {
+		return err
+	}
+	if e.After, err = value(after); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `select pg_advisory_xact_lock(hashtext($1))`, Table+" "+target); err != nil {
+		return err
+	}
+	err = tx.QueryRowContext(ctx,
+		`select hash from `+Table+` where target = $1 order by seq desc limit 1`, target,
+	).Scan(&e.PrevHash)
+	if err == sql.ErrNoRows {
+		e.PrevHash = genesis
+	} else if err != nil {
+		return err
+	}
+	// Taken under the lock, so a target's entries are numbered in the
+	// order they chain.
+	if err := tx.QueryRowContext(ctx, `select nextval($1)`, Table+"_seq").Scan(&e.Seq); err != nil {
+		return err
+	}
+	e.Hash = hash(e)
+
+	_, err = tx.ExecContext(ctx,
+		`insert into `+Table+`(seq, created_at, actor, action, target, reason, before, after, request_id, source_ip, prev_hash, hash)
+		 values ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)`,
+		e.Seq, e.CreatedAt, e.Actor, e.Action, e.Target, e.Reason, nullJSON(e.Before), nullJSON(e.After),
+		e.RequestID, e.SourceIP, e.PrevHash, e.Hash,
+	)
+	return err
+}
+
+func value(v any) (json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil || string(b) == "null" {
+		return nil, err
+	}
+	return b, nil
+}
+
+func nullJSON(b json.RawMessage) any {
+	if b == nil {
+		return nil
+	}
+	return string(b)
+}
+
+// hash is the hex SHA-256 of the entry's fields and the previous hash.
+// Values are compacted by the JSON encoder, so it does not depend on how
+// the database spaces them.
+func hash(e domain.AuditEntry) string {
+	b, _ := json.Marshal([]any{
+		e.Seq, e.CreatedAt.UTC().Format(time.RFC3339Nano), e.Actor, e.Action, e.Target, e.Reason,
+		e.Before, e.After, e.RequestID, e.SourceIP, e.PrevHash,
+	})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+const columns = `seq, created_at, actor, action, target, reason, before, after, request_id, source_ip, prev_hash, hash`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanEntry(r rowScanner) (domain.AuditEntry, error) {
+	var e domain.AuditEntry
+	var before, after []byte
+	err := r.Scan(&e.Seq, &e.CreatedAt, &e.Actor, &e.Action, &e.Target, &e.Reason, &before, &after,
+		&e.RequestID, &e.SourceIP, &e.PrevHash, &e.Hash)
+	if err != nil {
+		return domain.AuditEntry{}, err
+	}
+	if before != nil {
+		e.Before = before
+	}
+	if after != nil {
+		e.After = after
+	}
+	e.CreatedAt = e.CreatedAt.UTC()
+	return e, nil
+}
+
+// Filter selects entries; zero values mean "no filter". BeforeSeq pages
+// back from the Seq of the last entry seen.
+type Filter struct {
+	Actor     string
+	Action    string
+	Target    string
+	RequestID string
+	From      time.Time
+	To        time.Time
+	BeforeSeq int64
+	Limit     int
+}
+
+// Query returns the entries matching f, newest first.
+func Query(ctx context.Context, db *sql.DB, f Filter) ([]domain.AuditEntry, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var where []string
+	var args []any
+	add := func(cond string, v any) {
+		args = append(args, v)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if f.Actor != "" {
+		add("actor = $%d", f.Actor)
+	}
+	if f.Action != "" {
+		add("action = $%d", f.Action)
+	}
+	if f.Target != "" {
+		add("target = $%d", f.Target)
+	}
+	if f.RequestID != "" {
+		add("request_id = $%d", f.RequestID)
+	}
+	if !f.From.IsZero() {
+		add("created_at >= $%d", f.From)
+	}
+	if !f.To.IsZero() {
+		add("created_at < $%d", f.To)
+	}
+	if f.BeforeSeq > 0 {
+		add("seq < $%d", f.BeforeSeq)
+	}
+	query := `select ` + columns + ` from ` + Table
+	if len(where) > 0 {
+		query += ` where ` + strings.Join(where, " and ")
+	}
+	args = append(args, f.Limit)
+	query += fmt.Sprintf(` order by seq desc limit $%d`, len(args))
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []domain.AuditEntry{}
+	for rows.Next() {
+		e, err := scanEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// ErrBrokenChain is returned by Verify for a log that has been tampered with.
+var ErrBrokenChain = errors.New("audit chain is broken")
+
+// Report is the outcome of Verify. Digest is the SHA-256 of the last hash
+// of every target's chain; keeping it somewhere else lets a later check
+// notice entries cut off the end of a chain, which the chains alone can
+// not.
+type Report struct {
+	Entries int64
+	Targets int64
+	Digest  string
+}
+
+// Verify walks the chain of every target in order and checks that every
+// entry points at the hash of the one before and that every hash matches
+// the entry's fields. Sequence numbers come from a database sequence and
+// may have gaps. The first mismatch is an ErrBrokenChain naming the entry;
+// the report then covers the chains before it.
+func Verify(ctx context.Context, db *sql.DB) (Report, error) {
+	rows, err := db.QueryContext(ctx, `select `+columns+` from `+Table+` order by target, seq`)
+	if err != nil {
+		return Report{}, err
+	}
+	defer rows.Close()
+
+	var r Report
+	digest := sha256.New()
+	target, head := "", ""
+	closeChain := func() {
+		if head != "" {
+			fmt.Fprintf(digest, "%s %s\n", target, head)
+			r.Targets++
+		}
+		r.Digest = hex.EncodeToString(digest.Sum(nil))
+	}
+	for rows.Next() {
+		e, err := scanEntry(rows)
+		if err != nil {
+			return r, err
+		}
+		if head == "" || e.Target != target {
+			closeChain()
+			target, head = e.Target, genesis
+		}
+		switch {
+		case e.PrevHash != head:
+			return r, fmt.Errorf("%w: entry %d of %s does not point at the hash of the entry before", ErrBrokenChain, e.Seq, e.Target)
+		case hash(e) != e.Hash:
+			return r, fmt.Errorf("%w: entry %d of %s does not match its hash", ErrBrokenChain, e.Seq, e.Target)
+		}
+		r.Entries++
+		head = e.Hash
+	}
+	if err := rows.Err(); err != nil {
+		return r, err
+	}
+	closeChain()
+	return r, nil
+}
@@ -4,10 +4,11 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"log"
 	"os"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 func OpenDB() (*sql.DB, error) {
@@ -31,3 +32,23 @@ func OpenDB() (*sql.DB, error) {
 
 	return db, nil
 }
+
+// Listen opens a dedicated connection that LISTENs on channel. It reconnects
+// on its own; a nil notification is delivered after every reconnect.
+func Listen(channel string) (*pq.Listener, error) {
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		return nil, errors.New("DATABASE_URL is empty")
+	}
+
+	l := pq.NewListener(dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("listener %s: %v", channel, err)
+		}
+	})
+	if err := l.Listen(channel); err != nil {
+		_ = l.Close()
+		return nil, err
+	}
+	return l, nil
+}
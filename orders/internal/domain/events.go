@@ -1,15 +1,51 @@
 package domain
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
 
 type CreateOrderReq struct {
-	UserID      string      `json:"user_id"`
+	UserID string `json:"user_id"`
+	// Amount is a decimal in major units of Currency, e.g. 10.99.
 	Amount      json.Number `json:"amount"`
+	Currency    string      `json:"currency,omitempty"` // ISO-4217, DEFAULT_CURRENCY if empty
 	Description string      `json:"description"`
+	PromoCode   string      `json:"promo_code,omitempty"`
+	AddressID   string      `json:"address_id,omitempty"` // ships to this address when set
+	Category    string      `json:"category,omitempty"`   // product category for tax
+	// PaymentMethod is "balance" (default) or "card".
+	PaymentMethod string `json:"payment_method,omitempty"`
+	// Payers split the order between several users, each paying from their
+	// balance; every one pays or nobody does.
+	Payers []PayerReq `json:"payers,omitempty"`
 }
 
-type ListOrderReq struct {
+// PayerReq is a payer of a split order and their weight: shares 1 and 2
+// pay a third and two thirds.
+type PayerReq struct {
 	UserID string `json:"user_id"`
+	Share  int    `json:"share"`
+}
+
+type ListOrderReq struct {
+	UserID      string      `json:"user_id"`
+	Status      OrderStatus `json:"status,omitempty"`
+	Currency    string      `json:"currency,omitempty"`   // only orders in this currency
+	MinAmount   json.Number `json:"min_amount,omitempty"` // in Currency, DEFAULT_CURRENCY if empty
+	MaxAmount   json.Number `json:"max_amount,omitempty"`
+	CreatedFrom string      `json:"created_from,omitempty"` // RFC3339
+	CreatedTo   string      `json:"created_to,omitempty"`   // RFC3339
+	Sort        string      `json:"sort,omitempty"`         // "desc" (default) / "asc"
+	Cursor      string      `json:"cursor,omitempty"`
+	Limit       int         `json:"limit,omitempty"`
+}
+
+type ListOrderResp struct {
+	Orders     []Order `json:"orders"`
+	NextCursor string  `json:"next_cursor,omitempty"`
 }
 
 type StatusReq struct {
@@ -17,9 +53,268 @@ type StatusReq struct {
 }
 
 type StatusResp struct {
-	Status OrderStatus `json:"status"`
+	Status              OrderStatus `json:"status"`
+	CancellationReason  string      `json:"cancellation_reason,omitempty"`
+	CancellationMessage string      `json:"cancellation_message,omitempty"`
 }
 
 type ErrResp struct {
 	Error string `json:"error"`
 }
+
+// OrderStatusEvent is pushed to /orders/{id}/events subscribers.
+type OrderStatusEvent struct {
+	OrderID             uuid.UUID   `json:"order_id"`
+	Status              OrderStatus `json:"status"`
+	CancellationReason  string      `json:"cancellation_reason,omitempty"`
+	CancellationMessage string      `json:"cancellation_message,omitempty"`
+}
+
+type CreateWebhookReq struct {
+	URL        string   `json:"url"`
+	Secret     string   `json:"secret,omitempty"` // generated when empty
+	EventTypes []string `json:"event_types,omitempty"`
+}
+
+// CreateWebhookResp is the only response that carries the signing secret.
+type CreateWebhookResp struct {
+	Endpoint WebhookEndpoint `json:"endpoint"`
+	Secret   string          `json:"secret"`
+}
+
+type WebhookIDReq struct {
+	ID string `json:"id"`
+}
+
+type ListWebhooksResp struct {
+	Endpoints []WebhookEndpoint `json:"endpoints"`
+}
+
+type WebhookDeliveriesReq struct {
+	EndpointID string         `json:"endpoint_id"`
+	Status     DeliveryStatus `json:"status,omitempty"`
+	Limit      int            `json:"limit,omitempty"`
+}
+
+type WebhookDeliveriesResp struct {
+	Deliveries []WebhookDelivery `json:"deliveries"`
+}
+
+type ReplayDeliveryReq struct {
+	DeliveryID int64 `json:"delivery_id"`
+}
+
+// CreatePromotionReq amounts are decimals in major units of Currency.
+type CreatePromotionReq struct {
+	Code           string      `json:"code"`
+	Kind           PromoKind   `json:"kind"`
+	Percent        int         `json:"percent,omitempty"`    // PERCENT: 1..99
+	AmountOff      json.Number `json:"amount_off,omitempty"` // FIXED
+	Currency       string      `json:"currency,omitempty"`
+	MinOrder       json.Number `json:"min_order,omitempty"`
+	PerUserLimit   int         `json:"per_user_limit,omitempty"`
+	TotalLimit     int         `json:"total_limit,omitempty"`
+	FirstOrderOnly bool        `json:"first_order_only,omitempty"`
+	StartsAt       string      `json:"starts_at,omitempty"`  // RFC3339
+	ExpiresAt      string      `json:"expires_at,omitempty"` // RFC3339
+}
+
+type PromotionCodeReq struct {
+	Code string `json:"code"`
+}
+
+type ListPromotionsResp struct {
+	Promotions []Promotion `json:"promotions"`
+}
+
+type CartReq struct {
+	UserID string `json:"user_id"`
+}
+
+// CartItemReq adds Quantity (default 1) of an item; Price is per unit, a
+// decimal in major units of Currency.
+type CartItemReq struct {
+	UserID   string      `json:"user_id"`
+	SKU      string      `json:"sku"`
+	Name     string      `json:"name,omitempty"`
+	Price    json.Number `json:"price"`
+	Currency string      `json:"currency,omitempty"`
+	Quantity int         `json:"quantity,omitempty"`
+	Category string      `json:"category,omitempty"` // product category for tax
+}
+
+// CartQuantityReq sets the quantity of an item; 0 removes it.
+type CartQuantityReq struct {
+	UserID   string `json:"user_id"`
+	SKU      string `json:"sku"`
+	Quantity int    `json:"quantity"`
+}
+
+type CartRemoveReq struct {
+	UserID string `json:"user_id"`
+	SKU    string `json:"sku"`
+}
+
+type SubscriptionItemReq struct {
+	SKU      string      `json:"sku"`
+	Name     string      `json:"name,omitempty"`
+	Price    json.Number `json:"price"` // per unit
+	Quantity int         `json:"quantity,omitempty"`
+	Category string      `json:"category,omitempty"`
+}
+
+// CreateSubscriptionReq takes either Items or Amount. Schedule is a five
+// field cron expression in UTC, "@every 720h" or "@daily"/"@weekly"/"@monthly".
+type CreateSubscriptionReq struct {
+	UserID      string                `json:"user_id"`
+	Items       []SubscriptionItemReq `json:"items,omitempty"`
+	Amount      json.Number           `json:"amount,omitempty"`
+	Currency    string                `json:"currency,omitempty"`
+	Description string                `json:"description,omitempty"`
+	Category    string                `json:"category,omitempty"`
+	AddressID   string                `json:"address_id,omitempty"`
+	Schedule    string                `json:"schedule"`
+	MaxFailures int                   `json:"max_failures,omitempty"` // 3 if empty
+	StartAt     string                `json:"start_at,omitempty"`     // RFC3339; first due date is after it
+}
+
+type SubscriptionReq struct {
+	UserID string `json:"user_id"`
+	ID     string `json:"id"`
+}
+
+type ListSubscriptionsReq struct {
+	UserID string `json:"user_id"`
+}
+
+type ListSubscriptionsResp struct {
+	Subscriptions []Subscription `json:"subscriptions"`
+}
+
+type CheckoutReq struct {
+	UserID        string `json:"user_id"`
+	PromoCode     string `json:"promo_code,omitempty"`
+	AddressID     string `json:"address_id,omitempty"`
+	PaymentMethod string `json:"payment_method,omitempty"` // "balance" (default) or "card"
+}
+
+type CreateAddressReq struct {
+	UserID     string `json:"user_id"`
+	Recipient  string `json:"recipient"`
+	Line1      string `json:"line1"`
+	Line2      string `json:"line2,omitempty"`
+	City       string `json:"city"`
+	Region     string `json:"region,omitempty"`
+	PostalCode string `json:"postal_code"`
+	Country    string `json:"country"`
+	Phone      string `json:"phone,omitempty"`
+	IsDefault  bool   `json:"is_default,omitempty"`
+}
+
+type ListAddressesReq struct {
+	UserID string `json:"user_id"`
+}
+
+type ListAddressesResp struct {
+	Addresses []Address `json:"addresses"`
+}
+
+type DeleteAddressReq struct {
+	UserID string `json:"user_id"`
+	ID     string `json:"id"`
+}
+
+type FulfilmentReq struct {
+	OrderID string `json:"order_id"`
+}
+
+type ShipReq struct {
+	OrderID        string `json:"order_id"`
+	Carrier        string `json:"carrier"`
+	TrackingNumber string `json:"tracking_number"`
+}
+
+type FulfilmentQueueReq struct {
+	Status FulfilmentStatus `json:"status,omitempty"` // PENDING if empty
+	Limit  int              `json:"limit,omitempty"`
+}
+
+type FulfilmentQueueResp struct {
+	Fulfilments []Fulfilment `json:"fulfilments"`
+}
+
+// ImportOrderReq is one line of an NDJSON import; CSV columns carry the same
+// names.
+type ImportOrderReq struct {
+	CreateOrderReq
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+type ImportRowStatus string
+
+const (
+	ImportCreated  ImportRowStatus = "CREATED"
+	ImportExisting ImportRowStatus = "EXISTING" // idempotency_key matched an earlier order
+	ImportFailed   ImportRowStatus = "FAILED"
+)
+
+type ImportRowResult struct {
+	Line    int             `json:"line"` // in the uploaded file, header included
+	Status  ImportRowStatus `json:"status"`
+	OrderID *uuid.UUID      `json:"order_id,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+type ImportOrdersResp struct {
+	Created  int               `json:"created"`
+	Existing int               `json:"existing"`
+	Failed   int               `json:"failed"`
+	Rows     []ImportRowResult `json:"rows"`
+}
+
+// AdminSearchReq finds orders for support, newest first. Empty fields do
+// not filter.
+type AdminSearchReq struct {
+	OrderID string      `json:"order_id,omitempty"`
+	UserID  string      `json:"user_id,omitempty"`
+	Status  OrderStatus `json:"status,omitempty"`
+	Limit   int         `json:"limit,omitempty"` // 50 by default, at most 500
+}
+
+type AdminSearchResp struct {
+	Orders []Order `json:"orders"`
+}
+
+// ForceCancelReq cancels a NEW order stuck waiting for its payment.
+type ForceCancelReq struct {
+	OrderID string `json:"order_id"`
+	Reason  string `json:"reason"`
+}
+
+type OutboxReq struct {
+	PendingOnly bool `json:"pending_only,omitempty"`
+	Limit       int  `json:"limit,omitempty"` // 50 by default, at most 500
+}
+
+type RepublishReq struct {
+	MessageID string `json:"message_id"`
+	Reason    string `json:"reason"`
+}
+
+// AuditQueryReq reads the audit log, newest first. Empty fields do not
+// filter; NextBeforeSeq of a response fetches the page after it.
+type AuditQueryReq struct {
+	Actor     string     `json:"actor,omitempty"`
+	Action    string     `json:"action,omitempty"`
+	Target    string     `json:"target,omitempty"` // e.g. "order:<id>"
+	RequestID string     `json:"request_id,omitempty"`
+	From      *time.Time `json:"from,omitempty"`
+	To        *time.Time `json:"to,omitempty"`
+	BeforeSeq int64      `json:"before_seq,omitempty"`
+	Limit     int        `json:"limit,omitempty"` // 50 by default, at most 500
+}
+
+type AuditQueryResp struct {
+	Entries       []AuditEntry `json:"entries"`
+	NextBeforeSeq int64        `json:"next_before_seq,omitempty"` // 0 on the last page
+}
@@ -1,23 +1,299 @@
 package domain
 
 import (
+	"encoding/json"
+	"time"
+
 	"github.com/google/uuid"
+
+	"orders/internal/money"
 )
 
-type Money int64
+// Money is an amount in minor units of a currency.
+type Money = money.Money
 
 type OrderStatus string
 
 const (
-	OrderNew OrderStatus = "NEW"
-	OrderFinished       OrderStatus = "FINISHED"
-	OrderCancelled      OrderStatus = "CANCELLED"
+	OrderNew       OrderStatus = "NEW"
+	OrderFinished  OrderStatus = "FINISHED"
+	OrderCancelled OrderStatus = "CANCELLED"
+
+	OrderPartiallyRefunded OrderStatus = "PARTIALLY_REFUNDED"
+	OrderRefunded          OrderStatus = "REFUNDED"
+)
+
+// ReasonPaymentFailed is the cancellation reason of an order whose payment
+// failed without a reason code, as reported by older payments releases.
+// Other codes come from payments: NO_ACCOUNT, NO_WALLET, INSUFFICIENT_FUNDS,
+// LIMIT_EXCEEDED, ACCOUNT_FROZEN, ACCOUNT_BLOCKED, FRAUD_DENIED,
+// FRAUD_REJECTED, CARD_DECLINED, ADMIN_CANCELLED.
+const ReasonPaymentFailed = "PAYMENT_FAILED"
+
+// ReasonAdminCancelled is the cancellation reason of an order cancelled by
+// support from the back office.
+const ReasonAdminCancelled = "ADMIN_CANCELLED"
+
+// Payment methods of an order. A balance order is paid from the wallet as
+// soon as it is created; a card order waits until the card is given to
+// payments at POST /pay/card.
+const (
+	PayByBalance = "balance"
+	PayByCard    = "card"
 )
 
 type Order struct {
-	ID 	        uuid.UUID   `json:"id"`
+	ID          uuid.UUID   `json:"id"`
 	UserID      string      `json:"user_id"`
-	Amount 	    Money       `json:"amount"`
+	Amount      Money       `json:"amount"`
 	Description string      `json:"description"`
 	Status      OrderStatus `json:"status"`
-}
\ No newline at end of file
+	CreatedAt   time.Time   `json:"created_at"`
+	// RefundedAmount is the sum of refunds reported by payments.
+	RefundedAmount Money `json:"refunded_amount"`
+	// OriginalAmount is the price of the goods before Discount. Amount is
+	// what is charged: OriginalAmount - Discount + ShippingCost, plus tax
+	// when prices exclude it.
+	OriginalAmount Money  `json:"original_amount"`
+	Discount       Money  `json:"discount"`
+	PromoCode      string `json:"promo_code,omitempty"`
+	ShippingCost   Money  `json:"shipping_cost"`
+	// ShippingAddress is a copy taken when the order was created.
+	ShippingAddress *Address `json:"shipping_address,omitempty"`
+	// Tax is computed when the order is created; nil for older orders.
+	Tax *TaxBreakdown `json:"tax,omitempty"`
+	// CancellationReason is the code of why a CANCELLED order was not paid
+	// and CancellationMessage the payments service's words for it.
+	CancellationReason  string `json:"cancellation_reason,omitempty"`
+	CancellationMessage string `json:"cancellation_message,omitempty"`
+	PaymentMethod       string `json:"payment_method"`
+	// Payers split a group order; nil when UserID pays it all.
+	Payers []Payer `json:"payers,omitempty"`
+}
+
+// Payer is one user paying part of a split order. Amount is the order
+// Amount divided in proportion to Share; the minor units left over go to
+// the first payers, one each.
+type Payer struct {
+	UserID string `json:"user_id"`
+	Share  int    `json:"share"`
+	Amount Money  `json:"amount"`
+}
+
+// TaxLine is one taxed position of an order: a product line or shipping.
+// Net + Tax = Gross, and Gross already has the discount taken off.
+type TaxLine struct {
+	SKU      string `json:"sku,omitempty"`
+	Name     string `json:"name"`
+	Category string `json:"category,omitempty"`
+	Quantity int    `json:"quantity"`
+	Rate     string `json:"rate"` // percent, e.g. "20" or "8.875"
+	Discount Money  `json:"discount"`
+	Net      Money  `json:"net"`
+	Tax      Money  `json:"tax"`
+	Gross    Money  `json:"gross"`
+}
+
+// TaxBreakdown sums the lines; Gross equals the order Amount.
+type TaxBreakdown struct {
+	Region    string    `json:"region"` // ISO 3166-1 alpha-2, optionally "-REGION"
+	Inclusive bool      `json:"inclusive"`
+	Lines     []TaxLine `json:"lines"`
+	Net       Money     `json:"net"`
+	Tax       Money     `json:"tax"`
+	Gross     Money     `json:"gross"`
+}
+
+// Invoice is the receipt issued when an order is paid. Numbers run
+// without gaps within a year: INV-2026-000001, INV-2026-000002, ...
+type Invoice struct {
+	Number      string       `json:"number"`
+	OrderID     uuid.UUID    `json:"order_id"`
+	IssuedAt    time.Time    `json:"issued_at"`
+	Seller      string       `json:"seller"`
+	Payer       InvoicePayer `json:"payer"`
+	Description string       `json:"description"`
+	PromoCode   string       `json:"promo_code,omitempty"`
+	Tax         TaxBreakdown `json:"tax"`
+}
+
+type InvoicePayer struct {
+	UserID  string   `json:"user_id"`
+	Address *Address `json:"address,omitempty"`
+}
+
+type Address struct {
+	ID         uuid.UUID `json:"id"`
+	UserID     string    `json:"user_id"`
+	Recipient  string    `json:"recipient"`
+	Line1      string    `json:"line1"`
+	Line2      string    `json:"line2,omitempty"`
+	City       string    `json:"city"`
+	Region     string    `json:"region,omitempty"`
+	PostalCode string    `json:"postal_code"`
+	Country    string    `json:"country"` // ISO 3166-1 alpha-2
+	Phone      string    `json:"phone,omitempty"`
+	IsDefault  bool      `json:"is_default"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type FulfilmentStatus string
+
+const (
+	FulfilmentPending   FulfilmentStatus = "PENDING"
+	FulfilmentPicking   FulfilmentStatus = "PICKING"
+	FulfilmentShipped   FulfilmentStatus = "SHIPPED"
+	FulfilmentDelivered FulfilmentStatus = "DELIVERED"
+)
+
+// Fulfilment tracks a paid order with a shipping address through the
+// warehouse: PENDING -> PICKING -> SHIPPED -> DELIVERED.
+type Fulfilment struct {
+	OrderID        uuid.UUID        `json:"order_id"`
+	Status         FulfilmentStatus `json:"status"`
+	Carrier        string           `json:"carrier,omitempty"`
+	TrackingNumber string           `json:"tracking_number,omitempty"`
+	CreatedAt      time.Time        `json:"created_at"`
+	PickedAt       *time.Time       `json:"picked_at,omitempty"`
+	ShippedAt      *time.Time       `json:"shipped_at,omitempty"`
+	DeliveredAt    *time.Time       `json:"delivered_at,omitempty"`
+}
+
+type SubscriptionStatus string
+
+const (
+	SubscriptionActive    SubscriptionStatus = "ACTIVE"
+	SubscriptionPaused    SubscriptionStatus = "PAUSED"
+	SubscriptionCancelled SubscriptionStatus = "CANCELLED"
+)
+
+// Subscription places a copy of its template order on every due date of
+// Schedule. Amount is the sum of Items when they are given.
+type Subscription struct {
+	ID          uuid.UUID          `json:"id"`
+	UserID      string             `json:"user_id"`
+	Items       []CartItem         `json:"items,omitempty"`
+	Amount      Money              `json:"amount"`
+	Description string             `json:"description"`
+	Category    string             `json:"category,omitempty"`
+	AddressID   *uuid.UUID         `json:"address_id,omitempty"`
+	Schedule    string             `json:"schedule"`
+	Status      SubscriptionStatus `json:"status"`
+	// Failures counts payments failed in a row; reaching MaxFailures pauses
+	// the subscription.
+	Failures     int        `json:"failures"`
+	MaxFailures  int        `json:"max_failures"`
+	NextRunAt    time.Time  `json:"next_run_at"`
+	LastOrderID  *uuid.UUID `json:"last_order_id,omitempty"`
+	PausedReason string     `json:"paused_reason,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+type CartItem struct {
+	SKU       string `json:"sku"`
+	Name      string `json:"name"`
+	UnitPrice Money  `json:"unit_price"`
+	Quantity  int    `json:"quantity"`
+	LineTotal Money  `json:"line_total"`
+	Category  string `json:"category,omitempty"`
+}
+
+// Cart is a user's pending order. All items share one currency.
+type Cart struct {
+	UserID    string     `json:"user_id"`
+	Items     []CartItem `json:"items"`
+	ItemCount int        `json:"item_count"`
+	Total     Money      `json:"total"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+}
+
+type PromoKind string
+
+const (
+	PromoPercent PromoKind = "PERCENT"
+	PromoFixed   PromoKind = "FIXED"
+)
+
+// Promotion is a discount code. Zero limits mean unlimited. When AmountOff or
+// MinOrder is set, only orders in their currency qualify.
+type Promotion struct {
+	Code           string     `json:"code"`
+	Kind           PromoKind  `json:"kind"`
+	Percent        int        `json:"percent,omitempty"`
+	AmountOff      *Money     `json:"amount_off,omitempty"`
+	MinOrder       *Money     `json:"min_order,omitempty"`
+	PerUserLimit   int        `json:"per_user_limit,omitempty"`
+	TotalLimit     int        `json:"total_limit,omitempty"`
+	FirstOrderOnly bool       `json:"first_order_only"`
+	StartsAt       *time.Time `json:"starts_at,omitempty"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	Active         bool       `json:"active"`
+	Redemptions    int        `json:"redemptions"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+type WebhookEndpoint struct {
+	ID         uuid.UUID `json:"id"`
+	URL        string    `json:"url"`
+	EventTypes []string  `json:"event_types"`
+	Active     bool      `json:"active"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "PENDING"
+	DeliveryDelivered DeliveryStatus = "DELIVERED"
+	DeliveryFailed    DeliveryStatus = "FAILED"
+)
+
+type WebhookDelivery struct {
+	ID            int64          `json:"id"`
+	EndpointID    uuid.UUID      `json:"endpoint_id"`
+	EventID       uuid.UUID      `json:"event_id"`
+	EventType     string         `json:"event_type"`
+	Status        DeliveryStatus `json:"status"`
+	Attempts      int            `json:"attempts"`
+	ResponseCode  int            `json:"response_code,omitempty"`
+	LastError     string         `json:"last_error,omitempty"`
+	NextAttemptAt time.Time      `json:"next_attempt_at"`
+	CreatedAt     time.Time      `json:"created_at"`
+	DeliveredAt   *time.Time     `json:"delivered_at,omitempty"`
+}
+
+// OutboxMessage is a row of orders_outbox without its payload.
+type OutboxMessage struct {
+	ID          int64      `json:"id"`
+	MessageID   uuid.UUID  `json:"message_id"`
+	Topic       string     `json:"topic"`
+	Key         string     `json:"key"`
+	CreatedAt   time.Time  `json:"created_at"`
+	PublishedAt *time.Time `json:"published_at"` // nil until published
+}
+
+type OutboxBacklog struct {
+	Pending       int             `json:"pending"`
+	OldestPending *time.Time      `json:"oldest_pending"`
+	Messages      []OutboxMessage `json:"messages"`
+}
+
+// AuditEntry is one change in the audit log: who (Actor) did what (Action)
+// to which object (Target) and why (Reason, given for back-office
+// changes), its value Before and After, and the request it came with. Hash
+// covers all of it and PrevHash, the hash of the entry of Target before.
+type AuditEntry struct {
+	Seq       int64           `json:"seq"`
+	CreatedAt time.Time       `json:"created_at"`
+	Actor     string          `json:"actor"`
+	Action    string          `json:"action"`
+	Target    string          `json:"target"`
+	Reason    string          `json:"reason,omitempty"`
+	Before    json.RawMessage `json:"before"`
+	After     json.RawMessage `json:"after"`
+	RequestID string          `json:"request_id,omitempty"`
+	SourceIP  string          `json:"source_ip,omitempty"`
+	PrevHash  string          `json:"prev_hash"`
+	Hash      string          `json:"hash"`
+}
@@ -0,0 +1,101 @@
+package events
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"orders/internal/domain"
+)
+
+// Channel is the Postgres NOTIFY channel order status changes go through, so
+// that every replica's subscribers see them regardless of which replica
+// consumed the payment result.
+const Channel = "order_status"
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// NotifyStatus queues a status change notification. Inside a transaction it
+// is delivered only if the transaction commits.
+func NotifyStatus(ctx context.Context, db execer, orderID uuid.UUID, status domain.OrderStatus) error {
+	return Notify(ctx, db, domain.OrderStatusEvent{OrderID: orderID, Status: status})
+}
+
+// Notify is NotifyStatus for an event that carries more than the status.
+func Notify(ctx context.Context, db execer, ev domain.OrderStatusEvent) error {
+	payload, _ := json.Marshal(ev)
+	_, err := db.ExecContext(ctx, `select pg_notify($1, $2)`, Channel, string(payload))
+	return err
+}
+
+// Broker fans out status notifications to in-process subscribers.
+type Broker struct {
+	mu   sync.Mutex
+	subs map[uuid.UUID]map[chan domain.OrderStatusEvent]struct{}
+}
+
+func NewBroker() *Broker {
+	return &Broker{subs: map[uuid.UUID]map[chan domain.OrderStatusEvent]struct{}{}}
+}
+
+// Subscribe returns a channel of status changes for one order and a func
+// that must be called to unsubscribe.
+func (b *Broker) Subscribe(orderID uuid.UUID) (<-chan domain.OrderStatusEvent, func()) {
+	ch := make(chan domain.OrderStatusEvent, 8)
+
+	b.mu.Lock()
+	if b.subs[orderID] == nil {
+		b.subs[orderID] = map[chan domain.OrderStatusEvent]struct{}{}
+	}
+	b.subs[orderID][ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subs[orderID], ch)
+		if len(b.subs[orderID]) == 0 {
+			delete(b.subs, orderID)
+		}
+		b.mu.Unlock()
+	}
+}
+
+func (b *Broker) publish(ev domain.OrderStatusEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs[ev.OrderID] {
+		select {
+		case ch <- ev:
+		default:
+			// Slow subscriber: drop rather than block everyone else.
+		}
+	}
+}
+
+// Run delivers notifications from l until ctx is done.
+func (b *Broker) Run(ctx context.Context, l *pq.Listener) {
+	defer l.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n := <-l.Notify:
+			if n == nil {
+				continue
+			}
+			var ev domain.OrderStatusEvent
+			if err := json.Unmarshal([]byte(n.Extra), &ev); err != nil {
+				log.Printf("bad %s notification: %v", Channel, err)
+				continue
+			}
+			b.publish(ev)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package fulfilment
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"orders/internal/audit"
+	"orders/internal/domain"
+	"orders/internal/store"
+)
+
+var (
+	ErrNoFulfilment    = errors.New("no fulfilment for this order")
+	ErrBadTransition   = errors.New("fulfilment cannot move to this status")
+	ErrNotShippable    = errors.New("order is not shippable in its current status")
+	ErrInvalidTracking = errors.New("carrier and tracking_number are required")
+)
+
+// next is the only status each step may move to.
+var next = map[domain.FulfilmentStatus]domain.FulfilmentStatus{
+	domain.FulfilmentPending: domain.FulfilmentPicking,
+	domain.FulfilmentPicking: domain.FulfilmentShipped,
+	domain.FulfilmentShipped: domain.FulfilmentDelivered,
+}
+
+var eventTypes = map[domain.FulfilmentStatus]string{
+	domain.FulfilmentPicking:   store.EventOrderPicking,
+	domain.FulfilmentShipped:   store.EventOrderShipped,
+	domain.FulfilmentDelivered: store.EventOrderDelivered,
+}
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// Open queues a paid order for the warehouse as part of tx. Orders without
+// a shipping address need no fulfilment, and reopening is a no-op.
+func Open(ctx context.Context, tx *sql.Tx, orderID uuid.UUID) error {
+	_, err := tx.ExecContext(ctx,
+		`insert into fulfilments(order_id, status)
+		 select id, $2 from orders where id = $1 and shipping_address is not null
+		 on conflict (order_id) do nothing`,
+		orderID, string(domain.FulfilmentPending),
+	)
+	return err
+}
+
+const columns = `order_id, status, carrier, tracking_number, created_at, picked_at, shipped_at, delivered_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scan(r rowScanner) (domain.Fulfilment, error) {
+	var f domain.Fulfilment
+	var st string
+	var picked, shipped, delivered sql.NullTime
+	err := r.Scan(&f.OrderID, &st, &f.Carrier, &f.TrackingNumber, &f.CreatedAt, &picked, &shipped, &delivered)
+	if err != nil {
+		return domain.Fulfilment{}, err
+	}
+	f.Status = domain.FulfilmentStatus(st)
+	for _, t := range []struct {
+		src sql.NullTime
+		dst **time.Time
+	}{{picked, &f.PickedAt}, {shipped, &f.ShippedAt}, {delivered, &f.DeliveredAt}} {
+		if t.src.Valid {
+			v := t.src.Time
+			*t.dst = &v
+		}
+	}
+	return f, nil
+}
+
+func (s *Store) Get(ctx context.Context, orderID uuid.UUID) (domain.Fulfilment, error) {
+	f, err := scan(s.db.QueryRowContext(ctx,
+		`select `+columns+` from fulfilments where order_id = $1`, orderID,
+	))
+	if err == sql.ErrNoRows {
+		return domain.Fulfilment{}, ErrNoFulfilment
+	}
+	return f, err
+}
+
+// Queue lists fulfilments in status, oldest first, so the warehouse works
+// through them in order.
+func (s *Store) Queue(ctx context.Context, status domain.FulfilmentStatus, limit int) ([]domain.Fulfilment, error) {
+	if status == "" {
+		status = domain.FulfilmentPending
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 100
+	}
+	rows, err := s.db.QueryContext(ctx,
+		`select `+columns+` from fulfilments where status = $1 order by created_at, order_id limit $2`,
+		string(status), limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []domain.Fulfilment{}
+	for rows.Next() {
+		f, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, f)
+	}
+	return out, rows.Err()
+}
+
+func (s *Store) Pick(ctx context.Context, orderID uuid.UUID) (domain.Fulfilment, error) {
+	return s.advance(ctx, orderID, domain.FulfilmentPicking, "", "")
+}
+
+func (s *Store) Ship(ctx context.Context, orderID uuid.UUID, carrier, trackingNumber string) (domain.Fulfilment, error) {
+	carrier, trackingNumber = strings.TrimSpace(carrier), strings.TrimSpace(trackingNumber)
+	if carrier == "" || trackingNumber == "" || len(carrier) > 64 || len(trackingNumber) > 64 {
+		return domain.Fulfilment{}, ErrInvalidTracking
+	}
+	return s.advance(ctx, orderID, domain.FulfilmentShipped, carrier, trackingNumber)
+}
+
+func (s *Store) Deliver(ctx context.Context, orderID uuid.UUID) (domain.Fulfilment, error) {
+	return s.advance(ctx, orderID, domain.FulfilmentDelivered, "", "")
+}
+
+// advance moves the fulfilment one step forward and publishes the step to
+// orders_outbox. Repeating the step it is already at returns it unchanged,
+// so warehouse clients can retry.
+func (s *Store) advance(ctx context.Context, orderID uuid.UUID, to domain.FulfilmentStatus, carrier, trackingNumber string) (domain.Fulfilment, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return domain.Fulfilment{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	f, err := scan(tx.QueryRowContext(ctx,
+		`select `+columns+` from fulfilments where order_id = $1 for update`, orderID,
+	))
+	if err == sql.ErrNoRows {
+		return domain.Fulfilment{}, ErrNoFulfilment
+	}
+	if err != nil {
+		return domain.Fulfilment{}, err
+	}
+
+	if f.Status == to && (to != domain.FulfilmentShipped || f.Carrier == carrier && f.TrackingNumber == trackingNumber) {
+		return f, nil
+	}
+	if next[f.Status] != to {
+		return domain.Fulfilment{}, fmt.Errorf("%w: %s -> %s", ErrBadTransition, f.Status, to)
+	}
+
+	var userID, currency, st string
+	var amount int64
+	err = tx.QueryRowContext(ctx,
+		`select user_id, amount, currency, status from orders where id = $1`, orderID,
+	).Scan(&userID, &amount, &currency, &st)
+	if err != nil {
+		return domain.Fulfilment{}, err
+	}
+	// A refunded or cancelled order must not leave the warehouse; one already
+	// on its way is still tracked to the door.
+	if to != domain.FulfilmentDelivered &&
+		st != string(domain.OrderFinished) && st != string(domain.OrderPartiallyRefunded) {
+		return domain.Fulfilment{}, fmt.Errorf("%w: %s", ErrNotShippable, st)
+	}
+
+	before := f
+	f, err = scan(tx.QueryRowContext(ctx,
+		`update fulfilments set status = $2,
+		     carrier = case when $2 = 'SHIPPED' then $3 else carrier end,
+		     tracking_number = case when $2 = 'SHIPPED' then $4 else tracking_number end,
+		     picked_at = case when $2 = 'PICKING' then now() else picked_at end,
+		     shipped_at = case when $2 = 'SHIPPED' then now() else shipped_at end,
+		     delivered_at = case when $2 = 'DELIVERED' then now() else delivered_at end
+		 where order_id = $1
+		 returning `+columns,
+		orderID, string(to), carrier, trackingNumber,
+	))
+	if err != nil {
+		return domain.Fulfilment{}, err
+	}
+
+	err = store.InsertOrderEventOutbox(ctx, tx, store.OrderEvent{
+		Type:           eventTypes[to],
+		OrderID:        orderID,
+		UserID:         userID,
+		Amount:         amount,
+		Currency:       currency,
+		Status:         domain.OrderStatus(st),
+		Fulfilment:     f.Status,
+		Carrier:        f.Carrier,
+		TrackingNumber: f.TrackingNumber,
+	})
+	if err != nil {
+		return domain.Fulfilment{}, err
+	}
+	if err := audit.Record(ctx, tx, audit.FulfilmentAdvance, audit.Target("order", orderID), before, f); err != nil {
+		return domain.Fulfilment{}, err
+	}
+	return f, tx.Commit()
+}
@@ -0,0 +1,194 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"orders/api/ordersv1"
+	"orders/internal/domain"
+	"orders/internal/money"
+	"orders/internal/store"
+)
+
+type Server struct {
+	ordersv1.UnimplementedOrdersServer
+	st *store.OrdersStore
+}
+
+// NewServer returns a gRPC server exposing the Orders service together with
+// the standard health and reflection services.
+func NewServer(st *store.OrdersStore, authToken string) *grpc.Server {
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(metricsUnary, loggingUnary, authUnary(authToken), sourceUnary),
+		grpc.ChainStreamInterceptor(metricsStream, loggingStream, authStream(authToken)),
+	)
+	ordersv1.RegisterOrdersServer(srv, &Server{st: st})
+
+	hs := health.NewServer()
+	hs.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	hs.SetServingStatus(ordersv1.Orders_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(srv, hs)
+
+	reflection.Register(srv)
+	return srv
+}
+
+func toProto(o domain.Order) *ordersv1.Order {
+	p := &ordersv1.Order{
+		Id:             o.ID.String(),
+		UserId:         o.UserID,
+		Amount:         o.Amount.Amount,
+		Currency:       string(o.Amount.Currency),
+		RefundedAmount: o.RefundedAmount.Amount,
+		OriginalAmount: o.OriginalAmount.Amount,
+		Discount:       o.Discount.Amount,
+		PromoCode:      o.PromoCode,
+		ShippingCost:   o.ShippingCost.Amount,
+		Description:    o.Description,
+		Status:         string(o.Status),
+		CreatedAt:      timestamppb.New(o.CreatedAt),
+	}
+	if b := o.Tax; b != nil {
+		p.Tax = &ordersv1.TaxBreakdown{
+			Region:    b.Region,
+			Inclusive: b.Inclusive,
+			Net:       b.Net.Amount,
+			Tax:       b.Tax.Amount,
+			Gross:     b.Gross.Amount,
+		}
+		for _, l := range b.Lines {
+			p.Tax.Lines = append(p.Tax.Lines, &ordersv1.TaxLine{
+				Sku:      l.SKU,
+				Name:     l.Name,
+				Category: l.Category,
+				Quantity: int32(l.Quantity),
+				Rate:     l.Rate,
+				Discount: l.Discount.Amount,
+				Net:      l.Net.Amount,
+				Tax:      l.Tax.Amount,
+				Gross:    l.Gross.Amount,
+			})
+		}
+	}
+	return p
+}
+
+func toStatus(err error) error {
+	switch {
+	case errors.Is(err, store.ErrNoOrder):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, store.ErrInvalidPrice),
+		errors.Is(err, store.ErrDescriptionLimit),
+		errors.Is(err, store.ErrCategoryLimit),
+		errors.Is(err, store.ErrInvalidCursor),
+		errors.Is(err, store.ErrInvalidSort),
+		errors.Is(err, store.ErrAmountNeedsCurrency),
+		errors.Is(err, money.ErrUnknownCurrency):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, store.ErrNoPromotion), errors.Is(err, store.ErrPromoNotValid),
+		errors.Is(err, store.ErrNoAddress), errors.Is(err, store.ErrNoShippingRate):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, store.ErrIdempotencyConflict):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func (s *Server) CreateOrder(ctx context.Context, req *ordersv1.CreateOrderRequest) (*ordersv1.Order, error) {
+	if req.GetUserId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "empty user_id")
+	}
+	cur, err := money.CurrencyOrDefault(req.GetCurrency())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	var addressID uuid.UUID
+	if req.GetAddressId() != "" {
+		addressID, err = uuid.Parse(req.GetAddressId())
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, "invalid address_id")
+		}
+	}
+	o, err := s.st.CreateOrder(ctx, store.NewOrder{
+		UserID:         req.GetUserId(),
+		Amount:         money.New(req.GetAmount(), cur),
+		Description:    req.GetDescription(),
+		IdempotencyKey: req.GetIdempotencyKey(),
+		PromoCode:      req.GetPromoCode(),
+		AddressID:      addressID,
+		Category:       req.GetCategory(),
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toProto(o), nil
+}
+
+func (s *Server) GetOrder(ctx context.Context, req *ordersv1.GetOrderRequest) (*ordersv1.Order, error) {
+	id, err := uuid.Parse(req.GetId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid order id")
+	}
+	o, err := s.st.GetOrder(ctx, id)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toProto(o), nil
+}
+
+func (s *Server) ListOrders(req *ordersv1.ListOrdersRequest, stream grpc.ServerStreamingServer[ordersv1.Order]) error {
+	if req.GetUserId() == "" {
+		return status.Error(codes.InvalidArgument, "empty user_id")
+	}
+	f := store.ListOrdersFilter{
+		UserID:    req.GetUserId(),
+		Status:    domain.OrderStatus(req.GetStatus()),
+		MinAmount: req.GetMinAmount(),
+		MaxAmount: req.GetMaxAmount(),
+		Sort:      store.SortOrder(req.GetSort()),
+		Limit:     store.MaxListLimit,
+	}
+	if req.GetCurrency() != "" {
+		cur, err := money.ParseCurrency(req.GetCurrency())
+		if err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		f.Currency = cur
+	}
+	if req.GetCreatedFrom() != nil {
+		f.CreatedFrom = req.GetCreatedFrom().AsTime()
+	}
+	if req.GetCreatedTo() != nil {
+		f.CreatedTo = req.GetCreatedTo().AsTime()
+	}
+
+	ctx := stream.Context()
+	for {
+		page, err := s.st.ListOrders(ctx, f)
+		if err != nil {
+			return toStatus(err)
+		}
+		for _, o := range page.Orders {
+			if err := stream.Send(toProto(o)); err != nil {
+				return err
+			}
+		}
+		if page.NextCursor == "" {
+			return nil
+		}
+		f.Cursor = page.NextCursor
+	}
+}
@@ -0,0 +1,118 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"orders/internal/domain"
+	"orders/internal/store"
+)
+
+func makeHandleCreateAddress(s *store.OrdersStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.CreateAddressReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+		if req.UserID == "" {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "empty user_id"})
+			return
+		}
+
+		a, err := s.CreateAddress(r.Context(), domain.Address{
+			UserID:     req.UserID,
+			Recipient:  req.Recipient,
+			Line1:      req.Line1,
+			Line2:      req.Line2,
+			City:       req.City,
+			Region:     req.Region,
+			PostalCode: req.PostalCode,
+			Country:    req.Country,
+			Phone:      req.Phone,
+			IsDefault:  req.IsDefault,
+		})
+		if errors.Is(err, store.ErrInvalidAddress) {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+			return
+		}
+		if errors.Is(err, store.ErrTooManyAddress) {
+			writeJSON(w, http.StatusUnprocessableEntity, domain.ErrResp{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: "could not create address: " + err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, a)
+	}
+}
+
+func makeHandleListAddresses(s *store.OrdersStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.ListAddressesReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+		if req.UserID == "" {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "empty user_id"})
+			return
+		}
+
+		addrs, err := s.ListAddresses(r.Context(), req.UserID)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: "could not list addresses: " + err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, domain.ListAddressesResp{Addresses: addrs})
+	}
+}
+
+func makeHandleDeleteAddress(s *store.OrdersStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.DeleteAddressReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+		if req.UserID == "" {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "empty user_id"})
+			return
+		}
+		id, err := uuid.Parse(req.ID)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "invalid id format"})
+			return
+		}
+
+		err = s.DeleteAddress(r.Context(), req.UserID, id)
+		if errors.Is(err, store.ErrNoAddress) {
+			writeJSON(w, http.StatusNotFound, domain.ErrResp{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: "could not delete address: " + err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, req)
+	}
+}
@@ -0,0 +1,188 @@
+package httpapi
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"orders/internal/domain"
+	"orders/internal/openapi"
+	"orders/internal/store"
+)
+
+// ActorHeader names the support operator behind a back-office call. The
+// frontend sets it after checking the operator's role; it is trusted only
+// next to the service token (see guard). It makes the audit log entries of
+// the request by "admin:<name>".
+const ActorHeader = "X-Admin-Actor"
+
+var actorParam = openapi.Param{Name: ActorHeader, In: "header", Description: "support operator making the change", Required: true}
+
+// ServiceTokenHeader carries SERVICE_TOKEN, which the frontend shares with
+// the services. The service ports may be reachable from outside, so only a
+// request bearing it is trusted with ActorHeader and X-Forwarded-For.
+const ServiceTokenHeader = "X-Service-Token"
+
+// guard drops the headers a request without the service token may not set
+// and refuses it operator routes. An empty token trusts nobody, which
+// closes the back office.
+func guard(token string, rt openapi.Route, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		got := r.Header.Get(ServiceTokenHeader)
+		if token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			r.Header.Del(ActorHeader)
+			r.Header.Del("X-Forwarded-For")
+			if rt.Operator {
+				writeJSON(w, http.StatusUnauthorized, domain.ErrResp{Error: "back-office route: missing or wrong " + ServiceTokenHeader})
+				return
+			}
+		}
+		h(w, r)
+	}
+}
+
+// requireActor refuses a back-office change that names no operator, so that
+// the audit log always says who made it.
+func requireActor(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(ActorHeader) == "" {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "missing " + ActorHeader})
+			return
+		}
+		h(w, r)
+	}
+}
+
+const (
+	defaultAdminLimit = 50
+	maxAdminLimit     = 500
+)
+
+func adminLimit(n int) int {
+	if n <= 0 {
+		return defaultAdminLimit
+	}
+	return min(n, maxAdminLimit)
+}
+
+func adminStatus(err error) int {
+	switch {
+	case errors.Is(err, store.ErrNoActor), errors.Is(err, store.ErrAdminReason):
+		return http.StatusBadRequest
+	case errors.Is(err, store.ErrNoOrder), errors.Is(err, store.ErrNoOutboxMessage):
+		return http.StatusNotFound
+	case errors.Is(err, store.ErrOrderNotNew):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+func makeHandleSearchOrders(s *store.OrdersStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.AdminSearchReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+		f := store.AdminSearch{UserID: req.UserID, Status: req.Status, Limit: adminLimit(req.Limit)}
+		if req.OrderID != "" {
+			id, err := uuid.Parse(req.OrderID)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "invalid order_id format"})
+				return
+			}
+			f.OrderID = id
+		}
+
+		orders, err := s.SearchOrders(r.Context(), f)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: "could not search orders: " + err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, domain.AdminSearchResp{Orders: orders})
+	}
+}
+
+func makeHandleForceCancel(s *store.OrdersStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.ForceCancelReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+		id, err := uuid.Parse(req.OrderID)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "invalid order_id format"})
+			return
+		}
+
+		o, err := s.ForceCancel(r.Context(), r.Header.Get(ActorHeader), id, req.Reason)
+		if err != nil {
+			writeJSON(w, adminStatus(err), domain.ErrResp{Error: "could not cancel order: " + err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, o)
+	}
+}
+
+func makeHandleOutbox(s *store.OrdersStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.OutboxReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+
+		b, err := s.OutboxBacklog(r.Context(), req.PendingOnly, adminLimit(req.Limit))
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: "could not read outbox: " + err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, b)
+	}
+}
+
+func makeHandleRepublish(s *store.OrdersStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.RepublishReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+		id, err := uuid.Parse(req.MessageID)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "invalid message_id format"})
+			return
+		}
+
+		m, err := s.RepublishOutbox(r.Context(), r.Header.Get(ActorHeader), id, req.Reason)
+		if err != nil {
+			writeJSON(w, adminStatus(err), domain.ErrResp{Error: "could not republish: " + err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, m)
+	}
+}
@@ -0,0 +1,379 @@
+package httpapi
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"orders/internal/domain"
+	"orders/internal/store"
+)
+
+const (
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+
+	maxNDJSONLine = 64 << 10
+)
+
+var importFormats = map[string]string{
+	"text/csv":             formatCSV,
+	"application/x-ndjson": formatNDJSON,
+	"application/jsonl":    formatNDJSON,
+}
+
+// importColumns maps CSV header names to the fields of a create request.
+var importColumns = map[string]func(*domain.ImportOrderReq, string){
+	"user_id":         func(r *domain.ImportOrderReq, v string) { r.UserID = v },
+	"amount":          func(r *domain.ImportOrderReq, v string) { r.Amount = json.Number(v) },
+	"currency":        func(r *domain.ImportOrderReq, v string) { r.Currency = v },
+	"description":     func(r *domain.ImportOrderReq, v string) { r.Description = v },
+	"promo_code":      func(r *domain.ImportOrderReq, v string) { r.PromoCode = v },
+	"address_id":      func(r *domain.ImportOrderReq, v string) { r.AddressID = v },
+	"category":        func(r *domain.ImportOrderReq, v string) { r.Category = v },
+	"payment_method":  func(r *domain.ImportOrderReq, v string) { r.PaymentMethod = v },
+	"idempotency_key": func(r *domain.ImportOrderReq, v string) { r.IdempotencyKey = v },
+}
+
+var errTooManyRows = fmt.Errorf("an import takes at most %d rows", store.MaxImportRows)
+
+// importRow is a line of the upload that passed validation.
+type importRow struct {
+	idx int // into the report
+	n   store.NewOrder
+}
+
+// importParser collects the report and the valid rows while a file is read.
+type importParser struct {
+	report []domain.ImportRowResult
+	rows   []importRow
+}
+
+func (p *importParser) add(line int, req domain.ImportOrderReq, err error) error {
+	if len(p.report) == store.MaxImportRows {
+		return errTooManyRows
+	}
+	var n store.NewOrder
+	if err == nil {
+		n, err = newOrderFromReq(req.CreateOrderReq)
+	}
+	p.report = append(p.report, domain.ImportRowResult{Line: line})
+	if err != nil {
+		p.report[len(p.report)-1].Status = domain.ImportFailed
+		p.report[len(p.report)-1].Error = err.Error()
+		return nil
+	}
+	n.IdempotencyKey = req.IdempotencyKey
+	p.rows = append(p.rows, importRow{idx: len(p.report) - 1, n: n})
+	return nil
+}
+
+func (p *importParser) parseCSV(body io.Reader) error {
+	cr := csv.NewReader(body)
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err == io.EOF {
+		return errors.New("empty file")
+	}
+	if err != nil {
+		return err
+	}
+	setters := make([]func(*domain.ImportOrderReq, string), len(header))
+	seen := map[string]bool{}
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+		set, ok := importColumns[h]
+		if !ok {
+			return fmt.Errorf("unknown column %q", h)
+		}
+		if seen[h] {
+			return fmt.Errorf("duplicate column %q", h)
+		}
+		seen[h] = true
+		setters[i] = set
+	}
+	if !seen["user_id"] || !seen["amount"] {
+		return errors.New("user_id and amount columns are required")
+	}
+
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		var pe *csv.ParseError
+		if errors.As(err, &pe) {
+			// The reader resumes on the next line after a malformed one.
+			if err := p.add(pe.StartLine, domain.ImportOrderReq{}, pe.Err); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		line, _ := cr.FieldPos(0)
+		var req domain.ImportOrderReq
+		for i, v := range rec {
+			setters[i](&req, v)
+		}
+		if err := p.add(line, req, nil); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *importParser) parseNDJSON(body io.Reader) error {
+	sc := bufio.NewScanner(body)
+	sc.Buffer(make([]byte, 0, 4096), maxNDJSONLine)
+	line := 0
+	for sc.Scan() {
+		line++
+		if strings.TrimSpace(sc.Text()) == "" {
+			continue
+		}
+		var req domain.ImportOrderReq
+		var err error
+		if jerr := json.Unmarshal(sc.Bytes(), &req); jerr != nil {
+			err = errors.New("bad json: " + jerr.Error())
+		}
+		if err := p.add(line, req, err); err != nil {
+			return err
+		}
+	}
+	if errors.Is(sc.Err(), bufio.ErrTooLong) {
+		return fmt.Errorf("line %d is longer than %d bytes", line+1, maxNDJSONLine)
+	}
+	return sc.Err()
+}
+
+// makeHandleImportOrders creates orders from a CSV or NDJSON upload. The
+// whole file is validated first, so a file over the row limit creates
+// nothing; valid rows are then created in transactions of
+// store.ImportBatchSize and every line gets a result in the report.
+func makeHandleImportOrders(s *store.OrdersStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			format = importFormats[ct]
+		}
+
+		var p importParser
+		var err error
+		switch format {
+		case formatCSV:
+			err = p.parseCSV(r.Body)
+		case formatNDJSON:
+			err = p.parseNDJSON(r.Body)
+		default:
+			writeJSON(w, http.StatusUnsupportedMediaType, domain.ErrResp{Error: "send text/csv or application/x-ndjson, or set format=csv|ndjson"})
+			return
+		}
+		var tooLarge *http.MaxBytesError
+		if errors.Is(err, errTooManyRows) || errors.As(err, &tooLarge) {
+			writeJSON(w, http.StatusRequestEntityTooLarge, domain.ErrResp{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+			return
+		}
+
+		for start := 0; start < len(p.rows); start += store.ImportBatchSize {
+			rows := p.rows[start:min(start+store.ImportBatchSize, len(p.rows))]
+			batch := make([]store.NewOrder, len(rows))
+			for i, row := range rows {
+				batch[i] = row.n
+			}
+			results, err := s.ImportOrders(r.Context(), batch)
+			for i, row := range rows {
+				res := &p.report[row.idx]
+				switch {
+				case err != nil:
+					res.Status = domain.ImportFailed
+					res.Error = "batch was not saved: " + err.Error()
+				case results[i].Err != nil:
+					res.Status = domain.ImportFailed
+					res.Error = results[i].Err.Error()
+				default:
+					id := results[i].Order.ID
+					res.OrderID = &id
+					res.Status = domain.ImportCreated
+					if results[i].Replayed {
+						res.Status = domain.ImportExisting
+					}
+				}
+			}
+		}
+
+		resp := domain.ImportOrdersResp{Rows: p.report}
+		for _, res := range p.report {
+			switch res.Status {
+			case domain.ImportCreated:
+				resp.Created++
+			case domain.ImportExisting:
+				resp.Existing++
+			default:
+				resp.Failed++
+			}
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+var exportHeader = []string{
+	"id", "created_at", "user_id", "status", "currency", "original_amount", "discount",
+	"shipping_cost", "tax", "amount", "refunded_amount", "promo_code", "description",
+}
+
+func exportRecord(o domain.Order) []string {
+	var tax string
+	if o.Tax != nil {
+		tax = o.Tax.Tax.Decimal()
+	}
+	return []string{
+		o.ID.String(),
+		o.CreatedAt.UTC().Format(time.RFC3339),
+		spreadsheetSafe(o.UserID),
+		string(o.Status),
+		string(o.Amount.Currency),
+		o.OriginalAmount.Decimal(),
+		o.Discount.Decimal(),
+		o.ShippingCost.Decimal(),
+		tax,
+		o.Amount.Decimal(),
+		o.RefundedAmount.Decimal(),
+		spreadsheetSafe(o.PromoCode),
+		spreadsheetSafe(o.Description),
+	}
+}
+
+// spreadsheetSafe keeps a user supplied value from being run as a formula
+// when the CSV is opened in a spreadsheet.
+func spreadsheetSafe(v string) string {
+	if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+		return "'" + v
+	}
+	return v
+}
+
+// parseExportTime takes RFC3339 or a bare date, which means its midnight UTC.
+func parseExportTime(name, v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.DateOnly, v); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%s should be RFC3339 or YYYY-MM-DD", name)
+}
+
+// makeHandleExportOrders streams orders oldest first as CSV or NDJSON. Rows
+// are written as they are read, page by page, so nothing but one page is
+// held in memory. Should the database fail midway, the connection is cut
+// rather than ending the body cleanly, so a truncated file is noticed.
+// Unless allUsers, which only the back office gets, user_id is required.
+func makeHandleExportOrders(s *store.OrdersStore, allUsers bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+		q := r.URL.Query()
+		if !allUsers && q.Get("user_id") == "" {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "empty user_id"})
+			return
+		}
+		format := q.Get("format")
+		if format == "" {
+			format = formatCSV
+		}
+		if format != formatCSV && format != formatNDJSON {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "format should be csv or ndjson"})
+			return
+		}
+		from, err := parseExportTime("from", q.Get("from"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+			return
+		}
+		to, err := parseExportTime("to", q.Get("to"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+			return
+		}
+		flusher, _ := w.(http.Flusher)
+
+		cw := csv.NewWriter(w)
+		enc := json.NewEncoder(w)
+		started := false
+		start := func() {
+			started = true
+			if format == formatCSV {
+				w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+				w.Header().Set("Content-Disposition", `attachment; filename="orders.csv"`)
+				w.WriteHeader(http.StatusOK)
+				_ = cw.Write(exportHeader)
+				return
+			}
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.Header().Set("Content-Disposition", `attachment; filename="orders.ndjson"`)
+			w.WriteHeader(http.StatusOK)
+		}
+		flush := func() error {
+			cw.Flush()
+			if flusher != nil {
+				flusher.Flush()
+			}
+			return cw.Error()
+		}
+
+		n := 0
+		err = s.ExportOrders(r.Context(), store.ExportFilter{UserID: q.Get("user_id"), From: from, To: to}, func(o domain.Order) error {
+			if !started {
+				start()
+			}
+			var err error
+			if format == formatCSV {
+				err = cw.Write(exportRecord(o))
+			} else {
+				err = enc.Encode(o)
+			}
+			if err != nil {
+				return err
+			}
+			if n++; n%100 == 0 {
+				return flush()
+			}
+			return nil
+		})
+		if err != nil && !started {
+			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: "could not export orders: " + err.Error()})
+			return
+		}
+		if err != nil {
+			log.Printf("orders export aborted after %d rows: %v", n, err)
+			panic(http.ErrAbortHandler)
+		}
+		if !started {
+			start()
+		}
+		_ = flush()
+	}
+}
@@ -0,0 +1,192 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"orders/internal/domain"
+	"orders/internal/money"
+	"orders/internal/store"
+)
+
+// writeCartError maps store errors of the cart endpoints to HTTP codes.
+func writeCartError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, store.ErrNoCartItem):
+		writeJSON(w, http.StatusNotFound, domain.ErrResp{Error: err.Error()})
+	case errors.Is(err, store.ErrInvalidCartItem), errors.Is(err, store.ErrPaymentMethod):
+		writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+	case errors.Is(err, store.ErrCartFull), errors.Is(err, store.ErrEmptyCart),
+		errors.Is(err, money.ErrCurrencyMismatch),
+		errors.Is(err, store.ErrNoPromotion), errors.Is(err, store.ErrPromoNotValid),
+		errors.Is(err, store.ErrDescriptionLimit),
+		errors.Is(err, store.ErrNoAddress), errors.Is(err, store.ErrNoShippingRate):
+		writeJSON(w, http.StatusUnprocessableEntity, domain.ErrResp{Error: err.Error()})
+	case errors.Is(err, store.ErrIdempotencyConflict):
+		writeJSON(w, http.StatusConflict, domain.ErrResp{Error: err.Error()})
+	default:
+		writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: err.Error()})
+	}
+}
+
+func makeHandleGetCart(s *store.OrdersStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.CartReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+		if req.UserID == "" {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "empty user_id"})
+			return
+		}
+
+		c, err := s.GetCart(r.Context(), req.UserID)
+		if err != nil {
+			writeCartError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, c)
+	}
+}
+
+func makeHandleAddCartItem(s *store.OrdersStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.CartItemReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+		if req.UserID == "" {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "empty user_id"})
+			return
+		}
+		cur, err := money.CurrencyOrDefault(req.Currency)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+			return
+		}
+		price, err := money.Parse(string(req.Price), cur)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "invalid price: " + err.Error()})
+			return
+		}
+		if req.Quantity == 0 {
+			req.Quantity = 1
+		}
+
+		c, err := s.AddCartItem(r.Context(), req.UserID, store.CartItemInput{
+			SKU:      req.SKU,
+			Name:     req.Name,
+			Price:    price,
+			Quantity: req.Quantity,
+			Category: req.Category,
+		})
+		if err != nil {
+			writeCartError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, c)
+	}
+}
+
+func makeHandleUpdateCartItem(s *store.OrdersStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.CartQuantityReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+		if req.UserID == "" {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "empty user_id"})
+			return
+		}
+
+		c, err := s.SetCartItemQuantity(r.Context(), req.UserID, req.SKU, req.Quantity)
+		if err != nil {
+			writeCartError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, c)
+	}
+}
+
+func makeHandleRemoveCartItem(s *store.OrdersStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.CartRemoveReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+		if req.UserID == "" {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "empty user_id"})
+			return
+		}
+
+		c, err := s.SetCartItemQuantity(r.Context(), req.UserID, req.SKU, 0)
+		if err != nil {
+			writeCartError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, c)
+	}
+}
+
+func makeHandleCheckout(s *store.OrdersStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.CheckoutReq
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+		if req.UserID == "" {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "empty user_id"})
+			return
+		}
+
+		var addressID uuid.UUID
+		if req.AddressID != "" {
+			addressID, err = uuid.Parse(req.AddressID)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "invalid address_id format"})
+				return
+			}
+		}
+
+		o, err := s.Checkout(r.Context(), req.UserID, req.PromoCode, addressID, req.PaymentMethod, r.Header.Get("Idempotency-Key"))
+		if err != nil {
+			writeCartError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, o)
+	}
+}
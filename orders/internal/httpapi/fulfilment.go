@@ -0,0 +1,109 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"orders/internal/domain"
+	"orders/internal/fulfilment"
+)
+
+func writeFulfilmentError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, fulfilment.ErrNoFulfilment):
+		writeJSON(w, http.StatusNotFound, domain.ErrResp{Error: err.Error()})
+	case errors.Is(err, fulfilment.ErrInvalidTracking):
+		writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+	case errors.Is(err, fulfilment.ErrBadTransition), errors.Is(err, fulfilment.ErrNotShippable):
+		writeJSON(w, http.StatusConflict, domain.ErrResp{Error: err.Error()})
+	default:
+		writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: err.Error()})
+	}
+}
+
+func makeHandleFulfilmentQueue(s *fulfilment.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.FulfilmentQueueReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+		switch req.Status {
+		case "", domain.FulfilmentPending, domain.FulfilmentPicking, domain.FulfilmentShipped, domain.FulfilmentDelivered:
+		default:
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "unknown status"})
+			return
+		}
+
+		fs, err := s.Queue(r.Context(), req.Status, req.Limit)
+		if err != nil {
+			writeFulfilmentError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, domain.FulfilmentQueueResp{Fulfilments: fs})
+	}
+}
+
+// makeHandleFulfilmentStep serves the endpoints that take only an order id.
+func makeHandleFulfilmentStep(step func(context.Context, uuid.UUID) (domain.Fulfilment, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.FulfilmentReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+		orderUUID, err := uuid.Parse(req.OrderID)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "invalid orderID format"})
+			return
+		}
+
+		f, err := step(r.Context(), orderUUID)
+		if err != nil {
+			writeFulfilmentError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, f)
+	}
+}
+
+func makeHandleShip(s *fulfilment.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.ShipReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+		orderUUID, err := uuid.Parse(req.OrderID)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "invalid orderID format"})
+			return
+		}
+
+		f, err := s.Ship(r.Context(), orderUUID, req.Carrier, req.TrackingNumber)
+		if err != nil {
+			writeFulfilmentError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, f)
+	}
+}
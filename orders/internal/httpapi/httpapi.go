@@ -2,12 +2,20 @@ package httpapi
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
-	"strconv"
-	"strings"
+	"time"
 
 	"orders/internal/domain"
+	"orders/internal/events"
+	"orders/internal/fulfilment"
+	"orders/internal/invoice"
+	"orders/internal/money"
+	"orders/internal/openapi"
 	"orders/internal/store"
+	"orders/internal/subscriptions"
+	"orders/internal/webhooks"
 
 	"github.com/google/uuid"
 )
@@ -19,20 +27,6 @@ func writeJSON(w http.ResponseWriter, code int, v any) error {
 	return err
 }
 
-func parseAmount(amount json.Number) (int64, error) {
-	if val, err := amount.Int64(); err == nil {
-		return val, nil
-	}
-	str := amount.String()
-
-	if strings.Contains(str, ".") {
-		parts := strings.Split(str, ".")
-		str = parts[0]
-	}
-
-	return strconv.ParseInt(str, 10, 64)
-}
-
 func makeHandleCreateOrder(s *store.OrdersStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
@@ -46,24 +40,23 @@ func makeHandleCreateOrder(s *store.OrdersStore) http.HandlerFunc {
 			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
 			return
 		}
-		if req.UserID == "" {
-			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "empty user_id"})
+		n, err := newOrderFromReq(req)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
 			return
 		}
-		var amount int64
-		amount, err = parseAmount(req.Amount)
+		n.IdempotencyKey = r.Header.Get("Idempotency-Key")
 
-		if amount <= 0 {
-			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "amount should be greater than 0"})
+		o, err := s.CreateOrder(r.Context(), n)
+		if errors.Is(err, store.ErrIdempotencyConflict) {
+			writeJSON(w, http.StatusConflict, domain.ErrResp{Error: err.Error()})
 			return
 		}
-		if len(req.Description) > 200 {
-			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "description must contain less than 200 symbols"})
+		if errors.Is(err, store.ErrNoPromotion) || errors.Is(err, store.ErrPromoNotValid) ||
+			errors.Is(err, store.ErrNoAddress) || errors.Is(err, store.ErrNoShippingRate) {
+			writeJSON(w, http.StatusUnprocessableEntity, domain.ErrResp{Error: err.Error()})
 			return
 		}
-
-		var o domain.Order
-		o, err = s.CreateOrder(req.UserID, domain.Money(amount), req.Description)
 		if err != nil {
 			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
 			return
@@ -74,6 +67,100 @@ func makeHandleCreateOrder(s *store.OrdersStore) http.HandlerFunc {
 	}
 }
 
+// newOrderFromReq checks a create request the way every entry point that
+// takes one (the create endpoint and bulk import) must.
+func newOrderFromReq(req domain.CreateOrderReq) (store.NewOrder, error) {
+	if req.UserID == "" {
+		return store.NewOrder{}, errors.New("empty user_id")
+	}
+	cur, err := money.CurrencyOrDefault(req.Currency)
+	if err != nil {
+		return store.NewOrder{}, err
+	}
+	amount, err := money.Parse(string(req.Amount), cur)
+	if err != nil {
+		return store.NewOrder{}, err
+	}
+	if !amount.IsPositive() {
+		return store.NewOrder{}, errors.New("amount should be greater than 0")
+	}
+	if len(req.Description) > 200 {
+		return store.NewOrder{}, errors.New("description must contain less than 200 symbols")
+	}
+	if req.PaymentMethod != "" && req.PaymentMethod != domain.PayByBalance && req.PaymentMethod != domain.PayByCard {
+		return store.NewOrder{}, store.ErrPaymentMethod
+	}
+
+	var payers []domain.Payer
+	for _, p := range req.Payers {
+		payers = append(payers, domain.Payer{UserID: p.UserID, Share: p.Share})
+	}
+
+	var addressID uuid.UUID
+	if req.AddressID != "" {
+		addressID, err = uuid.Parse(req.AddressID)
+		if err != nil {
+			return store.NewOrder{}, errors.New("invalid address_id format")
+		}
+	}
+	return store.NewOrder{
+		UserID:        req.UserID,
+		Amount:        amount,
+		Description:   req.Description,
+		PromoCode:     req.PromoCode,
+		AddressID:     addressID,
+		Category:      req.Category,
+		PaymentMethod: req.PaymentMethod,
+		Payers:        payers,
+	}, nil
+}
+
+func listFilterFromReq(req domain.ListOrderReq) (store.ListOrdersFilter, error) {
+	f := store.ListOrdersFilter{
+		UserID: req.UserID,
+		Status: req.Status,
+		Sort:   store.SortOrder(req.Sort),
+		Cursor: req.Cursor,
+		Limit:  req.Limit,
+	}
+	if req.Currency != "" || req.MinAmount != "" || req.MaxAmount != "" {
+		cur, err := money.CurrencyOrDefault(req.Currency)
+		if err != nil {
+			return f, err
+		}
+		f.Currency = cur
+	}
+	if req.MinAmount != "" {
+		v, err := money.Parse(string(req.MinAmount), f.Currency)
+		if err != nil {
+			return f, fmt.Errorf("invalid min_amount: %w", err)
+		}
+		f.MinAmount = v.Amount
+	}
+	if req.MaxAmount != "" {
+		v, err := money.Parse(string(req.MaxAmount), f.Currency)
+		if err != nil {
+			return f, fmt.Errorf("invalid max_amount: %w", err)
+		}
+		f.MaxAmount = v.Amount
+	}
+	if req.CreatedFrom != "" {
+		t, err := time.Parse(time.RFC3339, req.CreatedFrom)
+		if err != nil {
+			return f, errors.New("created_from should be RFC3339")
+		}
+		f.CreatedFrom = t
+	}
+	if req.CreatedTo != "" {
+		t, err := time.Parse(time.RFC3339, req.CreatedTo)
+		if err != nil {
+			return f, errors.New("created_to should be RFC3339")
+		}
+		f.CreatedTo = t
+	}
+	return f, nil
+}
+
 func makeHandleListOrders(s *store.OrdersStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
@@ -91,9 +178,26 @@ func makeHandleListOrders(s *store.OrdersStore) http.HandlerFunc {
 			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "empty user_id"})
 			return
 		}
-		orders, _ := s.ListOrders(req.UserID)
+		f, err := listFilterFromReq(req)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+			return
+		}
+		page, err := s.ListOrders(r.Context(), f)
+		if errors.Is(err, store.ErrInvalidCursor) || errors.Is(err, store.ErrInvalidSort) {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: "could not list orders: " + err.Error()})
+			return
+		}
 
-		if err = writeJSON(w, http.StatusOK, orders); err != nil {
+		resp := domain.ListOrderResp{
+			Orders:     page.Orders,
+			NextCursor: page.NextCursor,
+		}
+		if err = writeJSON(w, http.StatusOK, resp); err != nil {
 			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: err.Error()})
 		}
 	}
@@ -123,11 +227,7 @@ func makeHandleGetStatus(s *store.OrdersStore) http.HandlerFunc {
 			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "invalid orderID format"})
 			return
 		}
-		var o domain.OrderStatus
-		o, err = s.GetStatus(orderUUID)
-		resp := domain.StatusResp{
-			Status: o,
-		}
+		resp, _ := s.GetStatus(r.Context(), orderUUID)
 		if err = writeJSON(w, http.StatusOK, resp); err != nil {
 			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: err.Error()})
 		}
@@ -135,8 +235,417 @@ func makeHandleGetStatus(s *store.OrdersStore) http.HandlerFunc {
 	}
 }
 
-func RegisterRoutes(mux *http.ServeMux, st *store.OrdersStore) {
-	mux.HandleFunc("/create", makeHandleCreateOrder(st))
-	mux.HandleFunc("/status", makeHandleGetStatus(st))
-	mux.HandleFunc("/list", makeHandleListOrders(st))
-}
\ No newline at end of file
+func writeEvent(w http.ResponseWriter, name string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data)
+	return err
+}
+
+// makeHandleOrderEvents streams the order's current status followed by every
+// status change as Server-Sent Events until the client goes away.
+func makeHandleOrderEvents(s *store.OrdersStore, b *events.Broker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+		orderUUID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "invalid orderID format"})
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: "streaming unsupported"})
+			return
+		}
+
+		// Subscribe before reading the current status so a change in between
+		// is not lost.
+		sub, unsubscribe := b.Subscribe(orderUUID)
+		defer unsubscribe()
+
+		st, err := s.GetStatus(r.Context(), orderUUID)
+		if errors.Is(err, store.ErrNoOrder) {
+			writeJSON(w, http.StatusNotFound, domain.ErrResp{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: err.Error()})
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+
+		ev := domain.OrderStatusEvent{
+			OrderID:             orderUUID,
+			Status:              st.Status,
+			CancellationReason:  st.CancellationReason,
+			CancellationMessage: st.CancellationMessage,
+		}
+		if err := writeEvent(w, "status", ev); err != nil {
+			return
+		}
+		flusher.Flush()
+
+		heartbeat := time.NewTicker(15 * time.Second)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case ev := <-sub:
+				if err := writeEvent(w, "status", ev); err != nil {
+					return
+				}
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+					return
+				}
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+type route struct {
+	openapi.Route
+	handler http.HandlerFunc
+}
+
+func routes(st *store.OrdersStore, b *events.Broker, wh *webhooks.Store, ff *fulfilment.Store, inv *invoice.Store, subs *subscriptions.Store) []route {
+	return []route{
+		{openapi.Route{
+			Method:     http.MethodPost,
+			Path:       "/create",
+			Summary:    "Create an order and request its payment",
+			Req:        domain.CreateOrderReq{},
+			Resp:       domain.Order{},
+			Idempotent: true,
+		}, makeHandleCreateOrder(st)},
+		{openapi.Route{
+			Method:  http.MethodPost,
+			Path:    "/status",
+			Summary: "Get order status",
+			Req:     domain.StatusReq{},
+			Resp:    domain.StatusResp{},
+		}, makeHandleGetStatus(st)},
+		{openapi.Route{
+			Method:  http.MethodPost,
+			Path:    "/list",
+			Summary: "List user orders, newest first, one page at a time",
+			Req:     domain.ListOrderReq{},
+			Resp:    domain.ListOrderResp{},
+		}, makeHandleListOrders(st)},
+		{openapi.Route{
+			Method:   http.MethodPost,
+			Path:     "/orders/import",
+			Summary:  "Create orders from a CSV (header row) or NDJSON file; reports every line",
+			Params:   []openapi.Param{{Name: "format", In: "query", Description: "csv or ndjson; taken from Content-Type if empty"}},
+			Consumes: []string{"text/csv", "application/x-ndjson"},
+			Resp:     domain.ImportOrdersResp{},
+		}, makeHandleImportOrders(st)},
+		{openapi.Route{
+			Method:  http.MethodGet,
+			Path:    "/orders/export",
+			Summary: "Download a user's orders, oldest first, streamed",
+			Params: []openapi.Param{
+				{Name: "user_id", In: "query", Description: "whose orders", Required: true},
+				{Name: "from", In: "query", Description: "created at or after, RFC3339 or YYYY-MM-DD"},
+				{Name: "to", In: "query", Description: "created before, RFC3339 or YYYY-MM-DD"},
+				{Name: "format", In: "query", Description: "csv (default) or ndjson"},
+			},
+			Produces: "text/csv",
+		}, makeHandleExportOrders(st, false)},
+		{openapi.Route{
+			Method:  http.MethodGet,
+			Path:    "/admin/orders/export",
+			Summary: "Download the orders of every user or of one, oldest first, streamed",
+			Params: []openapi.Param{
+				{Name: "user_id", In: "query", Description: "only this user's orders"},
+				{Name: "from", In: "query", Description: "created at or after, RFC3339 or YYYY-MM-DD"},
+				{Name: "to", In: "query", Description: "created before, RFC3339 or YYYY-MM-DD"},
+				{Name: "format", In: "query", Description: "csv (default) or ndjson"},
+			},
+			Produces: "text/csv",
+			Operator: true,
+		}, makeHandleExportOrders(st, true)},
+		{openapi.Route{
+			Method:   http.MethodGet,
+			Path:     "/orders/{id}/events",
+			Summary:  "Stream order status changes (Server-Sent Events)",
+			Params:   []openapi.Param{{Name: "id", In: "path", Description: "order id"}},
+			Produces: "text/event-stream",
+		}, makeHandleOrderEvents(st, b)},
+		{openapi.Route{
+			Method:  http.MethodGet,
+			Path:    "/orders/{id}/invoice",
+			Summary: "Invoice issued when the order was paid",
+			Params: []openapi.Param{
+				{Name: "id", In: "path", Description: "order id"},
+				{Name: "format", In: "query", Description: "pdf (default), html or json"},
+			},
+			Produces: "application/pdf",
+		}, makeHandleInvoice(inv)},
+		{openapi.Route{
+			Method:   http.MethodPost,
+			Path:     "/webhooks/create",
+			Summary:  "Subscribe a public URL to order events; the response carries the signing secret",
+			Req:      domain.CreateWebhookReq{},
+			Resp:     domain.CreateWebhookResp{},
+			Params:   []openapi.Param{actorParam},
+			Operator: true,
+		}, requireActor(makeHandleCreateWebhook(wh))},
+		{openapi.Route{
+			Method:   http.MethodPost,
+			Path:     "/webhooks/list",
+			Summary:  "List webhook endpoints",
+			Resp:     domain.ListWebhooksResp{},
+			Operator: true,
+		}, makeHandleListWebhooks(wh)},
+		{openapi.Route{
+			Method:   http.MethodPost,
+			Path:     "/webhooks/disable",
+			Summary:  "Stop deliveries to an endpoint (its log is kept)",
+			Req:      domain.WebhookIDReq{},
+			Resp:     domain.WebhookIDReq{},
+			Params:   []openapi.Param{actorParam},
+			Operator: true,
+		}, requireActor(makeHandleDisableWebhook(wh))},
+		{openapi.Route{
+			Method:   http.MethodPost,
+			Path:     "/webhooks/deliveries",
+			Summary:  "Delivery log of an endpoint, newest first",
+			Req:      domain.WebhookDeliveriesReq{},
+			Resp:     domain.WebhookDeliveriesResp{},
+			Operator: true,
+		}, makeHandleWebhookDeliveries(wh)},
+		{openapi.Route{
+			Method:   http.MethodPost,
+			Path:     "/webhooks/replay",
+			Summary:  "Send a delivery again",
+			Req:      domain.ReplayDeliveryReq{},
+			Resp:     domain.ReplayDeliveryReq{},
+			Params:   []openapi.Param{actorParam},
+			Operator: true,
+		}, requireActor(makeHandleReplayDelivery(wh))},
+		{openapi.Route{
+			Method:  http.MethodPost,
+			Path:    "/cart",
+			Summary: "View the user's cart with totals",
+			Req:     domain.CartReq{},
+			Resp:    domain.Cart{},
+		}, makeHandleGetCart(st)},
+		{openapi.Route{
+			Method:  http.MethodPost,
+			Path:    "/cart/items/add",
+			Summary: "Add an item to the cart (adds to the quantity if present)",
+			Req:     domain.CartItemReq{},
+			Resp:    domain.Cart{},
+		}, makeHandleAddCartItem(st)},
+		{openapi.Route{
+			Method:  http.MethodPost,
+			Path:    "/cart/items/update",
+			Summary: "Set the quantity of a cart item; 0 removes it",
+			Req:     domain.CartQuantityReq{},
+			Resp:    domain.Cart{},
+		}, makeHandleUpdateCartItem(st)},
+		{openapi.Route{
+			Method:  http.MethodPost,
+			Path:    "/cart/items/remove",
+			Summary: "Remove an item from the cart",
+			Req:     domain.CartRemoveReq{},
+			Resp:    domain.Cart{},
+		}, makeHandleRemoveCartItem(st)},
+		{openapi.Route{
+			Method:     http.MethodPost,
+			Path:       "/cart/checkout",
+			Summary:    "Create an order from the cart and empty it",
+			Req:        domain.CheckoutReq{},
+			Resp:       domain.Order{},
+			Idempotent: true,
+		}, makeHandleCheckout(st)},
+		{openapi.Route{
+			Method:   http.MethodPost,
+			Path:     "/promotions/create",
+			Summary:  "Create a promo code (PERCENT or FIXED)",
+			Req:      domain.CreatePromotionReq{},
+			Resp:     domain.Promotion{},
+			Params:   []openapi.Param{actorParam},
+			Operator: true,
+		}, requireActor(makeHandleCreatePromotion(st))},
+		{openapi.Route{
+			Method:   http.MethodPost,
+			Path:     "/promotions/list",
+			Summary:  "List promotions with their active redemption counts",
+			Resp:     domain.ListPromotionsResp{},
+			Operator: true,
+		}, makeHandleListPromotions(st)},
+		{openapi.Route{
+			Method:   http.MethodPost,
+			Path:     "/promotions/disable",
+			Summary:  "Stop accepting a promo code",
+			Req:      domain.PromotionCodeReq{},
+			Resp:     domain.PromotionCodeReq{},
+			Params:   []openapi.Param{actorParam},
+			Operator: true,
+		}, requireActor(makeHandleDisablePromotion(st))},
+		{openapi.Route{
+			Method:  http.MethodPost,
+			Path:    "/addresses/create",
+			Summary: "Add an address to the user's address book",
+			Req:     domain.CreateAddressReq{},
+			Resp:    domain.Address{},
+		}, makeHandleCreateAddress(st)},
+		{openapi.Route{
+			Method:  http.MethodPost,
+			Path:    "/addresses/list",
+			Summary: "List the user's addresses, default first",
+			Req:     domain.ListAddressesReq{},
+			Resp:    domain.ListAddressesResp{},
+		}, makeHandleListAddresses(st)},
+		{openapi.Route{
+			Method:  http.MethodPost,
+			Path:    "/addresses/delete",
+			Summary: "Remove an address from the book (orders keep their copy)",
+			Req:     domain.DeleteAddressReq{},
+			Resp:    domain.DeleteAddressReq{},
+		}, makeHandleDeleteAddress(st)},
+		{openapi.Route{
+			Method:   http.MethodPost,
+			Path:     "/fulfilment/queue",
+			Summary:  "Fulfilments in a status, oldest first (PENDING by default)",
+			Req:      domain.FulfilmentQueueReq{},
+			Resp:     domain.FulfilmentQueueResp{},
+			Operator: true,
+		}, makeHandleFulfilmentQueue(ff)},
+		{openapi.Route{
+			Method:  http.MethodPost,
+			Path:    "/fulfilment/get",
+			Summary: "Fulfilment of an order",
+			Req:     domain.FulfilmentReq{},
+			Resp:    domain.Fulfilment{},
+		}, makeHandleFulfilmentStep(ff.Get)},
+		{openapi.Route{
+			Method:   http.MethodPost,
+			Path:     "/fulfilment/pick",
+			Summary:  "Start picking a paid order (PENDING -> PICKING)",
+			Req:      domain.FulfilmentReq{},
+			Resp:     domain.Fulfilment{},
+			Params:   []openapi.Param{actorParam},
+			Operator: true,
+		}, requireActor(makeHandleFulfilmentStep(ff.Pick))},
+		{openapi.Route{
+			Method:   http.MethodPost,
+			Path:     "/fulfilment/ship",
+			Summary:  "Hand an order to the carrier (PICKING -> SHIPPED)",
+			Req:      domain.ShipReq{},
+			Resp:     domain.Fulfilment{},
+			Params:   []openapi.Param{actorParam},
+			Operator: true,
+		}, requireActor(makeHandleShip(ff))},
+		{openapi.Route{
+			Method:   http.MethodPost,
+			Path:     "/fulfilment/deliver",
+			Summary:  "Mark an order delivered (SHIPPED -> DELIVERED)",
+			Req:      domain.FulfilmentReq{},
+			Resp:     domain.Fulfilment{},
+			Params:   []openapi.Param{actorParam},
+			Operator: true,
+		}, requireActor(makeHandleFulfilmentStep(ff.Deliver))},
+		{openapi.Route{
+			Method:  http.MethodPost,
+			Path:    "/subscriptions/create",
+			Summary: "Subscribe to a recurring order on a cron or interval schedule (UTC)",
+			Req:     domain.CreateSubscriptionReq{},
+			Resp:    domain.Subscription{},
+		}, makeHandleCreateSubscription(subs)},
+		{openapi.Route{
+			Method:  http.MethodPost,
+			Path:    "/subscriptions/list",
+			Summary: "List the user's subscriptions",
+			Req:     domain.ListSubscriptionsReq{},
+			Resp:    domain.ListSubscriptionsResp{},
+		}, makeHandleListSubscriptions(subs)},
+		{openapi.Route{
+			Method:  http.MethodPost,
+			Path:    "/subscriptions/pause",
+			Summary: "Stop placing orders until resumed",
+			Req:     domain.SubscriptionReq{},
+			Resp:    domain.Subscription{},
+		}, makeHandleSubscriptionStatus(subs.Pause)},
+		{openapi.Route{
+			Method:  http.MethodPost,
+			Path:    "/subscriptions/resume",
+			Summary: "Resume a paused subscription from its next due date",
+			Req:     domain.SubscriptionReq{},
+			Resp:    domain.Subscription{},
+		}, makeHandleSubscriptionStatus(subs.Resume)},
+		{openapi.Route{
+			Method:  http.MethodPost,
+			Path:    "/subscriptions/cancel",
+			Summary: "Cancel a subscription for good",
+			Req:     domain.SubscriptionReq{},
+			Resp:    domain.Subscription{},
+		}, makeHandleSubscriptionStatus(subs.Cancel)},
+		{openapi.Route{
+			Method:   http.MethodPost,
+			Path:     "/admin/orders/search",
+			Summary:  "Find orders of any user by id, user or status, newest first",
+			Req:      domain.AdminSearchReq{},
+			Resp:     domain.AdminSearchResp{},
+			Operator: true,
+		}, makeHandleSearchOrders(st)},
+		{openapi.Route{
+			Method:   http.MethodPost,
+			Path:     "/admin/orders/cancel",
+			Summary:  "Cancel a NEW order with ADMIN_CANCELLED; void its payment in payments first",
+			Params:   []openapi.Param{actorParam},
+			Req:      domain.ForceCancelReq{},
+			Resp:     domain.Order{},
+			Operator: true,
+		}, makeHandleForceCancel(st)},
+		{openapi.Route{
+			Method:   http.MethodPost,
+			Path:     "/admin/outbox",
+			Summary:  "Outbox backlog: unpublished count and the latest (or oldest pending) messages",
+			Req:      domain.OutboxReq{},
+			Resp:     domain.OutboxBacklog{},
+			Operator: true,
+		}, makeHandleOutbox(st)},
+		{openapi.Route{
+			Method:   http.MethodPost,
+			Path:     "/admin/outbox/republish",
+			Summary:  "Publish an outbox message again with the same message id",
+			Params:   []openapi.Param{actorParam},
+			Req:      domain.RepublishReq{},
+			Resp:     domain.OutboxMessage{},
+			Operator: true,
+		}, makeHandleRepublish(st)},
+		{openapi.Route{
+			Method:   http.MethodPost,
+			Path:     "/admin/audit",
+			Summary:  "Read the audit log of changes, newest first",
+			Req:      domain.AuditQueryReq{},
+			Resp:     domain.AuditQueryResp{},
+			Operator: true,
+		}, makeHandleAuditLog(st)},
+	}
+}
+
+func RegisterRoutes(mux *http.ServeMux, serviceToken string, st *store.OrdersStore, b *events.Broker, wh *webhooks.Store, ff *fulfilment.Store, inv *invoice.Store, subs *subscriptions.Store) {
+	rs := routes(st, b, wh, ff, inv, subs)
+	spec := make([]openapi.Route, 0, len(rs))
+	for _, r := range rs {
+		mux.HandleFunc(r.Path, withBodyLimit(r.Path, guard(serviceToken, r.Route, withAuditSource(r.handler))))
+		spec = append(spec, r.Route)
+	}
+	mux.HandleFunc("/openapi.json", openapi.Handler(openapi.Info{Title: "orders", Version: "1.0.0"}, spec, domain.ErrResp{}))
+}
@@ -0,0 +1,59 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"orders/internal/domain"
+	"orders/internal/invoice"
+)
+
+var invoiceContentTypes = map[invoice.Format]string{
+	invoice.FormatPDF:  "application/pdf",
+	invoice.FormatHTML: "text/html; charset=utf-8",
+	invoice.FormatJSON: "application/json",
+}
+
+// makeHandleInvoice serves the invoice issued when the order was paid, as
+// PDF unless ?format=html or ?format=json is asked for.
+func makeHandleInvoice(s *invoice.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+		orderUUID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "invalid orderID format"})
+			return
+		}
+		format := invoice.Format(r.URL.Query().Get("format"))
+		if format == "" {
+			format = invoice.FormatPDF
+		}
+		ct, ok := invoiceContentTypes[format]
+		if !ok {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "format should be pdf, html or json"})
+			return
+		}
+
+		b, err := s.Get(r.Context(), orderUUID, format)
+		if errors.Is(err, invoice.ErrNoInvoice) {
+			writeJSON(w, http.StatusNotFound, domain.ErrResp{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: err.Error()})
+			return
+		}
+
+		w.Header().Set("Content-Type", ct)
+		if format == invoice.FormatPDF {
+			w.Header().Set("Content-Disposition", `inline; filename="invoice-`+orderUUID.String()+`.pdf"`)
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(b)
+	}
+}
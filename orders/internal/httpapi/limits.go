@@ -0,0 +1,31 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"orders/internal/domain"
+)
+
+// maxBody caps a request body; bodyLimits raise it for uploads. A body
+// declared larger is refused with 413, one that turns out larger fails to
+// decode.
+const maxBody = 1 << 20
+
+var bodyLimits = map[string]int64{
+	"/orders/import": 16 << 20,
+}
+
+func withBodyLimit(path string, h http.HandlerFunc) http.HandlerFunc {
+	n, ok := bodyLimits[path]
+	if !ok {
+		n = maxBody
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > n {
+			writeJSON(w, http.StatusRequestEntityTooLarge, domain.ErrResp{Error: "request body too large"})
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, n)
+		h(w, r)
+	}
+}
@@ -0,0 +1,141 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"orders/internal/domain"
+	"orders/internal/money"
+	"orders/internal/store"
+)
+
+func newPromotionFromReq(req domain.CreatePromotionReq) (store.NewPromotion, error) {
+	p := store.NewPromotion{
+		Code:           req.Code,
+		Kind:           req.Kind,
+		Percent:        req.Percent,
+		PerUserLimit:   req.PerUserLimit,
+		TotalLimit:     req.TotalLimit,
+		FirstOrderOnly: req.FirstOrderOnly,
+	}
+	if req.AmountOff != "" || req.MinOrder != "" {
+		cur, err := money.CurrencyOrDefault(req.Currency)
+		if err != nil {
+			return p, err
+		}
+		if req.AmountOff != "" {
+			m, err := money.Parse(string(req.AmountOff), cur)
+			if err != nil {
+				return p, fmt.Errorf("invalid amount_off: %w", err)
+			}
+			p.AmountOff = &m
+		}
+		if req.MinOrder != "" {
+			m, err := money.Parse(string(req.MinOrder), cur)
+			if err != nil {
+				return p, fmt.Errorf("invalid min_order: %w", err)
+			}
+			p.MinOrder = &m
+		}
+	}
+	if req.StartsAt != "" {
+		t, err := time.Parse(time.RFC3339, req.StartsAt)
+		if err != nil {
+			return p, errors.New("starts_at should be RFC3339")
+		}
+		p.StartsAt = &t
+	}
+	if req.ExpiresAt != "" {
+		t, err := time.Parse(time.RFC3339, req.ExpiresAt)
+		if err != nil {
+			return p, errors.New("expires_at should be RFC3339")
+		}
+		p.ExpiresAt = &t
+	}
+	return p, nil
+}
+
+func makeHandleCreatePromotion(s *store.OrdersStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.CreatePromotionReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+		np, err := newPromotionFromReq(req)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+			return
+		}
+
+		p, err := s.CreatePromotion(r.Context(), r.Header.Get(ActorHeader), np)
+		if errors.Is(err, store.ErrInvalidPromotion) || errors.Is(err, store.ErrNoActor) {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+			return
+		}
+		if errors.Is(err, store.ErrPromotionExists) {
+			writeJSON(w, http.StatusConflict, domain.ErrResp{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: "could not create promotion: " + err.Error()})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, p)
+	}
+}
+
+func makeHandleListPromotions(s *store.OrdersStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		ps, err := s.ListPromotions(r.Context())
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: "could not list promotions: " + err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, domain.ListPromotionsResp{Promotions: ps})
+	}
+}
+
+func makeHandleDisablePromotion(s *store.OrdersStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.PromotionCodeReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+
+		err := s.DisablePromotion(r.Context(), r.Header.Get(ActorHeader), req.Code)
+		if errors.Is(err, store.ErrNoActor) {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+			return
+		}
+		if errors.Is(err, store.ErrNoPromotion) {
+			writeJSON(w, http.StatusNotFound, domain.ErrResp{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: "could not disable promotion: " + err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, req)
+	}
+}
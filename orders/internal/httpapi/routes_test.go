@@ -0,0 +1,80 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestSpecMatchesRoutes checks /openapi.json against what the mux serves:
+// every route of the table is documented with its method, and every
+// documented operation reaches the handler registered for its path.
+func TestSpecMatchesRoutes(t *testing.T) {
+	mux := http.NewServeMux()
+	RegisterRoutes(mux, "", nil, nil, nil, nil, nil, nil)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /openapi.json: %d %s", rec.Code, rec.Body)
+	}
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &spec); err != nil {
+		t.Fatal(err)
+	}
+
+	rs := routes(nil, nil, nil, nil, nil, nil)
+	for _, r := range rs {
+		if _, ok := spec.Paths[r.Path][strings.ToLower(r.Method)]; !ok {
+			t.Errorf("%s %s is served but not in the spec", r.Method, r.Path)
+		}
+	}
+
+	ops := 0
+	for path, item := range spec.Paths {
+		for method := range item {
+			ops++
+			_, pattern := mux.Handler(httptest.NewRequest(strings.ToUpper(method), path, nil))
+			if pattern != path {
+				t.Errorf("%s %s is in the spec but the mux serves it with %q", strings.ToUpper(method), path, pattern)
+			}
+		}
+	}
+	if ops != len(rs) {
+		t.Errorf("spec has %d operations, route table %d", ops, len(rs))
+	}
+}
+
+// TestOperatorRoutesNeedServiceToken checks that no back-office route is
+// served to a caller without the service token.
+func TestOperatorRoutesNeedServiceToken(t *testing.T) {
+	mux := http.NewServeMux()
+	RegisterRoutes(mux, "secret", nil, nil, nil, nil, nil, nil)
+
+	n := 0
+	for _, r := range routes(nil, nil, nil, nil, nil, nil) {
+		if !r.Operator {
+			continue
+		}
+		n++
+		for _, token := range []string{"", "wrong"} {
+			req := httptest.NewRequest(r.Method, r.Path, strings.NewReader("{}"))
+			req.Header.Set(ActorHeader, "mallory")
+			if token != "" {
+				req.Header.Set(ServiceTokenHeader, token)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != http.StatusUnauthorized {
+				t.Errorf("%s %s with token %q: %d, want 401", r.Method, r.Path, token, rec.Code)
+			}
+		}
+	}
+	if n == 0 {
+		t.Error("no operator routes")
+	}
+}
@@ -0,0 +1,168 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+
+	"orders/internal/domain"
+	"orders/internal/money"
+	"orders/internal/store"
+	"orders/internal/subscriptions"
+)
+
+func newSubscriptionFromReq(req domain.CreateSubscriptionReq) (subscriptions.NewSubscription, error) {
+	n := subscriptions.NewSubscription{
+		UserID:      req.UserID,
+		Description: req.Description,
+		Category:    req.Category,
+		Schedule:    req.Schedule,
+		MaxFailures: req.MaxFailures,
+	}
+	cur, err := money.CurrencyOrDefault(req.Currency)
+	if err != nil {
+		return n, err
+	}
+	for _, it := range req.Items {
+		price, err := money.Parse(string(it.Price), cur)
+		if err != nil {
+			return n, fmt.Errorf("invalid price of %s: %w", it.SKU, err)
+		}
+		if it.Quantity == 0 {
+			it.Quantity = 1
+		}
+		n.Items = append(n.Items, store.CartItemInput{
+			SKU:      it.SKU,
+			Name:     it.Name,
+			Price:    price,
+			Quantity: it.Quantity,
+			Category: it.Category,
+		})
+	}
+	if len(req.Items) == 0 {
+		if n.Amount, err = money.Parse(string(req.Amount), cur); err != nil {
+			return n, fmt.Errorf("invalid amount: %w", err)
+		}
+	}
+	if req.AddressID != "" {
+		if n.AddressID, err = uuid.Parse(req.AddressID); err != nil {
+			return n, errors.New("invalid address_id format")
+		}
+	}
+	if req.StartAt != "" {
+		if n.StartAt, err = time.Parse(time.RFC3339, req.StartAt); err != nil {
+			return n, errors.New("start_at should be RFC3339")
+		}
+	}
+	return n, nil
+}
+
+func writeSubscriptionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, subscriptions.ErrNoSubscription):
+		writeJSON(w, http.StatusNotFound, domain.ErrResp{Error: err.Error()})
+	case errors.Is(err, subscriptions.ErrInvalidSubscription), errors.Is(err, subscriptions.ErrBadSchedule),
+		errors.Is(err, store.ErrInvalidCartItem), errors.Is(err, store.ErrInvalidPrice),
+		errors.Is(err, store.ErrDescriptionLimit), errors.Is(err, store.ErrCategoryLimit),
+		errors.Is(err, money.ErrCurrencyMismatch):
+		writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+	case errors.Is(err, subscriptions.ErrSubscriptionState):
+		writeJSON(w, http.StatusConflict, domain.ErrResp{Error: err.Error()})
+	default:
+		writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: err.Error()})
+	}
+}
+
+func makeHandleCreateSubscription(s *subscriptions.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.CreateSubscriptionReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+		if req.UserID == "" {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "empty user_id"})
+			return
+		}
+		n, err := newSubscriptionFromReq(req)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+			return
+		}
+
+		sub, err := s.Create(r.Context(), n)
+		if err != nil {
+			writeSubscriptionError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, sub)
+	}
+}
+
+func makeHandleListSubscriptions(s *subscriptions.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.ListSubscriptionsReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+		if req.UserID == "" {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "empty user_id"})
+			return
+		}
+
+		subs, err := s.List(r.Context(), req.UserID)
+		if err != nil {
+			writeSubscriptionError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, domain.ListSubscriptionsResp{Subscriptions: subs})
+	}
+}
+
+// makeHandleSubscriptionStatus serves pause, resume and cancel.
+func makeHandleSubscriptionStatus(change func(context.Context, string, uuid.UUID) (domain.Subscription, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.SubscriptionReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+		if req.UserID == "" {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "empty user_id"})
+			return
+		}
+		id, err := uuid.Parse(req.ID)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "invalid id format"})
+			return
+		}
+
+		sub, err := change(r.Context(), req.UserID, id)
+		if err != nil {
+			writeSubscriptionError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, sub)
+	}
+}
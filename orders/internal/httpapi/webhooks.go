@@ -0,0 +1,140 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"orders/internal/domain"
+	"orders/internal/webhooks"
+)
+
+func makeHandleCreateWebhook(s *webhooks.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.CreateWebhookReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+
+		e, secret, err := s.CreateEndpoint(r.Context(), req.URL, req.Secret, req.EventTypes)
+		if errors.Is(err, webhooks.ErrInvalidURL) || errors.Is(err, webhooks.ErrPrivateURL) ||
+			errors.Is(err, webhooks.ErrUnknownEventType) {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: "could not create webhook: " + err.Error()})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, domain.CreateWebhookResp{Endpoint: e, Secret: secret})
+	}
+}
+
+func makeHandleListWebhooks(s *webhooks.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		endpoints, err := s.ListEndpoints(r.Context())
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: "could not list webhooks: " + err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, domain.ListWebhooksResp{Endpoints: endpoints})
+	}
+}
+
+func makeHandleDisableWebhook(s *webhooks.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.WebhookIDReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+		id, err := uuid.Parse(req.ID)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "invalid id format"})
+			return
+		}
+
+		err = s.DisableEndpoint(r.Context(), id)
+		if errors.Is(err, webhooks.ErrNoEndpoint) {
+			writeJSON(w, http.StatusNotFound, domain.ErrResp{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: "could not disable webhook: " + err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, req)
+	}
+}
+
+func makeHandleWebhookDeliveries(s *webhooks.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.WebhookDeliveriesReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+		id, err := uuid.Parse(req.EndpointID)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "invalid endpoint_id format"})
+			return
+		}
+
+		deliveries, err := s.ListDeliveries(r.Context(), id, req.Status, req.Limit)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: "could not list deliveries: " + err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, domain.WebhookDeliveriesResp{Deliveries: deliveries})
+	}
+}
+
+func makeHandleReplayDelivery(s *webhooks.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.ReplayDeliveryReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+
+		err := s.Replay(r.Context(), req.DeliveryID)
+		if errors.Is(err, webhooks.ErrNoDelivery) {
+			writeJSON(w, http.StatusNotFound, domain.ErrResp{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: "could not replay delivery: " + err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, req)
+	}
+}
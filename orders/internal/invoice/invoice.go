@@ -0,0 +1,152 @@
+// Package invoice issues numbered receipts for paid orders and keeps their
+// rendered PDF and HTML.
+package invoice
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+
+	"orders/internal/domain"
+	"orders/internal/money"
+	"orders/internal/store"
+)
+
+var ErrNoInvoice = errors.New("no invoice for this order")
+
+type Format string
+
+const (
+	FormatPDF  Format = "pdf"
+	FormatHTML Format = "html"
+	FormatJSON Format = "json"
+)
+
+// Generator renders and stores invoices. Without a TTF font the PDF uses a
+// core font, which only has Latin-1 glyphs.
+type Generator struct {
+	seller string
+	font   []byte
+}
+
+func NewGenerator(seller string, font []byte) *Generator {
+	return &Generator{seller: seller, font: font}
+}
+
+// LoadGenerator reads INVOICE_SELLER and INVOICE_FONT (path to a TTF file).
+func LoadGenerator() (*Generator, error) {
+	seller := os.Getenv("INVOICE_SELLER")
+	if seller == "" {
+		seller = "KPO Online Shop"
+	}
+	var font []byte
+	if path := os.Getenv("INVOICE_FONT"); path != "" {
+		var err error
+		if font, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	return NewGenerator(seller, font), nil
+}
+
+// Issue numbers, renders and stores the invoice of a paid order as part of
+// tx. The counter row stays locked until tx ends and goes back with it on
+// rollback, so numbers have no gaps. Issuing twice is a no-op.
+func (g *Generator) Issue(ctx context.Context, tx *sql.Tx, orderID uuid.UUID) error {
+	var exists bool
+	err := tx.QueryRowContext(ctx, `select exists(select 1 from invoices where order_id = $1)`, orderID).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+
+	o, err := store.GetOrderTx(ctx, tx, orderID)
+	if err != nil {
+		return err
+	}
+
+	issuedAt := time.Now().UTC()
+	year := issuedAt.Year()
+	var seq int
+	err = tx.QueryRowContext(ctx,
+		`insert into invoice_counters(year, last) values ($1, 1)
+		 on conflict (year) do update set last = invoice_counters.last + 1
+		 returning last`, year,
+	).Scan(&seq)
+	if err != nil {
+		return err
+	}
+
+	inv := domain.Invoice{
+		Number:      fmt.Sprintf("INV-%d-%06d", year, seq),
+		OrderID:     o.ID,
+		IssuedAt:    issuedAt,
+		Seller:      g.seller,
+		Payer:       domain.InvoicePayer{UserID: o.UserID, Address: o.ShippingAddress},
+		Description: o.Description,
+		PromoCode:   o.PromoCode,
+		Tax:         breakdown(o),
+	}
+
+	html, err := renderHTML(inv)
+	if err != nil {
+		return err
+	}
+	pdf, err := renderPDF(inv, g.font)
+	if err != nil {
+		return err
+	}
+	data, _ := json.Marshal(inv)
+
+	_, err = tx.ExecContext(ctx,
+		`insert into invoices(number, order_id, year, seq, issued_at, data, html, pdf)
+		 values ($1,$2,$3,$4,$5,$6,$7,$8)`,
+		inv.Number, inv.OrderID, year, seq, inv.IssuedAt, string(data), string(html), pdf,
+	)
+	return err
+}
+
+// breakdown is the order's tax breakdown; orders from before tax was
+// computed get a single untaxed line.
+func breakdown(o domain.Order) domain.TaxBreakdown {
+	if o.Tax != nil {
+		return *o.Tax
+	}
+	zero := money.New(0, o.Amount.Currency)
+	return domain.TaxBreakdown{
+		Lines: []domain.TaxLine{{
+			Name: o.Description, Quantity: 1, Rate: "0",
+			Discount: o.Discount, Net: o.Amount, Tax: zero, Gross: o.Amount,
+		}},
+		Net:   o.Amount,
+		Tax:   zero,
+		Gross: o.Amount,
+	}
+}
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// Get returns the stored invoice of an order in format f.
+func (s *Store) Get(ctx context.Context, orderID uuid.UUID, f Format) ([]byte, error) {
+	col := map[Format]string{FormatPDF: "pdf", FormatHTML: "html", FormatJSON: "data"}[f]
+	if col == "" {
+		return nil, fmt.Errorf("unknown invoice format %q", f)
+	}
+	var b []byte
+	err := s.db.QueryRowContext(ctx, `select `+col+` from invoices where order_id = $1`, orderID).Scan(&b)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoInvoice
+	}
+	return b, err
+}
@@ -0,0 +1,161 @@
+package invoice
+
+import (
+	"bytes"
+	"html/template"
+	"strconv"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+
+	"orders/internal/domain"
+)
+
+var htmlTmpl = template.Must(template.New("invoice").Funcs(template.FuncMap{
+	"addr": addressLines,
+}).Parse(`<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Invoice {{.Number}}</title>
+<style>
+body { font-family: sans-serif; margin: 32px; color: #222; }
+table { border-collapse: collapse; width: 100%; margin-top: 16px; }
+th, td { border-bottom: 1px solid #ddd; padding: 6px 8px; text-align: left; }
+td.num, th.num { text-align: right; }
+tfoot td { font-weight: bold; }
+.small { color: #666; font-size: 12px; }
+</style>
+</head>
+<body>
+<h1>Invoice {{.Number}}</h1>
+<p class="small">Issued {{.IssuedAt.Format "2006-01-02 15:04 UTC"}} &middot; order {{.OrderID}}</p>
+<p><b>Seller:</b> {{.Seller}}</p>
+<p><b>Payer:</b> {{.Payer.UserID}}{{with .Payer.Address}}<br>{{range addr .}}{{.}}<br>{{end}}{{end}}</p>
+{{with .Description}}<p>{{.}}</p>{{end}}
+<table>
+<thead><tr><th>Item</th><th class="num">Qty</th><th class="num">Discount</th><th class="num">Tax rate</th><th class="num">Net</th><th class="num">Tax</th><th class="num">Gross</th></tr></thead>
+<tbody>
+{{range .Tax.Lines}}<tr><td>{{.Name}}{{with .SKU}} <span class="small">({{.}})</span>{{end}}</td><td class="num">{{.Quantity}}</td><td class="num">{{.Discount.Decimal}}</td><td class="num">{{.Rate}}%</td><td class="num">{{.Net.Decimal}}</td><td class="num">{{.Tax.Decimal}}</td><td class="num">{{.Gross.Decimal}}</td></tr>
+{{end}}</tbody>
+<tfoot><tr><td colspan="4">Total, {{.Tax.Gross.Currency}}</td><td class="num">{{.Tax.Net.Decimal}}</td><td class="num">{{.Tax.Tax.Decimal}}</td><td class="num">{{.Tax.Gross.Decimal}}</td></tr></tfoot>
+</table>
+<p class="small">{{if .Tax.Inclusive}}Prices include tax.{{else}}Tax is added to prices.{{end}}{{with .PromoCode}} Promo code {{.}}.{{end}}</p>
+</body>
+</html>
+`))
+
+func renderHTML(inv domain.Invoice) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := htmlTmpl.Execute(&buf, inv); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func addressLines(a *domain.Address) []string {
+	lines := []string{a.Recipient, a.Line1}
+	if a.Line2 != "" {
+		lines = append(lines, a.Line2)
+	}
+	city := a.PostalCode + " " + a.City
+	if a.Region != "" {
+		city += ", " + a.Region
+	}
+	return append(lines, city, a.Country)
+}
+
+func renderPDF(inv domain.Invoice, font []byte) ([]byte, error) {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	// Fixed dates keep the same invoice byte-for-byte identical.
+	pdf.SetCreationDate(inv.IssuedAt)
+	pdf.SetModificationDate(inv.IssuedAt)
+	pdf.SetTitle("Invoice "+inv.Number, true)
+
+	family, tr := "Helvetica", pdf.UnicodeTranslatorFromDescriptor("")
+	if font != nil {
+		pdf.AddUTF8FontFromBytes("invoice", "", font)
+		pdf.AddUTF8FontFromBytes("invoice", "B", font)
+		family, tr = "invoice", func(s string) string { return s }
+	}
+	pdf.AddPage()
+
+	pdf.SetFont(family, "B", 18)
+	pdf.CellFormat(0, 10, tr("Invoice "+inv.Number), "", 1, "L", false, 0, "")
+	pdf.SetFont(family, "", 9)
+	pdf.CellFormat(0, 5, tr("Issued "+inv.IssuedAt.Format("2006-01-02 15:04 UTC")+" · order "+inv.OrderID.String()), "", 1, "L", false, 0, "")
+	pdf.Ln(4)
+
+	pdf.SetFont(family, "", 10)
+	pdf.CellFormat(0, 5, tr("Seller: "+inv.Seller), "", 1, "L", false, 0, "")
+	pdf.CellFormat(0, 5, tr("Payer: "+inv.Payer.UserID), "", 1, "L", false, 0, "")
+	if a := inv.Payer.Address; a != nil {
+		for _, l := range addressLines(a) {
+			pdf.CellFormat(0, 5, tr("    "+l), "", 1, "L", false, 0, "")
+		}
+	}
+	if inv.Description != "" {
+		pdf.Ln(2)
+		pdf.MultiCell(0, 5, tr(inv.Description), "", "L", false)
+	}
+	pdf.Ln(4)
+
+	widths := []float64{70, 12, 22, 16, 20, 20, 20}
+	header := []string{"Item", "Qty", "Discount", "Rate", "Net", "Tax", "Gross"}
+	pdf.SetFont(family, "B", 9)
+	for i, h := range header {
+		align := "R"
+		if i == 0 {
+			align = "L"
+		}
+		pdf.CellFormat(widths[i], 7, h, "B", 0, align, false, 0, "")
+	}
+	pdf.Ln(-1)
+
+	pdf.SetFont(family, "", 9)
+	for _, l := range inv.Tax.Lines {
+		cells := []string{
+			truncate(l.Name, 40), strconv.Itoa(l.Quantity), l.Discount.Decimal(), l.Rate + "%",
+			l.Net.Decimal(), l.Tax.Decimal(), l.Gross.Decimal(),
+		}
+		for i, c := range cells {
+			align := "R"
+			if i == 0 {
+				align = "L"
+			}
+			pdf.CellFormat(widths[i], 6, tr(c), "", 0, align, false, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+
+	pdf.SetFont(family, "B", 9)
+	pdf.CellFormat(widths[0]+widths[1]+widths[2]+widths[3], 7, "Total, "+string(inv.Tax.Gross.Currency), "T", 0, "L", false, 0, "")
+	pdf.CellFormat(widths[4], 7, inv.Tax.Net.Decimal(), "T", 0, "R", false, 0, "")
+	pdf.CellFormat(widths[5], 7, inv.Tax.Tax.Decimal(), "T", 0, "R", false, 0, "")
+	pdf.CellFormat(widths[6], 7, inv.Tax.Gross.Decimal(), "T", 1, "R", false, 0, "")
+
+	pdf.Ln(4)
+	pdf.SetFont(family, "", 8)
+	note := "Tax is added to prices."
+	if inv.Tax.Inclusive {
+		note = "Prices include tax."
+	}
+	if inv.PromoCode != "" {
+		note += " Promo code " + inv.PromoCode + "."
+	}
+	pdf.CellFormat(0, 5, tr(note), "", 1, "L", false, 0, "")
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return strings.TrimSpace(string(r[:n-1])) + "…"
+}
@@ -10,21 +10,41 @@ import (
 	"github.com/IBM/sarama"
 	"github.com/google/uuid"
 
+	"orders/internal/audit"
 	"orders/internal/domain"
+	"orders/internal/events"
+	"orders/internal/fulfilment"
+	"orders/internal/invoice"
+	"orders/internal/store"
+	"orders/internal/subscriptions"
 )
 
 type PaymentResult struct {
 	MessageID uuid.UUID `json:"message_id"`
 	OrderID   uuid.UUID `json:"order_id"`
 	Status    string    `json:"status"` // "SUCCESS"/"FAILED"
+	// Reason is the code of a FAILED payment and Message its text.
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type PaymentRefunded struct {
+	MessageID     uuid.UUID `json:"message_id"`
+	RefundID      uuid.UUID `json:"refund_id"`
+	OrderID       uuid.UUID `json:"order_id"`
+	Amount        int64     `json:"amount"`
+	RefundedTotal int64     `json:"refunded_total"`
+	Captured      int64     `json:"captured"`
+	Currency      string    `json:"currency"`
 }
 
 type PaymentResultConsumer struct {
-	db *sql.DB
+	db       *sql.DB
+	invoices *invoice.Generator
 }
 
-func NewPaymentResultConsumer(db *sql.DB) *PaymentResultConsumer {
-	return &PaymentResultConsumer{db: db}
+func NewPaymentResultConsumer(db *sql.DB, invoices *invoice.Generator) *PaymentResultConsumer {
+	return &PaymentResultConsumer{db: db, invoices: invoices}
 }
 
 type paymentResultHandler struct {
@@ -36,8 +56,15 @@ func (h *paymentResultHandler) Cleanup(sarama.ConsumerGroupSession) error { retu
 
 func (h *paymentResultHandler) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
 	for msg := range claim.Messages() {
-		if err := h.c.handle(sess.Context(), msg.Value); err != nil {
-			log.Printf("payments.result handle error: %v", err)
+		var err error
+		switch msg.Topic {
+		case "payments.refunded":
+			err = h.c.handleRefund(sess.Context(), msg.Value)
+		default:
+			err = h.c.handle(sess.Context(), msg.Value)
+		}
+		if err != nil {
+			log.Printf("%s handle error: %v", msg.Topic, err)
 			continue
 		}
 		sess.MarkMessage(msg, "")
@@ -55,7 +82,7 @@ func (c *PaymentResultConsumer) Run(ctx context.Context) {
 	h := &paymentResultHandler{c: c}
 
 	for {
-		if err := cg.Consume(ctx, []string{"payments.result"}, h); err != nil {
+		if err := cg.Consume(ctx, []string{"payments.result", "payments.refunded"}, h); err != nil {
 			log.Printf("orders consumer error: %v", err)
 			time.Sleep(500 * time.Millisecond)
 		}
@@ -70,13 +97,166 @@ func (c *PaymentResultConsumer) handle(ctx context.Context, payload []byte) erro
 	if err := json.Unmarshal(payload, &ev); err != nil {
 		return err
 	}
+	ctx = audit.WithSource(ctx, audit.Source{Actor: "kafka:payments.result", RequestID: ev.MessageID.String()})
 
 	newStatus := domain.OrderCancelled
+	eventType := store.EventOrderCancelled
+	action := audit.OrderCancel
+	var reason, message any
 	if ev.Status == "SUCCESS" {
 		newStatus = domain.OrderFinished
+		eventType = store.EventOrderPaid
+		action = audit.OrderPaid
+	} else {
+		if ev.Reason == "" {
+			ev.Reason = domain.ReasonPaymentFailed
+		}
+		reason, message = ev.Reason, ev.Message
+	}
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
 	}
+	defer func() { _ = tx.Rollback() }()
 
-	
-	_, err := c.db.ExecContext(ctx, `update orders set status = $2 where id = $1`, ev.OrderID, string(newStatus))
-	return err
+	var userID, currency string
+	var amount int64
+	var subscriptionID uuid.NullUUID
+	err = tx.QueryRowContext(ctx,
+		`update orders set status = $2, cancellation_reason = $4, cancellation_message = $5
+		 where id = $1 and status = $3
+		 returning user_id, amount, currency, subscription_id`,
+		ev.OrderID, string(newStatus), string(domain.OrderNew), reason, message,
+	).Scan(&userID, &amount, &currency, &subscriptionID)
+	if err == sql.ErrNoRows {
+		// Unknown order or a redelivered result: nothing changed.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if newStatus == domain.OrderCancelled {
+		if err := store.ReleasePromotion(ctx, tx, ev.OrderID); err != nil {
+			return err
+		}
+	} else {
+		if err := fulfilment.Open(ctx, tx, ev.OrderID); err != nil {
+			return err
+		}
+		if err := c.invoices.Issue(ctx, tx, ev.OrderID); err != nil {
+			return err
+		}
+	}
+	if subscriptionID.Valid {
+		err := subscriptions.RecordPayment(ctx, tx, subscriptionID.UUID, newStatus == domain.OrderFinished)
+		if err != nil {
+			return err
+		}
+	}
+	err = events.Notify(ctx, tx, domain.OrderStatusEvent{
+		OrderID:             ev.OrderID,
+		Status:              newStatus,
+		CancellationReason:  ev.Reason,
+		CancellationMessage: ev.Message,
+	})
+	if err != nil {
+		return err
+	}
+	err = store.InsertOrderEventOutbox(ctx, tx, store.OrderEvent{
+		Type:                eventType,
+		OrderID:             ev.OrderID,
+		UserID:              userID,
+		Amount:              amount,
+		Currency:            currency,
+		Status:              newStatus,
+		CancellationReason:  ev.Reason,
+		CancellationMessage: ev.Message,
+	})
+	if err != nil {
+		return err
+	}
+	err = audit.Record(ctx, tx, action, audit.Target("order", ev.OrderID),
+		orderState{Status: domain.OrderNew},
+		orderState{Status: newStatus, CancellationReason: ev.Reason, CancellationMessage: ev.Message},
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// orderState is the part of an order the consumer changes, as recorded in
+// the audit log.
+type orderState struct {
+	Status              domain.OrderStatus `json:"status"`
+	CancellationReason  string             `json:"cancellation_reason,omitempty"`
+	CancellationMessage string             `json:"cancellation_message,omitempty"`
+	RefundedAmount      int64              `json:"refunded_amount,omitempty"`
+}
+
+// handleRefund moves a paid order to PARTIALLY_REFUNDED or REFUNDED. The
+// refunded total only grows, so redelivered or reordered events are no-ops.
+func (c *PaymentResultConsumer) handleRefund(ctx context.Context, payload []byte) error {
+	var ev PaymentRefunded
+	if err := json.Unmarshal(payload, &ev); err != nil {
+		return err
+	}
+	ctx = audit.WithSource(ctx, audit.Source{Actor: "kafka:payments.refunded", RequestID: ev.MessageID.String()})
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var userID, currency, st, oldSt string
+	var amount, oldRefunded int64
+	err = tx.QueryRowContext(ctx,
+		`update orders o
+		 set refunded_amount = $2,
+		     status = case when $2 >= o.amount then $3 else $4 end
+		 from (select id, status, refunded_amount from orders where id = $1 for update) old
+		 where o.id = old.id and o.refunded_amount < $2 and o.status in ($4, $5)
+		 returning o.user_id, o.amount, o.currency, o.status, old.status, old.refunded_amount`,
+		ev.OrderID, ev.RefundedTotal,
+		string(domain.OrderRefunded), string(domain.OrderPartiallyRefunded), string(domain.OrderFinished),
+	).Scan(&userID, &amount, &currency, &st, &oldSt, &oldRefunded)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	newStatus := domain.OrderStatus(st)
+	eventType := store.EventOrderPartiallyRefunded
+	if newStatus == domain.OrderRefunded {
+		eventType = store.EventOrderRefunded
+	}
+
+	if err := events.NotifyStatus(ctx, tx, ev.OrderID, newStatus); err != nil {
+		return err
+	}
+	err = store.InsertOrderEventOutbox(ctx, tx, store.OrderEvent{
+		Type:           eventType,
+		OrderID:        ev.OrderID,
+		UserID:         userID,
+		Amount:         amount,
+		Currency:       currency,
+		Status:         newStatus,
+		RefundedAmount: ev.RefundedTotal,
+	})
+	if err != nil {
+		return err
+	}
+	err = audit.Record(ctx, tx, audit.OrderRefund, audit.Target("order", ev.OrderID),
+		orderState{Status: domain.OrderStatus(oldSt), RefundedAmount: oldRefunded},
+		orderState{Status: newStatus, RefundedAmount: ev.RefundedTotal},
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
@@ -0,0 +1,207 @@
+// Package money is an amount in minor units of an ISO-4217 currency, with
+// strict decimal parsing.
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Currency is an ISO-4217 alphabetic code.
+type Currency string
+
+// exponents are the ISO-4217 minor unit exponents of supported currencies.
+var exponents = map[Currency]int{
+	"RUB": 2,
+	"USD": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"CNY": 2,
+	"KZT": 2,
+	"BYN": 2,
+	"JPY": 0,
+	"KWD": 3,
+}
+
+var (
+	ErrUnknownCurrency  = errors.New("unknown currency")
+	ErrInvalidAmount    = errors.New("amount should be a decimal number like 10 or 10.99")
+	ErrTooPrecise       = errors.New("amount has more decimal places than the currency allows")
+	ErrAmountOverflow   = errors.New("amount is too large")
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+)
+
+// ParseCurrency accepts a code in any case and checks that it is supported.
+func ParseCurrency(s string) (Currency, error) {
+	c := Currency(strings.ToUpper(strings.TrimSpace(s)))
+	if _, ok := exponents[c]; !ok {
+		return "", fmt.Errorf("%w: %q", ErrUnknownCurrency, s)
+	}
+	return c, nil
+}
+
+// Default is the currency used when a request does not name one
+// (DEFAULT_CURRENCY, RUB if unset).
+func Default() Currency {
+	if c, err := ParseCurrency(os.Getenv("DEFAULT_CURRENCY")); err == nil {
+		return c
+	}
+	return "RUB"
+}
+
+// CurrencyOrDefault parses s, falling back to Default when s is empty.
+func CurrencyOrDefault(s string) (Currency, error) {
+	if s == "" {
+		return Default(), nil
+	}
+	return ParseCurrency(s)
+}
+
+func (c Currency) Exponent() int {
+	return exponents[c]
+}
+
+type Money struct {
+	Amount   int64 // minor units
+	Currency Currency
+}
+
+func New(minor int64, c Currency) Money {
+	return Money{Amount: minor, Currency: c}
+}
+
+// Parse reads a decimal amount in major units ("10", "10.5", "10.99") of
+// currency c. More fractional digits than c allows is an error, never a
+// silent truncation.
+func Parse(s string, c Currency) (Money, error) {
+	exp, ok := exponents[c]
+	if !ok {
+		return Money{}, fmt.Errorf("%w: %q", ErrUnknownCurrency, string(c))
+	}
+
+	s = strings.TrimSpace(s)
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	whole, frac, hasPoint := strings.Cut(s, ".")
+	if whole == "" || !digits(whole) || (hasPoint && (frac == "" || !digits(frac))) {
+		return Money{}, ErrInvalidAmount
+	}
+	if len(frac) > exp {
+		if strings.Trim(frac[exp:], "0") != "" {
+			return Money{}, ErrTooPrecise
+		}
+		frac = frac[:exp]
+	}
+	frac += strings.Repeat("0", exp-len(frac))
+
+	minor, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return Money{}, ErrAmountOverflow
+	}
+	if neg {
+		minor = -minor
+	}
+	return Money{Amount: minor, Currency: c}, nil
+}
+
+func digits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Decimal formats the amount in major units with exactly the currency's
+// number of fractional digits.
+func (m Money) Decimal() string {
+	exp := m.Currency.Exponent()
+	a := m.Amount
+	sign := ""
+	if a < 0 {
+		sign = "-"
+		if a == math.MinInt64 {
+			return sign + "9223372036854775808"
+		}
+		a = -a
+	}
+	s := strconv.FormatInt(a, 10)
+	if exp == 0 {
+		return sign + s
+	}
+	if len(s) <= exp {
+		s = strings.Repeat("0", exp-len(s)+1) + s
+	}
+	return sign + s[:len(s)-exp] + "." + s[len(s)-exp:]
+}
+
+func (m Money) String() string {
+	return m.Decimal() + " " + string(m.Currency)
+}
+
+func (m Money) IsPositive() bool {
+	return m.Amount > 0
+}
+
+// Add returns m+o; both must be in the same currency.
+func (m Money) Add(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return Money{}, ErrCurrencyMismatch
+	}
+	return Money{Amount: m.Amount + o.Amount, Currency: m.Currency}, nil
+}
+
+// Sub returns m-o; both must be in the same currency.
+func (m Money) Sub(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return Money{}, ErrCurrencyMismatch
+	}
+	return Money{Amount: m.Amount - o.Amount, Currency: m.Currency}, nil
+}
+
+type wire struct {
+	Value    string   `json:"value"`
+	Currency Currency `json:"currency"`
+}
+
+// MarshalJSON writes {"value": "10.99", "currency": "RUB"}; the value is a
+// string so that no client parses it as a float.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(wire{Value: m.Decimal(), Currency: m.Currency})
+}
+
+func (m *Money) UnmarshalJSON(b []byte) error {
+	var w wire
+	if err := json.Unmarshal(b, &w); err != nil {
+		return err
+	}
+	c, err := ParseCurrency(string(w.Currency))
+	if err != nil {
+		return err
+	}
+	v, err := Parse(w.Value, c)
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+// OpenAPISchema describes the JSON form for the generated spec.
+func (Money) OpenAPISchema() map[string]any {
+	return map[string]any{
+		"type":     "object",
+		"required": []string{"value", "currency"},
+		"properties": map[string]any{
+			"value":    map[string]any{"type": "string", "example": "10.99"},
+			"currency": map[string]any{"type": "string", "example": "RUB"},
+		},
+	}
+}
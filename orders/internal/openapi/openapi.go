@@ -0,0 +1,264 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Route describes one HTTP endpoint. Req and Resp are zero values of the
+// exact types the handler decodes and encodes; the spec is built from them
+// by reflection, so it cannot drift from the handlers.
+type Route struct {
+	Method     string
+	Path       string
+	Summary    string
+	Req        any
+	Resp       any
+	Params     []Param
+	Idempotent bool // accepts an Idempotency-Key header
+	// Operator routes belong to the back office: only the frontend calls them,
+	// with the service token in X-Service-Token.
+	Operator bool
+	Produces string   // response content type when it is not JSON; Resp is ignored
+	Consumes []string // request content types when the body is not JSON; Req is ignored
+}
+
+type Param struct {
+	Name        string
+	In          string // "query" / "path" / "header"
+	Description string
+	Required    bool
+}
+
+type Info struct {
+	Title   string
+	Version string
+}
+
+// Schemaer is implemented by types whose JSON form differs from their Go
+// fields (custom MarshalJSON).
+type Schemaer interface {
+	OpenAPISchema() map[string]any
+}
+
+var (
+	schemaerType = reflect.TypeOf((*Schemaer)(nil)).Elem()
+	timeType     = reflect.TypeOf(time.Time{})
+	uuidType     = reflect.TypeOf(uuid.UUID{})
+	numberType   = reflect.TypeOf(json.Number(""))
+	rawType      = reflect.TypeOf(json.RawMessage(nil))
+)
+
+type generator struct {
+	schemas map[string]any
+}
+
+// Build returns an OpenAPI 3 document for routes. Every operation gets the
+// shared "ErrResp" schema as its default error response.
+func Build(info Info, routes []Route, errResp any) map[string]any {
+	g := &generator{schemas: map[string]any{}}
+	errRef := g.schema(reflect.TypeOf(errResp))
+
+	paths := map[string]any{}
+	for _, r := range routes {
+		op := map[string]any{
+			"summary":     r.Summary,
+			"operationId": operationID(r),
+		}
+
+		var params []any
+		for _, p := range r.Params {
+			params = append(params, map[string]any{
+				"name":        p.Name,
+				"in":          p.In,
+				"description": p.Description,
+				"required":    p.Required || p.In == "path",
+				"schema":      map[string]any{"type": "string"},
+			})
+		}
+		if r.Idempotent {
+			params = append(params, map[string]any{
+				"name":        "Idempotency-Key",
+				"in":          "header",
+				"description": "Repeating a request with the same key returns the first result instead of applying it twice.",
+				"schema":      map[string]any{"type": "string"},
+			})
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+		if r.Operator {
+			op["security"] = []any{map[string]any{"serviceToken": []string{}}}
+		}
+
+		if len(r.Consumes) > 0 {
+			content := map[string]any{}
+			for _, ct := range r.Consumes {
+				content[ct] = map[string]any{"schema": map[string]any{"type": "string"}}
+			}
+			op["requestBody"] = map[string]any{"required": true, "content": content}
+		} else if r.Req != nil {
+			op["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					"application/json": map[string]any{"schema": g.schema(reflect.TypeOf(r.Req))},
+				},
+			}
+		}
+
+		ok := map[string]any{"description": "OK"}
+		if r.Produces != "" {
+			ok["content"] = map[string]any{
+				r.Produces: map[string]any{"schema": map[string]any{"type": "string"}},
+			}
+		} else if r.Resp != nil {
+			ok["content"] = map[string]any{
+				"application/json": map[string]any{"schema": g.schema(reflect.TypeOf(r.Resp))},
+			}
+		}
+		op["responses"] = map[string]any{
+			"200": ok,
+			"default": map[string]any{
+				"description": "Error",
+				"content": map[string]any{
+					"application/json": map[string]any{"schema": errRef},
+				},
+			},
+		}
+
+		item, _ := paths[r.Path].(map[string]any)
+		if item == nil {
+			item = map[string]any{}
+			paths[r.Path] = item
+		}
+		item[strings.ToLower(r.Method)] = op
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   info.Title,
+			"version": info.Version,
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": g.schemas,
+			"securitySchemes": map[string]any{
+				"serviceToken": map[string]any{"type": "apiKey", "in": "header", "name": "X-Service-Token"},
+			},
+		},
+	}
+}
+
+// Handler serves the document built from routes at /openapi.json.
+func Handler(info Info, routes []Route, errResp any) http.HandlerFunc {
+	doc, err := json.MarshalIndent(Build(info, routes, errResp), "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(doc)
+	}
+}
+
+func operationID(r Route) string {
+	parts := strings.FieldsFunc(r.Path, func(c rune) bool {
+		return c == '/' || c == '{' || c == '}' || c == '.' || c == '-' || c == '_'
+	})
+	id := strings.ToLower(r.Method)
+	for _, p := range parts {
+		id += strings.ToUpper(p[:1]) + p[1:]
+	}
+	return id
+}
+
+func (g *generator) schema(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if t.Implements(schemaerType) {
+		return reflect.Zero(t).Interface().(Schemaer).OpenAPISchema()
+	}
+
+	switch t {
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case uuidType:
+		return map[string]any{"type": "string", "format": "uuid"}
+	case numberType:
+		return map[string]any{"type": "number"}
+	case rawType:
+		return map[string]any{} // any JSON value
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]any{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		return g.structRef(t)
+	}
+	return map[string]any{}
+}
+
+func (g *generator) structRef(t reflect.Type) map[string]any {
+	name := t.Name()
+	ref := map[string]any{"$ref": "#/components/schemas/" + name}
+	if _, ok := g.schemas[name]; ok {
+		return ref
+	}
+	// Reserve the name first so self-referencing types terminate.
+	g.schemas[name] = nil
+
+	props := map[string]any{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		field, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if field == "-" {
+			continue
+		}
+		if field == "" {
+			field = f.Name
+		}
+		props[field] = g.schema(f.Type)
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, field)
+		}
+	}
+
+	s := map[string]any{"type": "object", "properties": props}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	g.schemas[name] = s
+	return ref
+}
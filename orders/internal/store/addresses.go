@@ -0,0 +1,187 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"orders/internal/audit"
+	"orders/internal/domain"
+)
+
+var (
+	ErrNoAddress      = errors.New("no such address")
+	ErrInvalidAddress = errors.New("invalid address")
+	ErrTooManyAddress = errors.New("address book is full")
+)
+
+const MaxAddressesPerUser = 20
+
+func validateAddress(a *domain.Address) error {
+	a.Country = strings.ToUpper(strings.TrimSpace(a.Country))
+	fields := []struct {
+		name     string
+		v        string
+		required bool
+	}{
+		{"recipient", a.Recipient, true},
+		{"line1", a.Line1, true},
+		{"line2", a.Line2, false},
+		{"city", a.City, true},
+		{"region", a.Region, false},
+		{"postal_code", a.PostalCode, true},
+		{"phone", a.Phone, false},
+	}
+	for _, f := range fields {
+		if f.required && strings.TrimSpace(f.v) == "" {
+			return fmt.Errorf("%w: empty %s", ErrInvalidAddress, f.name)
+		}
+		if len(f.v) > 200 {
+			return fmt.Errorf("%w: %s is longer than 200 symbols", ErrInvalidAddress, f.name)
+		}
+	}
+	if len(a.Country) != 2 || strings.Trim(a.Country, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		return fmt.Errorf("%w: country should be an ISO 3166-1 alpha-2 code", ErrInvalidAddress)
+	}
+	return nil
+}
+
+// CreateAddress saves an address to the user's address book. The first
+// address, or one marked IsDefault, becomes the default.
+func (s *OrdersStore) CreateAddress(ctx context.Context, a domain.Address) (domain.Address, error) {
+	if a.UserID == "" {
+		return domain.Address{}, errors.New("empty user_id")
+	}
+	if err := validateAddress(&a); err != nil {
+		return domain.Address{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return domain.Address{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// Serializes concurrent creates for one user so the count holds.
+	if _, err := tx.ExecContext(ctx, `select pg_advisory_xact_lock(hashtext('addresses:' || $1))`, a.UserID); err != nil {
+		return domain.Address{}, err
+	}
+	var n int
+	err = tx.QueryRowContext(ctx,
+		`select count(*) from addresses where user_id = $1 and deleted_at is null`, a.UserID,
+	).Scan(&n)
+	if err != nil {
+		return domain.Address{}, err
+	}
+	if n >= MaxAddressesPerUser {
+		return domain.Address{}, ErrTooManyAddress
+	}
+	if n == 0 {
+		a.IsDefault = true
+	}
+	if a.IsDefault {
+		_, err := tx.ExecContext(ctx,
+			`update addresses set is_default = false where user_id = $1 and is_default`, a.UserID,
+		)
+		if err != nil {
+			return domain.Address{}, err
+		}
+	}
+
+	a.ID = uuid.New()
+	err = tx.QueryRowContext(ctx,
+		`insert into addresses(id, user_id, recipient, line1, line2, city, region, postal_code, country, phone, is_default)
+		 values ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)
+		 returning created_at`,
+		a.ID, a.UserID, a.Recipient, a.Line1, a.Line2, a.City, a.Region, a.PostalCode, a.Country, a.Phone, a.IsDefault,
+	).Scan(&a.CreatedAt)
+	if err != nil {
+		return domain.Address{}, err
+	}
+	if err := audit.Record(ctx, tx, audit.AddressCreate, audit.Target("address", a.ID), nil, a); err != nil {
+		return domain.Address{}, err
+	}
+	return a, tx.Commit()
+}
+
+const addressColumns = `id, user_id, recipient, line1, line2, city, region, postal_code, country, phone, is_default, created_at`
+
+func scanAddress(r rowScanner) (domain.Address, error) {
+	var a domain.Address
+	err := r.Scan(&a.ID, &a.UserID, &a.Recipient, &a.Line1, &a.Line2, &a.City, &a.Region,
+		&a.PostalCode, &a.Country, &a.Phone, &a.IsDefault, &a.CreatedAt)
+	return a, err
+}
+
+func (s *OrdersStore) ListAddresses(ctx context.Context, userID string) ([]domain.Address, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`select `+addressColumns+` from addresses where user_id = $1 and deleted_at is null
+		 order by is_default desc, created_at`, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []domain.Address{}
+	for rows.Next() {
+		a, err := scanAddress(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+// DeleteAddress hides an address from the book. Orders keep their copy.
+func (s *OrdersStore) DeleteAddress(ctx context.Context, userID string, id uuid.UUID) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	a, err := scanAddress(tx.QueryRowContext(ctx,
+		`select `+addressColumns+` from addresses where id = $1 and user_id = $2 and deleted_at is null for update`,
+		id, userID,
+	))
+	if err == sql.ErrNoRows {
+		return ErrNoAddress
+	}
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `update addresses set deleted_at = now(), is_default = false where id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if err := audit.Record(ctx, tx, audit.AddressDelete, audit.Target("address", id), a, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// snapshotAddress loads a live address of userID for copying into an order.
+func snapshotAddress(ctx context.Context, tx *sql.Tx, userID string, id uuid.UUID) (domain.Address, []byte, error) {
+	a, err := scanAddress(tx.QueryRowContext(ctx,
+		`select `+addressColumns+` from addresses where id = $1 and user_id = $2 and deleted_at is null`, id, userID,
+	))
+	if err == sql.ErrNoRows {
+		return domain.Address{}, nil, ErrNoAddress
+	}
+	if err != nil {
+		return domain.Address{}, nil, err
+	}
+	b, _ := json.Marshal(a)
+	return a, b, nil
+}
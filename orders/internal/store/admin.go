@@ -0,0 +1,251 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"orders/internal/audit"
+	"orders/internal/domain"
+	"orders/internal/events"
+)
+
+var (
+	ErrNoActor         = errors.New("admin actor is required")
+	ErrAdminReason     = errors.New("reason should contain 1 to 200 symbols")
+	ErrOrderNotNew     = errors.New("only a NEW order can be cancelled")
+	ErrNoOutboxMessage = errors.New("no outbox message")
+)
+
+func checkAdmin(actor, reason string) error {
+	if actor == "" {
+		return ErrNoActor
+	}
+	if reason == "" || len(reason) > 200 {
+		return ErrAdminReason
+	}
+	return nil
+}
+
+// AdminSearch describes a back-office order search; zero values mean "no
+// filter".
+type AdminSearch struct {
+	OrderID uuid.UUID
+	UserID  string
+	Status  domain.OrderStatus
+	Limit   int
+}
+
+// SearchOrders returns the orders of any user matching f, newest first.
+func (s *OrdersStore) SearchOrders(ctx context.Context, f AdminSearch) ([]domain.Order, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var where []string
+	var args []any
+	add := func(cond string, v any) {
+		args = append(args, v)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if f.OrderID != uuid.Nil {
+		add("id = $%d", f.OrderID)
+	}
+	if f.UserID != "" {
+		add("user_id = $%d", f.UserID)
+	}
+	if f.Status != "" {
+		add("status = $%d", string(f.Status))
+	}
+	query := `select ` + orderColumns + ` from orders`
+	if len(where) > 0 {
+		query += ` where ` + strings.Join(where, " and ")
+	}
+	args = append(args, f.Limit)
+	query += fmt.Sprintf(` order by created_at desc, id desc limit $%d`, len(args))
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := []domain.Order{}
+	for rows.Next() {
+		o, err := scanOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, o)
+	}
+	return orders, rows.Err()
+}
+
+// ForceCancel cancels a NEW order on behalf of actor with ADMIN_CANCELLED
+// and reason as its message. The caller voids the payment first, so that
+// the order can not be charged after it. An order already cancelled this
+// way is returned as it is; any other state is ErrOrderNotNew. Unlike a
+// failed payment, it does not count against the order's subscription.
+func (s *OrdersStore) ForceCancel(ctx context.Context, actor string, id uuid.UUID, reason string) (domain.Order, error) {
+	if err := checkAdmin(actor, reason); err != nil {
+		return domain.Order{}, err
+	}
+	ctx = audit.WithReason(ctx, reason)
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return domain.Order{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	o, err := scanOrder(tx.QueryRowContext(ctx, `select `+orderColumns+` from orders where id = $1 for update`, id))
+	if err == sql.ErrNoRows {
+		return domain.Order{}, ErrNoOrder
+	}
+	if err != nil {
+		return domain.Order{}, err
+	}
+	if o.Status == domain.OrderCancelled && o.CancellationReason == domain.ReasonAdminCancelled {
+		return o, nil
+	}
+	if o.Status != domain.OrderNew {
+		return o, ErrOrderNotNew
+	}
+
+	before := o
+	o.Status = domain.OrderCancelled
+	o.CancellationReason = domain.ReasonAdminCancelled
+	o.CancellationMessage = reason
+	_, err = tx.ExecContext(ctx,
+		`update orders set status = $2, cancellation_reason = $3, cancellation_message = $4 where id = $1`,
+		id, string(o.Status), o.CancellationReason, o.CancellationMessage,
+	)
+	if err != nil {
+		return domain.Order{}, err
+	}
+	if err := ReleasePromotion(ctx, tx, id); err != nil {
+		return domain.Order{}, err
+	}
+	err = events.Notify(ctx, tx, domain.OrderStatusEvent{
+		OrderID:             id,
+		Status:              o.Status,
+		CancellationReason:  o.CancellationReason,
+		CancellationMessage: o.CancellationMessage,
+	})
+	if err != nil {
+		return domain.Order{}, err
+	}
+	err = InsertOrderEventOutbox(ctx, tx, OrderEvent{
+		Type:                EventOrderCancelled,
+		OrderID:             id,
+		UserID:              o.UserID,
+		Amount:              o.Amount.Amount,
+		Currency:            string(o.Amount.Currency),
+		Status:              o.Status,
+		CancellationReason:  o.CancellationReason,
+		CancellationMessage: o.CancellationMessage,
+	})
+	if err != nil {
+		return domain.Order{}, err
+	}
+	if err := audit.Record(ctx, tx, audit.OrderCancel, audit.Target("order", id), before, o); err != nil {
+		return domain.Order{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return domain.Order{}, err
+	}
+	return o, nil
+}
+
+// OutboxBacklog counts the outbox messages not published yet and lists the
+// latest messages, or only unpublished ones oldest first.
+func (s *OrdersStore) OutboxBacklog(ctx context.Context, pendingOnly bool, limit int) (domain.OutboxBacklog, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var b domain.OutboxBacklog
+	err := s.db.QueryRowContext(ctx,
+		`select count(*), min(created_at) from orders_outbox where published_at is null`,
+	).Scan(&b.Pending, &b.OldestPending)
+	if err != nil {
+		return domain.OutboxBacklog{}, err
+	}
+
+	query := `select id, message_id, topic, key, created_at, published_at from orders_outbox order by id desc limit $1`
+	if pendingOnly {
+		query = `select id, message_id, topic, key, created_at, published_at from orders_outbox
+		         where published_at is null order by id limit $1`
+	}
+	rows, err := s.db.QueryContext(ctx, query, limit)
+	if err != nil {
+		return domain.OutboxBacklog{}, err
+	}
+	defer rows.Close()
+
+	b.Messages = []domain.OutboxMessage{}
+	for rows.Next() {
+		var m domain.OutboxMessage
+		if err := rows.Scan(&m.ID, &m.MessageID, &m.Topic, &m.Key, &m.CreatedAt, &m.PublishedAt); err != nil {
+			return domain.OutboxBacklog{}, err
+		}
+		b.Messages = append(b.Messages, m)
+	}
+	return b, rows.Err()
+}
+
+// RepublishOutbox queues an outbox message to be published again with the
+// same message id, so consumers that have seen it skip it.
+func (s *OrdersStore) RepublishOutbox(ctx context.Context, actor string, messageID uuid.UUID, reason string) (domain.OutboxMessage, error) {
+	if err := checkAdmin(actor, reason); err != nil {
+		return domain.OutboxMessage{}, err
+	}
+	ctx = audit.WithReason(ctx, reason)
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return domain.OutboxMessage{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var m domain.OutboxMessage
+	var publishedAt sql.NullTime
+	err = tx.QueryRowContext(ctx,
+		`update orders_outbox o set published_at = null
+		 from (select id, published_at from orders_outbox where message_id = $1 for update) old
+		 where o.id = old.id
+		 returning o.id, o.message_id, o.topic, o.key, o.created_at, o.published_at, old.published_at`,
+		messageID,
+	).Scan(&m.ID, &m.MessageID, &m.Topic, &m.Key, &m.CreatedAt, &m.PublishedAt, &publishedAt)
+	if err == sql.ErrNoRows {
+		return domain.OutboxMessage{}, ErrNoOutboxMessage
+	}
+	if err != nil {
+		return domain.OutboxMessage{}, err
+	}
+	before := m
+	if publishedAt.Valid {
+		before.PublishedAt = &publishedAt.Time
+	}
+	if err := audit.Record(ctx, tx, audit.OutboxRepublish, audit.Target("outbox", messageID), before, m); err != nil {
+		return domain.OutboxMessage{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return domain.OutboxMessage{}, err
+	}
+	return m, nil
+}
+
+// AuditLog returns the audit log entries matching f, newest first.
+func (s *OrdersStore) AuditLog(ctx context.Context, f audit.Filter) ([]domain.AuditEntry, error) {
+	return audit.Query(ctx, s.db, f)
+}
@@ -0,0 +1,155 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"orders/internal/audit"
+	"orders/internal/domain"
+)
+
+const (
+	// MaxImportRows caps one import request; larger files are split by the caller.
+	MaxImportRows   = 10000
+	ImportBatchSize = 100
+	exportPageSize  = 500
+)
+
+// ImportResult is the outcome of one NewOrder of an import batch. Replayed
+// means the idempotency key matched an order created before.
+type ImportResult struct {
+	Order    domain.Order
+	Replayed bool
+	Err      error
+}
+
+// ImportOrders creates orders in a single transaction. Every order runs in
+// its own savepoint, so a row that fails (unknown promo code, address of
+// another user) is reported without undoing the rest of the batch. The
+// returned error is set only when the batch as a whole could not be
+// committed; no order of it exists then.
+func (s *OrdersStore) ImportOrders(ctx context.Context, batch []NewOrder) ([]ImportResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	out := make([]ImportResult, len(batch))
+	for i, n := range batch {
+		if _, err := tx.ExecContext(ctx, `savepoint import_row`); err != nil {
+			return nil, err
+		}
+		o, created, err := s.createOrderTx(ctx, tx, n)
+		if err != nil {
+			if _, rbErr := tx.ExecContext(ctx, `rollback to savepoint import_row`); rbErr != nil {
+				return nil, rbErr
+			}
+			out[i].Err = err
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `release savepoint import_row`); err != nil {
+			return nil, err
+		}
+		out[i] = ImportResult{Order: o, Replayed: !created}
+	}
+	// Recorded once the batch is in, so the audit lock is not held while
+	// the rows insert.
+	for _, r := range out {
+		if r.Err != nil || r.Replayed {
+			continue
+		}
+		if err := audit.Record(ctx, tx, audit.OrderCreate, audit.Target("order", r.Order.ID), nil, r.Order); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ExportFilter selects orders for ExportOrders. Zero values mean "no filter".
+type ExportFilter struct {
+	UserID string
+	From   time.Time
+	To     time.Time
+}
+
+// ExportOrders calls fn for every order matching f, oldest first. It reads
+// the table in keyset pages, so an export of any size holds neither a long
+// running query nor more than one page in memory.
+func (s *OrdersStore) ExportOrders(ctx context.Context, f ExportFilter, fn func(domain.Order) error) error {
+	var after *cursor
+	for {
+		page, err := s.exportPage(ctx, f, after)
+		if err != nil {
+			return err
+		}
+		for _, o := range page {
+			if err := fn(o); err != nil {
+				return err
+			}
+		}
+		if len(page) < exportPageSize {
+			return nil
+		}
+		last := page[len(page)-1]
+		after = &cursor{CreatedAt: last.CreatedAt, ID: last.ID}
+	}
+}
+
+func (s *OrdersStore) exportPage(ctx context.Context, f ExportFilter, after *cursor) ([]domain.Order, error) {
+	var where []string
+	var args []any
+	add := func(cond string, v ...any) {
+		for _, a := range v {
+			args = append(args, a)
+			cond = strings.Replace(cond, "?", fmt.Sprintf("$%d", len(args)), 1)
+		}
+		where = append(where, cond)
+	}
+
+	if f.UserID != "" {
+		add("user_id = ?", f.UserID)
+	}
+	if !f.From.IsZero() {
+		add("created_at >= ?", f.From)
+	}
+	if !f.To.IsZero() {
+		add("created_at < ?", f.To)
+	}
+	if after != nil {
+		add("(created_at, id) > (?, ?)", after.CreatedAt, after.ID)
+	}
+	query := `select ` + orderColumns + ` from orders`
+	if len(where) > 0 {
+		query += ` where ` + strings.Join(where, " and ")
+	}
+	args = append(args, exportPageSize)
+	query += fmt.Sprintf(` order by created_at, id limit $%d`, len(args))
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]domain.Order, 0, exportPageSize)
+	for rows.Next() {
+		o, err := scanOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, o)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,436 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"orders/internal/audit"
+	"orders/internal/domain"
+	"orders/internal/money"
+	"orders/internal/tax"
+)
+
+var (
+	ErrEmptyCart       = errors.New("cart is empty")
+	ErrNoCartItem      = errors.New("no such item in cart")
+	ErrInvalidCartItem = errors.New("invalid cart item")
+	ErrCartFull        = errors.New("cart is full")
+)
+
+const (
+	MaxCartItems    = 100
+	MaxItemQuantity = 999
+)
+
+// DefaultCartTTL is how long an untouched cart lives.
+const DefaultCartTTL = 72 * time.Hour
+
+// CartItemInput is one line added to a cart; Price is per unit.
+type CartItemInput struct {
+	SKU      string
+	Name     string
+	Price    domain.Money
+	Quantity int
+	Category string // product category for tax
+}
+
+// Validate checks the limits of a single item.
+func (in CartItemInput) Validate() error {
+	switch {
+	case in.SKU == "" || len(in.SKU) > 64:
+		return fmt.Errorf("%w: sku should be 1..64 symbols", ErrInvalidCartItem)
+	case len(in.Name) > 100:
+		return fmt.Errorf("%w: name should be at most 100 symbols", ErrInvalidCartItem)
+	case !in.Price.IsPositive():
+		return fmt.Errorf("%w: price should be greater than 0", ErrInvalidCartItem)
+	case in.Quantity < 1 || in.Quantity > MaxItemQuantity:
+		return fmt.Errorf("%w: quantity should be 1..%d", ErrInvalidCartItem, MaxItemQuantity)
+	case len(in.Category) > 32:
+		return fmt.Errorf("%w: category should be at most 32 symbols", ErrInvalidCartItem)
+	}
+	return nil
+}
+
+// touchCart locks the user's cart row, creating it in currency if missing,
+// and returns the cart's currency. An expired cart is emptied first.
+func touchCart(ctx context.Context, tx *sql.Tx, userID string, currency money.Currency, ttl time.Duration) (money.Currency, error) {
+	_, err := tx.ExecContext(ctx,
+		`insert into carts(user_id, currency) values ($1, $2) on conflict (user_id) do nothing`,
+		userID, string(currency),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	var cur string
+	var updatedAt time.Time
+	err = tx.QueryRowContext(ctx,
+		`select currency, updated_at from carts where user_id = $1 for update`, userID,
+	).Scan(&cur, &updatedAt)
+	if err != nil {
+		return "", err
+	}
+	if time.Since(updatedAt) > ttl {
+		if _, err := tx.ExecContext(ctx, `delete from cart_items where user_id = $1`, userID); err != nil {
+			return "", err
+		}
+		cur = string(currency)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`update carts set updated_at = now(), currency = $2 where user_id = $1`, userID, cur,
+	)
+	return money.Currency(cur), err
+}
+
+// AddCartItem puts an item into the cart or, if the SKU is already there,
+// adds to its quantity and takes the new price and name.
+func (s *OrdersStore) AddCartItem(ctx context.Context, userID string, in CartItemInput) (domain.Cart, error) {
+	if userID == "" {
+		return domain.Cart{}, errors.New("empty user_id")
+	}
+	if err := in.Validate(); err != nil {
+		return domain.Cart{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return domain.Cart{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	cur, err := touchCart(ctx, tx, userID, in.Price.Currency, s.cartTTL)
+	if err != nil {
+		return domain.Cart{}, err
+	}
+	before, err := loadCart(ctx, tx, userID, s.cartTTL)
+	if err != nil {
+		return domain.Cart{}, err
+	}
+
+	var n int
+	if err := tx.QueryRowContext(ctx, `select count(*) from cart_items where user_id = $1`, userID).Scan(&n); err != nil {
+		return domain.Cart{}, err
+	}
+	if n > 0 && cur != in.Price.Currency {
+		return domain.Cart{}, fmt.Errorf("%w: cart is in %s", money.ErrCurrencyMismatch, cur)
+	}
+	if n == 0 && cur != in.Price.Currency {
+		_, err := tx.ExecContext(ctx, `update carts set currency = $2 where user_id = $1`, userID, string(in.Price.Currency))
+		if err != nil {
+			return domain.Cart{}, err
+		}
+	}
+
+	if n >= MaxCartItems {
+		var has bool
+		err := tx.QueryRowContext(ctx,
+			`select exists(select 1 from cart_items where user_id = $1 and sku = $2)`, userID, in.SKU,
+		).Scan(&has)
+		if err != nil {
+			return domain.Cart{}, err
+		}
+		if !has {
+			return domain.Cart{}, ErrCartFull
+		}
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`insert into cart_items(user_id, sku, name, unit_price, quantity, category) values ($1,$2,$3,$4,$5,$7)
+		 on conflict (user_id, sku) do update
+		 set name = excluded.name, unit_price = excluded.unit_price, category = excluded.category,
+		     quantity = least(cart_items.quantity + excluded.quantity, $6)`,
+		userID, in.SKU, in.Name, in.Price.Amount, in.Quantity, MaxItemQuantity, tax.NormalizeCategory(in.Category),
+	)
+	if err != nil {
+		return domain.Cart{}, err
+	}
+
+	c, err := loadCart(ctx, tx, userID, s.cartTTL)
+	if err != nil {
+		return domain.Cart{}, err
+	}
+	err = audit.Record(ctx, tx, audit.CartItemAdd, audit.Target("cart", userID), cartItem(before, in.SKU), cartItem(c, in.SKU))
+	if err != nil {
+		return domain.Cart{}, err
+	}
+	return c, tx.Commit()
+}
+
+// SetCartItemQuantity changes the quantity of an item; zero removes it.
+func (s *OrdersStore) SetCartItemQuantity(ctx context.Context, userID, sku string, quantity int) (domain.Cart, error) {
+	if quantity < 0 || quantity > MaxItemQuantity {
+		return domain.Cart{}, fmt.Errorf("%w: quantity should be 0..%d", ErrInvalidCartItem, MaxItemQuantity)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return domain.Cart{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := touchCart(ctx, tx, userID, money.Default(), s.cartTTL); err != nil {
+		return domain.Cart{}, err
+	}
+	before, err := loadCart(ctx, tx, userID, s.cartTTL)
+	if err != nil {
+		return domain.Cart{}, err
+	}
+
+	var res sql.Result
+	if quantity == 0 {
+		res, err = tx.ExecContext(ctx, `delete from cart_items where user_id = $1 and sku = $2`, userID, sku)
+	} else {
+		res, err = tx.ExecContext(ctx,
+			`update cart_items set quantity = $3 where user_id = $1 and sku = $2`, userID, sku, quantity,
+		)
+	}
+	if err != nil {
+		return domain.Cart{}, err
+	}
+	if ra, _ := res.RowsAffected(); ra == 0 {
+		return domain.Cart{}, ErrNoCartItem
+	}
+
+	c, err := loadCart(ctx, tx, userID, s.cartTTL)
+	if err != nil {
+		return domain.Cart{}, err
+	}
+	err = audit.Record(ctx, tx, audit.CartItemUpdate, audit.Target("cart", userID), cartItem(before, sku), cartItem(c, sku))
+	if err != nil {
+		return domain.Cart{}, err
+	}
+	return c, tx.Commit()
+}
+
+// cartItem returns the item of c with sku, nil if there is none.
+func cartItem(c domain.Cart, sku string) *domain.CartItem {
+	for i := range c.Items {
+		if c.Items[i].SKU == sku {
+			return &c.Items[i]
+		}
+	}
+	return nil
+}
+
+// GetCart returns the user's cart; a missing or expired cart is empty.
+func (s *OrdersStore) GetCart(ctx context.Context, userID string) (domain.Cart, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return domain.Cart{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	return loadCart(ctx, tx, userID, s.cartTTL)
+}
+
+func loadCart(ctx context.Context, tx *sql.Tx, userID string, ttl time.Duration) (domain.Cart, error) {
+	c := domain.Cart{UserID: userID, Items: []domain.CartItem{}}
+
+	var cur string
+	err := tx.QueryRowContext(ctx,
+		`select currency, updated_at from carts where user_id = $1`, userID,
+	).Scan(&cur, &c.UpdatedAt)
+	if err == sql.ErrNoRows || err == nil && time.Since(c.UpdatedAt) > ttl {
+		c.Total = money.New(0, money.Default())
+		c.UpdatedAt = time.Time{}
+		return c, nil
+	}
+	if err != nil {
+		return domain.Cart{}, err
+	}
+	currency := money.Currency(cur)
+	c.Total = money.New(0, currency)
+	c.ExpiresAt = c.UpdatedAt.Add(ttl)
+
+	rows, err := tx.QueryContext(ctx,
+		`select sku, name, unit_price, quantity, category from cart_items where user_id = $1 order by added_at, sku`, userID,
+	)
+	if err != nil {
+		return domain.Cart{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var it domain.CartItem
+		var price int64
+		if err := rows.Scan(&it.SKU, &it.Name, &price, &it.Quantity, &it.Category); err != nil {
+			return domain.Cart{}, err
+		}
+		it.UnitPrice = money.New(price, currency)
+		it.LineTotal = money.New(price*int64(it.Quantity), currency)
+		c.Total.Amount += it.LineTotal.Amount
+		c.ItemCount += it.Quantity
+		c.Items = append(c.Items, it)
+	}
+	return c, rows.Err()
+}
+
+// CartDescription summarizes the items as an order description that fits
+// the 200 symbol limit.
+func CartDescription(items []domain.CartItem) string {
+	parts := make([]string, 0, len(items))
+	for _, it := range items {
+		name := it.Name
+		if name == "" {
+			name = it.SKU
+		}
+		parts = append(parts, fmt.Sprintf("%dx %s", it.Quantity, name))
+	}
+	d := strings.Join(parts, ", ")
+	if len(d) > 200 {
+		cut := 197
+		for !utf8.RuneStart(d[cut]) {
+			cut--
+		}
+		d = d[:cut] + "..."
+	}
+	return d
+}
+
+// TaxLines turns items into the lines an order is taxed by.
+func TaxLines(items []domain.CartItem) []tax.Line {
+	lines := make([]tax.Line, 0, len(items))
+	for _, it := range items {
+		name := it.Name
+		if name == "" {
+			name = it.SKU
+		}
+		lines = append(lines, tax.Line{
+			SKU:      it.SKU,
+			Name:     name,
+			Category: it.Category,
+			Quantity: it.Quantity,
+			Amount:   it.LineTotal.Amount,
+		})
+	}
+	return lines
+}
+
+// Checkout turns the cart into an order in the same transaction that
+// writes the order and its outbox event, and empties the cart. Retrying
+// with the same idempotency key after success returns the order again.
+// paymentMethod is domain.PayByBalance when empty.
+func (s *OrdersStore) Checkout(ctx context.Context, userID, promoCode string, addressID uuid.UUID, paymentMethod, idempotencyKey string) (domain.Order, error) {
+	if userID == "" {
+		return domain.Order{}, errors.New("empty user_id")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return domain.Order{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// Locking the cart row makes concurrent checkouts of one cart wait.
+	var locked string
+	err = tx.QueryRowContext(ctx, `select user_id from carts where user_id = $1 for update`, userID).Scan(&locked)
+	if err != nil && err != sql.ErrNoRows {
+		return domain.Order{}, err
+	}
+
+	c, err := loadCart(ctx, tx, userID, s.cartTTL)
+	if err != nil {
+		return domain.Order{}, err
+	}
+	if len(c.Items) == 0 {
+		if idempotencyKey != "" {
+			o, err := scanOrder(tx.QueryRowContext(ctx,
+				`select `+orderColumns+` from orders where user_id = $1 and idempotency_key = $2`,
+				userID, idempotencyKey,
+			))
+			if err == nil {
+				return o, nil
+			}
+			if err != sql.ErrNoRows {
+				return domain.Order{}, err
+			}
+		}
+		return domain.Order{}, ErrEmptyCart
+	}
+
+	o, created, err := s.createOrderTx(ctx, tx, NewOrder{
+		UserID:         userID,
+		Amount:         c.Total,
+		Description:    CartDescription(c.Items),
+		IdempotencyKey: idempotencyKey,
+		PromoCode:      promoCode,
+		AddressID:      addressID,
+		Lines:          TaxLines(c.Items),
+		PaymentMethod:  paymentMethod,
+	})
+	if err != nil || !created {
+		return o, err
+	}
+
+	// cart_items go with the cart (on delete cascade).
+	if _, err := tx.ExecContext(ctx, `delete from carts where user_id = $1`, userID); err != nil {
+		return domain.Order{}, err
+	}
+	if err := audit.Record(ctx, tx, audit.OrderCreate, audit.Target("order", o.ID), nil, o); err != nil {
+		return domain.Order{}, err
+	}
+	if err := audit.Record(ctx, tx, audit.CartCheckout, audit.Target("cart", userID), c, nil); err != nil {
+		return domain.Order{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return domain.Order{}, err
+	}
+	return o, nil
+}
+
+// ExpireCarts deletes carts untouched for longer than the TTL.
+func (s *OrdersStore) ExpireCarts(ctx context.Context) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx,
+		`delete from carts where updated_at < now() - make_interval(secs => $1)`, s.cartTTL.Seconds(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// RunCartSweeper calls ExpireCarts every interval until ctx is done.
+func (s *OrdersStore) RunCartSweeper(ctx context.Context, every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			n, err := s.ExpireCarts(ctx)
+			if err != nil {
+				log.Printf("cart sweeper: %v", err)
+				continue
+			}
+			if n > 0 {
+				log.Printf("cart sweeper: expired %d carts", n)
+			}
+		}
+	}
+}
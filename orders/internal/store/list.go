@@ -0,0 +1,189 @@
+package store
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"orders/internal/domain"
+	"orders/internal/money"
+)
+
+const (
+	DefaultListLimit = 50
+	MaxListLimit     = 200
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrInvalidSort   = errors.New("sort should be asc or desc")
+
+	ErrAmountNeedsCurrency = errors.New("amount filters need a currency")
+)
+
+type SortOrder string
+
+const (
+	SortDesc SortOrder = "desc"
+	SortAsc  SortOrder = "asc"
+)
+
+// ListOrdersFilter describes one page of a user's orders. Zero values mean
+// "no filter"; Cursor is the NextCursor of the previous page.
+type ListOrdersFilter struct {
+	UserID      string
+	Status      domain.OrderStatus
+	Currency    money.Currency
+	MinAmount   int64 // minor units of Currency
+	MaxAmount   int64
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	Sort        SortOrder
+	Cursor      string
+	Limit       int
+}
+
+type OrdersPage struct {
+	Orders     []domain.Order
+	NextCursor string
+}
+
+// cursor is a (created_at, id) keyset position, encoded opaquely for clients.
+type cursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+func encodeCursor(c cursor) string {
+	raw := c.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + c.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(s string) (cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+	ts, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return cursor{}, ErrInvalidCursor
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+	orderID, err := uuid.Parse(id)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+	return cursor{CreatedAt: createdAt, ID: orderID}, nil
+}
+
+func (s *OrdersStore) ListOrders(ctx context.Context, f ListOrdersFilter) (OrdersPage, error) {
+	if f.UserID == "" {
+		return OrdersPage{}, errors.New("empty user_id")
+	}
+	if f.Sort == "" {
+		f.Sort = SortDesc
+	}
+	if f.Sort != SortDesc && f.Sort != SortAsc {
+		return OrdersPage{}, ErrInvalidSort
+	}
+	if (f.MinAmount > 0 || f.MaxAmount > 0) && f.Currency == "" {
+		return OrdersPage{}, ErrAmountNeedsCurrency
+	}
+	if f.Limit <= 0 {
+		f.Limit = DefaultListLimit
+	}
+	if f.Limit > MaxListLimit {
+		f.Limit = MaxListLimit
+	}
+
+	where := []string{"user_id = $1"}
+	args := []any{f.UserID}
+	add := func(cond string, v ...any) {
+		for _, a := range v {
+			args = append(args, a)
+			cond = strings.Replace(cond, "?", fmt.Sprintf("$%d", len(args)), 1)
+		}
+		where = append(where, cond)
+	}
+
+	if f.Status != "" {
+		add("status = ?", string(f.Status))
+	}
+	if f.Currency != "" {
+		add("currency = ?", string(f.Currency))
+	}
+	if f.MinAmount > 0 {
+		add("amount >= ?", f.MinAmount)
+	}
+	if f.MaxAmount > 0 {
+		add("amount <= ?", f.MaxAmount)
+	}
+	if !f.CreatedFrom.IsZero() {
+		add("created_at >= ?", f.CreatedFrom)
+	}
+	if !f.CreatedTo.IsZero() {
+		add("created_at < ?", f.CreatedTo)
+	}
+	if f.Cursor != "" {
+		c, err := decodeCursor(f.Cursor)
+		if err != nil {
+			return OrdersPage{}, err
+		}
+		if f.Sort == SortDesc {
+			add("(created_at, id) < (?, ?)", c.CreatedAt, c.ID)
+		} else {
+			add("(created_at, id) > (?, ?)", c.CreatedAt, c.ID)
+		}
+	}
+
+	dir := "desc"
+	if f.Sort == SortAsc {
+		dir = "asc"
+	}
+	// One extra row tells us whether there is a next page.
+	args = append(args, f.Limit+1)
+	query := fmt.Sprintf(
+		`select `+orderColumns+` from orders
+		 where %s
+		 order by created_at %s, id %s
+		 limit $%d`,
+		strings.Join(where, " and "), dir, dir, len(args),
+	)
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return OrdersPage{}, err
+	}
+	defer rows.Close()
+
+	out := []domain.Order{}
+	for rows.Next() {
+		o, err := scanOrder(rows)
+		if err != nil {
+			return OrdersPage{}, err
+		}
+		out = append(out, o)
+	}
+	if err := rows.Err(); err != nil {
+		return OrdersPage{}, err
+	}
+
+	page := OrdersPage{Orders: out}
+	if len(out) > f.Limit {
+		page.Orders = out[:f.Limit]
+		last := page.Orders[f.Limit-1]
+		page.NextCursor = encodeCursor(cursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+	return page, nil
+}
@@ -0,0 +1,64 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+
+	"orders/internal/domain"
+)
+
+// OrderEventsTopic carries order lifecycle events for other services and for
+// outgoing webhooks.
+const OrderEventsTopic = "orders.events"
+
+const (
+	EventOrderCreated   = "order.created"
+	EventOrderPaid      = "order.paid"
+	EventOrderCancelled = "order.cancelled"
+
+	EventOrderPartiallyRefunded = "order.partially_refunded"
+	EventOrderRefunded          = "order.refunded"
+
+	EventOrderPicking   = "order.picking"
+	EventOrderShipped   = "order.shipped"
+	EventOrderDelivered = "order.delivered"
+)
+
+type OrderEvent struct {
+	MessageID uuid.UUID          `json:"message_id"`
+	Type      string             `json:"type"`
+	OrderID   uuid.UUID          `json:"order_id"`
+	UserID    string             `json:"user_id"`
+	Amount    int64              `json:"amount"` // minor units of Currency
+	Currency  string             `json:"currency"`
+	Status    domain.OrderStatus `json:"status"`
+	// RefundedAmount is set on refund events: the total refunded so far.
+	RefundedAmount int64 `json:"refunded_amount,omitempty"`
+	// Fulfilment fields are set on order.picking/shipped/delivered.
+	Fulfilment     domain.FulfilmentStatus `json:"fulfilment,omitempty"`
+	Carrier        string                  `json:"carrier,omitempty"`
+	TrackingNumber string                  `json:"tracking_number,omitempty"`
+	// Cancellation fields are set on order.cancelled.
+	CancellationReason  string    `json:"cancellation_reason,omitempty"`
+	CancellationMessage string    `json:"cancellation_message,omitempty"`
+	OccurredAt          time.Time `json:"occurred_at"`
+}
+
+// InsertOrderEventOutbox writes ev to orders_outbox as part of tx.
+func InsertOrderEventOutbox(ctx context.Context, tx *sql.Tx, ev OrderEvent) error {
+	ev.MessageID = uuid.New()
+	if ev.OccurredAt.IsZero() {
+		ev.OccurredAt = time.Now().UTC()
+	}
+	payload, _ := json.Marshal(ev)
+
+	_, err := tx.ExecContext(ctx,
+		`insert into orders_outbox(message_id, topic, key, payload) values ($1,$2,$3,$4)`,
+		ev.MessageID, OrderEventsTopic, ev.OrderID.String(), payload,
+	)
+	return err
+}
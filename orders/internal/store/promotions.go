@@ -0,0 +1,325 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"orders/internal/audit"
+	"orders/internal/domain"
+	"orders/internal/money"
+)
+
+var (
+	ErrNoPromotion      = errors.New("no such promo code")
+	ErrPromotionExists  = errors.New("promo code already exists")
+	ErrInvalidPromotion = errors.New("invalid promotion")
+	ErrPromoNotValid    = errors.New("promo code is not valid for this order")
+)
+
+// NewPromotion is the input of CreatePromotion.
+type NewPromotion struct {
+	Code           string
+	Kind           domain.PromoKind
+	Percent        int
+	AmountOff      *domain.Money
+	MinOrder       *domain.Money
+	PerUserLimit   int
+	TotalLimit     int
+	FirstOrderOnly bool
+	StartsAt       *time.Time
+	ExpiresAt      *time.Time
+}
+
+// NormalizePromoCode makes codes case-insensitive.
+func NormalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func (p NewPromotion) validate() error {
+	switch p.Kind {
+	case domain.PromoPercent:
+		if p.Percent < 1 || p.Percent > 99 || p.AmountOff != nil {
+			return fmt.Errorf("%w: PERCENT needs percent between 1 and 99", ErrInvalidPromotion)
+		}
+	case domain.PromoFixed:
+		if p.AmountOff == nil || !p.AmountOff.IsPositive() || p.Percent != 0 {
+			return fmt.Errorf("%w: FIXED needs a positive amount_off", ErrInvalidPromotion)
+		}
+	default:
+		return fmt.Errorf("%w: kind should be PERCENT or FIXED", ErrInvalidPromotion)
+	}
+	if p.Code == "" || len(p.Code) > 64 {
+		return fmt.Errorf("%w: code should be 1..64 symbols", ErrInvalidPromotion)
+	}
+	if p.AmountOff != nil && p.MinOrder != nil && p.AmountOff.Currency != p.MinOrder.Currency {
+		return fmt.Errorf("%w: amount_off and min_order should be in one currency", ErrInvalidPromotion)
+	}
+	if p.PerUserLimit < 0 || p.TotalLimit < 0 {
+		return fmt.Errorf("%w: limits should not be negative", ErrInvalidPromotion)
+	}
+	if p.StartsAt != nil && p.ExpiresAt != nil && !p.ExpiresAt.After(*p.StartsAt) {
+		return fmt.Errorf("%w: expires_at should be after starts_at", ErrInvalidPromotion)
+	}
+	return nil
+}
+
+// currency is the one currency a promotion is bound to, if any.
+func (p NewPromotion) currency() sql.NullString {
+	switch {
+	case p.AmountOff != nil:
+		return sql.NullString{String: string(p.AmountOff.Currency), Valid: true}
+	case p.MinOrder != nil:
+		return sql.NullString{String: string(p.MinOrder.Currency), Valid: true}
+	}
+	return sql.NullString{}
+}
+
+// CreatePromotion adds a promo code on behalf of the back-office operator
+// actor.
+func (s *OrdersStore) CreatePromotion(ctx context.Context, actor string, p NewPromotion) (domain.Promotion, error) {
+	if actor == "" {
+		return domain.Promotion{}, ErrNoActor
+	}
+	p.Code = NormalizePromoCode(p.Code)
+	if err := p.validate(); err != nil {
+		return domain.Promotion{}, err
+	}
+
+	var amountOff, minOrder int64
+	if p.AmountOff != nil {
+		amountOff = p.AmountOff.Amount
+	}
+	if p.MinOrder != nil {
+		minOrder = p.MinOrder.Amount
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return domain.Promotion{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.ExecContext(ctx,
+		`insert into promotions(code, kind, percent, amount_off, currency, min_order, per_user_limit, total_limit,
+		                        first_order_only, starts_at, expires_at)
+		 values ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)
+		 on conflict (code) do nothing`,
+		p.Code, string(p.Kind), p.Percent, amountOff, p.currency(), minOrder, p.PerUserLimit, p.TotalLimit,
+		p.FirstOrderOnly, p.StartsAt, p.ExpiresAt,
+	)
+	if err != nil {
+		return domain.Promotion{}, err
+	}
+	if ra, _ := res.RowsAffected(); ra == 0 {
+		return domain.Promotion{}, ErrPromotionExists
+	}
+	promo, err := scanPromotion(tx.QueryRowContext(ctx,
+		`select `+promotionColumns+` from promotions p where p.code = $1`, p.Code,
+	))
+	if err != nil {
+		return domain.Promotion{}, err
+	}
+	if err := audit.Record(ctx, tx, audit.PromotionCreate, audit.Target("promotion", p.Code), nil, promo); err != nil {
+		return domain.Promotion{}, err
+	}
+	return promo, tx.Commit()
+}
+
+// promotionColumns is the select list scanPromotion expects.
+const promotionColumns = `p.code, p.kind, p.percent, p.amount_off, p.currency, p.min_order, p.per_user_limit,
+	p.total_limit, p.first_order_only, p.starts_at, p.expires_at, p.active, p.created_at,
+	(select count(*) from promo_redemptions r where r.code = p.code and r.released_at is null)`
+
+func scanPromotion(r rowScanner) (domain.Promotion, error) {
+	var p domain.Promotion
+	var kind string
+	var amountOff, minOrder int64
+	var cur sql.NullString
+	var startsAt, expiresAt sql.NullTime
+	err := r.Scan(&p.Code, &kind, &p.Percent, &amountOff, &cur, &minOrder, &p.PerUserLimit,
+		&p.TotalLimit, &p.FirstOrderOnly, &startsAt, &expiresAt, &p.Active, &p.CreatedAt, &p.Redemptions)
+	if err != nil {
+		return domain.Promotion{}, err
+	}
+	p.Kind = domain.PromoKind(kind)
+	if amountOff > 0 {
+		m := money.New(amountOff, money.Currency(cur.String))
+		p.AmountOff = &m
+	}
+	if minOrder > 0 {
+		m := money.New(minOrder, money.Currency(cur.String))
+		p.MinOrder = &m
+	}
+	if startsAt.Valid {
+		p.StartsAt = &startsAt.Time
+	}
+	if expiresAt.Valid {
+		p.ExpiresAt = &expiresAt.Time
+	}
+	return p, nil
+}
+
+func (s *OrdersStore) GetPromotion(ctx context.Context, code string) (domain.Promotion, error) {
+	p, err := scanPromotion(s.db.QueryRowContext(ctx,
+		`select `+promotionColumns+` from promotions p where p.code = $1`, NormalizePromoCode(code),
+	))
+	if err == sql.ErrNoRows {
+		return domain.Promotion{}, ErrNoPromotion
+	}
+	return p, err
+}
+
+func (s *OrdersStore) ListPromotions(ctx context.Context) ([]domain.Promotion, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`select `+promotionColumns+` from promotions p order by p.created_at desc`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []domain.Promotion{}
+	for rows.Next() {
+		p, err := scanPromotion(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// DisablePromotion stops new redemptions on behalf of the back-office
+// operator actor; existing ones are kept.
+func (s *OrdersStore) DisablePromotion(ctx context.Context, actor, code string) error {
+	if actor == "" {
+		return ErrNoActor
+	}
+	code = NormalizePromoCode(code)
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	before, err := scanPromotion(tx.QueryRowContext(ctx,
+		`select `+promotionColumns+` from promotions p where p.code = $1 for update`, code,
+	))
+	if err == sql.ErrNoRows {
+		return ErrNoPromotion
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `update promotions set active = false where code = $1`, code); err != nil {
+		return err
+	}
+	after := before
+	after.Active = false
+	if err := audit.Record(ctx, tx, audit.PromotionDisable, audit.Target("promotion", code), before, after); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// redeemPromotion checks code against the freshly inserted order o, records
+// the redemption and returns the discount. The promotion row stays locked
+// until tx ends, so concurrent orders cannot overrun its limits.
+func redeemPromotion(ctx context.Context, tx *sql.Tx, o domain.Order, code string) (domain.Money, error) {
+	p, err := scanPromotion(tx.QueryRowContext(ctx,
+		`select `+promotionColumns+` from promotions p where p.code = $1 for update`, code,
+	))
+	if err == sql.ErrNoRows {
+		return domain.Money{}, ErrNoPromotion
+	}
+	if err != nil {
+		return domain.Money{}, err
+	}
+
+	notValid := func(why string) error { return fmt.Errorf("%w: %s", ErrPromoNotValid, why) }
+
+	now := time.Now()
+	switch {
+	case !p.Active:
+		return domain.Money{}, notValid("promotion is disabled")
+	case p.StartsAt != nil && now.Before(*p.StartsAt):
+		return domain.Money{}, notValid("promotion has not started")
+	case p.ExpiresAt != nil && !now.Before(*p.ExpiresAt):
+		return domain.Money{}, notValid("promotion has expired")
+	case p.TotalLimit > 0 && p.Redemptions >= p.TotalLimit:
+		return domain.Money{}, notValid("promotion is used up")
+	}
+
+	for _, m := range []*domain.Money{p.AmountOff, p.MinOrder} {
+		if m != nil && m.Currency != o.OriginalAmount.Currency {
+			return domain.Money{}, notValid("promotion is for orders in " + string(m.Currency))
+		}
+	}
+	if p.MinOrder != nil && o.OriginalAmount.Amount < p.MinOrder.Amount {
+		return domain.Money{}, notValid("order is below the minimum of " + p.MinOrder.String())
+	}
+
+	if p.PerUserLimit > 0 {
+		var used int
+		err := tx.QueryRowContext(ctx,
+			`select count(*) from promo_redemptions where code = $1 and user_id = $2 and released_at is null`,
+			p.Code, o.UserID,
+		).Scan(&used)
+		if err != nil {
+			return domain.Money{}, err
+		}
+		if used >= p.PerUserLimit {
+			return domain.Money{}, notValid("usage limit reached")
+		}
+	}
+	if p.FirstOrderOnly {
+		var earlier bool
+		err := tx.QueryRowContext(ctx,
+			`select exists(select 1 from orders where user_id = $1 and id <> $2 and status <> $3)`,
+			o.UserID, o.ID, string(domain.OrderCancelled),
+		).Scan(&earlier)
+		if err != nil {
+			return domain.Money{}, err
+		}
+		if earlier {
+			return domain.Money{}, notValid("only for the first order")
+		}
+	}
+
+	// Discounts apply to the goods, never to shipping.
+	discount := money.New(0, o.OriginalAmount.Currency)
+	switch p.Kind {
+	case domain.PromoPercent:
+		discount.Amount = o.OriginalAmount.Amount * int64(p.Percent) / 100
+	case domain.PromoFixed:
+		discount.Amount = p.AmountOff.Amount
+	}
+	if discount.Amount >= o.OriginalAmount.Amount {
+		// Payments never charges zero, so a discount may not cover the order.
+		return domain.Money{}, notValid("discount covers the whole order")
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`insert into promo_redemptions(order_id, code, user_id, discount) values ($1,$2,$3,$4)`,
+		o.ID, p.Code, o.UserID, discount.Amount,
+	)
+	if err != nil {
+		return domain.Money{}, err
+	}
+	return discount, nil
+}
+
+// ReleasePromotion gives the redemption of a cancelled order back to its
+// promotion. It is a no-op for orders without one.
+func ReleasePromotion(ctx context.Context, tx *sql.Tx, orderID uuid.UUID) error {
+	_, err := tx.ExecContext(ctx,
+		`update promo_redemptions set released_at = now() where order_id = $1 and released_at is null`, orderID,
+	)
+	return err
+}
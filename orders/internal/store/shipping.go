@@ -0,0 +1,78 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"orders/internal/domain"
+	"orders/internal/money"
+)
+
+var ErrNoShippingRate = errors.New("no shipping rate for this currency")
+
+// DefaultShippingRates are used when SHIPPING_RATES is not set. Each entry
+// is "CUR=domestic/international" in major units.
+const DefaultShippingRates = "RUB=300/1500,USD=5/20,EUR=5/20"
+
+// ShippingRates is a flat fee per currency, one for the home country and
+// one for everywhere else.
+type ShippingRates struct {
+	Home  string // ISO 3166-1 alpha-2
+	rates map[money.Currency][2]int64
+}
+
+// LoadShippingRates reads SHIPPING_RATES and SHIPPING_HOME_COUNTRY (RU).
+func LoadShippingRates() (*ShippingRates, error) {
+	s := os.Getenv("SHIPPING_RATES")
+	if s == "" {
+		s = DefaultShippingRates
+	}
+	home := strings.ToUpper(os.Getenv("SHIPPING_HOME_COUNTRY"))
+	if home == "" {
+		home = "RU"
+	}
+	return ParseShippingRates(s, home)
+}
+
+func ParseShippingRates(s, home string) (*ShippingRates, error) {
+	r := &ShippingRates{Home: home, rates: map[money.Currency][2]int64{}}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		code, fees, ok := strings.Cut(item, "=")
+		dom, intl, ok2 := strings.Cut(fees, "/")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("bad shipping rate %q: want CUR=domestic/international", item)
+		}
+		cur, err := money.ParseCurrency(code)
+		if err != nil {
+			return nil, err
+		}
+		d, err := money.Parse(dom, cur)
+		if err != nil {
+			return nil, fmt.Errorf("bad shipping rate %q: %w", item, err)
+		}
+		i, err := money.Parse(intl, cur)
+		if err != nil {
+			return nil, fmt.Errorf("bad shipping rate %q: %w", item, err)
+		}
+		r.rates[cur] = [2]int64{d.Amount, i.Amount}
+	}
+	return r, nil
+}
+
+// Cost is the fee for shipping an order in currency to a.
+func (r *ShippingRates) Cost(a domain.Address, currency money.Currency) (domain.Money, error) {
+	fees, ok := r.rates[currency]
+	if !ok {
+		return domain.Money{}, fmt.Errorf("%w: %s", ErrNoShippingRate, currency)
+	}
+	if a.Country == r.Home {
+		return money.New(fees[0], currency), nil
+	}
+	return money.New(fees[1], currency), nil
+}
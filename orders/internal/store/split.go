@@ -0,0 +1,64 @@
+package store
+
+import (
+	"orders/internal/domain"
+	"orders/internal/money"
+)
+
+const (
+	maxPayers = 20
+	maxShare  = 1000
+)
+
+// checkPayers validates the payers of a split order; none means the order
+// is not split.
+func checkPayers(payers []domain.Payer) error {
+	if len(payers) == 0 {
+		return nil
+	}
+	if len(payers) < 2 || len(payers) > maxPayers {
+		return ErrPayers
+	}
+	seen := make(map[string]bool, len(payers))
+	for _, p := range payers {
+		if p.UserID == "" || seen[p.UserID] || p.Share < 1 || p.Share > maxShare {
+			return ErrPayers
+		}
+		seen[p.UserID] = true
+	}
+	return nil
+}
+
+// splitAmount divides total between payers in proportion to their shares.
+// What rounding down leaves over goes to the first payers, one minor unit
+// each, so the amounts always add up to total.
+func splitAmount(payers []domain.Payer, total domain.Money) []domain.Payer {
+	var shares int64
+	for _, p := range payers {
+		shares += int64(p.Share)
+	}
+	out := make([]domain.Payer, len(payers))
+	left := total.Amount
+	for i, p := range payers {
+		amt := total.Amount * int64(p.Share) / shares
+		out[i] = domain.Payer{UserID: p.UserID, Share: p.Share, Amount: money.New(amt, total.Currency)}
+		left -= amt
+	}
+	for i := 0; left > 0; i++ {
+		out[i].Amount.Amount++
+		left--
+	}
+	return out
+}
+
+func samePayers(a, b []domain.Payer) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].UserID != b[i].UserID || a[i].Share != b[i].Share {
+			return false
+		}
+	}
+	return true
+}
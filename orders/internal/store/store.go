@@ -9,43 +9,100 @@ import (
 
 	"github.com/google/uuid"
 
+	"orders/internal/audit"
 	"orders/internal/domain"
+	"orders/internal/money"
+	"orders/internal/tax"
 )
 
 var (
-	ErrNoOrder          = errors.New("no order")
-	ErrInvalidPrice     = errors.New("order price should be greater than 0")
-	ErrDescriptionLimit = errors.New("description should contain maximum of 200 symbols")
+	ErrNoOrder             = errors.New("no order")
+	ErrInvalidPrice        = errors.New("order price should be greater than 0")
+	ErrDescriptionLimit    = errors.New("description should contain maximum of 200 symbols")
+	ErrIdempotencyConflict = errors.New("idempotency key was already used with a different request")
+	ErrCategoryLimit       = errors.New("category should contain maximum of 32 symbols")
+	ErrPaymentMethod       = errors.New(`payment_method should be "balance" or "card"`)
+	ErrPayers              = errors.New("payers should be 2 to 20 different users with shares from 1 to 1000")
+	ErrSplitByCard         = errors.New("a split order is paid from the payers' balances, not by card")
+	ErrSplitTooSmall       = errors.New("order amount is too small to split between the payers")
 )
 
 type OrdersStore struct {
-	db *sql.DB
+	db       *sql.DB
+	cartTTL  time.Duration
+	shipping *ShippingRates
+	tax      *tax.Rates
 }
 
 func NewOrdersStore(db *sql.DB) *OrdersStore {
-	return &OrdersStore{db: db}
+	shipping, _ := ParseShippingRates(DefaultShippingRates, "RU")
+	taxRates, _ := tax.Parse(tax.DefaultRates, "RU", true)
+	return &OrdersStore{db: db, cartTTL: DefaultCartTTL, shipping: shipping, tax: taxRates}
 }
 
-type PaymentRequested struct {
-	MessageID    uuid.UUID `json:"message_id"`
-	OrderID      uuid.UUID `json:"order_id"`
-	UserID       string    `json:"user_id"`
-	Amount       int64     `json:"amount"`
-	Description  string    `json:"description"`
+func (s *OrdersStore) SetShippingRates(r *ShippingRates) {
+	s.shipping = r
 }
 
-func (s *OrdersStore) CreateOrder(userID string, amount domain.Money, description string) (domain.Order, error) {
-	if userID == "" {
-		return domain.Order{}, errors.New("empty user_id")
-	}
-	if amount <= 0 {
-		return domain.Order{}, ErrInvalidPrice
-	}
-	if len(description) > 200 {
-		return domain.Order{}, ErrDescriptionLimit
+func (s *OrdersStore) SetTaxRates(r *tax.Rates) {
+	s.tax = r
+}
+
+// SetCartTTL changes how long an untouched cart lives.
+func (s *OrdersStore) SetCartTTL(d time.Duration) {
+	if d > 0 {
+		s.cartTTL = d
 	}
+}
+
+type PaymentRequested struct {
+	MessageID   uuid.UUID `json:"message_id"`
+	OrderID     uuid.UUID `json:"order_id"`
+	UserID      string    `json:"user_id"`
+	Amount      int64     `json:"amount"` // minor units of Currency
+	Currency    string    `json:"currency"`
+	Description string    `json:"description"`
+	// Tax is the breakdown of Amount; Tax.Gross equals it.
+	Tax *domain.TaxBreakdown `json:"tax,omitempty"`
+	// PaymentMethod is left out for balance payments, which older payments
+	// releases take for granted.
+	PaymentMethod string `json:"payment_method,omitempty"`
+	// Payers of a split order; their amounts add up to Amount.
+	Payers []PayerShare `json:"payers,omitempty"`
+}
+
+type PayerShare struct {
+	UserID string `json:"user_id"`
+	Amount int64  `json:"amount"`
+}
+
+// NewOrder is the input of CreateOrder. A non-empty IdempotencyKey makes
+// repeated calls for the same user return the order created by the first one.
+type NewOrder struct {
+	UserID         string
+	Amount         domain.Money
+	Description    string
+	IdempotencyKey string
+	// PromoCode, if set, is redeemed in the same transaction; the order is
+	// charged Amount minus the discount.
+	PromoCode string
+	// AddressID, if set, is copied into the order and adds shipping cost.
+	AddressID uuid.UUID
+	// Lines split Amount for tax and must add up to it. When empty the
+	// order is taxed as one line of Category.
+	Lines    []tax.Line
+	Category string
+	// SubscriptionID links orders placed by a subscription to it.
+	SubscriptionID uuid.UUID
+	// PaymentMethod is domain.PayByBalance when empty.
+	PaymentMethod string
+	// Payers split the order (see domain.Payer); only their UserID and
+	// Share are read.
+	Payers []domain.Payer
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (s *OrdersStore) CreateOrder(ctx context.Context, n NewOrder) (domain.Order, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	tx, err := s.db.BeginTx(ctx, nil)
@@ -54,21 +111,146 @@ func (s *OrdersStore) CreateOrder(userID string, amount domain.Money, descriptio
 	}
 	defer func() { _ = tx.Rollback() }()
 
-	orderID := uuid.New()
-	o := domain.Order{
-		ID:          orderID,
-		UserID:      userID,
-		Amount:      amount,
-		Description: description,
-		Status:      domain.OrderNew,
+	o, created, err := s.createOrderTx(ctx, tx, n)
+	if err != nil || !created {
+		return o, err
+	}
+	if err := audit.Record(ctx, tx, audit.OrderCreate, audit.Target("order", o.ID), nil, o); err != nil {
+		return domain.Order{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return domain.Order{}, err
+	}
+	return o, nil
+}
+
+// createOrderTx inserts the order, redeems its promo code, computes tax and
+// writes the payment request to the outbox, all in tx. created is false when
+// the idempotency key matched an earlier order, which is returned instead.
+func (s *OrdersStore) createOrderTx(ctx context.Context, tx *sql.Tx, n NewOrder) (o domain.Order, created bool, err error) {
+	if n.UserID == "" {
+		return domain.Order{}, false, errors.New("empty user_id")
+	}
+	if !n.Amount.IsPositive() {
+		return domain.Order{}, false, ErrInvalidPrice
+	}
+	if _, err := money.ParseCurrency(string(n.Amount.Currency)); err != nil {
+		return domain.Order{}, false, err
+	}
+	if len(n.Description) > 200 {
+		return domain.Order{}, false, ErrDescriptionLimit
+	}
+	if len(n.Category) > 32 {
+		return domain.Order{}, false, ErrCategoryLimit
+	}
+	if n.PaymentMethod == "" {
+		n.PaymentMethod = domain.PayByBalance
+	}
+	if n.PaymentMethod != domain.PayByBalance && n.PaymentMethod != domain.PayByCard {
+		return domain.Order{}, false, ErrPaymentMethod
+	}
+	if err := checkPayers(n.Payers); err != nil {
+		return domain.Order{}, false, err
+	}
+	if len(n.Payers) > 0 && n.PaymentMethod == domain.PayByCard {
+		return domain.Order{}, false, ErrSplitByCard
+	}
+	if len(n.Lines) == 0 {
+		name := n.Description
+		if name == "" {
+			name = "Order"
+		}
+		n.Lines = []tax.Line{{Name: name, Category: n.Category, Quantity: 1, Amount: n.Amount.Amount}}
+	}
+	n.PromoCode = NormalizePromoCode(n.PromoCode)
+
+	o = domain.Order{
+		ID:             uuid.New(),
+		UserID:         n.UserID,
+		Amount:         n.Amount,
+		OriginalAmount: n.Amount,
+		Discount:       money.New(0, n.Amount.Currency),
+		ShippingCost:   money.New(0, n.Amount.Currency),
+		Description:    n.Description,
+		Status:         domain.OrderNew,
+		PaymentMethod:  n.PaymentMethod,
+	}
+
+	var addr any
+	if n.AddressID != uuid.Nil {
+		a, snapshot, err := snapshotAddress(ctx, tx, n.UserID, n.AddressID)
+		if err != nil {
+			return domain.Order{}, false, err
+		}
+		cost, err := s.shipping.Cost(a, n.Amount.Currency)
+		if err != nil {
+			return domain.Order{}, false, err
+		}
+		o.ShippingAddress = &a
+		o.ShippingCost = cost
+		o.Amount.Amount += cost.Amount
+		addr = string(snapshot) // lib/pq would send []byte as bytea
+	}
+
+	var key sql.NullString
+	if n.IdempotencyKey != "" {
+		key = sql.NullString{String: n.IdempotencyKey, Valid: true}
+	}
+	sub := uuid.NullUUID{UUID: n.SubscriptionID, Valid: n.SubscriptionID != uuid.Nil}
+
+	err = tx.QueryRowContext(ctx,
+		`insert into orders(id, user_id, amount, original_amount, currency, description, status, idempotency_key,
+		                    shipping_cost, shipping_address, subscription_id, payment_method)
+		 values ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)
+		 on conflict (user_id, idempotency_key) where idempotency_key is not null do nothing
+		 returning created_at`,
+		o.ID, o.UserID, o.Amount.Amount, o.OriginalAmount.Amount, string(o.Amount.Currency), o.Description,
+		string(o.Status), key, o.ShippingCost.Amount, addr, sub, o.PaymentMethod,
+	).Scan(&o.CreatedAt)
+	if err == sql.ErrNoRows {
+		o, err = replayOrder(ctx, tx, n)
+		return o, false, err
+	}
+	if err != nil {
+		return domain.Order{}, false, err
+	}
+
+	// The order row goes in first so that an idempotent replay never
+	// touches the promotion.
+	if n.PromoCode != "" {
+		d, err := redeemPromotion(ctx, tx, o, n.PromoCode)
+		if err != nil {
+			return domain.Order{}, false, err
+		}
+		o.Discount = d
+		o.Amount.Amount -= d.Amount
+		o.PromoCode = n.PromoCode
 	}
 
+	b := s.tax.Compute(s.tax.Region(o.ShippingAddress), o.Amount.Currency, n.Lines, o.Discount.Amount, o.ShippingCost.Amount)
+	o.Tax = &b
+	o.Amount.Amount = b.Gross.Amount
+	breakdown, _ := json.Marshal(b)
+	// Payers split what is charged, so only now that tax has settled it.
+	var payers any
+	if len(n.Payers) > 0 {
+		o.Payers = splitAmount(n.Payers, o.Amount)
+		for _, p := range o.Payers {
+			if !p.Amount.IsPositive() {
+				return domain.Order{}, false, ErrSplitTooSmall
+			}
+		}
+		split, _ := json.Marshal(o.Payers)
+		payers = string(split)
+	}
 	_, err = tx.ExecContext(ctx,
-		`insert into orders(id, user_id, amount, description, status) values ($1,$2,$3,$4,$5)`,
-		o.ID, o.UserID, int64(o.Amount), o.Description, string(o.Status),
+		`update orders set amount = $2, discount = $3, promo_code = nullif($4, ''), tax_amount = $5, tax = $6,
+		                   payers = $7
+		 where id = $1`,
+		o.ID, o.Amount.Amount, o.Discount.Amount, o.PromoCode, b.Tax.Amount, string(breakdown), payers,
 	)
 	if err != nil {
-		return domain.Order{}, err
+		return domain.Order{}, false, err
 	}
 
 	msgID := uuid.New()
@@ -76,8 +258,16 @@ func (s *OrdersStore) CreateOrder(userID string, amount domain.Money, descriptio
 		MessageID:   msgID,
 		OrderID:     o.ID,
 		UserID:      o.UserID,
-		Amount:      int64(o.Amount),
+		Amount:      o.Amount.Amount,
+		Currency:    string(o.Amount.Currency),
 		Description: o.Description,
+		Tax:         o.Tax,
+	}
+	if o.PaymentMethod == domain.PayByCard {
+		ev.PaymentMethod = domain.PayByCard
+	}
+	for _, p := range o.Payers {
+		ev.Payers = append(ev.Payers, PayerShare{UserID: p.UserID, Amount: p.Amount.Amount})
 	}
 	payload, _ := json.Marshal(ev)
 
@@ -86,46 +276,109 @@ func (s *OrdersStore) CreateOrder(userID string, amount domain.Money, descriptio
 		msgID, "payments.request", o.ID.String(), payload,
 	)
 	if err != nil {
-		return domain.Order{}, err
+		return domain.Order{}, false, err
 	}
+	return o, true, nil
+}
 
-	if err := tx.Commit(); err != nil {
+// replayOrder returns the order an earlier CreateOrder made with the same
+// idempotency key, provided the request body matches.
+func replayOrder(ctx context.Context, tx *sql.Tx, n NewOrder) (domain.Order, error) {
+	o, err := scanOrder(tx.QueryRowContext(ctx,
+		`select `+orderColumns+` from orders where user_id = $1 and idempotency_key = $2`,
+		n.UserID, n.IdempotencyKey,
+	))
+	if err != nil {
 		return domain.Order{}, err
 	}
 
+	if o.OriginalAmount != n.Amount || o.Description != n.Description || o.PromoCode != n.PromoCode ||
+		o.PaymentMethod != n.PaymentMethod || !samePayers(o.Payers, n.Payers) {
+		return domain.Order{}, ErrIdempotencyConflict
+	}
+	var addrID uuid.UUID
+	if o.ShippingAddress != nil {
+		addrID = o.ShippingAddress.ID
+	}
+	if addrID != n.AddressID {
+		return domain.Order{}, ErrIdempotencyConflict
+	}
 	return o, nil
 }
 
-func (s *OrdersStore) GetStatus(id uuid.UUID) (domain.OrderStatus, error) {
-	var st string
-	err := s.db.QueryRow(`select status from orders where id = $1`, id).Scan(&st)
+func (s *OrdersStore) GetStatus(ctx context.Context, id uuid.UUID) (domain.StatusResp, error) {
+	var st, reason, msg string
+	err := s.db.QueryRowContext(ctx,
+		`select status, coalesce(cancellation_reason, ''), coalesce(cancellation_message, '') from orders where id = $1`, id,
+	).Scan(&st, &reason, &msg)
 	if err == sql.ErrNoRows {
-		return "", ErrNoOrder
+		return domain.StatusResp{}, ErrNoOrder
 	}
 	if err != nil {
-		return "", err
+		return domain.StatusResp{}, err
 	}
-	return domain.OrderStatus(st), nil
+	return domain.StatusResp{Status: domain.OrderStatus(st), CancellationReason: reason, CancellationMessage: msg}, nil
 }
 
-func (s *OrdersStore) ListOrders(userID string) ([]domain.Order, error) {
-	rows, err := s.db.Query(`select id, user_id, amount, description, status from orders where user_id = $1 order by created_at desc`, userID)
-	if err != nil {
-		return nil, err
+func (s *OrdersStore) GetOrder(ctx context.Context, id uuid.UUID) (domain.Order, error) {
+	o, err := scanOrder(s.db.QueryRowContext(ctx, `select `+orderColumns+` from orders where id = $1`, id))
+	if err == sql.ErrNoRows {
+		return domain.Order{}, ErrNoOrder
+	}
+	return o, err
+}
+
+// GetOrderTx reads an order inside tx, for code that changes it alongside.
+func GetOrderTx(ctx context.Context, tx *sql.Tx, id uuid.UUID) (domain.Order, error) {
+	o, err := scanOrder(tx.QueryRowContext(ctx, `select `+orderColumns+` from orders where id = $1`, id))
+	if err == sql.ErrNoRows {
+		return domain.Order{}, ErrNoOrder
 	}
-	defer rows.Close()
+	return o, err
+}
 
-	out := []domain.Order{}
-	for rows.Next() {
-		var o domain.Order
-		var amt int64
-		var st string
-		if err := rows.Scan(&o.ID, &o.UserID, &amt, &o.Description, &st); err != nil {
-			return nil, err
+// orderColumns is the select list scanOrder expects.
+const orderColumns = `id, user_id, amount, currency, description, status, created_at, refunded_amount,
+	coalesce(original_amount, amount), discount, coalesce(promo_code, ''), shipping_cost, shipping_address, tax,
+	coalesce(cancellation_reason, ''), coalesce(cancellation_message, ''), payment_method, payers`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanOrder(r rowScanner) (domain.Order, error) {
+	var o domain.Order
+	var amt, refunded, original, discount, shipping int64
+	var cur, st string
+	var addr, breakdown, payers []byte
+	err := r.Scan(&o.ID, &o.UserID, &amt, &cur, &o.Description, &st, &o.CreatedAt, &refunded,
+		&original, &discount, &o.PromoCode, &shipping, &addr, &breakdown, &o.CancellationReason, &o.CancellationMessage,
+		&o.PaymentMethod, &payers)
+	if err != nil {
+		return domain.Order{}, err
+	}
+	if breakdown != nil {
+		o.Tax = &domain.TaxBreakdown{}
+		if err := json.Unmarshal(breakdown, o.Tax); err != nil {
+			return domain.Order{}, err
 		}
-		o.Amount = domain.Money(amt)
-		o.Status = domain.OrderStatus(st)
-		out = append(out, o)
 	}
-	return out, nil
+	if addr != nil {
+		o.ShippingAddress = &domain.Address{}
+		if err := json.Unmarshal(addr, o.ShippingAddress); err != nil {
+			return domain.Order{}, err
+		}
+	}
+	if payers != nil {
+		if err := json.Unmarshal(payers, &o.Payers); err != nil {
+			return domain.Order{}, err
+		}
+	}
+	o.Amount = money.New(amt, money.Currency(cur))
+	o.Status = domain.OrderStatus(st)
+	o.RefundedAmount = money.New(refunded, money.Currency(cur))
+	o.OriginalAmount = money.New(original, money.Currency(cur))
+	o.Discount = money.New(discount, money.Currency(cur))
+	o.ShippingCost = money.New(shipping, money.Currency(cur))
+	return o, nil
 }
@@ -0,0 +1,169 @@
+package subscriptions
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrBadSchedule = errors.New("invalid schedule")
+
+// MinInterval keeps schedules from flooding payments: no two orders of a
+// subscription are due closer than this.
+const MinInterval = time.Hour
+
+// checkedActivations is how many due times of a cron schedule are checked
+// against MinInterval. The minute field repeats in every matching hour, so
+// a schedule firing too often shows it within the first two.
+const checkedActivations = 8
+
+// Schedule gives the next due time strictly after t.
+type Schedule interface {
+	Next(t time.Time) time.Time
+}
+
+type interval time.Duration
+
+func (d interval) Next(t time.Time) time.Time { return t.Add(time.Duration(d)) }
+
+var descriptors = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+}
+
+// ParseSchedule accepts "@every <duration>", a descriptor such as
+// "@monthly", or a five field cron expression (minute hour day-of-month
+// month day-of-week) evaluated in UTC.
+func ParseSchedule(s string) (Schedule, error) {
+	s = strings.TrimSpace(s)
+	if rest, ok := strings.CutPrefix(s, "@every "); ok {
+		d, err := time.ParseDuration(strings.TrimSpace(rest))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrBadSchedule, err)
+		}
+		if d < MinInterval {
+			return nil, fmt.Errorf("%w: interval should be at least %s", ErrBadSchedule, MinInterval)
+		}
+		return interval(d), nil
+	}
+	if expr, ok := descriptors[s]; ok {
+		s = expr
+	}
+
+	fields := strings.Fields(s)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%w: want 5 cron fields, @every <duration> or @daily/@weekly/@monthly", ErrBadSchedule)
+	}
+	c := &cron{}
+	var err error
+	bounds := []struct {
+		dst      *uint64
+		min, max int
+	}{{&c.minute, 0, 59}, {&c.hour, 0, 23}, {&c.dom, 1, 31}, {&c.month, 1, 12}, {&c.dow, 0, 7}}
+	for i, b := range bounds {
+		if *b.dst, err = parseField(fields[i], b.min, b.max); err != nil {
+			return nil, fmt.Errorf("%w: field %d: %v", ErrBadSchedule, i+1, err)
+		}
+	}
+	// 7 is Sunday too.
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domStar, c.dowStar = fields[2] == "*", fields[4] == "*"
+	t := c.Next(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	if t.IsZero() {
+		return nil, fmt.Errorf("%w: never fires", ErrBadSchedule)
+	}
+	for range checkedActivations {
+		next := c.Next(t)
+		if next.IsZero() {
+			break
+		}
+		if next.Sub(t) < MinInterval {
+			return nil, fmt.Errorf("%w: fires %s apart, should be at least %s", ErrBadSchedule, next.Sub(t), MinInterval)
+		}
+		t = next
+	}
+	return c, nil
+}
+
+// parseField reads lists of "*", "n", "a-b", each optionally "/step".
+func parseField(f string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(f, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepStr); err != nil || step < 1 {
+				return 0, fmt.Errorf("bad step %q", stepStr)
+			}
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(a); err != nil {
+				return 0, fmt.Errorf("bad value %q", a)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(b); err != nil {
+					return 0, fmt.Errorf("bad value %q", b)
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q is out of %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+type cron struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+}
+
+func (c *cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<t.Weekday()) != 0
+	// As in cron, a restricted day-of-month and day-of-week mean either.
+	if c.domStar || c.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next walks forward field by field; it gives the zero time when nothing
+// matches within five years (e.g. "0 0 30 2 *").
+func (c *cron) Next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<t.Month()) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case c.hour&(1<<t.Hour()) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case c.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,42 @@
+package subscriptions
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	from := time.Date(2026, 3, 14, 15, 9, 26, 0, time.UTC)
+	for _, tc := range []struct {
+		spec string
+		next time.Time // zero when the spec is refused
+	}{
+		{"@every 30m", time.Time{}},
+		{"@every 2h", from.Add(2 * time.Hour)},
+		{"* * * * *", time.Time{}},
+		{"*/5 * * * *", time.Time{}},
+		{"0,30 9 * * *", time.Time{}},
+		{"0 * * * *", time.Date(2026, 3, 14, 16, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2026, 3, 14, 16, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC)},
+		{"30 9 1 * *", time.Date(2026, 4, 1, 9, 30, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+		{"0 0 * *", time.Time{}},
+	} {
+		s, err := ParseSchedule(tc.spec)
+		if tc.next.IsZero() {
+			if !errors.Is(err, ErrBadSchedule) {
+				t.Errorf("ParseSchedule(%q) = %v, want ErrBadSchedule", tc.spec, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseSchedule(%q): %v", tc.spec, err)
+			continue
+		}
+		if got := s.Next(from); !got.Equal(tc.next) {
+			t.Errorf("%q: Next(%s) = %s, want %s", tc.spec, from, got, tc.next)
+		}
+	}
+}
@@ -0,0 +1,434 @@
+// Package subscriptions places recurring orders from a template.
+package subscriptions
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+
+	"orders/internal/audit"
+	"orders/internal/domain"
+	"orders/internal/money"
+	"orders/internal/store"
+	"orders/internal/tax"
+)
+
+var (
+	ErrNoSubscription      = errors.New("no such subscription")
+	ErrInvalidSubscription = errors.New("invalid subscription")
+	ErrSubscriptionState   = errors.New("subscription cannot change to this status")
+)
+
+const (
+	DefaultMaxFailures         = 3
+	MaxSubscriptionsPerUser    = 50
+	MaxSubscriptionItems       = store.MaxCartItems
+	maxSubscriptionDescription = 200
+)
+
+type Store struct {
+	db     *sql.DB
+	orders *store.OrdersStore
+}
+
+func NewStore(db *sql.DB, orders *store.OrdersStore) *Store {
+	return &Store{db: db, orders: orders}
+}
+
+// NewSubscription is the input of Create. Amount is ignored when Items are
+// given; their total is used instead.
+type NewSubscription struct {
+	UserID      string
+	Items       []store.CartItemInput
+	Amount      domain.Money
+	Description string
+	Category    string
+	AddressID   uuid.UUID
+	Schedule    string
+	MaxFailures int
+	StartAt     time.Time
+}
+
+func (s *Store) Create(ctx context.Context, n NewSubscription) (domain.Subscription, error) {
+	invalid := func(why string) error { return fmt.Errorf("%w: %s", ErrInvalidSubscription, why) }
+	if n.UserID == "" {
+		return domain.Subscription{}, invalid("empty user_id")
+	}
+	sched, err := ParseSchedule(n.Schedule)
+	if err != nil {
+		return domain.Subscription{}, err
+	}
+	if n.MaxFailures == 0 {
+		n.MaxFailures = DefaultMaxFailures
+	}
+	if n.MaxFailures < 1 || n.MaxFailures > 100 {
+		return domain.Subscription{}, invalid("max_failures should be 1..100")
+	}
+	if len(n.Items) > MaxSubscriptionItems {
+		return domain.Subscription{}, invalid(fmt.Sprintf("at most %d items", MaxSubscriptionItems))
+	}
+	if len(n.Category) > 32 {
+		return domain.Subscription{}, store.ErrCategoryLimit
+	}
+
+	sub := domain.Subscription{
+		ID:          uuid.New(),
+		UserID:      n.UserID,
+		Amount:      n.Amount,
+		Description: n.Description,
+		Category:    tax.NormalizeCategory(n.Category),
+		Schedule:    n.Schedule,
+		Status:      domain.SubscriptionActive,
+		MaxFailures: n.MaxFailures,
+	}
+	if len(n.Items) > 0 {
+		cur := n.Items[0].Price.Currency
+		sub.Amount = money.New(0, cur)
+		seen := map[string]bool{}
+		for _, in := range n.Items {
+			if err := in.Validate(); err != nil {
+				return domain.Subscription{}, err
+			}
+			if in.Price.Currency != cur {
+				return domain.Subscription{}, fmt.Errorf("%w: items should be in one currency", money.ErrCurrencyMismatch)
+			}
+			if seen[in.SKU] {
+				return domain.Subscription{}, invalid("duplicate sku " + in.SKU)
+			}
+			seen[in.SKU] = true
+			it := domain.CartItem{
+				SKU:       in.SKU,
+				Name:      in.Name,
+				UnitPrice: in.Price,
+				Quantity:  in.Quantity,
+				LineTotal: money.New(in.Price.Amount*int64(in.Quantity), cur),
+				Category:  tax.NormalizeCategory(in.Category),
+			}
+			sub.Amount.Amount += it.LineTotal.Amount
+			sub.Items = append(sub.Items, it)
+		}
+		if sub.Description == "" {
+			sub.Description = store.CartDescription(sub.Items)
+		}
+	}
+	if !sub.Amount.IsPositive() {
+		return domain.Subscription{}, store.ErrInvalidPrice
+	}
+	if len(sub.Description) > maxSubscriptionDescription {
+		return domain.Subscription{}, store.ErrDescriptionLimit
+	}
+	if n.AddressID != uuid.Nil {
+		id := n.AddressID
+		sub.AddressID = &id
+	}
+	start := n.StartAt
+	if start.IsZero() || start.Before(time.Now()) {
+		start = time.Now()
+	}
+	sub.NextRunAt = sched.Next(start)
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return domain.Subscription{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// Serializes concurrent creates for one user so the count holds.
+	if _, err := tx.ExecContext(ctx, `select pg_advisory_xact_lock(hashtext('subscriptions:' || $1))`, sub.UserID); err != nil {
+		return domain.Subscription{}, err
+	}
+	var count int
+	err = tx.QueryRowContext(ctx,
+		`select count(*) from subscriptions where user_id = $1 and status <> $2`,
+		sub.UserID, string(domain.SubscriptionCancelled),
+	).Scan(&count)
+	if err != nil {
+		return domain.Subscription{}, err
+	}
+	if count >= MaxSubscriptionsPerUser {
+		return domain.Subscription{}, invalid(fmt.Sprintf("at most %d subscriptions per user", MaxSubscriptionsPerUser))
+	}
+
+	items, _ := json.Marshal(sub.Items)
+	err = tx.QueryRowContext(ctx,
+		`insert into subscriptions(id, user_id, items, amount, currency, description, category, address_id,
+		                           schedule, status, max_failures, next_run_at)
+		 values ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)
+		 returning created_at`,
+		sub.ID, sub.UserID, string(items), sub.Amount.Amount, string(sub.Amount.Currency), sub.Description,
+		sub.Category, uuid.NullUUID{UUID: n.AddressID, Valid: sub.AddressID != nil}, sub.Schedule,
+		string(sub.Status), sub.MaxFailures, sub.NextRunAt,
+	).Scan(&sub.CreatedAt)
+	if err != nil {
+		return domain.Subscription{}, err
+	}
+	if err := audit.Record(ctx, tx, audit.SubscriptionCreate, audit.Target("subscription", sub.ID), nil, sub); err != nil {
+		return domain.Subscription{}, err
+	}
+	return sub, tx.Commit()
+}
+
+const columns = `id, user_id, items, amount, currency, description, category, address_id, schedule, status,
+	failures, max_failures, next_run_at, last_order_id, paused_reason, created_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scan(r rowScanner) (domain.Subscription, error) {
+	var sub domain.Subscription
+	var items []byte
+	var amount int64
+	var cur, st string
+	var addressID, lastOrderID uuid.NullUUID
+	err := r.Scan(&sub.ID, &sub.UserID, &items, &amount, &cur, &sub.Description, &sub.Category, &addressID,
+		&sub.Schedule, &st, &sub.Failures, &sub.MaxFailures, &sub.NextRunAt, &lastOrderID, &sub.PausedReason,
+		&sub.CreatedAt)
+	if err != nil {
+		return domain.Subscription{}, err
+	}
+	if err := json.Unmarshal(items, &sub.Items); err != nil {
+		return domain.Subscription{}, err
+	}
+	sub.Amount = money.New(amount, money.Currency(cur))
+	sub.Status = domain.SubscriptionStatus(st)
+	if addressID.Valid {
+		sub.AddressID = &addressID.UUID
+	}
+	if lastOrderID.Valid {
+		sub.LastOrderID = &lastOrderID.UUID
+	}
+	return sub, nil
+}
+
+func (s *Store) List(ctx context.Context, userID string) ([]domain.Subscription, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`select `+columns+` from subscriptions where user_id = $1 order by created_at desc`, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []domain.Subscription{}
+	for rows.Next() {
+		sub, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, sub)
+	}
+	return out, rows.Err()
+}
+
+func (s *Store) Pause(ctx context.Context, userID string, id uuid.UUID) (domain.Subscription, error) {
+	return s.setStatus(ctx, userID, id, domain.SubscriptionPaused)
+}
+
+// Resume reactivates a paused subscription from its next due date after
+// now; missed dates are skipped, not made up.
+func (s *Store) Resume(ctx context.Context, userID string, id uuid.UUID) (domain.Subscription, error) {
+	return s.setStatus(ctx, userID, id, domain.SubscriptionActive)
+}
+
+// Cancel is final.
+func (s *Store) Cancel(ctx context.Context, userID string, id uuid.UUID) (domain.Subscription, error) {
+	return s.setStatus(ctx, userID, id, domain.SubscriptionCancelled)
+}
+
+func (s *Store) setStatus(ctx context.Context, userID string, id uuid.UUID, to domain.SubscriptionStatus) (domain.Subscription, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return domain.Subscription{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	sub, err := scan(tx.QueryRowContext(ctx,
+		`select `+columns+` from subscriptions where id = $1 and user_id = $2 for update`, id, userID,
+	))
+	if err == sql.ErrNoRows {
+		return domain.Subscription{}, ErrNoSubscription
+	}
+	if err != nil {
+		return domain.Subscription{}, err
+	}
+	if sub.Status == to {
+		return sub, nil
+	}
+	if sub.Status == domain.SubscriptionCancelled || (to == domain.SubscriptionActive && sub.Status != domain.SubscriptionPaused) {
+		return domain.Subscription{}, fmt.Errorf("%w: %s -> %s", ErrSubscriptionState, sub.Status, to)
+	}
+
+	nextRun := sub.NextRunAt
+	reason := ""
+	switch to {
+	case domain.SubscriptionActive:
+		sched, err := ParseSchedule(sub.Schedule)
+		if err != nil {
+			return domain.Subscription{}, err
+		}
+		if now := time.Now(); !nextRun.After(now) {
+			nextRun = sched.Next(now)
+		}
+	case domain.SubscriptionPaused:
+		reason = "paused by user"
+	}
+
+	before := sub
+	sub, err = scan(tx.QueryRowContext(ctx,
+		`update subscriptions set status = $2, next_run_at = $3, paused_reason = $4, failures = 0
+		 where id = $1
+		 returning `+columns,
+		id, string(to), nextRun, reason,
+	))
+	if err != nil {
+		return domain.Subscription{}, err
+	}
+	if err := audit.Record(ctx, tx, audit.SubscriptionStatus, audit.Target("subscription", id), before, sub); err != nil {
+		return domain.Subscription{}, err
+	}
+	return sub, tx.Commit()
+}
+
+// RecordPayment is called by the payment result consumer in its tx for
+// orders placed by a subscription. A success resets the failure count; a
+// failure that reaches MaxFailures pauses the subscription.
+func RecordPayment(ctx context.Context, tx *sql.Tx, subscriptionID uuid.UUID, paid bool) error {
+	if paid {
+		_, err := tx.ExecContext(ctx, `update subscriptions set failures = 0 where id = $1`, subscriptionID)
+		return err
+	}
+	_, err := tx.ExecContext(ctx,
+		`update subscriptions
+		 set failures = failures + 1,
+		     status = case when status = $2 and failures + 1 >= max_failures then $3 else status end,
+		     paused_reason = case when status = $2 and failures + 1 >= max_failures
+		                          then 'paused after ' || (failures + 1) || ' failed payments' else paused_reason end
+		 where id = $1`,
+		subscriptionID, string(domain.SubscriptionActive), string(domain.SubscriptionPaused),
+	)
+	return err
+}
+
+// RunScheduler places due orders until ctx is done. Each due subscription
+// is locked with skip locked, so replicas share the work without placing
+// an order twice; the idempotency key covers a crash between placing the
+// order and moving the subscription on. The lock is "no key update" so the
+// order's foreign key to the subscription does not wait on it.
+func (s *Store) RunScheduler(ctx context.Context, every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		for {
+			ran, err := s.runDue(ctx)
+			if err != nil {
+				log.Printf("subscription scheduler: %v", err)
+			}
+			if !ran || err != nil {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// runDue places the order of one due subscription. ran is false when none
+// is due.
+func (s *Store) runDue(ctx context.Context) (ran bool, err error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	sub, err := scan(tx.QueryRowContext(ctx,
+		`select `+columns+` from subscriptions
+		 where status = $1 and next_run_at <= now()
+		 order by next_run_at
+		 limit 1
+		 for no key update skip locked`,
+		string(domain.SubscriptionActive),
+	))
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	nextRun := now.Add(time.Hour)
+	if sched, err := ParseSchedule(sub.Schedule); err == nil {
+		if nextRun = sched.Next(sub.NextRunAt); !nextRun.After(now) {
+			nextRun = sched.Next(now)
+		}
+	}
+
+	n := store.NewOrder{
+		UserID:         sub.UserID,
+		Amount:         sub.Amount,
+		Description:    sub.Description,
+		IdempotencyKey: "subscription:" + sub.ID.String() + ":" + strconv.FormatInt(sub.NextRunAt.Unix(), 10),
+		Category:       sub.Category,
+		SubscriptionID: sub.ID,
+	}
+	if sub.AddressID != nil {
+		n.AddressID = *sub.AddressID
+	}
+	if len(sub.Items) > 0 {
+		n.Lines = store.TaxLines(sub.Items)
+	}
+
+	o, err := s.orders.CreateOrder(ctx, n)
+	switch {
+	case err == nil:
+		_, err = tx.ExecContext(ctx,
+			`update subscriptions set next_run_at = $2, last_order_id = $3 where id = $1`,
+			sub.ID, nextRun, o.ID,
+		)
+	case isTemplateError(err):
+		// The template no longer makes a valid order; retrying won't help.
+		_, err = tx.ExecContext(ctx,
+			`update subscriptions set status = $2, paused_reason = $3 where id = $1`,
+			sub.ID, string(domain.SubscriptionPaused), "cannot place order: "+err.Error(),
+		)
+	default:
+		return false, fmt.Errorf("subscription %s: %w", sub.ID, err)
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func isTemplateError(err error) bool {
+	for _, e := range []error{
+		store.ErrNoAddress, store.ErrNoShippingRate, store.ErrInvalidPrice, store.ErrDescriptionLimit,
+		store.ErrCategoryLimit, store.ErrIdempotencyConflict, money.ErrUnknownCurrency,
+	} {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+	return false
+}
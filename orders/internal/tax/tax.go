@@ -0,0 +1,248 @@
+// Package tax computes the net/tax/gross breakdown of an order.
+package tax
+
+import (
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"orders/internal/domain"
+	"orders/internal/money"
+)
+
+// DefaultRates are used when TAX_RATES is not set: Russian VAT with the
+// reduced rate for food, books and children's goods.
+const DefaultRates = "RU=20,RU:food=10,RU:books=10,RU:kids=10"
+
+// ShippingCategory is the category of the shipping line, so shipping can
+// have its own rate ("RU:shipping=20").
+const ShippingCategory = "shipping"
+
+// full is 100% in rate units (thousandths of a percent).
+const full = 100_000
+
+// Rates maps "REGION[:category]" to a percent. A region is an ISO 3166-1
+// alpha-2 country, optionally with its subdivision ("US-CA").
+type Rates struct {
+	Home string // region of orders without a shipping address
+	// Inclusive means prices already contain tax, as is usual for VAT;
+	// otherwise tax is added on top of them.
+	Inclusive bool
+	rates     map[string]int64
+}
+
+// Load reads TAX_RATES, TAX_HOME_REGION (RU) and TAX_PRICES
+// ("inclusive", the default, or "exclusive").
+func Load() (*Rates, error) {
+	s := os.Getenv("TAX_RATES")
+	if s == "" {
+		s = DefaultRates
+	}
+	home := os.Getenv("TAX_HOME_REGION")
+	if home == "" {
+		home = "RU"
+	}
+	var inclusive bool
+	switch strings.ToLower(os.Getenv("TAX_PRICES")) {
+	case "", "inclusive":
+		inclusive = true
+	case "exclusive":
+	default:
+		return nil, fmt.Errorf("TAX_PRICES should be inclusive or exclusive")
+	}
+	return Parse(s, home, inclusive)
+}
+
+// Parse reads comma separated "REGION[:category]=percent" entries, e.g.
+// "RU=20,RU:books=10,US-NY=8.875".
+func Parse(s, home string, inclusive bool) (*Rates, error) {
+	r := &Rates{Home: strings.ToUpper(home), Inclusive: inclusive, rates: map[string]int64{}}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		key, pct, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("bad tax rate %q: want REGION[:category]=percent", item)
+		}
+		region, category, _ := strings.Cut(key, ":")
+		rate, err := parseRate(pct)
+		if err != nil {
+			return nil, fmt.Errorf("bad tax rate %q: %w", item, err)
+		}
+		r.rates[rateKey(region, category)] = rate
+	}
+	return r, nil
+}
+
+func rateKey(region, category string) string {
+	k := strings.ToUpper(strings.TrimSpace(region))
+	if c := NormalizeCategory(category); c != "" {
+		k += ":" + c
+	}
+	return k
+}
+
+// NormalizeCategory makes categories case-insensitive.
+func NormalizeCategory(c string) string {
+	return strings.ToLower(strings.TrimSpace(c))
+}
+
+// parseRate reads a percent with up to three decimals into thousandths.
+func parseRate(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	whole, frac, _ := strings.Cut(s, ".")
+	if len(frac) > 3 {
+		return 0, fmt.Errorf("at most 3 decimals")
+	}
+	frac += strings.Repeat("0", 3-len(frac))
+	w, err := strconv.ParseUint(whole, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("percent should be a number")
+	}
+	f, err := strconv.ParseUint(frac, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("percent should be a number")
+	}
+	rate := int64(w)*1000 + int64(f)
+	if rate > full {
+		return 0, fmt.Errorf("percent should be at most 100")
+	}
+	return rate, nil
+}
+
+func formatRate(rate int64) string {
+	s := strconv.FormatInt(rate/1000, 10)
+	if f := rate % 1000; f != 0 {
+		s += strings.TrimRight(fmt.Sprintf(".%03d", f), "0")
+	}
+	return s
+}
+
+// Region is where an order is taxed: its shipping address, or Home.
+func (r *Rates) Region(a *domain.Address) string {
+	if a == nil {
+		return r.Home
+	}
+	region := strings.ToUpper(a.Country)
+	if sub := strings.ToUpper(strings.TrimSpace(a.Region)); sub != "" {
+		region += "-" + sub
+	}
+	return region
+}
+
+// Rate looks up the most specific rate: region and category, region,
+// country and category, country. Regions with no rate are not taxed.
+func (r *Rates) Rate(region, category string) int64 {
+	country, _, _ := strings.Cut(region, "-")
+	for _, k := range []string{
+		rateKey(region, category), rateKey(region, ""),
+		rateKey(country, category), rateKey(country, ""),
+	} {
+		if rate, ok := r.rates[k]; ok {
+			return rate
+		}
+	}
+	return 0
+}
+
+// Line is a priced position: Amount is the line total in minor units, with
+// or without tax as Rates.Inclusive says.
+type Line struct {
+	SKU      string
+	Name     string
+	Category string
+	Quantity int
+	Amount   int64
+}
+
+// Compute spreads discount over goods proportionally to their amounts and
+// taxes every line, shipping included, rounding half up per line.
+func (r *Rates) Compute(region string, cur money.Currency, goods []Line, discount, shipping int64) domain.TaxBreakdown {
+	lines := append([]Line(nil), goods...)
+	discounts := allocate(goods, discount)
+	if shipping > 0 {
+		lines = append(lines, Line{Name: "Shipping", Category: ShippingCategory, Quantity: 1, Amount: shipping})
+		discounts = append(discounts, 0)
+	}
+
+	b := domain.TaxBreakdown{
+		Region:    region,
+		Inclusive: r.Inclusive,
+		Lines:     make([]domain.TaxLine, 0, len(lines)),
+		Net:       money.New(0, cur),
+		Tax:       money.New(0, cur),
+		Gross:     money.New(0, cur),
+	}
+	for i, l := range lines {
+		rate := r.Rate(region, l.Category)
+		price := l.Amount - discounts[i]
+
+		var net, tax int64
+		if r.Inclusive {
+			tax = mulDivRound(price, rate, full+rate)
+			net = price - tax
+		} else {
+			tax = mulDivRound(price, rate, full)
+			net = price
+		}
+		b.Lines = append(b.Lines, domain.TaxLine{
+			SKU:      l.SKU,
+			Name:     l.Name,
+			Category: NormalizeCategory(l.Category),
+			Quantity: l.Quantity,
+			Rate:     formatRate(rate),
+			Discount: money.New(discounts[i], cur),
+			Net:      money.New(net, cur),
+			Tax:      money.New(tax, cur),
+			Gross:    money.New(net+tax, cur),
+		})
+		b.Net.Amount += net
+		b.Tax.Amount += tax
+		b.Gross.Amount += net + tax
+	}
+	return b
+}
+
+// allocate splits discount over lines by largest remainder, so the parts add
+// up exactly and none exceeds its line while discount is below the total.
+func allocate(lines []Line, discount int64) []int64 {
+	out := make([]int64, len(lines))
+	if discount <= 0 || len(lines) == 0 {
+		return out
+	}
+	var total int64
+	for _, l := range lines {
+		total += l.Amount
+	}
+
+	rems := make([]*big.Int, len(lines))
+	left := discount
+	for i, l := range lines {
+		q, rem := new(big.Int).QuoRem(
+			new(big.Int).Mul(big.NewInt(discount), big.NewInt(l.Amount)), big.NewInt(total), new(big.Int))
+		out[i] = q.Int64()
+		rems[i] = rem
+		left -= out[i]
+	}
+	idx := make([]int, len(lines))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(a, b int) bool { return rems[idx[a]].Cmp(rems[idx[b]]) > 0 })
+	for _, i := range idx[:left] {
+		out[i]++
+	}
+	return out
+}
+
+// mulDivRound is a*b/c rounded half up, without overflowing int64 on the way.
+func mulDivRound(a, b, c int64) int64 {
+	n := new(big.Int).Mul(big.NewInt(a), big.NewInt(b))
+	n.Mul(n, big.NewInt(2)).Add(n, big.NewInt(c))
+	return n.Quo(n, big.NewInt(2*c)).Int64()
+}
@@ -0,0 +1,126 @@
+package tax
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestComputeLine(t *testing.T) {
+	for _, tc := range []struct {
+		inclusive bool
+		category  string
+		amount    int64
+		net, tax  int64
+	}{
+		{true, "", 120000, 100000, 20000},
+		{true, "food", 11000, 10000, 1000},
+		// 100 * 20/120 = 16.67, rounded half up.
+		{true, "", 100, 83, 17},
+		{false, "", 100, 100, 20},
+		// 5 * 10% = 0.5, rounded half up.
+		{false, "Books", 5, 5, 1},
+		{false, "books", 4, 4, 0},
+	} {
+		r, err := Parse(DefaultRates, "RU", tc.inclusive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := r.Compute("RU", "RUB", []Line{{Category: tc.category, Quantity: 1, Amount: tc.amount}}, 0, 0)
+		l := b.Lines[0]
+		if l.Net.Amount != tc.net || l.Tax.Amount != tc.tax || l.Gross.Amount != tc.net+tc.tax {
+			t.Errorf("inclusive %v %q %d: net %d tax %d gross %d, want %d %d %d", tc.inclusive, tc.category, tc.amount,
+				l.Net.Amount, l.Tax.Amount, l.Gross.Amount, tc.net, tc.tax, tc.net+tc.tax)
+		}
+	}
+}
+
+func TestComputeOrder(t *testing.T) {
+	r, err := Parse(DefaultRates, "RU", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	goods := []Line{
+		{SKU: "b1", Category: "Books", Quantity: 1, Amount: 11000},
+		{SKU: "g1", Quantity: 2, Amount: 12000},
+	}
+	b := r.Compute("RU", "RUB", goods, 2300, 30000)
+
+	want := []struct {
+		category string
+		rate     string
+		discount int64
+		net, tax int64
+	}{
+		{"books", "10", 1100, 9000, 900},
+		{"", "20", 1200, 9000, 1800},
+		{ShippingCategory, "20", 0, 25000, 5000},
+	}
+	if len(b.Lines) != len(want) {
+		t.Fatalf("%d lines, want %d", len(b.Lines), len(want))
+	}
+	for i, w := range want {
+		l := b.Lines[i]
+		if l.Category != w.category || l.Rate != w.rate || l.Discount.Amount != w.discount ||
+			l.Net.Amount != w.net || l.Tax.Amount != w.tax {
+			t.Errorf("line %d: %+v, want %+v", i, l, w)
+		}
+	}
+	// The order total is what the customer pays: goods less the discount,
+	// plus shipping.
+	if b.Net.Amount != 43000 || b.Tax.Amount != 7700 || b.Gross.Amount != 23000-2300+30000 {
+		t.Errorf("totals net %d tax %d gross %d", b.Net.Amount, b.Tax.Amount, b.Gross.Amount)
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	for _, tc := range []struct {
+		amounts  []int64
+		discount int64
+		want     []int64
+	}{
+		{[]int64{300, 100}, 100, []int64{75, 25}},
+		{[]int64{100, 100, 100}, 1, []int64{1, 0, 0}},
+		{[]int64{100, 100, 100}, 2, []int64{1, 1, 0}},
+		{[]int64{1, 2}, 3, []int64{1, 2}},
+		{[]int64{100, 200}, 0, []int64{0, 0}},
+	} {
+		lines := make([]Line, len(tc.amounts))
+		for i, a := range tc.amounts {
+			lines[i].Amount = a
+		}
+		if got := allocate(lines, tc.discount); !slices.Equal(got, tc.want) {
+			t.Errorf("allocate(%v, %d) = %v, want %v", tc.amounts, tc.discount, got, tc.want)
+		}
+	}
+}
+
+func TestRate(t *testing.T) {
+	r, err := Parse("RU=20,RU:books=10,US=0,US-NY=8.875,US-NY:food=0", "RU", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		region, category string
+		want             string
+	}{
+		{"RU", "", "20"},
+		{"RU", "BOOKS", "10"},
+		{"RU-MOW", "books", "10"},
+		{"US-NY", "", "8.875"},
+		{"US-NY", "food", "0"},
+		{"US-CA", "", "0"},
+		{"DE", "", "0"},
+	} {
+		if got := formatRate(r.Rate(tc.region, tc.category)); got != tc.want {
+			t.Errorf("Rate(%q, %q) = %s, want %s", tc.region, tc.category, got, tc.want)
+		}
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	for _, s := range []string{"RU", "RU=abc", "RU=20.0001", "RU=101", "RU=-1"} {
+		if _, err := Parse(s, "RU", true); err == nil {
+			t.Errorf("Parse(%q) accepted", s)
+		}
+	}
+}
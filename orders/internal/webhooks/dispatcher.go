@@ -0,0 +1,326 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"orders/internal/domain"
+	"orders/internal/store"
+)
+
+const (
+	MaxAttempts = 10
+	baseBackoff = 10 * time.Second
+	maxBackoff  = time.Hour
+
+	// A claimed delivery is hidden from other replicas for this long.
+	claimLease = time.Minute
+)
+
+// Envelope is the JSON body POSTed to endpoints.
+type Envelope struct {
+	ID        uuid.UUID       `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Sign returns the X-Webhook-Signature value for body sent at ts:
+// "t=<unix>,v1=<hex HMAC-SHA256(secret, "<unix>.<body>")>".
+func Sign(secret string, ts time.Time, body []byte) string {
+	unix := strconv.FormatInt(ts.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unix + "."))
+	mac.Write(body)
+	return "t=" + unix + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func backoff(attempts int) time.Duration {
+	d := baseBackoff << (attempts - 1)
+	if d <= 0 || d > maxBackoff {
+		return maxBackoff
+	}
+	return d
+}
+
+// Dispatcher turns orders_outbox rows into webhook deliveries and sends them.
+// Several replicas can run it: outbox rows and due deliveries are claimed
+// with skip locked.
+type Dispatcher struct {
+	db     *sql.DB
+	client *http.Client
+}
+
+// NewDispatcher sends deliveries only to public addresses, so that an
+// endpoint can not make the service call itself, the internal network or
+// the cloud metadata service, whatever its name resolves to at the time.
+func NewDispatcher(db *sql.DB) *Dispatcher {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			addr, err := netip.ParseAddr(host)
+			if err != nil || !publicAddr(addr) {
+				return fmt.Errorf("%w: %s", ErrPrivateURL, host)
+			}
+			return nil
+		},
+	}
+	transport := &http.Transport{
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+		MaxIdleConnsPerHost: 2,
+	}
+	return &Dispatcher{db: db, client: &http.Client{Timeout: 10 * time.Second, Transport: transport}}
+}
+
+// publicAddr reports whether addr is on the internet: not loopback,
+// private, link-local (169.254.169.254 among them), shared address space,
+// unspecified or multicast.
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedSpace.Contains(addr)
+}
+
+// sharedSpace is the carrier-grade NAT range, private in all but name.
+var sharedSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+func (d *Dispatcher) Run(ctx context.Context) {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := d.fanOut(ctx); err != nil {
+				log.Printf("webhooks fan-out error: %v", err)
+			}
+			if err := d.deliverDue(ctx); err != nil {
+				log.Printf("webhooks delivery error: %v", err)
+			}
+		}
+	}
+}
+
+// eventType maps an outbox row to a webhook event type, or "" if the row is
+// not exposed through webhooks.
+func eventType(topic string, payload []byte) string {
+	switch topic {
+	case "payments.request":
+		return store.EventOrderCreated
+	case store.OrderEventsTopic:
+		var ev struct {
+			Type string `json:"type"`
+		}
+		_ = json.Unmarshal(payload, &ev)
+		return ev.Type
+	}
+	return ""
+}
+
+// fanOut creates the deliveries of outbox rows not fanned out yet and marks
+// them. Rows are tracked one by one rather than by a cursor over ids: an id
+// is taken at insert but seen at commit, so a long transaction can commit a
+// row below ids already handled.
+func (d *Dispatcher) fanOut(ctx context.Context) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	rows, err := tx.QueryContext(ctx,
+		`select id, message_id, topic, payload, created_at from orders_outbox
+		 where fanned_out_at is null
+		 order by id
+		 limit 100
+		 for update skip locked`,
+	)
+	if err != nil {
+		return err
+	}
+	type outboxRow struct {
+		id        int64
+		messageID uuid.UUID
+		topic     string
+		payload   []byte
+		createdAt time.Time
+	}
+	var batch []outboxRow
+	for rows.Next() {
+		var r outboxRow
+		if err := rows.Scan(&r.id, &r.messageID, &r.topic, &r.payload, &r.createdAt); err != nil {
+			rows.Close()
+			return err
+		}
+		batch = append(batch, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+
+	for _, r := range batch {
+		typ := eventType(r.topic, r.payload)
+		if typ == "" {
+			continue
+		}
+		body, _ := json.Marshal(Envelope{ID: r.messageID, Type: typ, CreatedAt: r.createdAt, Data: r.payload})
+		_, err := tx.ExecContext(ctx,
+			`insert into webhook_deliveries(endpoint_id, event_id, event_type, payload)
+			 select id, $1, $2, $3 from webhook_endpoints
+			 where active and (cardinality(event_types) = 0 or $2 = any(event_types))
+			 on conflict (endpoint_id, event_id) do nothing`,
+			r.messageID, typ, body,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	ids := make([]int64, len(batch))
+	for i, r := range batch {
+		ids[i] = r.id
+	}
+	_, err = tx.ExecContext(ctx, `update orders_outbox set fanned_out_at = now() where id = any($1)`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+type dueDelivery struct {
+	id       int64
+	eventID  uuid.UUID
+	typ      string
+	payload  []byte
+	attempts int
+	url      string
+	secret   string
+}
+
+func (d *Dispatcher) claimDue(ctx context.Context) ([]dueDelivery, error) {
+	rows, err := d.db.QueryContext(ctx,
+		`with due as (
+		   select id from webhook_deliveries
+		   where status = $1 and next_attempt_at <= now()
+		   order by next_attempt_at
+		   limit 20
+		   for update skip locked
+		 ), claimed as (
+		   update webhook_deliveries w set next_attempt_at = now() + make_interval(secs => $2)
+		   from due where w.id = due.id
+		   returning w.id, w.endpoint_id, w.event_id, w.event_type, w.payload, w.attempts
+		 )
+		 select c.id, c.event_id, c.event_type, c.payload, c.attempts, e.url, e.secret
+		 from claimed c join webhook_endpoints e on e.id = c.endpoint_id`,
+		string(domain.DeliveryPending), int(claimLease.Seconds()),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []dueDelivery
+	for rows.Next() {
+		var dd dueDelivery
+		if err := rows.Scan(&dd.id, &dd.eventID, &dd.typ, &dd.payload, &dd.attempts, &dd.url, &dd.secret); err != nil {
+			return nil, err
+		}
+		out = append(out, dd)
+	}
+	return out, rows.Err()
+}
+
+func (d *Dispatcher) deliverDue(ctx context.Context) error {
+	due, err := d.claimDue(ctx)
+	if err != nil {
+		return err
+	}
+	for _, dd := range due {
+		code, sendErr := d.send(ctx, dd)
+		if err := d.record(ctx, dd, code, sendErr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Dispatcher) send(ctx context.Context, dd dueDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, dd.url, bytes.NewReader(dd.payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "kpo-orders-webhooks/1")
+	req.Header.Set("X-Webhook-Id", dd.eventID.String())
+	req.Header.Set("X-Webhook-Event", dd.typ)
+	req.Header.Set("X-Webhook-Signature", Sign(dd.secret, time.Now(), dd.payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+func (d *Dispatcher) record(ctx context.Context, dd dueDelivery, code int, sendErr error) error {
+	attempts := dd.attempts + 1
+	var respCode sql.NullInt64
+	if code != 0 {
+		respCode = sql.NullInt64{Int64: int64(code), Valid: true}
+	}
+
+	if sendErr == nil {
+		_, err := d.db.ExecContext(ctx,
+			`update webhook_deliveries
+			 set status = $2, attempts = $3, response_code = $4, last_error = null, delivered_at = now()
+			 where id = $1`,
+			dd.id, string(domain.DeliveryDelivered), attempts, respCode,
+		)
+		return err
+	}
+
+	status := domain.DeliveryPending
+	if attempts >= MaxAttempts {
+		status = domain.DeliveryFailed
+	}
+	_, err := d.db.ExecContext(ctx,
+		`update webhook_deliveries
+		 set status = $2, attempts = $3, response_code = $4, last_error = $5, next_attempt_at = $6
+		 where id = $1`,
+		dd.id, string(status), attempts, respCode, sendErr.Error(), time.Now().Add(backoff(attempts)),
+	)
+	return err
+}
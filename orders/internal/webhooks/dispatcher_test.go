@@ -0,0 +1,163 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// verify checks a signature the way a receiver would.
+func verify(secret, header string, body []byte, now time.Time) bool {
+	var ts, sig string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(part, "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			sig = v
+		}
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || now.Sub(time.Unix(unix, 0)) > 5*time.Minute {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	want := hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(sig), []byte(want))
+}
+
+// loopbackDispatcher sends to httptest receivers, which listen on the
+// loopback address NewDispatcher refuses.
+func loopbackDispatcher() *Dispatcher {
+	return &Dispatcher{client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func TestSendSignsDelivery(t *testing.T) {
+	dd := dueDelivery{
+		id:      1,
+		eventID: uuid.New(),
+		typ:     "order.paid",
+		payload: []byte(`{"id":"x","type":"order.paid"}`),
+		secret:  "s3cret",
+	}
+	var got *http.Request
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = io.ReadAll(r.Body)
+		if !verify("s3cret", r.Header.Get("X-Webhook-Signature"), body, time.Now()) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	dd.url = srv.URL
+
+	code, err := loopbackDispatcher().send(context.Background(), dd)
+	if err != nil || code != http.StatusNoContent {
+		t.Fatalf("send: %d %v", code, err)
+	}
+	if got.Method != http.MethodPost || got.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("request %s %q", got.Method, got.Header.Get("Content-Type"))
+	}
+	if got.Header.Get("X-Webhook-Id") != dd.eventID.String() || got.Header.Get("X-Webhook-Event") != dd.typ {
+		t.Errorf("headers id %q event %q", got.Header.Get("X-Webhook-Id"), got.Header.Get("X-Webhook-Event"))
+	}
+	if string(body) != string(dd.payload) {
+		t.Errorf("body %s", body)
+	}
+}
+
+func TestSendRejectedByReceiver(t *testing.T) {
+	dd := dueDelivery{eventID: uuid.New(), typ: "order.paid", payload: []byte(`{}`), secret: "right"}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !verify("wrong", r.Header.Get("X-Webhook-Signature"), body, time.Now()) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}))
+	defer srv.Close()
+	dd.url = srv.URL
+
+	code, err := loopbackDispatcher().send(context.Background(), dd)
+	if err == nil || code != http.StatusUnauthorized {
+		t.Fatalf("send: %d %v, want 401 and an error", code, err)
+	}
+}
+
+func TestSendUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+
+	code, err := loopbackDispatcher().send(context.Background(), dueDelivery{eventID: uuid.New(), url: url, payload: []byte(`{}`)})
+	if err == nil || code != 0 {
+		t.Fatalf("send: %d %v, want no code and an error", code, err)
+	}
+}
+
+func TestSendRefusesPrivateAddress(t *testing.T) {
+	hit := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hit = true
+	}))
+	defer srv.Close()
+
+	_, err := NewDispatcher(nil).send(context.Background(), dueDelivery{eventID: uuid.New(), url: srv.URL, payload: []byte(`{}`)})
+	if !errors.Is(err, ErrPrivateURL) || hit {
+		t.Fatalf("send to %s: %v, reached %v; want ErrPrivateURL", srv.URL, err, hit)
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	for _, tc := range []struct {
+		url  string
+		want error
+	}{
+		{"https://hooks.example.com/orders", nil},
+		{"http://93.184.216.34:8080/hook", nil},
+		{"ftp://hooks.example.com/", ErrInvalidURL},
+		{"/relative", ErrInvalidURL},
+		{"http://localhost:8081/webhooks/create", ErrPrivateURL},
+		{"http://api.localhost/", ErrPrivateURL},
+		{"http://127.0.0.1/", ErrPrivateURL},
+		{"http://[::1]/", ErrPrivateURL},
+		{"http://169.254.169.254/latest/meta-data/", ErrPrivateURL},
+		{"http://10.0.0.5/", ErrPrivateURL},
+		{"http://172.16.3.4/", ErrPrivateURL},
+		{"http://192.168.1.1/", ErrPrivateURL},
+		{"http://100.64.0.1/", ErrPrivateURL},
+		{"http://0.0.0.0/", ErrPrivateURL},
+		{"http://[fd00::1]/", ErrPrivateURL},
+		{"http://[::ffff:127.0.0.1]/", ErrPrivateURL},
+	} {
+		if err := checkURL(tc.url); !errors.Is(err, tc.want) {
+			t.Errorf("checkURL(%q) = %v, want %v", tc.url, err, tc.want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	if backoff(1) != baseBackoff || backoff(2) != 2*baseBackoff {
+		t.Errorf("backoff(1..2) = %v, %v", backoff(1), backoff(2))
+	}
+	if backoff(MaxAttempts) > maxBackoff || backoff(64) != maxBackoff {
+		t.Errorf("backoff is not capped: %v, %v", backoff(MaxAttempts), backoff(64))
+	}
+}
@@ -0,0 +1,247 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"orders/internal/audit"
+	"orders/internal/domain"
+	"orders/internal/store"
+)
+
+var (
+	ErrNoEndpoint       = errors.New("no webhook endpoint")
+	ErrNoDelivery       = errors.New("no webhook delivery")
+	ErrInvalidURL       = errors.New("url should be an absolute http(s) url")
+	ErrPrivateURL       = errors.New("url should point to a public address")
+	ErrUnknownEventType = errors.New("unknown event type")
+)
+
+// EventTypes are the events an endpoint can subscribe to. An endpoint with no
+// event types receives all of them.
+var EventTypes = []string{
+	store.EventOrderCreated,
+	store.EventOrderPaid,
+	store.EventOrderCancelled,
+	store.EventOrderPartiallyRefunded,
+	store.EventOrderRefunded,
+	store.EventOrderPicking,
+	store.EventOrderShipped,
+	store.EventOrderDelivered,
+}
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func newSecret() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// checkURL accepts an absolute http(s) URL whose host is not a local or
+// private address. Names are not resolved here: what they resolve to is
+// checked by the dispatcher on every connection.
+func checkURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidURL
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrPrivateURL
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && !publicAddr(addr) {
+		return ErrPrivateURL
+	}
+	return nil
+}
+
+// CreateEndpoint registers a subscription and returns it with its secret.
+func (s *Store) CreateEndpoint(ctx context.Context, rawURL, secret string, eventTypes []string) (domain.WebhookEndpoint, string, error) {
+	if err := checkURL(rawURL); err != nil {
+		return domain.WebhookEndpoint{}, "", err
+	}
+	for _, t := range eventTypes {
+		if !slices.Contains(EventTypes, t) {
+			return domain.WebhookEndpoint{}, "", ErrUnknownEventType
+		}
+	}
+	if eventTypes == nil {
+		eventTypes = []string{}
+	}
+	if secret == "" {
+		secret = newSecret()
+	}
+
+	e := domain.WebhookEndpoint{
+		ID:         uuid.New(),
+		URL:        rawURL,
+		EventTypes: eventTypes,
+		Active:     true,
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return domain.WebhookEndpoint{}, "", err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	err = tx.QueryRowContext(ctx,
+		`insert into webhook_endpoints(id, url, secret, event_types) values ($1,$2,$3,$4)
+		 returning created_at`,
+		e.ID, e.URL, secret, pq.Array(e.EventTypes),
+	).Scan(&e.CreatedAt)
+	if err != nil {
+		return domain.WebhookEndpoint{}, "", err
+	}
+	// The secret stays out of the log.
+	if err := audit.Record(ctx, tx, audit.WebhookCreate, audit.Target("webhook", e.ID), nil, e); err != nil {
+		return domain.WebhookEndpoint{}, "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return domain.WebhookEndpoint{}, "", err
+	}
+	return e, secret, nil
+}
+
+func (s *Store) ListEndpoints(ctx context.Context) ([]domain.WebhookEndpoint, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`select id, url, event_types, active, created_at from webhook_endpoints order by created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []domain.WebhookEndpoint{}
+	for rows.Next() {
+		var e domain.WebhookEndpoint
+		if err := rows.Scan(&e.ID, &e.URL, pq.Array(&e.EventTypes), &e.Active, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// DisableEndpoint stops new deliveries to the endpoint. Its delivery log is
+// kept.
+func (s *Store) DisableEndpoint(ctx context.Context, id uuid.UUID) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var e domain.WebhookEndpoint
+	err = tx.QueryRowContext(ctx,
+		`select id, url, event_types, active, created_at from webhook_endpoints where id = $1 for update`, id,
+	).Scan(&e.ID, &e.URL, pq.Array(&e.EventTypes), &e.Active, &e.CreatedAt)
+	if err == sql.ErrNoRows {
+		return ErrNoEndpoint
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `update webhook_endpoints set active = false where id = $1`, id); err != nil {
+		return err
+	}
+	after := e
+	after.Active = false
+	if err := audit.Record(ctx, tx, audit.WebhookDisable, audit.Target("webhook", id), e, after); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Store) ListDeliveries(ctx context.Context, endpointID uuid.UUID, status domain.DeliveryStatus, limit int) ([]domain.WebhookDelivery, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	rows, err := s.db.QueryContext(ctx,
+		`select id, endpoint_id, event_id, event_type, status, attempts,
+		        coalesce(response_code, 0), coalesce(last_error, ''), next_attempt_at, created_at, delivered_at
+		 from webhook_deliveries
+		 where endpoint_id = $1 and ($2 = '' or status = $2)
+		 order by id desc
+		 limit $3`,
+		endpointID, string(status), limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []domain.WebhookDelivery{}
+	for rows.Next() {
+		var d domain.WebhookDelivery
+		var st string
+		var deliveredAt sql.NullTime
+		err := rows.Scan(&d.ID, &d.EndpointID, &d.EventID, &d.EventType, &st, &d.Attempts,
+			&d.ResponseCode, &d.LastError, &d.NextAttemptAt, &d.CreatedAt, &deliveredAt)
+		if err != nil {
+			return nil, err
+		}
+		d.Status = domain.DeliveryStatus(st)
+		if deliveredAt.Valid {
+			t := deliveredAt.Time
+			d.DeliveredAt = &t
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// Replay schedules a delivery to be sent again right away, whatever its
+// current status, with a fresh attempt budget.
+func (s *Store) Replay(ctx context.Context, deliveryID int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	type state struct {
+		Status   string `json:"status"`
+		Attempts int    `json:"attempts"`
+	}
+	var before state
+	err = tx.QueryRowContext(ctx,
+		`select status, attempts from webhook_deliveries where id = $1 for update`, deliveryID,
+	).Scan(&before.Status, &before.Attempts)
+	if err == sql.ErrNoRows {
+		return ErrNoDelivery
+	}
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		`update webhook_deliveries
+		 set status = $2, attempts = 0, next_attempt_at = $3, last_error = null
+		 where id = $1`,
+		deliveryID, string(domain.DeliveryPending), time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+	after := state{Status: string(domain.DeliveryPending)}
+	if err := audit.Record(ctx, tx, audit.DeliveryReplay, audit.Target("delivery", deliveryID), before, after); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
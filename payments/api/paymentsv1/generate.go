@@ -0,0 +1,4 @@
+// Package paymentsv1 holds the generated gRPC stubs for payments.proto.
+package paymentsv1
+
+//go:generate protoc -I .. --go_out=.. --go_opt=paths=source_relative --go-grpc_out=.. --go-grpc_opt=paths=source_relative paymentsv1/payments.proto
@@ -0,0 +1,63 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// BonusBucket is expiring promotional credit; it is spent before cash,
+// earliest expiry first.
+type BonusBucket struct {
+	ID        uuid.UUID `json:"id"`
+	UserID    string    `json:"user_id"`
+	Amount    Money     `json:"amount"`
+	Remaining Money     `json:"remaining"`
+	Source    string    `json:"source"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Wallet is the cash and unexpired bonus of one currency; Total is what a
+// payment can spend. Held is cash taken off for withdrawals not paid out yet.
+type Wallet struct {
+	Cash    Money         `json:"cash"`
+	Bonus   Money         `json:"bonus"`
+	Total   Money         `json:"total"`
+	Held    Money         `json:"held"`
+	Bonuses []BonusBucket `json:"bonuses"`
+}
+
+type GrantBonusRequest struct {
+	UserID    string    `json:"user_id"`
+	Amount    string    `json:"amount"`
+	Currency  string    `json:"currency,omitempty"`
+	Source    string    `json:"source"` // campaign
+	ExpiresAt time.Time `json:"expires_at"`
+
+	// IdempotencyKey is sent as the Idempotency-Key header. A random key is
+	// used when empty; set it to deduplicate across separate calls.
+	IdempotencyKey string `json:"-"`
+}
+
+// GrantBonus is a back-office call and needs a client made WithOperator.
+func (c *Client) GrantBonus(ctx context.Context, req GrantBonusRequest) (BonusBucket, error) {
+	key := req.IdempotencyKey
+	if key == "" {
+		key = uuid.NewString()
+	}
+	var b BonusBucket
+	err := c.do(ctx, http.MethodPost, "/bonuses/grant", key, req, &b)
+	return b, err
+}
+
+// Wallets returns cash and bonus of every wallet of the user.
+func (c *Client) Wallets(ctx context.Context, userID string) ([]Wallet, error) {
+	var resp struct {
+		Wallets []Wallet `json:"wallets"`
+	}
+	err := c.do(ctx, http.MethodPost, "/balance", "", map[string]string{"user_id": userID}, &resp)
+	return resp.Wallets, err
+}
@@ -0,0 +1,79 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Card is sent to the card gateway as is; the sandbox gateway's test cards
+// are listed in the README.
+type Card struct {
+	Number   string `json:"number"`
+	ExpMonth int    `json:"exp_month"`
+	ExpYear  int    `json:"exp_year"`
+	CVC      string `json:"cvc"`
+}
+
+// Card charge statuses. A CHALLENGE charge waits for the card holder to
+// visit ChallengeURL; poll CardCharge for the outcome.
+const (
+	ChargePending   = "PENDING"
+	ChargeChallenge = "CHALLENGE"
+	ChargeSucceeded = "SUCCEEDED"
+	ChargeFailed    = "FAILED"
+)
+
+type CardCharge struct {
+	ID           uuid.UUID  `json:"id"`
+	Kind         string     `json:"kind"` // TOPUP or PAYMENT
+	UserID       string     `json:"user_id"`
+	OrderID      *uuid.UUID `json:"order_id,omitempty"`
+	Amount       Money      `json:"amount"`
+	Status       string     `json:"status"`
+	Provider     string     `json:"provider"`
+	ProviderTxID string     `json:"provider_tx_id,omitempty"`
+	ChallengeURL string     `json:"challenge_url,omitempty"`
+	FailureCode  string     `json:"failure_code,omitempty"` // e.g. card_declined
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+type CardTopUpRequest struct {
+	UserID   string `json:"user_id"`
+	Amount   string `json:"amount"`
+	Currency string `json:"currency,omitempty"`
+	Card     Card   `json:"card"`
+
+	// IdempotencyKey is sent as the Idempotency-Key header. A random key is
+	// used when empty; set it to deduplicate across separate calls.
+	IdempotencyKey string `json:"-"`
+}
+
+// TopUpByCard charges the card and credits the wallet once the charge
+// SUCCEEDED. A declined card is a FAILED charge, not an error.
+func (c *Client) TopUpByCard(ctx context.Context, req CardTopUpRequest) (CardCharge, error) {
+	key := req.IdempotencyKey
+	if key == "" {
+		key = uuid.NewString()
+	}
+	var ch CardCharge
+	err := c.do(ctx, http.MethodPost, "/topup/card", key, req, &ch)
+	return ch, err
+}
+
+// PayByCard pays an order created with payment method "card". Calling it
+// again for the order returns the same charge.
+func (c *Client) PayByCard(ctx context.Context, orderID uuid.UUID, card Card) (CardCharge, error) {
+	var ch CardCharge
+	req := map[string]any{"order_id": orderID.String(), "card": card}
+	err := c.do(ctx, http.MethodPost, "/pay/card", "", req, &ch)
+	return ch, err
+}
+
+func (c *Client) CardCharge(ctx context.Context, id uuid.UUID) (CardCharge, error) {
+	var ch CardCharge
+	err := c.do(ctx, http.MethodGet, "/card-charges/"+id.String(), "", nil, &ch)
+	return ch, err
+}
@@ -0,0 +1,67 @@
+package client
+
+import (
+	"context"
+	"net/http"
+)
+
+// AccountControls are the spending limits of a wallet and the freeze and
+// block flags of its user. Nil limits are not set.
+type AccountControls struct {
+	UserID         string `json:"user_id"`
+	Currency       string `json:"currency"`
+	DailyLimit     *Money `json:"daily_limit,omitempty"`
+	WeeklyLimit    *Money `json:"weekly_limit,omitempty"`
+	MonthlyLimit   *Money `json:"monthly_limit,omitempty"`
+	MaxPayment     *Money `json:"max_payment,omitempty"`
+	Frozen         bool   `json:"frozen"`
+	Blocked        bool   `json:"blocked"`
+	BlockedReason  string `json:"blocked_reason,omitempty"`
+	SpentToday     Money  `json:"spent_today"`
+	SpentThisWeek  Money  `json:"spent_this_week"`
+	SpentThisMonth Money  `json:"spent_this_month"`
+}
+
+// LimitsRequest replaces all limits of a wallet; an empty limit is removed.
+// Amounts are decimals in major units of Currency.
+type LimitsRequest struct {
+	UserID       string `json:"user_id"`
+	Currency     string `json:"currency,omitempty"`
+	DailyLimit   string `json:"daily_limit,omitempty"`
+	WeeklyLimit  string `json:"weekly_limit,omitempty"`
+	MonthlyLimit string `json:"monthly_limit,omitempty"`
+	MaxPayment   string `json:"max_payment,omitempty"`
+}
+
+func (c *Client) Controls(ctx context.Context, userID, currency string) (AccountControls, error) {
+	var ac AccountControls
+	req := map[string]string{"user_id": userID, "currency": currency}
+	err := c.do(ctx, http.MethodPost, "/accounts/controls", "", req, &ac)
+	return ac, err
+}
+
+func (c *Client) SetLimits(ctx context.Context, req LimitsRequest) (AccountControls, error) {
+	var ac AccountControls
+	err := c.do(ctx, http.MethodPost, "/accounts/controls/set", "", req, &ac)
+	return ac, err
+}
+
+// Freeze stops payments and exchanges from every wallet of the user;
+// top-ups still go through. Freezes and blocks are back-office calls and
+// need a client made WithOperator.
+func (c *Client) Freeze(ctx context.Context, userID string) error {
+	return c.do(ctx, http.MethodPost, "/accounts/freeze", "", map[string]string{"user_id": userID}, nil)
+}
+
+func (c *Client) Unfreeze(ctx context.Context, userID string) error {
+	return c.do(ctx, http.MethodPost, "/accounts/unfreeze", "", map[string]string{"user_id": userID}, nil)
+}
+
+// Block stops payments, exchanges and top-ups of every wallet of the user.
+func (c *Client) Block(ctx context.Context, userID, reason string) error {
+	return c.do(ctx, http.MethodPost, "/accounts/block", "", map[string]string{"user_id": userID, "reason": reason}, nil)
+}
+
+func (c *Client) Unblock(ctx context.Context, userID string) error {
+	return c.do(ctx, http.MethodPost, "/accounts/unblock", "", map[string]string{"user_id": userID}, nil)
+}
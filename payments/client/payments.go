@@ -0,0 +1,205 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Money is a decimal amount in major units, e.g. {"value":"10.99","currency":"USD"}.
+type Money struct {
+	Value    string `json:"value"`
+	Currency string `json:"currency"`
+}
+
+type Account struct {
+	UserID  string `json:"user_id"`
+	Balance Money  `json:"balance"`
+}
+
+type TopUpRequest struct {
+	UserID string `json:"user_id"`
+	// Amount is a decimal in major units of Currency, e.g. "10.99".
+	Amount   string `json:"amount"`
+	Currency string `json:"currency,omitempty"`
+
+	// IdempotencyKey is sent as the Idempotency-Key header. A random key is
+	// used when empty; set it to deduplicate across separate calls.
+	IdempotencyKey string `json:"-"`
+}
+
+type PayRequest struct {
+	OrderID  uuid.UUID `json:"order_id"`
+	UserID   string    `json:"user_id"`
+	Amount   string    `json:"amount"`
+	Currency string    `json:"currency,omitempty"`
+}
+
+// Failure reasons of a declined payment.
+const (
+	ReasonNoAccount         = "NO_ACCOUNT"
+	ReasonNoWallet          = "NO_WALLET"
+	ReasonInsufficientFunds = "INSUFFICIENT_FUNDS"
+	ReasonLimitExceeded     = "LIMIT_EXCEEDED"
+	ReasonAccountFrozen     = "ACCOUNT_FROZEN"
+	ReasonAccountBlocked    = "ACCOUNT_BLOCKED"
+	ReasonFraudDenied       = "FRAUD_DENIED"
+	ReasonFraudRejected     = "FRAUD_REJECTED"
+	ReasonCardDeclined      = "CARD_DECLINED"
+	ReasonAdminCancelled    = "ADMIN_CANCELLED"
+)
+
+type Payment struct {
+	OrderID uuid.UUID `json:"order_id"`
+	UserID  string    `json:"user_id"`
+	Amount  Money     `json:"amount"`
+	Status  string    `json:"status"`
+	// FailureReason is the code of a decline (see the Reason constants) and
+	// FailureMessage says the same in words.
+	FailureReason  string `json:"failure_reason,omitempty"`
+	FailureMessage string `json:"failure_message,omitempty"`
+	// ReviewReason is set on PENDING_REVIEW: the fraud rule that held it.
+	ReviewReason string `json:"review_reason,omitempty"`
+	// ReviewedBy is the operator who approved or rejected it on review.
+	ReviewedBy string `json:"reviewed_by,omitempty"`
+	// Provider and ProviderTxID are set on a payment made by card.
+	Provider     string `json:"provider,omitempty"`
+	ProviderTxID string `json:"provider_tx_id,omitempty"`
+	// Bonus is the part of Amount paid from bonus credit.
+	Bonus *Money `json:"bonus,omitempty"`
+	// Split payments are paid by the payers in Shares.
+	Split  bool           `json:"split,omitempty"`
+	Shares []PaymentShare `json:"shares,omitempty"`
+}
+
+// PaymentShare is one payer's part of a split payment: HELD off their
+// balance, then CAPTURED, or RELEASED back if another payer failed.
+type PaymentShare struct {
+	UserID string `json:"user_id"`
+	Amount Money  `json:"amount"`
+	Status string `json:"status"`
+}
+
+// CreateAccount opens a wallet in currency; empty means the service default.
+func (c *Client) CreateAccount(ctx context.Context, userID, currency string) (Account, error) {
+	var a Account
+	req := map[string]string{"user_id": userID, "currency": currency}
+	err := c.do(ctx, http.MethodPost, "/create", "", req, &a)
+	return a, err
+}
+
+// TopUp credits the wallet without a card provider and returns the balance
+// after it. It is a back-office call and needs a client made WithOperator;
+// customers top up with TopUpByCard.
+func (c *Client) TopUp(ctx context.Context, req TopUpRequest) (Money, error) {
+	key := req.IdempotencyKey
+	if key == "" {
+		key = uuid.NewString()
+	}
+	var resp struct {
+		Balance Money `json:"balance"`
+	}
+	err := c.do(ctx, http.MethodPost, "/topup", key, req, &resp)
+	return resp.Balance, err
+}
+
+// Balances returns every wallet of the user.
+func (c *Client) Balances(ctx context.Context, userID string) ([]Money, error) {
+	var resp struct {
+		Balances []Money `json:"balances"`
+	}
+	err := c.do(ctx, http.MethodPost, "/balance", "", map[string]string{"user_id": userID}, &resp)
+	return resp.Balances, err
+}
+
+func (c *Client) Pay(ctx context.Context, req PayRequest) (Payment, error) {
+	var p Payment
+	err := c.do(ctx, http.MethodPost, "/pay", "", req, &p)
+	return p, err
+}
+
+type RefundRequest struct {
+	OrderID uuid.UUID `json:"order_id"`
+	// An empty Amount refunds everything not refunded yet.
+	Amount   string `json:"amount,omitempty"`
+	Currency string `json:"currency,omitempty"`
+	Reason   string `json:"reason"`
+
+	// IdempotencyKey is sent as the Idempotency-Key header. A random key is
+	// used when empty; set it to deduplicate across separate calls.
+	IdempotencyKey string `json:"-"`
+}
+
+type Refund struct {
+	ID      uuid.UUID `json:"id"`
+	OrderID uuid.UUID `json:"order_id"`
+	UserID  string    `json:"user_id"`
+	Amount  Money     `json:"amount"`
+	// Status is SUCCEEDED, PENDING while a refund to a card is on its way,
+	// or FAILED if support gave it up.
+	Status    string    `json:"status"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+	// Bonus is the part of Amount returned as bonus credit; cash is
+	// refunded first.
+	Bonus *Money `json:"bonus,omitempty"`
+}
+
+type RefundResponse struct {
+	Refund        Refund `json:"refund"`
+	Captured      Money  `json:"captured"`
+	RefundedTotal Money  `json:"refunded_total"`
+}
+
+// Refund is a back-office call and needs a client made WithOperator.
+func (c *Client) Refund(ctx context.Context, req RefundRequest) (RefundResponse, error) {
+	key := req.IdempotencyKey
+	if key == "" {
+		key = uuid.NewString()
+	}
+	var resp RefundResponse
+	err := c.do(ctx, http.MethodPost, "/refunds", key, req, &resp)
+	return resp, err
+}
+
+// CancelRefund gives up the refund of an order stuck PENDING at the card
+// gateway, so that the order can be refunded again. Make sure the gateway
+// has not paid it first.
+func (c *Client) CancelRefund(ctx context.Context, orderID uuid.UUID, reason string) (Refund, error) {
+	var r Refund
+	req := map[string]string{"order_id": orderID.String(), "reason": reason}
+	err := c.do(ctx, http.MethodPost, "/admin/refunds/cancel", "", req, &r)
+	return r, err
+}
+
+type ExchangeRequest struct {
+	UserID string `json:"user_id"`
+	Amount string `json:"amount"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+
+	// IdempotencyKey is sent as the Idempotency-Key header. A random key is
+	// used when empty; set it to deduplicate across separate calls.
+	IdempotencyKey string `json:"-"`
+}
+
+type Exchange struct {
+	ID        uuid.UUID `json:"id"`
+	UserID    string    `json:"user_id"`
+	From      Money     `json:"from"`
+	To        Money     `json:"to"`
+	Rate      string    `json:"rate"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (c *Client) Exchange(ctx context.Context, req ExchangeRequest) (Exchange, error) {
+	key := req.IdempotencyKey
+	if key == "" {
+		key = uuid.NewString()
+	}
+	var ex Exchange
+	err := c.do(ctx, http.MethodPost, "/exchange", key, req, &ex)
+	return ex, err
+}
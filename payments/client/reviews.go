@@ -0,0 +1,44 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PendingReview is a payment held by the fraud rules.
+type PendingReview struct {
+	OrderID      uuid.UUID `json:"order_id"`
+	UserID       string    `json:"user_id"`
+	Amount       Money     `json:"amount"`
+	ReviewReason string    `json:"review_reason"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// PendingReviews lists held payments, oldest first; limit 0 means the
+// service default. Reviews are back-office calls and need a client made
+// WithOperator.
+func (c *Client) PendingReviews(ctx context.Context, limit int) ([]PendingReview, error) {
+	var resp struct {
+		Payments []PendingReview `json:"payments"`
+	}
+	err := c.do(ctx, http.MethodPost, "/admin/reviews", "", map[string]int{"limit": limit}, &resp)
+	return resp.Payments, err
+}
+
+// ApproveReview debits the held payment, or fails it if the balance or the
+// limits no longer allow it.
+func (c *Client) ApproveReview(ctx context.Context, orderID uuid.UUID) (Payment, error) {
+	var p Payment
+	err := c.do(ctx, http.MethodPost, "/admin/reviews/approve", "", map[string]string{"order_id": orderID.String()}, &p)
+	return p, err
+}
+
+func (c *Client) RejectReview(ctx context.Context, orderID uuid.UUID, reason string) (Payment, error) {
+	var p Payment
+	req := map[string]string{"order_id": orderID.String(), "reason": reason}
+	err := c.do(ctx, http.MethodPost, "/admin/reviews/reject", "", req, &p)
+	return p, err
+}
@@ -0,0 +1,49 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type StatementEntry struct {
+	At        time.Time `json:"at"`
+	Type      string    `json:"type"` // TOPUP, PAYMENT, REFUND, EXCHANGE_OUT, EXCHANGE_IN
+	Reference string    `json:"reference"`
+	Detail    string    `json:"detail,omitempty"`
+	Amount    Money     `json:"amount"` // negative for debits
+	Balance   Money     `json:"balance"`
+}
+
+type Statement struct {
+	UserID      string           `json:"user_id"`
+	Currency    string           `json:"currency"`
+	From        time.Time        `json:"from"`
+	To          time.Time        `json:"to"`
+	Opening     Money            `json:"opening_balance"`
+	Credits     Money            `json:"credits"`
+	Debits      Money            `json:"debits"`
+	Closing     Money            `json:"closing_balance"`
+	Entries     []StatementEntry `json:"entries"`
+	GeneratedAt time.Time        `json:"generated_at"`
+}
+
+// Statement returns the movements of the user's wallet in currency (the
+// service default if empty) in [from, to). Zero times mean the start of
+// this month and now.
+func (c *Client) Statement(ctx context.Context, userID, currency string, from, to time.Time) (Statement, error) {
+	q := url.Values{}
+	if currency != "" {
+		q.Set("currency", currency)
+	}
+	if !from.IsZero() {
+		q.Set("from", from.Format(time.RFC3339))
+	}
+	if !to.IsZero() {
+		q.Set("to", to.Format(time.RFC3339))
+	}
+	var st Statement
+	err := c.do(ctx, http.MethodGet, "/accounts/"+url.PathEscape(userID)+"/statement?"+q.Encode(), "", nil, &st)
+	return st, err
+}
@@ -0,0 +1,94 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Withdrawal statuses.
+const (
+	WithdrawalRequested = "REQUESTED"
+	WithdrawalApproved  = "APPROVED"
+	WithdrawalPaidOut   = "PAID_OUT"
+	WithdrawalRejected  = "REJECTED"
+)
+
+// Withdrawal takes money out of a wallet. The amount is held off the balance
+// from the request until it is paid out, or returned when rejected.
+type Withdrawal struct {
+	ID          uuid.UUID `json:"id"`
+	UserID      string    `json:"user_id"`
+	Amount      Money     `json:"amount"`
+	Destination string    `json:"destination"`
+	Status      string    `json:"status"`
+	Reason      string    `json:"reason,omitempty"`
+	Provider    string    `json:"provider,omitempty"`
+	PayoutID    string    `json:"payout_id,omitempty"`
+	DecidedBy   string    `json:"decided_by,omitempty"` // operator who approved or rejected it
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+type WithdrawalRequest struct {
+	UserID      string `json:"user_id"`
+	Amount      string `json:"amount"`
+	Currency    string `json:"currency,omitempty"`
+	Destination string `json:"destination"`
+
+	// IdempotencyKey is sent as the Idempotency-Key header. A random key is
+	// used when empty; set it to deduplicate across separate calls.
+	IdempotencyKey string `json:"-"`
+}
+
+func (c *Client) RequestWithdrawal(ctx context.Context, req WithdrawalRequest) (Withdrawal, error) {
+	key := req.IdempotencyKey
+	if key == "" {
+		key = uuid.NewString()
+	}
+	var wd Withdrawal
+	err := c.do(ctx, http.MethodPost, "/withdrawals", key, req, &wd)
+	return wd, err
+}
+
+// Withdrawals lists the user's withdrawals newest first. An empty status
+// lists every status; limit 0 means the service default.
+func (c *Client) Withdrawals(ctx context.Context, userID, status string, limit int) ([]Withdrawal, error) {
+	var resp struct {
+		Withdrawals []Withdrawal `json:"withdrawals"`
+	}
+	req := map[string]any{"user_id": userID, "status": status, "limit": limit}
+	err := c.do(ctx, http.MethodPost, "/withdrawals/list", "", req, &resp)
+	return resp.Withdrawals, err
+}
+
+// AllWithdrawals lists withdrawals of every user, or of userID if it is not
+// empty, for the review queue. It is a back-office call and needs a client
+// made WithOperator.
+func (c *Client) AllWithdrawals(ctx context.Context, userID, status string, limit int) ([]Withdrawal, error) {
+	var resp struct {
+		Withdrawals []Withdrawal `json:"withdrawals"`
+	}
+	req := map[string]any{"user_id": userID, "status": status, "limit": limit}
+	err := c.do(ctx, http.MethodPost, "/admin/withdrawals/list", "", req, &resp)
+	return resp.Withdrawals, err
+}
+
+// ApproveWithdrawal sends a requested withdrawal to the payout provider. It
+// comes back PAID_OUT, REJECTED by the provider, or APPROVED while the payout
+// is retried. Decisions are back-office calls and need a client made
+// WithOperator.
+func (c *Client) ApproveWithdrawal(ctx context.Context, id uuid.UUID) (Withdrawal, error) {
+	var wd Withdrawal
+	err := c.do(ctx, http.MethodPost, "/admin/withdrawals/approve", "", map[string]string{"id": id.String()}, &wd)
+	return wd, err
+}
+
+func (c *Client) RejectWithdrawal(ctx context.Context, id uuid.UUID, reason string) (Withdrawal, error) {
+	var wd Withdrawal
+	req := map[string]string{"id": id.String(), "reason": reason}
+	err := c.do(ctx, http.MethodPost, "/admin/withdrawals/reject", "", req, &wd)
+	return wd, err
+}
@@ -0,0 +1,33 @@
+// Command auditverify checks the hash chains of the payments audit log. It
+// prints the digest of their heads, to compare with one noted earlier, and
+// exits with status 1 if a chain is broken.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"payments/internal/audit"
+	"payments/internal/db"
+)
+
+func main() {
+	sqlDB, err := db.OpenDB()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer sqlDB.Close()
+
+	r, err := audit.Verify(context.Background(), sqlDB)
+	if errors.Is(err, audit.ErrBrokenChain) {
+		fmt.Printf("%v\nchains checked before it: %d\n", err, r.Targets)
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("ok: %d entries in %d chains, digest %s\n", r.Entries, r.Targets, r.Digest)
+}
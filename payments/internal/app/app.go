@@ -2,12 +2,24 @@ package app
 
 import (
 	"context"
+	"expvar"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"time"
 
+	"payments/internal/audit"
 	"payments/internal/db"
+	"payments/internal/fraud"
+	"payments/internal/grpcapi"
 	"payments/internal/httpapi"
 	"payments/internal/kafka"
+	"payments/internal/money"
+	"payments/internal/payout"
+	"payments/internal/provider"
+	"payments/internal/provider/sandbox"
+	"payments/internal/statement"
 	"payments/internal/store"
 )
 
@@ -16,8 +28,13 @@ func Run() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	st := store.NewStore(db)
 	ctx := context.Background()
+	screen, err := fraud.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	go screen.Watch(ctx, 5*time.Second)
+	st := store.NewStore(db, screen, cardGateway(), payout.NewStub())
 	cons := kafka.NewPaymentRequestConsumer(db, st)
 	go cons.Run(ctx)
 
@@ -28,10 +45,78 @@ func Run() {
 	pub := kafka.NewOutboxPublisher(db, prod)
 	go pub.Run(ctx)
 
+	grpcAddr := os.Getenv("GRPC_ADDR")
+	if grpcAddr == "" {
+		grpcAddr = ":9090"
+	}
+	lis, err := net.Listen("tcp", grpcAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	authToken := os.Getenv("GRPC_AUTH_TOKEN")
+	if authToken == "" {
+		if os.Getenv("GRPC_INSECURE") != "true" {
+			log.Fatal("GRPC_AUTH_TOKEN is empty; set it, or GRPC_INSECURE=true to serve gRPC without auth")
+		}
+		log.Println("GRPC_INSECURE is set, gRPC auth is disabled")
+	}
+	grpcSrv := grpcapi.NewServer(st, authToken)
+	go func() {
+		log.Printf("payments gRPC listening on %s", grpcAddr)
+		log.Fatal(grpcSrv.Serve(lis))
+	}()
+
+	rates, err := money.LoadRates()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	font, err := statement.LoadFont()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Nightly at 02:00 UTC, well after the previous month has settled.
+	go st.RunStatementSnapshots(ctx, 2)
+	go st.RunBonusExpiry(ctx, time.Minute)
+	go st.RunPayouts(audit.WithSource(ctx, audit.Source{Actor: "job:payouts"}), time.Minute)
 
 	mux := http.NewServeMux()
-	httpapi.RegisterRoutes(mux, st)
+	serviceToken := os.Getenv("SERVICE_TOKEN")
+	if serviceToken == "" {
+		log.Println("SERVICE_TOKEN is empty, back-office routes are closed")
+	}
+	httpapi.RegisterRoutes(mux, serviceToken, st, rates, font)
+	mux.Handle("/debug/vars", expvar.Handler())
 
 	log.Println("payments listening on :8080")
 	log.Fatal(http.ListenAndServe(":8080", mux))
 }
+
+// cardGateway connects to the card gateway at CARD_GATEWAY_URL. Without one
+// the sandbox gateway is started in-process on SANDBOX_ADDR; its test cards
+// are listed in the README.
+func cardGateway() provider.Provider {
+	secret := getenv("SANDBOX_SECRET", "sandbox-secret")
+	url := os.Getenv("CARD_GATEWAY_URL")
+	if url != "" {
+		return sandbox.NewClient(url, secret)
+	}
+
+	addr := getenv("SANDBOX_ADDR", ":8090")
+	srv := sandbox.NewServer(secret,
+		getenv("SANDBOX_PUBLIC_URL", "http://localhost:8092"),
+		getenv("PROVIDER_WEBHOOK_URL", "http://localhost:8080/provider/webhook"),
+	)
+	go func() {
+		log.Printf("sandbox card gateway listening on %s", addr)
+		log.Fatal(http.ListenAndServe(addr, srv.Handler()))
+	}()
+	return sandbox.NewClient("http://localhost"+addr, secret)
+}
+
+func getenv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
@@ -1,39 +1,222 @@
 package domain
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"time"
+
+	"payments/internal/provider"
+)
 
 type CreateAccountReq struct {
-	UserID string `json:"user_id"`
+	UserID   string `json:"user_id"`
+	Currency string `json:"currency,omitempty"` // ISO-4217, DEFAULT_CURRENCY if empty
 }
 
 type CreateAccountResp struct {
-	UserID  string      `json:"user_id"`
-	Balance json.Number `json:"balance"`
+	UserID  string `json:"user_id"`
+	Balance Money  `json:"balance"`
 }
 
 type TopUpReq struct {
-	UserID string       `json:"user_id"`
-	Amount json.Number  `json:"amount"`
+	UserID string `json:"user_id"`
+	// Amount is a decimal in major units of Currency, e.g. 10.99.
+	Amount   json.Number `json:"amount"`
+	Currency string      `json:"currency,omitempty"`
+}
+
+// CardTopUpReq tops up a wallet from a card through the card gateway.
+type CardTopUpReq struct {
+	UserID   string        `json:"user_id"`
+	Amount   json.Number   `json:"amount"`
+	Currency string        `json:"currency,omitempty"`
+	Card     provider.Card `json:"card"`
+}
+
+// CardPayReq pays an order created with payment_method "card".
+type CardPayReq struct {
+	OrderID string        `json:"order_id"`
+	Card    provider.Card `json:"card"`
 }
 
 type TopUpResp struct {
-	Balance json.Number `json:"balance"`
+	Balance Money `json:"balance"`
 }
 
+// BalanceReq returns one wallet when Currency is set and all of them otherwise.
 type BalanceReq struct {
-	UserID string `json:"user_id"`
+	UserID   string `json:"user_id"`
+	Currency string `json:"currency,omitempty"`
 }
 
+// BalanceResp lists cash balances in Balances, as before bonuses existed,
+// and cash and bonus apart in Wallets.
 type BalanceResp struct {
-	Balance json.Number `json:"balance"`
+	Balances []Money         `json:"balances"`
+	Wallets  []WalletBalance `json:"wallets"`
+}
+
+type WithdrawalReq struct {
+	UserID      string      `json:"user_id"`
+	Amount      json.Number `json:"amount"`
+	Currency    string      `json:"currency,omitempty"`
+	Destination string      `json:"destination"` // bank account, IBAN or card number
+}
+
+// WithdrawalsReq lists withdrawals, newest first: those of UserID, or of
+// everyone when it is empty, which only the back office may ask; only
+// Status ones when it is set.
+type WithdrawalsReq struct {
+	UserID string           `json:"user_id,omitempty"`
+	Status WithdrawalStatus `json:"status,omitempty"`
+	Limit  int              `json:"limit,omitempty"` // 50 by default, at most 500
+}
+
+type WithdrawalsResp struct {
+	Withdrawals []Withdrawal `json:"withdrawals"`
+}
+
+// WithdrawalDecisionReq approves or rejects a REQUESTED withdrawal. Reason
+// is required on rejection.
+type WithdrawalDecisionReq struct {
+	ID     string `json:"id"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// GrantBonusReq credits a wallet with bonus money of a campaign (Source)
+// that expires at ExpiresAt (RFC3339).
+type GrantBonusReq struct {
+	UserID    string      `json:"user_id"`
+	Amount    json.Number `json:"amount"`
+	Currency  string      `json:"currency,omitempty"`
+	Source    string      `json:"source"`
+	ExpiresAt time.Time   `json:"expires_at"`
 }
 
 type PayReq struct {
-	OrderID string       `json:"order_id"`
-	UserID  string       `json:"user_id"`
-	Amount  json.Number  `json:"amount"`
+	OrderID  string      `json:"order_id"`
+	UserID   string      `json:"user_id"`
+	Amount   json.Number `json:"amount"`
+	Currency string      `json:"currency,omitempty"`
 }
 
 type ErrResp struct {
 	Error string `json:"error"`
-}
\ No newline at end of file
+}
+
+// RefundReq refunds part of a successful payment. An empty amount refunds
+// everything not refunded yet. Currency, if set, must match the payment.
+type RefundReq struct {
+	OrderID  string      `json:"order_id"`
+	Amount   json.Number `json:"amount,omitempty"`
+	Currency string      `json:"currency,omitempty"`
+	Reason   string      `json:"reason"`
+}
+
+// CancelRefundReq gives up the PENDING refund of an order to the card.
+type CancelRefundReq struct {
+	OrderID string `json:"order_id"`
+	Reason  string `json:"reason"`
+}
+
+type RefundResp struct {
+	Refund        Refund `json:"refund"`
+	Captured      Money  `json:"captured"`
+	RefundedTotal Money  `json:"refunded_total"`
+}
+
+// ExchangeReq converts Amount of From into To between the user's wallets.
+type ExchangeReq struct {
+	UserID string      `json:"user_id"`
+	Amount json.Number `json:"amount"`
+	From   string      `json:"from"`
+	To     string      `json:"to"`
+}
+
+type AccountControlsReq struct {
+	UserID   string `json:"user_id"`
+	Currency string `json:"currency,omitempty"`
+}
+
+// SetLimitsReq replaces all limits of a wallet; an omitted limit is removed.
+type SetLimitsReq struct {
+	UserID       string      `json:"user_id"`
+	Currency     string      `json:"currency,omitempty"`
+	DailyLimit   json.Number `json:"daily_limit,omitempty"`
+	WeeklyLimit  json.Number `json:"weekly_limit,omitempty"`
+	MonthlyLimit json.Number `json:"monthly_limit,omitempty"`
+	MaxPayment   json.Number `json:"max_payment,omitempty"`
+}
+
+// AccountFlagReq freezes, unfreezes, blocks or unblocks all wallets of a
+// user. Reason is required to block.
+type AccountFlagReq struct {
+	UserID string `json:"user_id"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type ReviewsReq struct {
+	Limit int `json:"limit,omitempty"` // 50 by default, at most 500
+}
+
+type ReviewsResp struct {
+	Payments []PendingReview `json:"payments"`
+}
+
+// ReviewDecisionReq approves or rejects a payment held for review. Reason
+// is recorded on rejection.
+type ReviewDecisionReq struct {
+	OrderID string `json:"order_id"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// AdjustBalanceReq corrects a balance from the back office. Amount is
+// signed: negative debits the wallet.
+type AdjustBalanceReq struct {
+	UserID   string      `json:"user_id"`
+	Amount   json.Number `json:"amount"`
+	Currency string      `json:"currency,omitempty"`
+	Reason   string      `json:"reason"`
+}
+
+// VoidPaymentReq fails the payment of an order that is to be cancelled by
+// hand. UserID, Amount and Currency are those of the order; they record the
+// payment if the request has not reached payments yet.
+type VoidPaymentReq struct {
+	OrderID  string      `json:"order_id"`
+	UserID   string      `json:"user_id"`
+	Amount   json.Number `json:"amount"`
+	Currency string      `json:"currency,omitempty"`
+	Reason   string      `json:"reason"`
+}
+
+type OutboxReq struct {
+	PendingOnly bool `json:"pending_only,omitempty"`
+	Limit       int  `json:"limit,omitempty"` // 50 by default, at most 500
+}
+
+type RepublishReq struct {
+	MessageID string `json:"message_id"`
+	Reason    string `json:"reason"`
+}
+
+type InboxReq struct {
+	Limit int `json:"limit,omitempty"` // 50 by default, at most 500
+}
+
+// AuditQueryReq reads the audit log, newest first. Empty fields do not
+// filter; NextBeforeSeq of a response fetches the page after it.
+type AuditQueryReq struct {
+	Actor     string     `json:"actor,omitempty"`
+	Action    string     `json:"action,omitempty"`
+	Target    string     `json:"target,omitempty"` // e.g. "account:<user_id>"
+	RequestID string     `json:"request_id,omitempty"`
+	From      *time.Time `json:"from,omitempty"`
+	To        *time.Time `json:"to,omitempty"`
+	BeforeSeq int64      `json:"before_seq,omitempty"`
+	Limit     int        `json:"limit,omitempty"` // 50 by default, at most 500
+}
+
+type AuditQueryResp struct {
+	Entries       []AuditEntry `json:"entries"`
+	NextBeforeSeq int64        `json:"next_before_seq,omitempty"` // 0 on the last page
+}
@@ -1,21 +1,319 @@
 package domain
 
 import (
+	"encoding/json"
+	"time"
+
 	"github.com/google/uuid"
+
+	"payments/internal/money"
 )
 
-type Money int64
+type Money = money.Money
 
 type PaymentStatus string
 
 const (
 	PaySuccess PaymentStatus = "SUCCESS"
 	PayFailed  PaymentStatus = "FAILED"
+	// PayPendingReview is a payment held by the fraud rules until an admin
+	// approves or rejects it; no money has moved yet.
+	PayPendingReview PaymentStatus = "PENDING_REVIEW"
+	// PayAwaitingCard is the payment of an order to be paid by card; it
+	// waits for POST /pay/card and, if asked for, the card's challenge.
+	PayAwaitingCard PaymentStatus = "AWAITING_CARD"
 )
 
+// Settled reports whether the payment is final and its result is due to
+// orders.
+func (s PaymentStatus) Settled() bool {
+	return s == PaySuccess || s == PayFailed
+}
+
 type Payment struct {
 	OrderID uuid.UUID     `json:"order_id"`
-	UserID  string		  `json:"user_id"`
-	Amount  Money		  `json:"amount"`
+	UserID  string        `json:"user_id"`
+	Amount  Money         `json:"amount"`
 	Status  PaymentStatus `json:"status"`
-}
\ No newline at end of file
+	// FailureReason is the code of a decline (NO_ACCOUNT, NO_WALLET,
+	// INSUFFICIENT_FUNDS, LIMIT_EXCEEDED, ACCOUNT_FROZEN, ACCOUNT_BLOCKED,
+	// FRAUD_DENIED, FRAUD_REJECTED, CARD_DECLINED, ADMIN_CANCELLED) and
+	// FailureMessage says the same in words.
+	FailureReason  string `json:"failure_reason,omitempty"`
+	FailureMessage string `json:"failure_message,omitempty"`
+	// ReviewReason is the fraud rule that sent the payment to review.
+	ReviewReason string `json:"review_reason,omitempty"`
+	// ReviewedBy is the operator who approved or rejected it on review.
+	ReviewedBy string `json:"reviewed_by,omitempty"`
+	// Provider and ProviderTxID are set on a payment made by card.
+	Provider     string `json:"provider,omitempty"`
+	ProviderTxID string `json:"provider_tx_id,omitempty"`
+	// Bonus is the part of Amount paid from bonus buckets; the rest came
+	// from the cash balance.
+	Bonus *Money `json:"bonus,omitempty"`
+	// Split payments are paid by the payers in Shares rather than UserID.
+	// Shares of a failed one are only those that were held and released.
+	Split  bool           `json:"split,omitempty"`
+	Shares []PaymentShare `json:"shares,omitempty"`
+}
+
+type ShareStatus string
+
+// A share is HELD off its payer's balance first and CAPTURED once every
+// share of the payment is held, or RELEASED back if any payer fails.
+const (
+	ShareHeld     ShareStatus = "HELD"
+	ShareCaptured ShareStatus = "CAPTURED"
+	ShareReleased ShareStatus = "RELEASED"
+)
+
+// PaymentShare is one payer's part of a split payment, paid from cash only.
+type PaymentShare struct {
+	UserID string      `json:"user_id"`
+	Amount Money       `json:"amount"`
+	Status ShareStatus `json:"status,omitempty"`
+}
+
+// BonusBucket is promotional credit granted to a wallet by a campaign
+// (Source). It is spent before cash, earliest ExpiresAt first, and what
+// Remains at ExpiresAt is lost.
+type BonusBucket struct {
+	ID        uuid.UUID `json:"id"`
+	UserID    string    `json:"user_id"`
+	Amount    Money     `json:"amount"`
+	Remaining Money     `json:"remaining"`
+	Source    string    `json:"source"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WalletBalance is a wallet's cash and unexpired bonus credit; Total is
+// what a payment can spend. Held is cash already taken off for withdrawals
+// that are not paid out yet.
+type WalletBalance struct {
+	Cash    Money         `json:"cash"`
+	Bonus   Money         `json:"bonus"`
+	Total   Money         `json:"total"`
+	Held    Money         `json:"held"`
+	Bonuses []BonusBucket `json:"bonuses"`
+}
+
+type WithdrawalStatus string
+
+// A withdrawal goes REQUESTED -> APPROVED -> PAID_OUT. An admin may reject
+// a REQUESTED one and the payout provider an APPROVED one; REJECTED gives
+// the money back to the wallet.
+const (
+	WithdrawalRequested WithdrawalStatus = "REQUESTED"
+	WithdrawalApproved  WithdrawalStatus = "APPROVED"
+	WithdrawalPaidOut   WithdrawalStatus = "PAID_OUT"
+	WithdrawalRejected  WithdrawalStatus = "REJECTED"
+)
+
+// Withdrawal takes money out of a wallet to Destination. The amount leaves
+// the balance when it is requested and is held until it is paid out or
+// rejected.
+type Withdrawal struct {
+	ID          uuid.UUID        `json:"id"`
+	UserID      string           `json:"user_id"`
+	Amount      Money            `json:"amount"`
+	Destination string           `json:"destination"`
+	Status      WithdrawalStatus `json:"status"`
+	Reason      string           `json:"reason,omitempty"` // why it was rejected
+	Provider    string           `json:"provider,omitempty"`
+	PayoutID    string           `json:"payout_id,omitempty"`
+	DecidedBy   string           `json:"decided_by,omitempty"` // operator who approved or rejected it
+	CreatedAt   time.Time        `json:"created_at"`
+	UpdatedAt   time.Time        `json:"updated_at"`
+}
+
+type CardChargeKind string
+
+const (
+	ChargeTopUp   CardChargeKind = "TOPUP"
+	ChargePayment CardChargeKind = "PAYMENT"
+)
+
+type CardChargeStatus string
+
+const (
+	ChargePending   CardChargeStatus = "PENDING"
+	ChargeChallenge CardChargeStatus = "CHALLENGE"
+	ChargeSucceeded CardChargeStatus = "SUCCEEDED"
+	ChargeFailed    CardChargeStatus = "FAILED"
+)
+
+// CardCharge is money taken from a card through the payment provider,
+// either to top up a wallet or to pay an order. On CHALLENGE the card
+// holder has to visit ChallengeURL; the outcome arrives by webhook.
+type CardCharge struct {
+	ID           uuid.UUID        `json:"id"`
+	Kind         CardChargeKind   `json:"kind"`
+	UserID       string           `json:"user_id"`
+	OrderID      *uuid.UUID       `json:"order_id,omitempty"`
+	Amount       Money            `json:"amount"`
+	Status       CardChargeStatus `json:"status"`
+	Provider     string           `json:"provider"`
+	ProviderTxID string           `json:"provider_tx_id,omitempty"`
+	ChallengeURL string           `json:"challenge_url,omitempty"`
+	FailureCode  string           `json:"failure_code,omitempty"`
+	CreatedAt    time.Time        `json:"created_at"`
+}
+
+// PendingReview is a payment waiting for an admin decision.
+type PendingReview struct {
+	OrderID      uuid.UUID `json:"order_id"`
+	UserID       string    `json:"user_id"`
+	Amount       Money     `json:"amount"`
+	ReviewReason string    `json:"review_reason"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// AccountControls are the spending limits of a wallet and the freeze and
+// block flags of its user. Nil limits are not set.
+type AccountControls struct {
+	UserID         string         `json:"user_id"`
+	Currency       money.Currency `json:"currency"`
+	DailyLimit     *Money         `json:"daily_limit,omitempty"`
+	WeeklyLimit    *Money         `json:"weekly_limit,omitempty"`
+	MonthlyLimit   *Money         `json:"monthly_limit,omitempty"`
+	MaxPayment     *Money         `json:"max_payment,omitempty"`
+	Frozen         bool           `json:"frozen"`
+	Blocked        bool           `json:"blocked"`
+	BlockedReason  string         `json:"blocked_reason,omitempty"`
+	SpentToday     Money          `json:"spent_today"`
+	SpentThisWeek  Money          `json:"spent_this_week"`
+	SpentThisMonth Money          `json:"spent_this_month"`
+}
+
+type Refund struct {
+	ID      uuid.UUID `json:"id"`
+	OrderID uuid.UUID `json:"order_id"`
+	UserID  string    `json:"user_id"`
+	Amount  Money     `json:"amount"`
+	// Status is SUCCEEDED, PENDING while the card gateway has not paid a
+	// refund to a card, or FAILED if support gave such a refund up.
+	Status    string    `json:"status"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+	// Bonus is the part of Amount returned to bonus buckets: a refund
+	// gives back cash first.
+	Bonus *Money `json:"bonus,omitempty"`
+}
+
+// Exchange moves money between two wallets of one user at a fixed rate.
+type Exchange struct {
+	ID        uuid.UUID `json:"id"`
+	UserID    string    `json:"user_id"`
+	From      Money     `json:"from"`
+	To        Money     `json:"to"`
+	Rate      string    `json:"rate"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type StatementEntryType string
+
+const (
+	EntryTopUp       StatementEntryType = "TOPUP"
+	EntryPayment     StatementEntryType = "PAYMENT"
+	EntryRefund      StatementEntryType = "REFUND"
+	EntryExchangeOut StatementEntryType = "EXCHANGE_OUT"
+	EntryExchangeIn  StatementEntryType = "EXCHANGE_IN"
+	EntryWithdrawal  StatementEntryType = "WITHDRAWAL"
+	// EntryWithdrawalReturned gives back a rejected withdrawal.
+	EntryWithdrawalReturned StatementEntryType = "WITHDRAWAL_RETURNED"
+	// EntryPaymentShare is the user's share of a split payment, taken when
+	// it is held; EntryShareReleased gives it back if the payment failed.
+	EntryPaymentShare  StatementEntryType = "PAYMENT_SHARE"
+	EntryShareReleased StatementEntryType = "PAYMENT_SHARE_RELEASED"
+	// EntryAdjustment is a correction made by support.
+	EntryAdjustment StatementEntryType = "ADJUSTMENT"
+)
+
+// StatementEntry is one movement of a wallet. Amount is negative for money
+// leaving it; Balance is the running balance right after the entry.
+type StatementEntry struct {
+	At        time.Time          `json:"at"`
+	Type      StatementEntryType `json:"type"`
+	Reference string             `json:"reference"` // order id for payments and refunds
+	Detail    string             `json:"detail,omitempty"`
+	Amount    Money              `json:"amount"`
+	Balance   Money              `json:"balance"`
+}
+
+// Statement lists the movements of one wallet in [From, To).
+// Opening + Credits - Debits = Closing.
+type Statement struct {
+	UserID   string           `json:"user_id"`
+	Currency money.Currency   `json:"currency"`
+	From     time.Time        `json:"from"`
+	To       time.Time        `json:"to"`
+	Opening  Money            `json:"opening_balance"`
+	Credits  Money            `json:"credits"`
+	Debits   Money            `json:"debits"`
+	Closing  Money            `json:"closing_balance"`
+	Entries  []StatementEntry `json:"entries"`
+	// GeneratedAt is when the figures were computed; older than the request
+	// when a monthly snapshot was served.
+	GeneratedAt time.Time `json:"generated_at"`
+}
+
+// BalanceAdjustment is a correction of a wallet balance made from the back
+// office; Amount is negative for a debit and Balance is the one after it.
+type BalanceAdjustment struct {
+	ID        uuid.UUID `json:"id"`
+	UserID    string    `json:"user_id"`
+	Amount    Money     `json:"amount"`
+	Reason    string    `json:"reason"`
+	Actor     string    `json:"actor"`
+	CreatedAt time.Time `json:"created_at"`
+	Balance   Money     `json:"balance"`
+}
+
+// OutboxMessage is a row of payments_outbox without its payload.
+type OutboxMessage struct {
+	ID          int64      `json:"id"`
+	MessageID   uuid.UUID  `json:"message_id"`
+	Topic       string     `json:"topic"`
+	Key         string     `json:"key"`
+	CreatedAt   time.Time  `json:"created_at"`
+	PublishedAt *time.Time `json:"published_at"` // nil until published
+}
+
+type OutboxBacklog struct {
+	Pending       int             `json:"pending"`
+	OldestPending *time.Time      `json:"oldest_pending"`
+	Messages      []OutboxMessage `json:"messages"`
+}
+
+// InboxMessage is a payment request that has been received and handled.
+type InboxMessage struct {
+	MessageID  uuid.UUID `json:"message_id"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+type InboxBacklog struct {
+	Total    int            `json:"total"`
+	LastHour int            `json:"last_hour"`
+	LatestAt *time.Time     `json:"latest_at"`
+	Messages []InboxMessage `json:"messages"`
+}
+
+// AuditEntry is one change in the audit log: who (Actor) did what (Action)
+// to which object (Target) and why (Reason, given for back-office
+// changes), its value Before and After, and the request it came with. Hash
+// covers all of it and PrevHash, the hash of the entry of Target before.
+type AuditEntry struct {
+	Seq       int64           `json:"seq"`
+	CreatedAt time.Time       `json:"created_at"`
+	Actor     string          `json:"actor"`
+	Action    string          `json:"action"`
+	Target    string          `json:"target"`
+	Reason    string          `json:"reason,omitempty"`
+	Before    json.RawMessage `json:"before"`
+	After     json.RawMessage `json:"after"`
+	RequestID string          `json:"request_id,omitempty"`
+	SourceIP  string          `json:"source_ip,omitempty"`
+	PrevHash  string          `json:"prev_hash"`
+	Hash      string          `json:"hash"`
+}
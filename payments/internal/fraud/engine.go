@@ -0,0 +1,151 @@
+package fraud
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// History is what the rules need to know about the paying user.
+type History interface {
+	// Count is the number of payments of the user since then.
+	Count(ctx context.Context, since time.Time) (int, error)
+	// Average is the mean successful payment in the payment's currency
+	// since then, in minor units, and how many payments it is taken over.
+	Average(ctx context.Context, since time.Time) (avg float64, n int, err error)
+}
+
+// Decision is the verdict on one payment. Rule names the rule that decided
+// it and Detail explains it; both are empty for Allow.
+type Decision struct {
+	Action Action
+	Rule   string
+	Detail string
+}
+
+func (d Decision) String() string {
+	if d.Rule == "" {
+		return string(d.Action)
+	}
+	return d.Rule + ": " + d.Detail
+}
+
+// Engine holds the current rules. The file they come from is re-read when
+// it changes, see Watch.
+type Engine struct {
+	path    string
+	rules   atomic.Pointer[Rules]
+	modTime time.Time
+}
+
+// Load reads the rules from the file named by FRAUD_RULES. Without one the
+// engine allows every payment.
+func Load() (*Engine, error) {
+	e := &Engine{path: os.Getenv("FRAUD_RULES")}
+	e.rules.Store(&Rules{})
+	if e.path == "" {
+		return e, nil
+	}
+	if _, err := e.reload(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// reload reads the file if it changed since the last read.
+func (e *Engine) reload() (bool, error) {
+	fi, err := os.Stat(e.path)
+	if err != nil {
+		return false, err
+	}
+	if fi.ModTime().Equal(e.modTime) {
+		return false, nil
+	}
+	b, err := os.ReadFile(e.path)
+	if err != nil {
+		return false, err
+	}
+	r, err := Parse(b)
+	if err != nil {
+		return false, err
+	}
+	e.rules.Store(r)
+	e.modTime = fi.ModTime()
+	return true, nil
+}
+
+// Watch re-reads the rules file every interval until ctx is done. A file
+// that does not parse is logged and the rules in force are kept.
+func (e *Engine) Watch(ctx context.Context, interval time.Duration) {
+	if e.path == "" {
+		return
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		changed, err := e.reload()
+		if err != nil {
+			log.Printf("fraud rules %s: %v (keeping the previous rules)", e.path, err)
+			continue
+		}
+		if changed {
+			log.Printf("fraud rules reloaded from %s", e.path)
+		}
+	}
+}
+
+// Check screens a payment of amount minor units by userID. All rules are
+// evaluated and the strictest action wins.
+func (e *Engine) Check(ctx context.Context, h History, userID string, amount int64) (Decision, error) {
+	r := e.rules.Load()
+	if r.deny[userID] {
+		return Decision{Action: Deny, Rule: "deny_users", Detail: "user is on the deny list"}, nil
+	}
+
+	d := Decision{Action: Allow}
+	match := func(a Action, rule, detail string) {
+		if a.severity() > d.Action.severity() {
+			d = Decision{Action: a, Rule: rule, Detail: detail}
+		}
+	}
+	if r.review[userID] {
+		match(Review, "review_users", "user is on the review list")
+	}
+
+	now := time.Now()
+	for _, v := range r.Velocity {
+		if d.Action == Deny {
+			return d, nil
+		}
+		n, err := h.Count(ctx, now.Add(-time.Duration(v.Window)))
+		if err != nil {
+			return Decision{}, err
+		}
+		if n+1 > v.Max {
+			match(v.Action, v.Name, formatVelocity(n+1, v))
+		}
+	}
+	for _, a := range r.Amount {
+		if d.Action == Deny {
+			return d, nil
+		}
+		avg, n, err := h.Average(ctx, now.Add(-time.Duration(a.Lookback)))
+		if err != nil {
+			return Decision{}, err
+		}
+		if n == 0 || n < a.MinHistory {
+			continue
+		}
+		if float64(amount) > a.Multiplier*avg {
+			match(a.Action, a.Name, formatAmount(amount, avg, a))
+		}
+	}
+	return d, nil
+}
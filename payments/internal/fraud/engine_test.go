@@ -0,0 +1,109 @@
+package fraud
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// history is a History with fixed answers.
+type history struct {
+	count int
+	avg   float64
+	n     int
+	err   error
+}
+
+func (h history) Count(context.Context, time.Time) (int, error) { return h.count, h.err }
+
+func (h history) Average(context.Context, time.Time) (float64, int, error) { return h.avg, h.n, h.err }
+
+const testRules = `{
+	"deny_users": ["bad"],
+	"review_users": ["watched"],
+	"velocity": [
+		{"name": "burst", "max": 3, "window": "1m", "action": "review"},
+		{"name": "flood", "max": 10, "window": "1m", "action": "deny"}
+	],
+	"amount": [
+		{"name": "spike", "multiplier": 5, "min_history": 3, "lookback": "720h", "action": "review"}
+	]
+}`
+
+func testEngine(t *testing.T) *Engine {
+	t.Helper()
+	r, err := Parse([]byte(testRules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &Engine{}
+	e.rules.Store(r)
+	return e
+}
+
+func TestCheck(t *testing.T) {
+	e := testEngine(t)
+	for _, tc := range []struct {
+		name   string
+		user   string
+		h      history
+		amount int64
+		action Action
+		rule   string
+	}{
+		{"usual payment", "u1", history{count: 1, avg: 1000, n: 5}, 1000, Allow, ""},
+		{"deny list", "bad", history{}, 1000, Deny, "deny_users"},
+		{"review list", "watched", history{}, 1000, Review, "review_users"},
+		{"below velocity", "u1", history{count: 2}, 1000, Allow, ""},
+		{"velocity", "u1", history{count: 3}, 1000, Review, "burst"},
+		{"strictest velocity", "u1", history{count: 10}, 1000, Deny, "flood"},
+		{"deny beats review list", "watched", history{count: 10}, 1000, Deny, "flood"},
+		{"at the multiplier", "u1", history{avg: 1000, n: 5}, 5000, Allow, ""},
+		{"above the multiplier", "u1", history{avg: 1000, n: 5}, 5001, Review, "spike"},
+		{"short history", "u1", history{avg: 1000, n: 2}, 100000, Allow, ""},
+		{"no history", "u1", history{}, 100000, Allow, ""},
+		{"first of equals wins", "u1", history{count: 3, avg: 1000, n: 5}, 100000, Review, "burst"},
+	} {
+		d, err := e.Check(context.Background(), tc.h, tc.user, tc.amount)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if d.Action != tc.action || d.Rule != tc.rule {
+			t.Errorf("%s: %s %q, want %s %q", tc.name, d.Action, d.Rule, tc.action, tc.rule)
+		}
+	}
+}
+
+func TestCheckHistoryError(t *testing.T) {
+	boom := errors.New("boom")
+	if _, err := testEngine(t).Check(context.Background(), history{err: boom}, "u1", 1000); !errors.Is(err, boom) {
+		t.Fatalf("Check = %v, want the history error", err)
+	}
+}
+
+func TestCheckWithoutRules(t *testing.T) {
+	e := &Engine{}
+	e.rules.Store(&Rules{})
+	d, err := e.Check(context.Background(), history{count: 1000}, "u1", 1)
+	if err != nil || d.Action != Allow {
+		t.Fatalf("Check = %v %v, want allow", d, err)
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	for _, s := range []string{
+		`{"velocity": [{"name": "v", "max": 3, "window": "1m", "action": "block"}]}`,
+		`{"velocity": [{"max": 3, "window": "1m", "action": "deny"}]}`,
+		`{"velocity": [{"name": "v", "max": 0, "window": "1m", "action": "deny"}]}`,
+		`{"velocity": [{"name": "v", "max": 3, "window": "soon", "action": "deny"}]}`,
+		`{"amount": [{"name": "a", "multiplier": 0, "lookback": "24h", "action": "review"}]}`,
+		`{"amount": [{"name": "a", "multiplier": 3, "action": "review"}]}`,
+		`{"deny_users": "bad"}`,
+	} {
+		if _, err := Parse([]byte(s)); err == nil {
+			t.Errorf("Parse(%s) accepted", s)
+		}
+	}
+}
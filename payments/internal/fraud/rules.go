@@ -0,0 +1,132 @@
+// Package fraud screens payments against rules read from a JSON file: deny
+// lists, velocity (too many payments in a window) and amount anomalies
+// (far above what the user usually pays).
+package fraud
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type Action string
+
+const (
+	Allow  Action = "allow"
+	Review Action = "review"
+	Deny   Action = "deny"
+)
+
+// severity orders actions so that the strictest matching rule wins.
+func (a Action) severity() int {
+	switch a {
+	case Deny:
+		return 2
+	case Review:
+		return 1
+	}
+	return 0
+}
+
+// Duration is a time.Duration written as in Go, e.g. "1m" or "720h".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// VelocityRule matches when the user already made Max payments (in any
+// status and currency) within Window.
+type VelocityRule struct {
+	Name   string   `json:"name"`
+	Max    int      `json:"max"`
+	Window Duration `json:"window"`
+	Action Action   `json:"action"`
+}
+
+// AmountRule matches when a payment is more than Multiplier times the
+// user's average successful payment in its currency over Lookback. Users
+// with fewer than MinHistory such payments are not judged.
+type AmountRule struct {
+	Name       string   `json:"name"`
+	Multiplier float64  `json:"multiplier"`
+	MinHistory int      `json:"min_history"`
+	Lookback   Duration `json:"lookback"`
+	Action     Action   `json:"action"`
+}
+
+type Rules struct {
+	DenyUsers   []string       `json:"deny_users"`
+	ReviewUsers []string       `json:"review_users"`
+	Velocity    []VelocityRule `json:"velocity"`
+	Amount      []AmountRule   `json:"amount"`
+
+	deny, review map[string]bool
+}
+
+// Parse reads and validates a rules file.
+func Parse(b []byte) (*Rules, error) {
+	var r Rules
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, err
+	}
+	if err := r.validate(); err != nil {
+		return nil, err
+	}
+	r.deny = set(r.DenyUsers)
+	r.review = set(r.ReviewUsers)
+	return &r, nil
+}
+
+func (r *Rules) validate() error {
+	var errs []error
+	action := func(name string, a Action) {
+		if a != Review && a != Deny {
+			errs = append(errs, fmt.Errorf("rule %q: action should be review or deny", name))
+		}
+	}
+	for _, v := range r.Velocity {
+		if v.Name == "" || v.Max <= 0 || v.Window <= 0 {
+			errs = append(errs, fmt.Errorf("velocity rule %q: name, max > 0 and window are required", v.Name))
+		}
+		action(v.Name, v.Action)
+	}
+	for _, a := range r.Amount {
+		if a.Name == "" || a.Multiplier <= 0 || a.Lookback <= 0 {
+			errs = append(errs, fmt.Errorf("amount rule %q: name, multiplier > 0 and lookback are required", a.Name))
+		}
+		action(a.Name, a.Action)
+	}
+	return errors.Join(errs...)
+}
+
+func set(ids []string) map[string]bool {
+	m := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		m[id] = true
+	}
+	return m
+}
+
+func formatVelocity(n int, v VelocityRule) string {
+	return fmt.Sprintf("%d payments within %s, at most %d allowed", n, time.Duration(v.Window), v.Max)
+}
+
+func formatAmount(amount int64, avg float64, a AmountRule) string {
+	return fmt.Sprintf("amount is %.1fx the average of the last %s, at most %gx allowed",
+		float64(amount)/avg, time.Duration(a.Lookback), a.Multiplier)
+}
@@ -0,0 +1,144 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+
+	"payments/api/paymentsv1"
+	"payments/internal/money"
+	"payments/internal/store"
+)
+
+type Server struct {
+	paymentsv1.UnimplementedPaymentsServer
+	st *store.Store
+}
+
+// NewServer returns a gRPC server exposing the Payments service together
+// with the standard health and reflection services.
+func NewServer(st *store.Store, authToken string) *grpc.Server {
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(metricsUnary, loggingUnary, authUnary(authToken), sourceUnary),
+		grpc.ChainStreamInterceptor(metricsStream, loggingStream, authStream(authToken)),
+	)
+	paymentsv1.RegisterPaymentsServer(srv, &Server{st: st})
+
+	hs := health.NewServer()
+	hs.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	hs.SetServingStatus(paymentsv1.Payments_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(srv, hs)
+
+	reflection.Register(srv)
+	return srv
+}
+
+func toStatus(err error) error {
+	switch {
+	case errors.Is(err, store.ErrNoAccount), errors.Is(err, store.ErrNoWallet):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, money.ErrUnknownCurrency):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, store.ErrNotEnoughMoney), errors.Is(err, store.ErrLimitExceeded),
+		errors.Is(err, store.ErrAccountFrozen), errors.Is(err, store.ErrAccountBlocked):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func (s *Server) account(ctx context.Context, userID string, currency money.Currency) (*paymentsv1.Account, error) {
+	b, err := s.st.Balance(ctx, userID, currency)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &paymentsv1.Account{UserId: userID, Balance: b.Amount, Currency: string(b.Currency)}, nil
+}
+
+func (s *Server) CreateAccount(ctx context.Context, req *paymentsv1.CreateAccountRequest) (*paymentsv1.Account, error) {
+	if req.GetUserId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "empty user_id")
+	}
+	cur, err := money.CurrencyOrDefault(req.GetCurrency())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if err := s.st.CreateAccount(ctx, req.GetUserId(), cur); err != nil {
+		return nil, toStatus(err)
+	}
+	return s.account(ctx, req.GetUserId(), cur)
+}
+
+func (s *Server) TopUp(ctx context.Context, req *paymentsv1.TopUpRequest) (*paymentsv1.Account, error) {
+	if req.GetUserId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "empty user_id")
+	}
+	if req.GetAmount() <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "amount must be > 0")
+	}
+	cur, err := money.CurrencyOrDefault(req.GetCurrency())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if err := s.st.TopUp(ctx, req.GetUserId(), money.New(req.GetAmount(), cur), req.GetIdempotencyKey()); err != nil {
+		return nil, toStatus(err)
+	}
+	return s.account(ctx, req.GetUserId(), cur)
+}
+
+func (s *Server) GetBalance(ctx context.Context, req *paymentsv1.GetBalanceRequest) (*paymentsv1.Account, error) {
+	if req.GetUserId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "empty user_id")
+	}
+	cur, err := money.CurrencyOrDefault(req.GetCurrency())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return s.account(ctx, req.GetUserId(), cur)
+}
+
+func (s *Server) Pay(ctx context.Context, req *paymentsv1.PayRequest) (*paymentsv1.Payment, error) {
+	if req.GetUserId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "empty user_id")
+	}
+	if req.GetAmount() <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "amount must be > 0")
+	}
+	orderID, err := uuid.Parse(req.GetOrderId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid order_id")
+	}
+	cur, err := money.CurrencyOrDefault(req.GetCurrency())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	p, err := s.st.Pay(ctx, orderID, req.GetUserId(), money.New(req.GetAmount(), cur))
+	resp := &paymentsv1.Payment{
+		OrderId:  p.OrderID.String(),
+		UserId:   p.UserID,
+		Amount:   p.Amount.Amount,
+		Currency: string(p.Amount.Currency),
+		Status:   string(p.Status),
+	}
+	if err == nil {
+		return resp, nil
+	}
+	if reason := store.FailureReason(err); reason != "" {
+		// The decline is recorded; report it as a result, not a transport
+		// error, with the same code HTTP and Kafka give.
+		resp.FailureReason = reason
+		return resp, nil
+	}
+	return nil, toStatus(err)
+}
@@ -0,0 +1,273 @@
+package httpapi
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"payments/internal/domain"
+	"payments/internal/openapi"
+	"payments/internal/store"
+)
+
+// ActorHeader names the support operator behind a back-office call. The
+// frontend sets it after checking the operator's role; it is trusted only
+// next to the service token (see guard). It makes the audit log entries of
+// the request by "admin:<name>".
+const ActorHeader = "X-Admin-Actor"
+
+var actorParam = openapi.Param{Name: ActorHeader, In: "header", Description: "support operator making the change", Required: true}
+
+// ServiceTokenHeader carries SERVICE_TOKEN, which the frontend shares with
+// the services. The service ports may be reachable from outside, so only a
+// request bearing it is trusted with ActorHeader and X-Forwarded-For.
+const ServiceTokenHeader = "X-Service-Token"
+
+// guard drops the headers a request without the service token may not set
+// and refuses it operator routes. An empty token trusts nobody, which
+// closes the back office.
+func guard(token string, rt openapi.Route, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		got := r.Header.Get(ServiceTokenHeader)
+		if token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			r.Header.Del(ActorHeader)
+			r.Header.Del("X-Forwarded-For")
+			if rt.Operator {
+				writeJSON(w, http.StatusUnauthorized, domain.ErrResp{Error: "back-office route: missing or wrong " + ServiceTokenHeader})
+				return
+			}
+		}
+		h(w, r)
+	}
+}
+
+// requireActor refuses a back-office change that names no operator, so that
+// the audit log always says who made it.
+func requireActor(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(ActorHeader) == "" {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "missing " + ActorHeader})
+			return
+		}
+		h(w, r)
+	}
+}
+
+const (
+	defaultAdminLimit = 50
+	maxAdminLimit     = 500
+)
+
+func adminLimit(n int) int {
+	if n <= 0 {
+		return defaultAdminLimit
+	}
+	return min(n, maxAdminLimit)
+}
+
+// adminStatus maps the errors of back-office calls that are the caller's
+// fault; anything else is a 500.
+func adminStatus(err error) int {
+	switch {
+	case errors.Is(err, store.ErrNoActor), errors.Is(err, store.ErrAdminReason),
+		errors.Is(err, store.ErrZeroAdjustment):
+		return http.StatusBadRequest
+	case errors.Is(err, store.ErrNoPayment), errors.Is(err, store.ErrNoOutboxMessage),
+		errors.Is(err, store.ErrNoPendingRefund):
+		return http.StatusNotFound
+	case errors.Is(err, store.ErrPaymentPaid), errors.Is(err, store.ErrChargeInFlight),
+		errors.Is(err, store.ErrAdjustmentKeyReused):
+		return http.StatusConflict
+	case errors.Is(err, store.ErrNotEnoughMoney), errors.Is(err, store.ErrNotRefundable):
+		return http.StatusUnprocessableEntity
+	}
+	return walletStatus(err)
+}
+
+func makeHandleAdjustBalance(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.AdjustBalanceReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+		if req.UserID == "" {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "empty user_id"})
+			return
+		}
+		amount, err := parseAmount(req.Amount, req.Currency)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+			return
+		}
+
+		a, err := s.AdjustBalance(r.Context(), r.Header.Get(ActorHeader), req.UserID, amount, req.Reason, r.Header.Get("Idempotency-Key"))
+		if err != nil {
+			writeJSON(w, adminStatus(err), domain.ErrResp{Error: "could not adjust balance: " + err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, a)
+	}
+}
+
+func makeHandleAdminPayment(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+		id, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "invalid order id"})
+			return
+		}
+
+		p, err := s.Payment(r.Context(), id)
+		if err != nil {
+			writeJSON(w, adminStatus(err), domain.ErrResp{Error: "could not get payment: " + err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, p)
+	}
+}
+
+func makeHandleVoidPayment(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.VoidPaymentReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+		id, err := uuid.Parse(req.OrderID)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "invalid order_id format"})
+			return
+		}
+		var amount domain.Money
+		if req.Amount != "" {
+			amount, err = parseAmount(req.Amount, req.Currency)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+				return
+			}
+		}
+
+		p, err := s.VoidPayment(r.Context(), r.Header.Get(ActorHeader), id, req.UserID, amount, req.Reason)
+		if err != nil {
+			writeJSON(w, adminStatus(err), domain.ErrResp{Error: "could not void payment: " + err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, p)
+	}
+}
+
+func makeHandleCancelRefund(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.CancelRefundReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+		id, err := uuid.Parse(req.OrderID)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "invalid order_id format"})
+			return
+		}
+
+		ref, err := s.CancelRefund(r.Context(), r.Header.Get(ActorHeader), id, req.Reason)
+		if err != nil {
+			writeJSON(w, adminStatus(err), domain.ErrResp{Error: "could not cancel refund: " + err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, ref)
+	}
+}
+
+func makeHandleOutbox(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.OutboxReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+
+		b, err := s.OutboxBacklog(r.Context(), req.PendingOnly, adminLimit(req.Limit))
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: "could not read outbox: " + err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, b)
+	}
+}
+
+func makeHandleRepublish(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.RepublishReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+		id, err := uuid.Parse(req.MessageID)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "invalid message_id format"})
+			return
+		}
+
+		m, err := s.RepublishOutbox(r.Context(), r.Header.Get(ActorHeader), id, req.Reason)
+		if err != nil {
+			writeJSON(w, adminStatus(err), domain.ErrResp{Error: "could not republish: " + err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, m)
+	}
+}
+
+func makeHandleInbox(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.InboxReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+
+		b, err := s.InboxBacklog(r.Context(), adminLimit(req.Limit))
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: "could not read inbox: " + err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, b)
+	}
+}
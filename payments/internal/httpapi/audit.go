@@ -0,0 +1,82 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+
+	"payments/internal/audit"
+	"payments/internal/domain"
+	"payments/internal/store"
+)
+
+// withAuditSource tells the audit log who a request's changes are for: the
+// support operator of a back-office call, "api" otherwise. The request id
+// is the caller's X-Request-ID, returned in the response. guard keeps
+// X-Forwarded-For only on the frontend's requests, whose first entry is the
+// client; others are known by their peer address.
+func withAuditSource(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		src := audit.Source{Actor: "api", RequestID: r.Header.Get("X-Request-ID")}
+		if a := r.Header.Get(ActorHeader); a != "" {
+			src.Actor = "admin:" + a
+		}
+		if len(src.RequestID) > 128 {
+			src.RequestID = src.RequestID[:128]
+		}
+		if src.RequestID != "" {
+			w.Header().Set("X-Request-ID", src.RequestID)
+		}
+		src.IP, _, _ = strings.Cut(r.Header.Get("X-Forwarded-For"), ",")
+		src.IP = strings.TrimSpace(src.IP)
+		if src.IP == "" {
+			host, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				host = r.RemoteAddr
+			}
+			src.IP = host
+		}
+		h(w, r.WithContext(audit.WithSource(r.Context(), src)))
+	}
+}
+
+func makeHandleAuditLog(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.AuditQueryReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+		f := audit.Filter{
+			Actor:     req.Actor,
+			Action:    req.Action,
+			Target:    req.Target,
+			RequestID: req.RequestID,
+			BeforeSeq: req.BeforeSeq,
+			Limit:     adminLimit(req.Limit),
+		}
+		if req.From != nil {
+			f.From = *req.From
+		}
+		if req.To != nil {
+			f.To = *req.To
+		}
+
+		entries, err := s.AuditLog(r.Context(), f)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: "could not read audit log: " + err.Error()})
+			return
+		}
+		resp := domain.AuditQueryResp{Entries: entries}
+		if len(entries) == f.Limit {
+			resp.NextBeforeSeq = entries[len(entries)-1].Seq
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,79 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"payments/internal/domain"
+	"payments/internal/money"
+	"payments/internal/store"
+)
+
+func makeHandleGrantBonus(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.GrantBonusReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+		if req.UserID == "" {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "empty user_id"})
+			return
+		}
+		amount, err := parseAmount(req.Amount, req.Currency)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+			return
+		}
+		if !amount.IsPositive() {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "amount should be greater than 0"})
+			return
+		}
+
+		b, err := s.GrantBonus(r.Context(), req.UserID, amount, req.Source, req.ExpiresAt, r.Header.Get("Idempotency-Key"))
+		switch {
+		case errors.Is(err, store.ErrBonusSource), errors.Is(err, store.ErrBonusExpiry):
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+			return
+		case errors.Is(err, store.ErrBonusKeyReused):
+			writeJSON(w, http.StatusConflict, domain.ErrResp{Error: err.Error()})
+			return
+		case errors.Is(err, store.ErrBonusesDisabled):
+			writeJSON(w, http.StatusUnprocessableEntity, domain.ErrResp{Error: err.Error()})
+			return
+		case err != nil:
+			writeJSON(w, walletStatus(err), domain.ErrResp{Error: "could not grant bonus: " + err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, b)
+	}
+}
+
+// wallets puts the bonus buckets and the held withdrawals next to the cash
+// balance of their wallet.
+func wallets(balances []domain.Money, bonuses []domain.BonusBucket, held map[money.Currency]int64) []domain.WalletBalance {
+	out := make([]domain.WalletBalance, 0, len(balances))
+	for _, cash := range balances {
+		wb := domain.WalletBalance{
+			Cash:    cash,
+			Bonus:   money.New(0, cash.Currency),
+			Held:    money.New(held[cash.Currency], cash.Currency),
+			Bonuses: []domain.BonusBucket{},
+		}
+		for _, b := range bonuses {
+			if b.Remaining.Currency == cash.Currency {
+				wb.Bonus.Amount += b.Remaining.Amount
+				wb.Bonuses = append(wb.Bonuses, b)
+			}
+		}
+		wb.Total = money.New(cash.Amount+wb.Bonus.Amount, cash.Currency)
+		out = append(out, wb)
+	}
+	return out
+}
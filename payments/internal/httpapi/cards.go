@@ -0,0 +1,148 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"payments/internal/domain"
+	"payments/internal/provider"
+	"payments/internal/store"
+)
+
+// cardStatus maps the errors of card charges to a status code.
+func cardStatus(err error) int {
+	switch {
+	case errors.Is(err, store.ErrNoPayment), errors.Is(err, store.ErrNoCardCharge):
+		return http.StatusNotFound
+	case errors.Is(err, store.ErrNotAwaitingCard), errors.Is(err, store.ErrChargeKeyReused):
+		return http.StatusConflict
+	}
+	if code := walletStatus(err); code != http.StatusInternalServerError {
+		return code
+	}
+	// The gateway could not be reached or failed; the charge stays PENDING
+	// and repeating the call resumes it.
+	return http.StatusBadGateway
+}
+
+func makeHandleCardTopUp(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.CardTopUpReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+		if req.UserID == "" {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "empty user_id"})
+			return
+		}
+		amount, err := parseAmount(req.Amount, req.Currency)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+			return
+		}
+		if !amount.IsPositive() {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "amount should be greater than 0"})
+			return
+		}
+
+		c, err := s.TopUpByCard(r.Context(), req.UserID, amount, req.Card, r.Header.Get("Idempotency-Key"))
+		if err != nil {
+			writeJSON(w, cardStatus(err), domain.ErrResp{Error: "could not top up: " + err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, c)
+	}
+}
+
+// makeHandleCardPay charges the card for an order. A declined card is a
+// FAILED charge, not an error.
+func makeHandleCardPay(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.CardPayReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+		orderUUID, err := uuid.Parse(req.OrderID)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "invalid orderID format"})
+			return
+		}
+
+		c, err := s.PayByCard(r.Context(), orderUUID, req.Card)
+		if err != nil {
+			writeJSON(w, cardStatus(err), domain.ErrResp{Error: "could not pay: " + err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, c)
+	}
+}
+
+func makeHandleCardCharge(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+		id, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "invalid charge id"})
+			return
+		}
+
+		c, err := s.CardCharge(r.Context(), id)
+		if err != nil {
+			writeJSON(w, cardStatus(err), domain.ErrResp{Error: "could not get charge: " + err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, c)
+	}
+}
+
+// makeHandleProviderWebhook receives the outcome of challenges from the
+// card gateway. Anything but 2xx makes the gateway retry.
+func makeHandleProviderWebhook(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, 64<<10))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "could not read body: " + err.Error()})
+			return
+		}
+
+		err = s.HandleProviderWebhook(r.Context(), r.Header, body)
+		switch {
+		case errors.Is(err, provider.ErrBadSignature):
+			writeJSON(w, http.StatusUnauthorized, domain.ErrResp{Error: err.Error()})
+			return
+		case errors.Is(err, store.ErrNoCardCharge), errors.Is(err, store.ErrProviderMismatch):
+			// Retrying would not help.
+			log.Printf("provider webhook ignored: %v", err)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case err != nil:
+			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: "could not handle webhook: " + err.Error()})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
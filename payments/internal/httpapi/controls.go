@@ -0,0 +1,134 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"payments/internal/domain"
+	"payments/internal/money"
+	"payments/internal/store"
+)
+
+var errEmptyReason = errors.New("empty reason")
+
+func makeHandleControls(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.AccountControlsReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+		if req.UserID == "" {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "empty user_id"})
+			return
+		}
+		cur, err := money.CurrencyOrDefault(req.Currency)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+			return
+		}
+
+		c, err := s.Controls(r.Context(), req.UserID, cur)
+		if err != nil {
+			writeJSON(w, walletStatus(err), domain.ErrResp{Error: "could not get controls: " + err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, c)
+	}
+}
+
+func makeHandleSetLimits(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.SetLimitsReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+		if req.UserID == "" {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "empty user_id"})
+			return
+		}
+		cur, err := money.CurrencyOrDefault(req.Currency)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+			return
+		}
+
+		var l store.Limits
+		for _, f := range []struct {
+			name string
+			v    json.Number
+			dst  *int64
+		}{
+			{"daily_limit", req.DailyLimit, &l.Daily},
+			{"weekly_limit", req.WeeklyLimit, &l.Weekly},
+			{"monthly_limit", req.MonthlyLimit, &l.Monthly},
+			{"max_payment", req.MaxPayment, &l.MaxPayment},
+		} {
+			if f.v == "" {
+				continue
+			}
+			m, err := money.Parse(f.v.String(), cur)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: f.name + ": " + err.Error()})
+				return
+			}
+			if !m.IsPositive() {
+				writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: f.name + " should be greater than 0"})
+				return
+			}
+			*f.dst = m.Amount
+		}
+
+		c, err := s.SetLimits(r.Context(), req.UserID, cur, l)
+		if err != nil {
+			writeJSON(w, walletStatus(err), domain.ErrResp{Error: "could not set limits: " + err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, c)
+	}
+}
+
+// makeHandleAccountFlag serves the freeze and block switches, which apply
+// to every wallet of the user.
+func makeHandleAccountFlag(set func(ctx context.Context, req domain.AccountFlagReq) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.AccountFlagReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+		if req.UserID == "" {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "empty user_id"})
+			return
+		}
+
+		err := set(r.Context(), req)
+		switch {
+		case errors.Is(err, errEmptyReason), errors.Is(err, store.ErrReasonLimit):
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+			return
+		case err != nil:
+			writeJSON(w, walletStatus(err), domain.ErrResp{Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, req)
+	}
+}
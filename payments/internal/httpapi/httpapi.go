@@ -1,12 +1,14 @@
 package httpapi
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
-	"strconv"
-	"strings"
 
 	"payments/internal/domain"
+	"payments/internal/money"
+	"payments/internal/openapi"
 	"payments/internal/store"
 
 	"github.com/google/uuid"
@@ -20,7 +22,7 @@ func writeJSON(w http.ResponseWriter, code int, v any) error {
 }
 
 func makeHandleCreatePayment(s *store.Store) http.HandlerFunc {
-    return func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
 			return
@@ -37,43 +39,58 @@ func makeHandleCreatePayment(s *store.Store) http.HandlerFunc {
 			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "empty user_id"})
 			return
 		}
-		s.CreateAccount(req.UserID)
+		cur, err := money.CurrencyOrDefault(req.Currency)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+			return
+		}
+		if err = s.CreateAccount(r.Context(), req.UserID, cur); err != nil {
+			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: "could not create account: " + err.Error()})
+			return
+		}
 		var balance domain.Money
-		balance, err = s.Balance(req.UserID)
+		balance, err = s.Balance(r.Context(), req.UserID, cur)
 		if err != nil {
 			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: "could not get balance: " + err.Error()})
 			return
 		}
 
 		resp := domain.CreateAccountResp{
-			UserID: req.UserID,
-			Balance: json.Number(strconv.FormatInt(int64(balance), 10)),
+			UserID:  req.UserID,
+			Balance: balance,
 		}
 		err = writeJSON(w, http.StatusOK, resp)
 		if err != nil {
-			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: "failed to write response: " + err.Error()})	 	
+			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: "failed to write response: " + err.Error()})
 			return
 		}
 	}
 }
 
-func parseAmount(amount json.Number) (int64, error) {
-    if val, err := amount.Int64(); err == nil {
-        return val, nil
-    }
-    
-    str := amount.String()
-    
-    if strings.Contains(str, ".") {
-        parts := strings.Split(str, ".")
-        str = parts[0]
-    }
-    
-    return strconv.ParseInt(str, 10, 64)
+// parseAmount reads a decimal amount of currency (DEFAULT_CURRENCY if
+// empty). Extra fractional digits are rejected rather than truncated.
+func parseAmount(amount json.Number, currency string) (domain.Money, error) {
+	cur, err := money.CurrencyOrDefault(currency)
+	if err != nil {
+		return domain.Money{}, err
+	}
+	return money.Parse(amount.String(), cur)
+}
+
+// walletStatus maps a missing account or wallet to 404 and a blocked or
+// frozen one to 422.
+func walletStatus(err error) int {
+	if errors.Is(err, store.ErrNoAccount) || errors.Is(err, store.ErrNoWallet) {
+		return http.StatusNotFound
+	}
+	if errors.Is(err, store.ErrAccountBlocked) || errors.Is(err, store.ErrAccountFrozen) {
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
 }
 
 func makeHandleTopUp(s *store.Store) http.HandlerFunc {
-    return func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
 			return
@@ -90,37 +107,40 @@ func makeHandleTopUp(s *store.Store) http.HandlerFunc {
 			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "empty user_id"})
 			return
 		}
-		var amount int64
-		amount, err = parseAmount(req.Amount)
-		if amount < 0 {
-			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "can not deposit negative amount"})
+		amount, err := parseAmount(req.Amount, req.Currency)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+			return
+		}
+		if !amount.IsPositive() {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "amount should be greater than 0"})
 			return
 		}
 
-		if err = s.TopUp(req.UserID, domain.Money(amount)); err != nil {
-			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: "could not top up: " + err.Error()})
+		if err = s.TopUp(r.Context(), req.UserID, amount, r.Header.Get("Idempotency-Key")); err != nil {
+			writeJSON(w, walletStatus(err), domain.ErrResp{Error: "could not top up: " + err.Error()})
 			return
-		} 
+		}
 		var balance domain.Money
-		balance, err = s.Balance(req.UserID)
+		balance, err = s.Balance(r.Context(), req.UserID, amount.Currency)
 		if err != nil {
 			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: "could not get balance: " + err.Error()})
 			return
 		}
 
-		resp := domain.TopUpResp {
-			Balance: json.Number(strconv.FormatInt(int64(balance), 10)),
+		resp := domain.TopUpResp{
+			Balance: balance,
 		}
 		err = writeJSON(w, http.StatusOK, resp)
 		if err != nil {
-			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: "failed to write response: " + err.Error()})	 	
+			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: "failed to write response: " + err.Error()})
 			return
 		}
 	}
 }
 
 func makeHandleBalance(s *store.Store) http.HandlerFunc {
-    return func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
 			return
@@ -138,27 +158,48 @@ func makeHandleBalance(s *store.Store) http.HandlerFunc {
 			return
 		}
 
-		var balance domain.Money
-		balance, err = s.Balance(req.UserID)
+		var balances []domain.Money
+		if req.Currency != "" {
+			cur, err := money.ParseCurrency(req.Currency)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+				return
+			}
+			var b domain.Money
+			b, err = s.Balance(r.Context(), req.UserID, cur)
+			balances = []domain.Money{b}
+		} else {
+			balances, err = s.Balances(r.Context(), req.UserID)
+		}
 		if err != nil {
-			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: "could not get balance: " + err.Error()})
+			writeJSON(w, walletStatus(err), domain.ErrResp{Error: "could not get balance: " + err.Error()})
+			return
+		}
+		bonuses, err := s.Bonuses(r.Context(), req.UserID)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: "could not get bonuses: " + err.Error()})
+			return
+		}
+		held, err := s.HeldWithdrawals(r.Context(), req.UserID)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: "could not get withdrawals: " + err.Error()})
 			return
 		}
 
-		resp := domain.BalanceResp {
-			Balance: json.Number(strconv.FormatInt(int64(balance), 10)),
+		resp := domain.BalanceResp{
+			Balances: balances,
+			Wallets:  wallets(balances, bonuses, held),
 		}
 		err = writeJSON(w, http.StatusOK, resp)
 		if err != nil {
-			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: "failed to write response: " + err.Error()})	 	
+			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: "failed to write response: " + err.Error()})
 			return
 		}
 	}
 }
 
-
 func makeHandlePay(s *store.Store) http.HandlerFunc {
-    return func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
 			return
@@ -171,17 +212,20 @@ func makeHandlePay(s *store.Store) http.HandlerFunc {
 			return
 		}
 
-		if req.UserID == ""{
+		if req.UserID == "" {
 			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "empty user_id"})
 			return
 		}
-		if req.OrderID == ""{
+		if req.OrderID == "" {
 			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "empty order_id"})
 			return
 		}
-		var amount int64
-		amount, err = parseAmount(req.Amount)
-		if amount < 0 {
+		amount, err := parseAmount(req.Amount, req.Currency)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+			return
+		}
+		if amount.Amount < 0 {
 			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "can not pay negative amount"})
 			return
 		}
@@ -193,22 +237,473 @@ func makeHandlePay(s *store.Store) http.HandlerFunc {
 		}
 
 		var payment domain.Payment
-		if payment, err = s.Pay(orderUUID, req.UserID, domain.Money(amount)); err != nil {
+		if payment, err = s.Pay(r.Context(), orderUUID, req.UserID, amount); err != nil {
 			writeJSON(w, http.StatusOK, domain.ErrResp{Error: "could not pay: " + err.Error()})
 			return
-		} 
+		}
 		err = writeJSON(w, http.StatusOK, payment)
 		if err != nil {
-			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: "failed to write response"})	 	
+			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: "failed to write response"})
 			return
 		}
 	}
 }
 
+func makeHandleRefund(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.RefundReq
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+
+		orderUUID, err := uuid.Parse(req.OrderID)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "invalid orderID format"})
+			return
+		}
+		var amount domain.Money
+		if req.Currency != "" {
+			if amount.Currency, err = money.ParseCurrency(req.Currency); err != nil {
+				writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+				return
+			}
+		}
+		if req.Amount != "" {
+			// Without a currency the amount is read in the default one;
+			// the store rejects it if the payment was in another.
+			amount, err = parseAmount(req.Amount, req.Currency)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+				return
+			}
+			if !amount.IsPositive() {
+				writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "amount should be greater than 0"})
+				return
+			}
+		}
 
-func RegisterRoutes(mux *http.ServeMux, st *store.Store) {
-	mux.HandleFunc("/create", makeHandleCreatePayment(st))
-	mux.HandleFunc("/topup", makeHandleTopUp(st))
-	mux.HandleFunc("/balance", makeHandleBalance(st))
-	mux.HandleFunc("/pay", makeHandlePay(st))
-}
\ No newline at end of file
+		res, err := s.Refund(r.Context(), r.Header.Get(ActorHeader), orderUUID, amount, req.Reason, r.Header.Get("Idempotency-Key"))
+		switch {
+		case errors.Is(err, store.ErrNoActor):
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+			return
+		case errors.Is(err, store.ErrNoPayment):
+			writeJSON(w, http.StatusNotFound, domain.ErrResp{Error: err.Error()})
+			return
+		case errors.Is(err, store.ErrNotRefundable), errors.Is(err, store.ErrRefundExceeds), errors.Is(err, store.ErrReasonLimit),
+			errors.Is(err, money.ErrCurrencyMismatch):
+			writeJSON(w, http.StatusUnprocessableEntity, domain.ErrResp{Error: err.Error()})
+			return
+		case errors.Is(err, store.ErrRefundKeyReused), errors.Is(err, store.ErrRefundInFlight), errors.Is(err, store.ErrRefundCancelled):
+			writeJSON(w, http.StatusConflict, domain.ErrResp{Error: err.Error()})
+			return
+		case err != nil:
+			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: "could not refund: " + err.Error()})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, domain.RefundResp{
+			Refund:        res.Refund,
+			Captured:      res.Captured,
+			RefundedTotal: res.RefundedTotal,
+		})
+	}
+}
+
+func makeHandleExchange(s *store.Store, rates *money.Rates) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.ExchangeReq
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+
+		if req.UserID == "" {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "empty user_id"})
+			return
+		}
+		if req.From == "" || req.To == "" {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "from and to currencies are required"})
+			return
+		}
+		from, err := parseAmount(req.Amount, req.From)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+			return
+		}
+		if !from.IsPositive() {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "amount should be greater than 0"})
+			return
+		}
+		toCur, err := money.ParseCurrency(req.To)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+			return
+		}
+		to, rate, err := rates.Convert(from, toCur)
+		if err != nil {
+			writeJSON(w, http.StatusUnprocessableEntity, domain.ErrResp{Error: err.Error()})
+			return
+		}
+		if !to.IsPositive() {
+			writeJSON(w, http.StatusUnprocessableEntity, domain.ErrResp{Error: "amount is too small to exchange"})
+			return
+		}
+
+		ex, err := s.Exchange(r.Context(), req.UserID, from, to, rate.FloatString(6), r.Header.Get("Idempotency-Key"))
+		switch {
+		case errors.Is(err, store.ErrNoAccount), errors.Is(err, store.ErrNoWallet):
+			writeJSON(w, http.StatusNotFound, domain.ErrResp{Error: err.Error()})
+			return
+		case errors.Is(err, store.ErrNotEnoughMoney), errors.Is(err, store.ErrSameCurrency),
+			errors.Is(err, store.ErrAccountFrozen), errors.Is(err, store.ErrAccountBlocked):
+			writeJSON(w, http.StatusUnprocessableEntity, domain.ErrResp{Error: err.Error()})
+			return
+		case errors.Is(err, store.ErrExchangeKeyReused):
+			writeJSON(w, http.StatusConflict, domain.ErrResp{Error: err.Error()})
+			return
+		case err != nil:
+			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: "could not exchange: " + err.Error()})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, ex)
+	}
+}
+
+type route struct {
+	openapi.Route
+	handler http.HandlerFunc
+}
+
+func routes(st *store.Store, rates *money.Rates, statementFont []byte) []route {
+	return []route{
+		{openapi.Route{
+			Method:  http.MethodPost,
+			Path:    "/create",
+			Summary: "Open a wallet in a currency (no-op if it exists)",
+			Req:     domain.CreateAccountReq{},
+			Resp:    domain.CreateAccountResp{},
+		}, makeHandleCreatePayment(st)},
+		{openapi.Route{
+			Method:     http.MethodPost,
+			Path:       "/topup",
+			Summary:    "Credit a balance without a card provider (back office); customers top up through /topup/card",
+			Req:        domain.TopUpReq{},
+			Resp:       domain.TopUpResp{},
+			Params:     []openapi.Param{actorParam},
+			Idempotent: true,
+			Operator:   true,
+		}, requireActor(makeHandleTopUp(st))},
+		{openapi.Route{
+			Method:     http.MethodPost,
+			Path:       "/topup/card",
+			Summary:    "Top up a wallet from a card; CHALLENGE charges finish after the card holder visits challenge_url",
+			Req:        domain.CardTopUpReq{},
+			Resp:       domain.CardCharge{},
+			Idempotent: true,
+		}, makeHandleCardTopUp(st)},
+		{openapi.Route{
+			Method:  http.MethodPost,
+			Path:    "/pay/card",
+			Summary: "Pay an order created with payment_method card (once per order)",
+			Req:     domain.CardPayReq{},
+			Resp:    domain.CardCharge{},
+		}, makeHandleCardPay(st)},
+		{openapi.Route{
+			Method:  http.MethodGet,
+			Path:    "/card-charges/{id}",
+			Summary: "State of a card charge",
+			Params: []openapi.Param{
+				{Name: "id", In: "path", Description: "charge id"},
+			},
+			Resp: domain.CardCharge{},
+		}, makeHandleCardCharge(st)},
+		{openapi.Route{
+			Method:  http.MethodPost,
+			Path:    "/provider/webhook",
+			Summary: "Signed outcome of card challenges from the card gateway",
+		}, makeHandleProviderWebhook(st)},
+		{openapi.Route{
+			Method:  http.MethodPost,
+			Path:    "/balance",
+			Summary: "Get wallet balances: cash in balances, cash and bonus apart in wallets",
+			Req:     domain.BalanceReq{},
+			Resp:    domain.BalanceResp{},
+		}, makeHandleBalance(st)},
+		{openapi.Route{
+			Method:     http.MethodPost,
+			Path:       "/bonuses/grant",
+			Summary:    "Grant expiring bonus credit of a campaign; bonuses are spent before cash",
+			Req:        domain.GrantBonusReq{},
+			Resp:       domain.BonusBucket{},
+			Params:     []openapi.Param{actorParam},
+			Idempotent: true,
+			Operator:   true,
+		}, requireActor(makeHandleGrantBonus(st))},
+		{openapi.Route{
+			Method:  http.MethodPost,
+			Path:    "/pay",
+			Summary: "Pay for an order (idempotent by order_id)",
+			Req:     domain.PayReq{},
+			Resp:    domain.Payment{},
+		}, makeHandlePay(st)},
+		{openapi.Route{
+			Method:     http.MethodPost,
+			Path:       "/refunds",
+			Summary:    "Refund a successful payment fully or partially",
+			Req:        domain.RefundReq{},
+			Resp:       domain.RefundResp{},
+			Params:     []openapi.Param{actorParam},
+			Idempotent: true,
+			Operator:   true,
+		}, requireActor(makeHandleRefund(st))},
+		{openapi.Route{
+			Method:   http.MethodPost,
+			Path:     "/admin/refunds/cancel",
+			Summary:  "Give up the refund of an order stuck PENDING at the card gateway (reason required); check the gateway did not pay it first",
+			Req:      domain.CancelRefundReq{},
+			Resp:     domain.Refund{},
+			Params:   []openapi.Param{actorParam},
+			Operator: true,
+		}, requireActor(makeHandleCancelRefund(st))},
+		{openapi.Route{
+			Method:     http.MethodPost,
+			Path:       "/exchange",
+			Summary:    "Convert money between two wallets of a user",
+			Req:        domain.ExchangeReq{},
+			Resp:       domain.Exchange{},
+			Idempotent: true,
+		}, makeHandleExchange(st, rates)},
+		{openapi.Route{
+			Method:     http.MethodPost,
+			Path:       "/withdrawals",
+			Summary:    "Request a withdrawal to a bank account; the amount is held off the balance until an admin decides",
+			Req:        domain.WithdrawalReq{},
+			Resp:       domain.Withdrawal{},
+			Idempotent: true,
+		}, makeHandleRequestWithdrawal(st)},
+		{openapi.Route{
+			Method:  http.MethodPost,
+			Path:    "/withdrawals/list",
+			Summary: "Withdrawals of a user newest first, optionally in one status",
+			Req:     domain.WithdrawalsReq{},
+			Resp:    domain.WithdrawalsResp{},
+		}, makeHandleWithdrawals(st, false)},
+		{openapi.Route{
+			Method:   http.MethodPost,
+			Path:     "/admin/withdrawals/list",
+			Summary:  "Withdrawals newest first, of a user or everyone, optionally in one status",
+			Req:      domain.WithdrawalsReq{},
+			Resp:     domain.WithdrawalsResp{},
+			Operator: true,
+		}, makeHandleWithdrawals(st, true)},
+		{openapi.Route{
+			Method:   http.MethodPost,
+			Path:     "/admin/withdrawals/approve",
+			Summary:  "Approve a requested withdrawal and send it to the payout provider",
+			Req:      domain.WithdrawalDecisionReq{},
+			Resp:     domain.Withdrawal{},
+			Params:   []openapi.Param{actorParam},
+			Operator: true,
+		}, requireActor(makeHandleWithdrawalDecision(func(ctx context.Context, actor string, id uuid.UUID, _ string) (domain.Withdrawal, error) {
+			return st.ApproveWithdrawal(ctx, actor, id)
+		}))},
+		{openapi.Route{
+			Method:   http.MethodPost,
+			Path:     "/admin/withdrawals/reject",
+			Summary:  "Reject a requested withdrawal (reason required) and give the money back",
+			Req:      domain.WithdrawalDecisionReq{},
+			Resp:     domain.Withdrawal{},
+			Params:   []openapi.Param{actorParam},
+			Operator: true,
+		}, requireActor(makeHandleWithdrawalDecision(st.RejectWithdrawal))},
+		{openapi.Route{
+			Method:     http.MethodPost,
+			Path:       "/admin/accounts/adjust",
+			Summary:    "Correct a balance by a signed amount; the reason and the operator go to the audit log",
+			Req:        domain.AdjustBalanceReq{},
+			Resp:       domain.BalanceAdjustment{},
+			Params:     []openapi.Param{actorParam},
+			Idempotent: true,
+			Operator:   true,
+		}, makeHandleAdjustBalance(st)},
+		{openapi.Route{
+			Method:  http.MethodGet,
+			Path:    "/admin/payments/{id}",
+			Summary: "Payment of an order, shares included",
+			Params: []openapi.Param{
+				{Name: "id", In: "path", Description: "order id"},
+			},
+			Resp:     domain.Payment{},
+			Operator: true,
+		}, makeHandleAdminPayment(st)},
+		{openapi.Route{
+			Method:   http.MethodPost,
+			Path:     "/admin/payments/void",
+			Summary:  "Fail the payment of an order about to be cancelled by hand so it is never charged",
+			Req:      domain.VoidPaymentReq{},
+			Resp:     domain.Payment{},
+			Params:   []openapi.Param{actorParam},
+			Operator: true,
+		}, makeHandleVoidPayment(st)},
+		{openapi.Route{
+			Method:   http.MethodPost,
+			Path:     "/admin/outbox",
+			Summary:  "Outbox backlog: unpublished count and the latest (or the pending) messages",
+			Req:      domain.OutboxReq{},
+			Resp:     domain.OutboxBacklog{},
+			Operator: true,
+		}, makeHandleOutbox(st)},
+		{openapi.Route{
+			Method:   http.MethodPost,
+			Path:     "/admin/outbox/republish",
+			Summary:  "Publish an outbox message again under its message id",
+			Req:      domain.RepublishReq{},
+			Resp:     domain.OutboxMessage{},
+			Params:   []openapi.Param{actorParam},
+			Operator: true,
+		}, makeHandleRepublish(st)},
+		{openapi.Route{
+			Method:   http.MethodPost,
+			Path:     "/admin/inbox",
+			Summary:  "Payment requests received: totals and the latest messages",
+			Req:      domain.InboxReq{},
+			Resp:     domain.InboxBacklog{},
+			Operator: true,
+		}, makeHandleInbox(st)},
+		{openapi.Route{
+			Method:   http.MethodPost,
+			Path:     "/admin/audit",
+			Summary:  "Read the audit log of changes, newest first",
+			Req:      domain.AuditQueryReq{},
+			Resp:     domain.AuditQueryResp{},
+			Operator: true,
+		}, makeHandleAuditLog(st)},
+		{openapi.Route{
+			Method:   http.MethodPost,
+			Path:     "/admin/reviews",
+			Summary:  "Payments held by the fraud rules for review, oldest first",
+			Req:      domain.ReviewsReq{},
+			Resp:     domain.ReviewsResp{},
+			Operator: true,
+		}, makeHandleReviews(st)},
+		{openapi.Route{
+			Method:   http.MethodPost,
+			Path:     "/admin/reviews/approve",
+			Summary:  "Approve a held payment: debit the wallet (balance and limits are checked again) and report the result to orders",
+			Req:      domain.ReviewDecisionReq{},
+			Resp:     domain.Payment{},
+			Params:   []openapi.Param{actorParam},
+			Operator: true,
+		}, requireActor(makeHandleReviewDecision(func(ctx context.Context, actor string, orderID uuid.UUID, _ string) (domain.Payment, error) {
+			return st.ApproveReview(ctx, actor, orderID)
+		}))},
+		{openapi.Route{
+			Method:   http.MethodPost,
+			Path:     "/admin/reviews/reject",
+			Summary:  "Reject a held payment as FAILED with FRAUD_REJECTED and report it to orders",
+			Req:      domain.ReviewDecisionReq{},
+			Resp:     domain.Payment{},
+			Params:   []openapi.Param{actorParam},
+			Operator: true,
+		}, requireActor(makeHandleReviewDecision(st.RejectReview))},
+		{openapi.Route{
+			Method:  http.MethodPost,
+			Path:    "/accounts/controls",
+			Summary: "Spending limits and flags of a wallet with what was spent against them",
+			Req:     domain.AccountControlsReq{},
+			Resp:    domain.AccountControls{},
+		}, makeHandleControls(st)},
+		{openapi.Route{
+			Method:  http.MethodPost,
+			Path:    "/accounts/controls/set",
+			Summary: "Replace the daily, weekly, monthly and single payment limits of a wallet",
+			Req:     domain.SetLimitsReq{},
+			Resp:    domain.AccountControls{},
+		}, makeHandleSetLimits(st)},
+		{openapi.Route{
+			Method:   http.MethodPost,
+			Path:     "/accounts/freeze",
+			Summary:  "Stop payments and exchanges from all wallets of a user",
+			Req:      domain.AccountFlagReq{},
+			Resp:     domain.AccountFlagReq{},
+			Params:   []openapi.Param{actorParam},
+			Operator: true,
+		}, requireActor(makeHandleAccountFlag(func(ctx context.Context, req domain.AccountFlagReq) error {
+			return st.SetFrozen(ctx, req.UserID, true)
+		}))},
+		{openapi.Route{
+			Method:   http.MethodPost,
+			Path:     "/accounts/unfreeze",
+			Summary:  "Lift a freeze",
+			Req:      domain.AccountFlagReq{},
+			Resp:     domain.AccountFlagReq{},
+			Params:   []openapi.Param{actorParam},
+			Operator: true,
+		}, requireActor(makeHandleAccountFlag(func(ctx context.Context, req domain.AccountFlagReq) error {
+			return st.SetFrozen(ctx, req.UserID, false)
+		}))},
+		{openapi.Route{
+			Method:   http.MethodPost,
+			Path:     "/accounts/block",
+			Summary:  "Block a user: no payments, exchanges or top-ups (reason required)",
+			Req:      domain.AccountFlagReq{},
+			Resp:     domain.AccountFlagReq{},
+			Params:   []openapi.Param{actorParam},
+			Operator: true,
+		}, requireActor(makeHandleAccountFlag(func(ctx context.Context, req domain.AccountFlagReq) error {
+			if req.Reason == "" {
+				return errEmptyReason
+			}
+			return st.SetBlocked(ctx, req.UserID, true, req.Reason)
+		}))},
+		{openapi.Route{
+			Method:   http.MethodPost,
+			Path:     "/accounts/unblock",
+			Summary:  "Lift a block",
+			Req:      domain.AccountFlagReq{},
+			Resp:     domain.AccountFlagReq{},
+			Params:   []openapi.Param{actorParam},
+			Operator: true,
+		}, requireActor(makeHandleAccountFlag(func(ctx context.Context, req domain.AccountFlagReq) error {
+			return st.SetBlocked(ctx, req.UserID, false, "")
+		}))},
+		{openapi.Route{
+			Method:  http.MethodGet,
+			Path:    "/accounts/{id}/statement",
+			Summary: "Wallet statement with running balance, this month so far by default",
+			Params: []openapi.Param{
+				{Name: "id", In: "path", Description: "user id"},
+				{Name: "currency", In: "query", Description: "wallet currency, DEFAULT_CURRENCY if empty"},
+				{Name: "from", In: "query", Description: "inclusive, RFC3339 or YYYY-MM-DD"},
+				{Name: "to", In: "query", Description: "exclusive RFC3339, or YYYY-MM-DD for that whole day"},
+				{Name: "format", In: "query", Description: "json (default), csv or pdf"},
+			},
+			Resp: domain.Statement{},
+		}, makeHandleStatement(st, statementFont)},
+	}
+}
+
+func RegisterRoutes(mux *http.ServeMux, serviceToken string, st *store.Store, rates *money.Rates, statementFont []byte) {
+	rs := routes(st, rates, statementFont)
+	spec := make([]openapi.Route, 0, len(rs))
+	for _, r := range rs {
+		mux.HandleFunc(r.Path, withBodyLimit(guard(serviceToken, r.Route, withAuditSource(r.handler))))
+		spec = append(spec, r.Route)
+	}
+	mux.HandleFunc("/openapi.json", openapi.Handler(openapi.Info{Title: "payments", Version: "1.0.0"}, spec, domain.ErrResp{}))
+}
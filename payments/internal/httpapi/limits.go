@@ -0,0 +1,22 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"payments/internal/domain"
+)
+
+// maxBody caps a request body. A body declared larger is refused with 413,
+// one that turns out larger fails to decode.
+const maxBody = 1 << 20
+
+func withBodyLimit(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > maxBody {
+			writeJSON(w, http.StatusRequestEntityTooLarge, domain.ErrResp{Error: "request body too large"})
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxBody)
+		h(w, r)
+	}
+}
@@ -0,0 +1,88 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"payments/internal/domain"
+	"payments/internal/store"
+)
+
+const (
+	defaultReviewsLimit = 50
+	maxReviewsLimit     = 500
+)
+
+func makeHandleReviews(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.ReviewsReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+		if req.Limit <= 0 {
+			req.Limit = defaultReviewsLimit
+		}
+		req.Limit = min(req.Limit, maxReviewsLimit)
+
+		ps, err := s.PendingReviews(r.Context(), req.Limit)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: "could not list reviews: " + err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, domain.ReviewsResp{Payments: ps})
+	}
+}
+
+// makeHandleReviewDecision serves approve and reject on behalf of the
+// operator in ActorHeader. The decided payment is returned; a declined
+// approval (e.g. the balance ran out meanwhile) is a FAILED payment, not an
+// error.
+func makeHandleReviewDecision(decide func(ctx context.Context, actor string, orderID uuid.UUID, reason string) (domain.Payment, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.ReviewDecisionReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+		orderUUID, err := uuid.Parse(req.OrderID)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "invalid orderID format"})
+			return
+		}
+
+		p, err := decide(r.Context(), r.Header.Get(ActorHeader), orderUUID, req.Reason)
+		switch {
+		case errors.Is(err, store.ErrNoActor):
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+			return
+		case errors.Is(err, store.ErrNoPayment):
+			writeJSON(w, http.StatusNotFound, domain.ErrResp{Error: err.Error()})
+			return
+		case errors.Is(err, store.ErrNotInReview):
+			writeJSON(w, http.StatusConflict, domain.ErrResp{Error: err.Error() + ": " + string(p.Status)})
+			return
+		case errors.Is(err, store.ErrReasonLimit):
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+			return
+		case err != nil:
+			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: "could not decide review: " + err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, p)
+	}
+}
@@ -0,0 +1,105 @@
+package httpapi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"payments/internal/domain"
+	"payments/internal/money"
+	"payments/internal/statement"
+	"payments/internal/store"
+)
+
+// statementTime reads a from/to bound: RFC3339, or a date in UTC. A date
+// in "to" includes that whole day.
+func statementTime(name, v string, def time.Time) (time.Time, error) {
+	if v == "" {
+		return def, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s should be RFC3339 or YYYY-MM-DD", name)
+	}
+	if name == "to" {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// makeHandleStatement serves the statement of wallet {id} (a user id) in
+// ?currency= for [from, to), this month so far by default.
+func makeHandleStatement(s *store.Store, font []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+		q := r.URL.Query()
+		userID := r.PathValue("id")
+		if userID == "" {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "empty user_id"})
+			return
+		}
+		cur, err := money.CurrencyOrDefault(q.Get("currency"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+			return
+		}
+		now := time.Now().UTC()
+		from, err := statementTime("from", q.Get("from"), time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+			return
+		}
+		to, err := statementTime("to", q.Get("to"), now)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+			return
+		}
+		format := q.Get("format")
+		if format == "" {
+			format = "json"
+		}
+		if format != "json" && format != "csv" && format != "pdf" {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "format should be json, csv or pdf"})
+			return
+		}
+
+		st, err := s.Statement(r.Context(), userID, cur, from, to)
+		if errors.Is(err, store.ErrStatementRange) {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			writeJSON(w, walletStatus(err), domain.ErrResp{Error: "could not build statement: " + err.Error()})
+			return
+		}
+		if format == "json" {
+			writeJSON(w, http.StatusOK, st)
+			return
+		}
+
+		var b []byte
+		ct := "text/csv; charset=utf-8"
+		if format == "csv" {
+			b, err = statement.CSV(st)
+		} else {
+			ct = "application/pdf"
+			b, err = statement.PDF(st, font)
+		}
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: err.Error()})
+			return
+		}
+		name := fmt.Sprintf("statement-%s-%s.%s", st.Currency, st.From.Format("20060102"), format)
+		w.Header().Set("Content-Type", ct)
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(b)
+	}
+}
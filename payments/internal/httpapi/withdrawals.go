@@ -0,0 +1,139 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"payments/internal/domain"
+	"payments/internal/store"
+)
+
+const (
+	defaultWithdrawalsLimit = 50
+	maxWithdrawalsLimit     = 500
+)
+
+func makeHandleRequestWithdrawal(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.WithdrawalReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+		if req.UserID == "" {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "empty user_id"})
+			return
+		}
+		amount, err := parseAmount(req.Amount, req.Currency)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+			return
+		}
+		if !amount.IsPositive() {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "amount should be greater than 0"})
+			return
+		}
+
+		wd, err := s.RequestWithdrawal(r.Context(), req.UserID, amount, req.Destination, r.Header.Get("Idempotency-Key"))
+		switch {
+		case errors.Is(err, store.ErrDestination):
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+			return
+		case errors.Is(err, store.ErrWithdrawalKeyReused):
+			writeJSON(w, http.StatusConflict, domain.ErrResp{Error: err.Error()})
+			return
+		case errors.Is(err, store.ErrNotEnoughMoney):
+			writeJSON(w, http.StatusUnprocessableEntity, domain.ErrResp{Error: err.Error()})
+			return
+		case err != nil:
+			writeJSON(w, walletStatus(err), domain.ErrResp{Error: "could not request withdrawal: " + err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, wd)
+	}
+}
+
+// makeHandleWithdrawals lists withdrawals. Unless allUsers, which only the
+// back office gets, user_id is required.
+func makeHandleWithdrawals(s *store.Store, allUsers bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.WithdrawalsReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+		if !allUsers && req.UserID == "" {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "empty user_id"})
+			return
+		}
+		if req.Limit <= 0 {
+			req.Limit = defaultWithdrawalsLimit
+		}
+		req.Limit = min(req.Limit, maxWithdrawalsLimit)
+
+		ws, err := s.Withdrawals(r.Context(), req.UserID, req.Status, req.Limit)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: "could not list withdrawals: " + err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, domain.WithdrawalsResp{Withdrawals: ws})
+	}
+}
+
+// makeHandleWithdrawalDecision serves approve and reject on behalf of the
+// operator in ActorHeader, who is stored with the withdrawal. An approved
+// withdrawal comes back as the payout left it: PAID_OUT, REJECTED by the
+// provider or still APPROVED while the payout is retried.
+func makeHandleWithdrawalDecision(decide func(ctx context.Context, actor string, id uuid.UUID, reason string) (domain.Withdrawal, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, domain.ErrResp{Error: "method not allowed"})
+			return
+		}
+
+		var req domain.WithdrawalDecisionReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "bad json: " + err.Error()})
+			return
+		}
+		id, err := uuid.Parse(req.ID)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: "invalid id format"})
+			return
+		}
+
+		wd, err := decide(r.Context(), r.Header.Get(ActorHeader), id, req.Reason)
+		switch {
+		case errors.Is(err, store.ErrNoActor):
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+			return
+		case errors.Is(err, store.ErrNoWithdrawal):
+			writeJSON(w, http.StatusNotFound, domain.ErrResp{Error: err.Error()})
+			return
+		case errors.Is(err, store.ErrWithdrawalState):
+			writeJSON(w, http.StatusConflict, domain.ErrResp{Error: err.Error()})
+			return
+		case errors.Is(err, store.ErrWithdrawalReasonLimit):
+			writeJSON(w, http.StatusBadRequest, domain.ErrResp{Error: err.Error()})
+			return
+		case err != nil:
+			writeJSON(w, http.StatusInternalServerError, domain.ErrResp{Error: "could not decide withdrawal: " + err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, wd)
+	}
+}
@@ -10,18 +10,32 @@ import (
 	"github.com/IBM/sarama"
 	"github.com/google/uuid"
 
-	"payments/internal/store"
+	"payments/internal/audit"
 	"payments/internal/domain"
+	"payments/internal/money"
+	"payments/internal/store"
 )
 
 type PaymentRequested struct {
 	MessageID   uuid.UUID `json:"message_id"`
 	OrderID     uuid.UUID `json:"order_id"`
 	UserID      string    `json:"user_id"`
-	Amount      int64     `json:"amount"`
+	Amount      int64     `json:"amount"` // minor units of Currency
+	Currency    string    `json:"currency"`
 	Description string    `json:"description"`
+	// PaymentMethod is "balance" (also when empty) or "card".
+	PaymentMethod string `json:"payment_method,omitempty"`
+	// Payers split the order; their amounts add up to Amount.
+	Payers []PayerShare `json:"payers,omitempty"`
+}
+
+type PayerShare struct {
+	UserID string `json:"user_id"`
+	Amount int64  `json:"amount"`
 }
 
+const PayByCard = "card"
+
 type PaymentRequestConsumer struct {
 	db    *sql.DB
 	store *store.Store
@@ -74,6 +88,7 @@ func (c *PaymentRequestConsumer) handleMessage(ctx context.Context, msg *sarama.
 	if err := json.Unmarshal(msg.Value, &ev); err != nil {
 		return err
 	}
+	ctx = audit.WithSource(ctx, audit.Source{Actor: "kafka:" + msg.Topic, RequestID: ev.MessageID.String()})
 
 	tx, err := c.db.BeginTx(ctx, nil)
 	if err != nil {
@@ -93,8 +108,35 @@ func (c *PaymentRequestConsumer) handleMessage(ctx context.Context, msg *sarama.
 		return tx.Commit()
 	}
 
-	p, _ := store.PayInTx(ctx, tx, ev.OrderID, ev.UserID, domain.Money(ev.Amount))
-	_ = store.InsertPaymentResultOutbox(ctx, tx, ev.OrderID, p.Status)
+	// Messages produced before currencies existed carry none.
+	cur := money.Currency(ev.Currency)
+	if cur == "" {
+		cur = money.Default()
+	}
+	var p domain.Payment
+	switch {
+	case len(ev.Payers) > 0:
+		shares := make([]domain.PaymentShare, len(ev.Payers))
+		for i, s := range ev.Payers {
+			shares[i] = domain.PaymentShare{UserID: s.UserID, Amount: money.New(s.Amount, cur)}
+		}
+		p, err = c.store.PaySplitInTx(ctx, tx, ev.OrderID, ev.UserID, money.New(ev.Amount, cur), shares)
+	case ev.PaymentMethod == PayByCard:
+		p, err = c.store.AwaitCardInTx(ctx, tx, ev.OrderID, ev.UserID, money.New(ev.Amount, cur))
+	default:
+		p, err = c.store.PayInTx(ctx, tx, ev.OrderID, ev.UserID, money.New(ev.Amount, cur))
+	}
+	if p.Status == "" {
+		// Nothing was recorded; leave the message for a retry.
+		return err
+	}
+	// The result of a payment under review or awaiting a card is sent once
+	// it is settled.
+	if p.Status.Settled() {
+		if err := store.InsertPaymentResultOutbox(ctx, tx, p); err != nil {
+			return err
+		}
+	}
 
 	return tx.Commit()
 }
@@ -0,0 +1,135 @@
+package money
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+)
+
+// DefaultRates are used when FX_RATES is not set. Quotes are "BASE/QUOTE=price":
+// one unit of BASE costs price units of QUOTE.
+const DefaultRates = "USD/RUB=92.50,EUR/RUB=100.10,GBP/RUB=117.20,CNY/RUB=12.70," +
+	"KZT/RUB=0.19,BYN/RUB=28.60,JPY/RUB=0.62,KWD/RUB=301.50"
+
+var ErrNoRate = errors.New("no exchange rate")
+
+type pair struct{ from, to Currency }
+
+// Rates is a static exchange rate table. Missing directions are derived from
+// the inverse quote or through a common third currency.
+type Rates struct {
+	quotes map[pair]*big.Rat
+}
+
+// LoadRates reads FX_RATES, falling back to DefaultRates.
+func LoadRates() (*Rates, error) {
+	s := os.Getenv("FX_RATES")
+	if s == "" {
+		s = DefaultRates
+	}
+	return ParseRates(s)
+}
+
+// ParseRates reads a comma separated list like "USD/RUB=92.50,EUR/RUB=100.10".
+func ParseRates(s string) (*Rates, error) {
+	r := &Rates{quotes: map[pair]*big.Rat{}}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, price, ok := strings.Cut(item, "=")
+		base, quote, ok2 := strings.Cut(name, "/")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("bad rate %q: want BASE/QUOTE=price", item)
+		}
+		b, err := ParseCurrency(base)
+		if err != nil {
+			return nil, err
+		}
+		q, err := ParseCurrency(quote)
+		if err != nil {
+			return nil, err
+		}
+		p, ok := new(big.Rat).SetString(strings.TrimSpace(price))
+		if !ok || p.Sign() <= 0 {
+			return nil, fmt.Errorf("bad rate %q: price should be a positive decimal", item)
+		}
+		r.quotes[pair{b, q}] = p
+	}
+	return r, nil
+}
+
+func (r *Rates) direct(from, to Currency) (*big.Rat, bool) {
+	if p, ok := r.quotes[pair{from, to}]; ok {
+		return p, true
+	}
+	if p, ok := r.quotes[pair{to, from}]; ok {
+		return new(big.Rat).Inv(p), true
+	}
+	return nil, false
+}
+
+// Rate is the price of one unit of from in units of to.
+func (r *Rates) Rate(from, to Currency) (*big.Rat, error) {
+	if from == to {
+		return big.NewRat(1, 1), nil
+	}
+	if p, ok := r.direct(from, to); ok {
+		return p, nil
+	}
+
+	// Cross rate through a third currency, tried in a fixed order so the
+	// result does not depend on map iteration.
+	pivots := make([]string, 0, len(exponents))
+	for c := range exponents {
+		pivots = append(pivots, string(c))
+	}
+	sort.Strings(pivots)
+	for _, c := range pivots {
+		a, ok := r.direct(from, Currency(c))
+		if !ok {
+			continue
+		}
+		b, ok := r.direct(Currency(c), to)
+		if !ok {
+			continue
+		}
+		return new(big.Rat).Mul(a, b), nil
+	}
+	return nil, fmt.Errorf("%w: %s/%s", ErrNoRate, from, to)
+}
+
+// Convert returns m in currency to, rounded down to a whole minor unit, and
+// the rate it used.
+func (r *Rates) Convert(m Money, to Currency) (Money, *big.Rat, error) {
+	rate, err := r.Rate(m.Currency, to)
+	if err != nil {
+		return Money{}, nil, err
+	}
+
+	v := new(big.Rat).Mul(new(big.Rat).SetInt64(m.Amount), rate)
+	shift := to.Exponent() - m.Currency.Exponent()
+	scale := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(shift))), nil))
+	if shift >= 0 {
+		v.Mul(v, scale)
+	} else {
+		v.Quo(v, scale)
+	}
+
+	minor := new(big.Int).Quo(v.Num(), v.Denom())
+	if !minor.IsInt64() {
+		return Money{}, nil, ErrAmountOverflow
+	}
+	return Money{Amount: minor.Int64(), Currency: to}, rate, nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,55 @@
+package money
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestConvert(t *testing.T) {
+	rates, err := ParseRates(DefaultRates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		from Money
+		to   Currency
+		want string
+	}{
+		{New(10000, "USD"), "RUB", "9250.00 RUB"},
+		// 1 RUB is 0.0108... USD: the remainder is never rounded up.
+		{New(100, "RUB"), "USD", "0.01 USD"},
+		{New(1, "RUB"), "USD", "0.00 USD"},
+		{New(1000, "JPY"), "RUB", "620.00 RUB"},
+		{New(1000, "KWD"), "RUB", "301.50 RUB"},
+		// Through RUB: 92.50 / 0.62 = 149.19... JPY for a dollar.
+		{New(100, "USD"), "JPY", "149 JPY"},
+		{New(12345, "RUB"), "RUB", "123.45 RUB"},
+	} {
+		got, _, err := rates.Convert(tc.from, tc.to)
+		if err != nil {
+			t.Errorf("Convert(%s, %s): %v", tc.from, tc.to, err)
+			continue
+		}
+		if got.String() != tc.want {
+			t.Errorf("Convert(%s, %s) = %s, want %s", tc.from, tc.to, got, tc.want)
+		}
+	}
+}
+
+func TestConvertNoRate(t *testing.T) {
+	rates, err := ParseRates("USD/RUB=92.50")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := rates.Convert(New(100, "EUR"), "USD"); !errors.Is(err, ErrNoRate) {
+		t.Errorf("Convert EUR to USD = %v, want ErrNoRate", err)
+	}
+}
+
+func TestParseRates(t *testing.T) {
+	for _, s := range []string{"USD/RUB", "USDRUB=92.50", "USD/XXX=1", "USD/RUB=0", "USD/RUB=-1", "USD/RUB=abc"} {
+		if _, err := ParseRates(s); err == nil {
+			t.Errorf("ParseRates(%q) accepted", s)
+		}
+	}
+}
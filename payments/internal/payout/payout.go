@@ -0,0 +1,63 @@
+// Package payout sends money out of the service to a user's bank account.
+package payout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+
+	"payments/internal/money"
+)
+
+// ErrRejected is a payout the provider refused for good, e.g. a closed
+// account. Other errors of Send may pass on a retry.
+var ErrRejected = errors.New("payout rejected")
+
+type Request struct {
+	// Reference is ours and makes Send idempotent: sending the same
+	// reference again returns the first payout.
+	Reference   string
+	Amount      money.Money
+	Destination string // bank account, IBAN or card number
+}
+
+type Provider interface {
+	// Name is stored with every payout made through the provider.
+	Name() string
+	// Send pays Amount out to Destination and returns the provider's id
+	// of the payout.
+	Send(ctx context.Context, req Request) (string, error)
+}
+
+// Stub is a local payout provider that moves no money. Destinations that
+// start with "FAIL" are rejected; everything else is paid out.
+type Stub struct {
+	mu   sync.Mutex
+	sent map[string]string // reference -> payout id
+}
+
+var _ Provider = (*Stub)(nil)
+
+func NewStub() *Stub {
+	return &Stub{sent: map[string]string{}}
+}
+
+func (s *Stub) Name() string { return "stub" }
+
+func (s *Stub) Send(ctx context.Context, req Request) (string, error) {
+	if strings.HasPrefix(strings.ToUpper(req.Destination), "FAIL") {
+		return "", fmt.Errorf("%w: account closed", ErrRejected)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, ok := s.sent[req.Reference]
+	if !ok {
+		id = "po_" + uuid.NewString()
+		s.sent[req.Reference] = id
+	}
+	return id, nil
+}
@@ -0,0 +1,77 @@
+// Package provider is the interface to an external card gateway. Money is
+// authorized first and captured after; refunds go back to the card. Slow
+// steps such as a 3-D Secure challenge finish later and are reported to a
+// webhook.
+package provider
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"payments/internal/money"
+)
+
+var ErrBadSignature = errors.New("bad webhook signature")
+
+type Card struct {
+	Number   string `json:"number"`
+	ExpMonth int    `json:"exp_month"`
+	ExpYear  int    `json:"exp_year"`
+	CVC      string `json:"cvc"`
+}
+
+type AuthStatus string
+
+const (
+	Authorized AuthStatus = "authorized"
+	Declined   AuthStatus = "declined"
+	// Challenged waits for the card holder to pass a challenge at
+	// ChallengeURL; the outcome comes to the webhook.
+	Challenged AuthStatus = "challenge"
+)
+
+// AuthorizeReq holds money on a card. Reference is ours and makes the call
+// idempotent: authorizing the same reference again returns the first result.
+type AuthorizeReq struct {
+	Reference string
+	Amount    money.Money
+	Card      Card
+}
+
+type Authorization struct {
+	ID           string
+	Status       AuthStatus
+	DeclineCode  string // set on Declined, e.g. card_declined
+	ChallengeURL string // set on Challenged
+}
+
+type EventType string
+
+const (
+	AuthorizationSucceeded EventType = "authorization.succeeded"
+	AuthorizationFailed    EventType = "authorization.failed"
+)
+
+// Event is a verified webhook call.
+type Event struct {
+	Type            EventType
+	AuthorizationID string
+	Reference       string
+	DeclineCode     string
+}
+
+type Provider interface {
+	// Name is stored with every transaction made through the provider.
+	Name() string
+	Authorize(ctx context.Context, req AuthorizeReq) (Authorization, error)
+	// Capture takes amount (at most the authorized one) from an
+	// authorization. Capturing again is a no-op.
+	Capture(ctx context.Context, authorizationID string, amount money.Money) error
+	// Refund gives amount of a capture back to the card. Reference makes
+	// it idempotent like AuthorizeReq.Reference.
+	Refund(ctx context.Context, authorizationID string, amount money.Money, reference string) (string, error)
+	// VerifyWebhook checks that a webhook call came from the provider and
+	// decodes it.
+	VerifyWebhook(header http.Header, body []byte) (Event, error)
+}
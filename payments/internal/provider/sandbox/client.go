@@ -0,0 +1,138 @@
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"payments/internal/money"
+	"payments/internal/provider"
+)
+
+// webhookTolerance bounds how old a signed webhook may be, against replays.
+const webhookTolerance = 5 * time.Minute
+
+// Client is the provider.Provider of the sandbox gateway at baseURL.
+type Client struct {
+	baseURL string
+	secret  string
+	http    *http.Client
+}
+
+var _ provider.Provider = (*Client)(nil)
+
+func NewClient(baseURL, secret string) *Client {
+	return &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		secret:  secret,
+		http:    &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (c *Client) Name() string { return "sandbox" }
+
+func (c *Client) Authorize(ctx context.Context, req provider.AuthorizeReq) (provider.Authorization, error) {
+	var resp authorizationBody
+	err := c.call(ctx, "/v1/authorizations", authorizeBody{
+		Reference: req.Reference,
+		Amount:    req.Amount.Amount,
+		Currency:  string(req.Amount.Currency),
+		Card:      req.Card,
+	}, &resp)
+	if err != nil {
+		return provider.Authorization{}, err
+	}
+	return provider.Authorization{
+		ID:           resp.ID,
+		Status:       resp.Status,
+		DeclineCode:  resp.DeclineCode,
+		ChallengeURL: resp.ChallengeURL,
+	}, nil
+}
+
+func (c *Client) Capture(ctx context.Context, authorizationID string, amount money.Money) error {
+	return c.call(ctx, "/v1/authorizations/"+authorizationID+"/capture", amountBody{Amount: amount.Amount}, nil)
+}
+
+func (c *Client) Refund(ctx context.Context, authorizationID string, amount money.Money, reference string) (string, error) {
+	var resp struct {
+		ID string `json:"id"`
+	}
+	err := c.call(ctx, "/v1/authorizations/"+authorizationID+"/refunds", amountBody{Amount: amount.Amount, Reference: reference}, &resp)
+	return resp.ID, err
+}
+
+func (c *Client) VerifyWebhook(header http.Header, body []byte) (provider.Event, error) {
+	var ts, sig string
+	for _, part := range strings.Split(header.Get(SignatureHeader), ",") {
+		k, v, _ := strings.Cut(part, "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			sig = v
+		}
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || sig == "" {
+		return provider.Event{}, provider.ErrBadSignature
+	}
+	if d := time.Since(time.Unix(unix, 0)); d > webhookTolerance || d < -webhookTolerance {
+		return provider.Event{}, fmt.Errorf("%w: too old", provider.ErrBadSignature)
+	}
+	if !hmac.Equal([]byte(sig), []byte(mac(c.secret, ts, body))) {
+		return provider.Event{}, provider.ErrBadSignature
+	}
+
+	var ev webhookBody
+	if err := json.Unmarshal(body, &ev); err != nil {
+		return provider.Event{}, err
+	}
+	return provider.Event{
+		Type:            ev.Type,
+		AuthorizationID: ev.AuthorizationID,
+		Reference:       ev.Reference,
+		DeclineCode:     ev.DeclineCode,
+	}, nil
+}
+
+func (c *Client) call(ctx context.Context, path string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.secret)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Error string `json:"error"`
+		}
+		_ = json.Unmarshal(raw, &e)
+		return fmt.Errorf("sandbox %s: %d: %s", path, resp.StatusCode, e.Error)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(raw, out)
+}
@@ -0,0 +1,376 @@
+// Package sandbox is a local card gateway for development: Server is the
+// gateway itself, an HTTP stub keeping its state in memory, and Client is
+// the provider.Provider talking to it. Outcomes depend on the card only:
+//
+//	4242 4242 4242 4242  approved
+//	4000 0000 0000 0002  declined, card_declined
+//	4000 0000 0000 9995  declined, insufficient_funds
+//	4000 0000 0000 3220  3-D Secure challenge, passed or failed on its page
+//
+// Any other number that passes the Luhn check, with a future expiry and a
+// 3-4 digit CVC, is approved.
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"payments/internal/provider"
+)
+
+const SignatureHeader = "Sandbox-Signature"
+
+type authorizeBody struct {
+	Reference string        `json:"reference"`
+	Amount    int64         `json:"amount"`
+	Currency  string        `json:"currency"`
+	Card      provider.Card `json:"card"`
+}
+
+type authorizationBody struct {
+	ID           string              `json:"id"`
+	Status       provider.AuthStatus `json:"status"`
+	DeclineCode  string              `json:"decline_code,omitempty"`
+	ChallengeURL string              `json:"challenge_url,omitempty"`
+}
+
+type amountBody struct {
+	Amount    int64  `json:"amount"`
+	Reference string `json:"reference,omitempty"`
+}
+
+type webhookBody struct {
+	Type            provider.EventType `json:"type"`
+	AuthorizationID string             `json:"authorization_id"`
+	Reference       string             `json:"reference"`
+	DeclineCode     string             `json:"decline_code,omitempty"`
+}
+
+type authorization struct {
+	authorizationBody
+	reference string
+	amount    int64
+	currency  string
+	captured  int64
+	refunded  int64
+	refunds   map[string]string // reference -> refund id
+}
+
+// Server is the gateway stub. Authorizations live in memory, so a restart
+// forgets them.
+type Server struct {
+	secret     string
+	publicURL  string // base of challenge links, as the browser sees it
+	webhookURL string
+	client     *http.Client
+
+	mu    sync.Mutex
+	auths map[string]*authorization
+	refs  map[string]string // reference -> authorization id
+}
+
+func NewServer(secret, publicURL, webhookURL string) *Server {
+	return &Server{
+		secret:     secret,
+		publicURL:  strings.TrimRight(publicURL, "/"),
+		webhookURL: webhookURL,
+		client:     &http.Client{Timeout: 5 * time.Second},
+		auths:      map[string]*authorization{},
+		refs:       map[string]string{},
+	}
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/authorizations", s.authorized(s.handleAuthorize))
+	mux.HandleFunc("POST /v1/authorizations/{id}/capture", s.authorized(s.handleCapture))
+	mux.HandleFunc("POST /v1/authorizations/{id}/refunds", s.authorized(s.handleRefund))
+	mux.HandleFunc("GET /challenge/{id}", s.handleChallengePage)
+	mux.HandleFunc("POST /challenge/{id}", s.handleChallenge)
+	return mux
+}
+
+func (s *Server) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !hmac.Equal([]byte(r.Header.Get("Authorization")), []byte("Bearer "+s.secret)) {
+			writeError(w, http.StatusUnauthorized, "bad api key")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	var req authorizeBody
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "bad json: "+err.Error())
+		return
+	}
+	if req.Reference == "" || req.Amount <= 0 || req.Currency == "" {
+		writeError(w, http.StatusBadRequest, "reference, amount > 0 and currency are required")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id, ok := s.refs[req.Reference]; ok {
+		writeJSON(w, http.StatusOK, s.auths[id].authorizationBody)
+		return
+	}
+
+	a := &authorization{
+		authorizationBody: authorizationBody{ID: "auth_" + uuid.NewString()},
+		reference:         req.Reference,
+		amount:            req.Amount,
+		currency:          req.Currency,
+		refunds:           map[string]string{},
+	}
+	switch outcome := decide(req.Card, time.Now()); outcome {
+	case "":
+		a.Status = provider.Authorized
+	case "challenge":
+		a.Status = provider.Challenged
+		a.ChallengeURL = s.publicURL + "/challenge/" + a.ID
+	default:
+		a.Status = provider.Declined
+		a.DeclineCode = outcome
+	}
+	s.auths[a.ID] = a
+	s.refs[req.Reference] = a.ID
+	writeJSON(w, http.StatusOK, a.authorizationBody)
+}
+
+// decide returns "" to approve, "challenge" or a decline code; see the
+// package doc.
+func decide(c provider.Card, now time.Time) string {
+	number := strings.ReplaceAll(strings.ReplaceAll(c.Number, " ", ""), "-", "")
+	switch number {
+	case "4000000000000002":
+		return "card_declined"
+	case "4000000000009995":
+		return "insufficient_funds"
+	case "4000000000003220":
+		return "challenge"
+	}
+	if !luhn(number) {
+		return "incorrect_number"
+	}
+	if c.ExpMonth < 1 || c.ExpMonth > 12 {
+		return "invalid_expiry"
+	}
+	// A card is valid through the last day of its expiry month.
+	if !time.Date(c.ExpYear, time.Month(c.ExpMonth)+1, 1, 0, 0, 0, 0, time.UTC).After(now) {
+		return "expired_card"
+	}
+	if len(c.CVC) < 3 || len(c.CVC) > 4 {
+		return "incorrect_cvc"
+	}
+	return ""
+}
+
+func luhn(number string) bool {
+	if len(number) < 12 || len(number) > 19 {
+		return false
+	}
+	sum := 0
+	for i := range number {
+		d := int(number[len(number)-1-i] - '0')
+		if d < 0 || d > 9 {
+			return false
+		}
+		if i%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+func (s *Server) handleCapture(w http.ResponseWriter, r *http.Request) {
+	var req amountBody
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "bad json: "+err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.auths[r.PathValue("id")]
+	switch {
+	case !ok:
+		writeError(w, http.StatusNotFound, "no such authorization")
+		return
+	case a.Status != provider.Authorized:
+		writeError(w, http.StatusConflict, "authorization is "+string(a.Status))
+		return
+	case req.Amount <= 0 || req.Amount > a.amount:
+		writeError(w, http.StatusUnprocessableEntity, "capture exceeds the authorized amount")
+		return
+	}
+	if a.captured == 0 {
+		a.captured = req.Amount
+	}
+	writeJSON(w, http.StatusOK, a.authorizationBody)
+}
+
+func (s *Server) handleRefund(w http.ResponseWriter, r *http.Request) {
+	var req amountBody
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "bad json: "+err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.auths[r.PathValue("id")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "no such authorization")
+		return
+	}
+	if id, ok := a.refunds[req.Reference]; ok && req.Reference != "" {
+		writeJSON(w, http.StatusOK, map[string]string{"id": id})
+		return
+	}
+	if req.Amount <= 0 || a.refunded+req.Amount > a.captured {
+		writeError(w, http.StatusUnprocessableEntity, "refund exceeds the captured amount")
+		return
+	}
+	a.refunded += req.Amount
+	id := "re_" + uuid.NewString()
+	if req.Reference != "" {
+		a.refunds[req.Reference] = id
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"id": id})
+}
+
+var challengePage = template.Must(template.New("challenge").Parse(`<!doctype html>
+<html><head><meta charset="utf-8"><title>Sandbox 3-D Secure</title></head>
+<body style="font-family: sans-serif; max-width: 420px; margin: 40px auto">
+<h3>Sandbox 3-D Secure</h3>
+{{if .Done}}<p>Done: the authorization is <b>{{.Status}}</b>. You can close this page.</p>
+{{else}}<p>Confirm a payment of <b>{{.Amount}} {{.Currency}}</b> (minor units).</p>
+<form method="post"><button name="result" value="pass">Pass</button> <button name="result" value="fail">Fail</button></form>
+{{end}}</body></html>`))
+
+func (s *Server) handleChallengePage(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	a, ok := s.auths[r.PathValue("id")]
+	var data map[string]any
+	if ok {
+		data = map[string]any{
+			"Done":     a.Status != provider.Challenged,
+			"Status":   a.Status,
+			"Amount":   a.amount,
+			"Currency": a.currency,
+		}
+	}
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_ = challengePage.Execute(w, data)
+}
+
+// handleChallenge completes a challenge and reports the outcome to the
+// webhook in the background, like a real gateway would.
+func (s *Server) handleChallenge(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	a, ok := s.auths[r.PathValue("id")]
+	if !ok {
+		s.mu.Unlock()
+		http.NotFound(w, r)
+		return
+	}
+	if a.Status == provider.Challenged {
+		ev := webhookBody{AuthorizationID: a.ID, Reference: a.reference}
+		if r.FormValue("result") == "pass" {
+			a.Status = provider.Authorized
+			ev.Type = provider.AuthorizationSucceeded
+		} else {
+			a.Status = provider.Declined
+			a.DeclineCode = "authentication_failed"
+			ev.Type = provider.AuthorizationFailed
+			ev.DeclineCode = a.DeclineCode
+		}
+		go s.sendWebhook(ev)
+	}
+	s.mu.Unlock()
+	http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+}
+
+// sendWebhook delivers ev, retrying for a minute.
+func (s *Server) sendWebhook(ev webhookBody) {
+	body, _ := json.Marshal(ev)
+	backoff := 500 * time.Millisecond
+	for deadline := time.Now().Add(time.Minute); time.Now().Before(deadline); backoff *= 2 {
+		err := s.post(body)
+		if err == nil {
+			return
+		}
+		log.Printf("sandbox webhook %s for %s: %v", ev.Type, ev.AuthorizationID, err)
+		time.Sleep(backoff)
+	}
+}
+
+func (s *Server) post(body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(s.secret, time.Now(), body))
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Sign is the signature header value for body sent at t:
+// "t=<unix>,v1=<hex HMAC-SHA256 of "<unix>.<body>">".
+func Sign(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + ts + ",v1=" + mac(secret, ts, body)
+}
+
+func mac(secret, ts string, body []byte) string {
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write([]byte(ts))
+	m.Write([]byte("."))
+	m.Write(body)
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
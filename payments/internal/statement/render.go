@@ -0,0 +1,152 @@
+// Package statement renders wallet statements for download.
+package statement
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jung-kurt/gofpdf"
+
+	"payments/internal/domain"
+)
+
+// LoadFont reads the TTF named by STATEMENT_FONT. Without one the PDF uses
+// a core font, which only has Latin-1 glyphs.
+func LoadFont() ([]byte, error) {
+	path := os.Getenv("STATEMENT_FONT")
+	if path == "" {
+		return nil, nil
+	}
+	return os.ReadFile(path)
+}
+
+// CSV writes the opening balance, every entry and the closing balance as
+// rows with the same columns, so the file sums up in a spreadsheet.
+func CSV(st domain.Statement) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	_ = w.Write([]string{"at", "type", "reference", "detail", "amount", "balance", "currency"})
+	_ = w.Write([]string{st.From.Format(time.RFC3339), "OPENING", "", "", "", st.Opening.Decimal(), string(st.Currency)})
+	for _, e := range st.Entries {
+		_ = w.Write([]string{
+			e.At.Format(time.RFC3339), string(e.Type), e.Reference, spreadsheetSafe(e.Detail),
+			e.Amount.Decimal(), e.Balance.Decimal(), string(st.Currency),
+		})
+	}
+	_ = w.Write([]string{st.To.Format(time.RFC3339), "CLOSING", "", "", "", st.Closing.Decimal(), string(st.Currency)})
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// spreadsheetSafe keeps free text (refund reasons) from being run as a
+// formula when the CSV is opened in a spreadsheet.
+func spreadsheetSafe(v string) string {
+	if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+		return "'" + v
+	}
+	return v
+}
+
+var entryTitles = map[domain.StatementEntryType]string{
+	domain.EntryTopUp:       "Top-up",
+	domain.EntryPayment:     "Payment",
+	domain.EntryRefund:      "Refund",
+	domain.EntryExchangeOut: "Exchange out",
+	domain.EntryExchangeIn:  "Exchange in",
+}
+
+func PDF(st domain.Statement, font []byte) ([]byte, error) {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetCreationDate(st.GeneratedAt)
+	pdf.SetModificationDate(st.GeneratedAt)
+	pdf.SetTitle("Statement "+st.UserID+" "+string(st.Currency), true)
+
+	family, tr := "Helvetica", pdf.UnicodeTranslatorFromDescriptor("")
+	if font != nil {
+		pdf.AddUTF8FontFromBytes("statement", "", font)
+		pdf.AddUTF8FontFromBytes("statement", "B", font)
+		family, tr = "statement", func(s string) string { return s }
+	}
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-12)
+		pdf.SetFont(family, "", 8)
+		pdf.CellFormat(0, 5, tr(st.UserID+" · "+string(st.Currency)), "", 0, "L", false, 0, "")
+		pdf.CellFormat(0, 5, "page "+strconv.Itoa(pdf.PageNo()), "", 0, "R", false, 0, "")
+	})
+	pdf.AddPage()
+
+	pdf.SetFont(family, "B", 16)
+	pdf.CellFormat(0, 9, tr("Account statement"), "", 1, "L", false, 0, "")
+	pdf.SetFont(family, "", 10)
+	pdf.CellFormat(0, 5, tr("Account: "+st.UserID+", "+string(st.Currency)), "", 1, "L", false, 0, "")
+	pdf.CellFormat(0, 5, tr("Period: "+st.From.Format("2006-01-02 15:04")+" - "+st.To.Format("2006-01-02 15:04")+" UTC"), "", 1, "L", false, 0, "")
+	pdf.Ln(3)
+
+	summary := [][2]string{
+		{"Opening balance", st.Opening.Decimal()},
+		{"Credits", st.Credits.Decimal()},
+		{"Debits", st.Debits.Decimal()},
+		{"Closing balance", st.Closing.Decimal()},
+	}
+	for _, row := range summary {
+		pdf.CellFormat(40, 5, row[0], "", 0, "L", false, 0, "")
+		pdf.CellFormat(30, 5, row[1], "", 1, "R", false, 0, "")
+	}
+	pdf.Ln(4)
+
+	widths := []float64{32, 26, 72, 30, 30}
+	header := []string{"Date", "Type", "Reference", "Amount", "Balance"}
+	printHeader := func() {
+		pdf.SetFont(family, "B", 9)
+		for i, h := range header {
+			align := "L"
+			if i >= 3 {
+				align = "R"
+			}
+			pdf.CellFormat(widths[i], 7, h, "B", 0, align, false, 0, "")
+		}
+		pdf.Ln(-1)
+		pdf.SetFont(family, "", 8)
+	}
+	printHeader()
+	for _, e := range st.Entries {
+		if pdf.GetY() > 270 {
+			pdf.AddPage()
+			printHeader()
+		}
+		ref := e.Reference
+		if e.Detail != "" {
+			ref += " " + truncate(e.Detail, 30)
+		}
+		cells := []string{e.At.Format("2006-01-02 15:04"), entryTitles[e.Type], ref, e.Amount.Decimal(), e.Balance.Decimal()}
+		for i, c := range cells {
+			align := "L"
+			if i >= 3 {
+				align = "R"
+			}
+			pdf.CellFormat(widths[i], 6, tr(truncate(c, 48)), "", 0, align, false, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+	if len(st.Entries) == 0 {
+		pdf.CellFormat(0, 6, tr("No movements in this period."), "", 1, "L", false, 0, "")
+	}
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return strings.TrimSpace(string(r[:n-1])) + "…"
+}
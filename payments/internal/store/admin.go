@@ -0,0 +1,349 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"payments/internal/audit"
+	"payments/internal/domain"
+	"payments/internal/money"
+)
+
+var (
+	ErrNoActor             = errors.New("admin actor is required")
+	ErrAdminReason         = errors.New("reason should contain 1 to 200 symbols")
+	ErrZeroAdjustment      = errors.New("adjustment should not be 0")
+	ErrAdjustmentKeyReused = errors.New("idempotency key was already used with a different adjustment")
+	ErrPaymentPaid         = errors.New("order is paid; refund it instead")
+	ErrChargeInFlight      = errors.New("a card charge of the order is in progress")
+	ErrAdminCancelled      = errors.New("payment cancelled by support")
+	ErrNoOutboxMessage     = errors.New("no outbox message")
+)
+
+func checkAdmin(actor, reason string) error {
+	if actor == "" {
+		return ErrNoActor
+	}
+	if reason == "" || len(reason) > 200 {
+		return ErrAdminReason
+	}
+	return nil
+}
+
+// AdjustBalance corrects a wallet balance by amount (negative to debit) on
+// behalf of actor. Freezes and blocks do not stop it, but a debit can not
+// take the balance below zero. Repeating a call with the same non-empty
+// idempotencyKey returns the first adjustment.
+func (s *Store) AdjustBalance(ctx context.Context, actor, userID string, amount domain.Money, reason, idempotencyKey string) (domain.BalanceAdjustment, error) {
+	if err := checkAdmin(actor, reason); err != nil {
+		return domain.BalanceAdjustment{}, err
+	}
+	ctx = audit.WithReason(ctx, reason)
+
+	if amount.Amount == 0 {
+		return domain.BalanceAdjustment{}, ErrZeroAdjustment
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return domain.BalanceAdjustment{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	bal, _, err := lockWallet(ctx, tx, userID, amount.Currency)
+	if err == sql.ErrNoRows {
+		return domain.BalanceAdjustment{}, walletErr(ctx, tx, userID, amount.Currency)
+	}
+	if err != nil {
+		return domain.BalanceAdjustment{}, err
+	}
+
+	a := domain.BalanceAdjustment{UserID: userID, Amount: amount, Reason: reason, Actor: actor}
+	if idempotencyKey != "" {
+		var amt int64
+		err := tx.QueryRowContext(ctx,
+			`select id, amount, reason, actor, created_at from balance_adjustments
+			 where user_id = $1 and idempotency_key = $2`,
+			userID, idempotencyKey,
+		).Scan(&a.ID, &amt, &a.Reason, &a.Actor, &a.CreatedAt)
+		if err == nil {
+			if amt != amount.Amount {
+				return domain.BalanceAdjustment{}, ErrAdjustmentKeyReused
+			}
+			a.Balance = money.New(bal, amount.Currency)
+			return a, nil
+		}
+		if err != sql.ErrNoRows {
+			return domain.BalanceAdjustment{}, err
+		}
+	}
+	if bal+amount.Amount < 0 {
+		return domain.BalanceAdjustment{}, ErrNotEnoughMoney
+	}
+
+	var mc moneyChange
+	if err := mc.lock(ctx, tx, wallet{userID, amount.Currency}); err != nil {
+		return domain.BalanceAdjustment{}, err
+	}
+	_, err = tx.ExecContext(ctx,
+		`update accounts set balance = balance + $3 where user_id = $1 and currency = $2`,
+		userID, string(amount.Currency), amount.Amount,
+	)
+	if err != nil {
+		return domain.BalanceAdjustment{}, err
+	}
+	var key sql.NullString
+	if idempotencyKey != "" {
+		key = sql.NullString{String: idempotencyKey, Valid: true}
+	}
+	a.ID = uuid.New()
+	err = tx.QueryRowContext(ctx,
+		`insert into balance_adjustments(id, user_id, currency, amount, reason, actor, idempotency_key)
+		 values ($1,$2,$3,$4,$5,$6,$7)
+		 returning created_at`,
+		a.ID, userID, string(amount.Currency), amount.Amount, reason, actor, key,
+	).Scan(&a.CreatedAt)
+	if err != nil {
+		return domain.BalanceAdjustment{}, err
+	}
+	a.Balance = money.New(bal+amount.Amount, amount.Currency)
+	if err := mc.record(ctx, tx, audit.BalanceAdjust, audit.Target("account", userID), nil, a); err != nil {
+		return domain.BalanceAdjustment{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return domain.BalanceAdjustment{}, err
+	}
+	return a, nil
+}
+
+// Payment returns the payment of orderID, shares included.
+func (s *Store) Payment(ctx context.Context, orderID uuid.UUID) (domain.Payment, error) {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return domain.Payment{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	p, err := findPayment(ctx, tx, orderID)
+	if err == sql.ErrNoRows {
+		return domain.Payment{}, ErrNoPayment
+	}
+	return p, err
+}
+
+// VoidPayment makes sure the order is never charged, so that it can be
+// cancelled by hand. A payment not made yet is recorded as FAILED with
+// ADMIN_CANCELLED, which a late payment request then finds; one under
+// review or awaiting a card is failed the same way and the holds of a split
+// payment are released. A failed payment is returned as it is and a
+// successful one is ErrPaymentPaid. userID and amount describe the order
+// for the case there is no payment yet.
+func (s *Store) VoidPayment(ctx context.Context, actor string, orderID uuid.UUID, userID string, amount domain.Money, reason string) (domain.Payment, error) {
+	if err := checkAdmin(actor, reason); err != nil {
+		return domain.Payment{}, err
+	}
+	ctx = audit.WithReason(ctx, reason)
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return domain.Payment{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	cancelled := fmt.Errorf("%w: %s", ErrAdminCancelled, reason)
+	p, err := scanPayment(orderID, tx.QueryRowContext(ctx,
+		`select `+paymentColumns+` from payments where order_id = $1 for update`, orderID,
+	))
+	var prev any
+	var mc moneyChange
+	switch {
+	case err == sql.ErrNoRows:
+		if userID == "" || !amount.IsPositive() {
+			return domain.Payment{}, errors.New("user_id and a positive amount are needed to void a payment not made yet")
+		}
+		p = declined(domain.Payment{OrderID: orderID, UserID: userID, Amount: amount}, cancelled)
+		if err := insertPayment(ctx, tx, p); err != nil {
+			return domain.Payment{}, err
+		}
+	case err != nil:
+		return domain.Payment{}, err
+	case p.Status == domain.PaySuccess:
+		return p, ErrPaymentPaid
+	case p.Status == domain.PayFailed:
+		return p, nil
+	default:
+		if p.Status == domain.PayAwaitingCard {
+			var n int
+			err := tx.QueryRowContext(ctx,
+				`select count(*) from card_charges where order_id = $1 and status in ($2, $3)`,
+				orderID, string(domain.ChargePending), string(domain.ChargeChallenge),
+			).Scan(&n)
+			if err != nil {
+				return domain.Payment{}, err
+			}
+			if n > 0 {
+				return p, ErrChargeInFlight
+			}
+		}
+		if p.Split {
+			ws, err := payerWallets(ctx, tx, p)
+			if err != nil {
+				return domain.Payment{}, err
+			}
+			if err := mc.lock(ctx, tx, ws...); err != nil {
+				return domain.Payment{}, err
+			}
+		}
+		prev = p
+		if p.Split {
+			if p.Shares, err = settleShares(ctx, tx, orderID, false); err != nil {
+				return domain.Payment{}, err
+			}
+		}
+		p = declined(p, cancelled)
+		_, err = tx.ExecContext(ctx,
+			`update payments set status = $2, failure_reason = $3, failure_message = $4 where order_id = $1`,
+			orderID, string(p.Status), p.FailureReason, p.FailureMessage,
+		)
+		if err != nil {
+			return domain.Payment{}, err
+		}
+	}
+
+	if err := InsertPaymentResultOutbox(ctx, tx, p); err != nil {
+		return domain.Payment{}, err
+	}
+	if err := mc.record(ctx, tx, audit.PaymentVoid, audit.Target("payment", orderID), prev, p); err != nil {
+		return domain.Payment{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return domain.Payment{}, err
+	}
+	return p, nil
+}
+
+// OutboxBacklog counts the outbox messages not published yet and lists the
+// latest messages, or only unpublished ones oldest first.
+func (s *Store) OutboxBacklog(ctx context.Context, pendingOnly bool, limit int) (domain.OutboxBacklog, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var b domain.OutboxBacklog
+	err := s.db.QueryRowContext(ctx,
+		`select count(*), min(created_at) from payments_outbox where published_at is null`,
+	).Scan(&b.Pending, &b.OldestPending)
+	if err != nil {
+		return domain.OutboxBacklog{}, err
+	}
+
+	query := `select id, message_id, topic, key, created_at, published_at from payments_outbox order by id desc limit $1`
+	if pendingOnly {
+		query = `select id, message_id, topic, key, created_at, published_at from payments_outbox
+		         where published_at is null order by id limit $1`
+	}
+	rows, err := s.db.QueryContext(ctx, query, limit)
+	if err != nil {
+		return domain.OutboxBacklog{}, err
+	}
+	defer rows.Close()
+
+	b.Messages = []domain.OutboxMessage{}
+	for rows.Next() {
+		var m domain.OutboxMessage
+		if err := rows.Scan(&m.ID, &m.MessageID, &m.Topic, &m.Key, &m.CreatedAt, &m.PublishedAt); err != nil {
+			return domain.OutboxBacklog{}, err
+		}
+		b.Messages = append(b.Messages, m)
+	}
+	return b, rows.Err()
+}
+
+// RepublishOutbox queues an outbox message to be published again with the
+// same message id, so consumers that have seen it skip it.
+func (s *Store) RepublishOutbox(ctx context.Context, actor string, messageID uuid.UUID, reason string) (domain.OutboxMessage, error) {
+	if err := checkAdmin(actor, reason); err != nil {
+		return domain.OutboxMessage{}, err
+	}
+	ctx = audit.WithReason(ctx, reason)
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return domain.OutboxMessage{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var m domain.OutboxMessage
+	var publishedAt sql.NullTime
+	err = tx.QueryRowContext(ctx,
+		`update payments_outbox o set published_at = null
+		 from (select id, published_at from payments_outbox where message_id = $1 for update) old
+		 where o.id = old.id
+		 returning o.id, o.message_id, o.topic, o.key, o.created_at, o.published_at, old.published_at`,
+		messageID,
+	).Scan(&m.ID, &m.MessageID, &m.Topic, &m.Key, &m.CreatedAt, &m.PublishedAt, &publishedAt)
+	if err == sql.ErrNoRows {
+		return domain.OutboxMessage{}, ErrNoOutboxMessage
+	}
+	if err != nil {
+		return domain.OutboxMessage{}, err
+	}
+	before := m
+	if publishedAt.Valid {
+		before.PublishedAt = &publishedAt.Time
+	}
+	if err := audit.Record(ctx, tx, audit.OutboxRepublish, audit.Target("outbox", messageID), before, m); err != nil {
+		return domain.OutboxMessage{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return domain.OutboxMessage{}, err
+	}
+	return m, nil
+}
+
+// InboxBacklog summarizes the payment requests received: how many in all
+// and in the last hour, the latest ones first.
+func (s *Store) InboxBacklog(ctx context.Context, limit int) (domain.InboxBacklog, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var b domain.InboxBacklog
+	err := s.db.QueryRowContext(ctx,
+		`select count(*), count(*) filter (where received_at >= now() - interval '1 hour'), max(received_at)
+		 from payments_inbox`,
+	).Scan(&b.Total, &b.LastHour, &b.LatestAt)
+	if err != nil {
+		return domain.InboxBacklog{}, err
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		`select message_id, received_at from payments_inbox order by received_at desc limit $1`, limit,
+	)
+	if err != nil {
+		return domain.InboxBacklog{}, err
+	}
+	defer rows.Close()
+
+	b.Messages = []domain.InboxMessage{}
+	for rows.Next() {
+		var m domain.InboxMessage
+		if err := rows.Scan(&m.MessageID, &m.ReceivedAt); err != nil {
+			return domain.InboxBacklog{}, err
+		}
+		b.Messages = append(b.Messages, m)
+	}
+	return b, rows.Err()
+}
@@ -0,0 +1,104 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+
+	"payments/internal/audit"
+	"payments/internal/domain"
+	"payments/internal/money"
+)
+
+type wallet struct {
+	userID   string
+	currency money.Currency
+}
+
+// walletBalance is the cash balance of a wallet as the audit log keeps it.
+type walletBalance struct {
+	UserID  string       `json:"user_id"`
+	Balance domain.Money `json:"balance"`
+}
+
+// auditState is a value of the audit log: the object changed and, for a
+// change that moves money, the cash balances of the wallets it touches.
+type auditState struct {
+	Object   any             `json:"object"`
+	Balances []walletBalance `json:"balances,omitempty"`
+}
+
+// moneyChange notes the balances of wallets before a change, to log them
+// with the balances after it. The zero value notes no wallets.
+type moneyChange struct {
+	wallets []wallet
+	before  []walletBalance
+}
+
+// lock locks ws for the rest of tx and notes their balances; a wallet that
+// does not exist yet is left out. Callers lock wallets in the order the
+// change takes them, so that no new lock order appears.
+func (m *moneyChange) lock(ctx context.Context, tx *sql.Tx, ws ...wallet) error {
+	bs, err := balancesOf(ctx, tx, ws)
+	if err != nil {
+		return err
+	}
+	m.wallets = append(m.wallets, ws...)
+	m.before = append(m.before, bs...)
+	return nil
+}
+
+// record logs the change of target from prev (nil for something new) to
+// next with the balances of the noted wallets before and after it.
+func (m moneyChange) record(ctx context.Context, tx *sql.Tx, action, target string, prev, next any) error {
+	after, err := balancesOf(ctx, tx, m.wallets)
+	if err != nil {
+		return err
+	}
+	var before any
+	if prev != nil || len(m.before) > 0 {
+		before = auditState{Object: prev, Balances: m.before}
+	}
+	return audit.Record(ctx, tx, action, target, before, auditState{Object: next, Balances: after})
+}
+
+func balancesOf(ctx context.Context, tx *sql.Tx, ws []wallet) ([]walletBalance, error) {
+	var out []walletBalance
+	for _, w := range ws {
+		var b int64
+		err := tx.QueryRowContext(ctx,
+			`select balance from accounts where user_id = $1 and currency = $2 for update`, w.userID, string(w.currency),
+		).Scan(&b)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, walletBalance{UserID: w.userID, Balance: money.New(b, w.currency)})
+	}
+	return out, nil
+}
+
+// payerWallets are the wallets p is paid from.
+func payerWallets(ctx context.Context, tx *sql.Tx, p domain.Payment) ([]wallet, error) {
+	if !p.Split {
+		return []wallet{{p.UserID, p.Amount.Currency}}, nil
+	}
+	shares := p.Shares
+	if shares == nil {
+		var err error
+		if shares, err = loadShares(ctx, tx, p.OrderID); err != nil {
+			return nil, err
+		}
+	}
+	ws := make([]wallet, len(shares))
+	for i, sh := range shares {
+		ws[i] = wallet{sh.UserID, sh.Amount.Currency}
+	}
+	return ws, nil
+}
+
+// AuditLog returns the audit log entries matching f, newest first.
+func (s *Store) AuditLog(ctx context.Context, f audit.Filter) ([]domain.AuditEntry, error) {
+	return audit.Query(ctx, s.db, f)
+}
@@ -0,0 +1,311 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"payments/internal/audit"
+	"payments/internal/domain"
+	"payments/internal/money"
+)
+
+var (
+	ErrBonusExpiry     = errors.New("expires_at should be in the future")
+	ErrBonusSource     = errors.New("source should contain 1 to 100 symbols")
+	ErrBonusKeyReused  = errors.New("idempotency key was already used with a different grant")
+	ErrBonusesDisabled = errors.New("bonuses can not be granted to a blocked account")
+)
+
+// Kinds of bonus_ledger entries.
+const (
+	bonusGrant  = "GRANT"
+	bonusSpend  = "SPEND"
+	bonusExpire = "EXPIRE"
+	bonusRefund = "REFUND"
+)
+
+const bonusColumns = `id, user_id, amount, remaining, currency, source, expires_at, created_at`
+
+// rowScanner is *sql.Row or *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanBonus(row rowScanner) (domain.BonusBucket, error) {
+	var b domain.BonusBucket
+	var amt, rem int64
+	var cur string
+	if err := row.Scan(&b.ID, &b.UserID, &amt, &rem, &cur, &b.Source, &b.ExpiresAt, &b.CreatedAt); err != nil {
+		return domain.BonusBucket{}, err
+	}
+	b.Amount = money.New(amt, money.Currency(cur))
+	b.Remaining = money.New(rem, money.Currency(cur))
+	return b, nil
+}
+
+// GrantBonus credits the wallet in amount's currency with a bonus bucket of
+// campaign source. Repeating a call with the same non-empty idempotencyKey
+// returns the first bucket.
+func (s *Store) GrantBonus(ctx context.Context, userID string, amount domain.Money, source string, expiresAt time.Time, idempotencyKey string) (domain.BonusBucket, error) {
+	if !amount.IsPositive() {
+		return domain.BonusBucket{}, errors.New("amount must be > 0")
+	}
+	if source == "" || len(source) > 100 {
+		return domain.BonusBucket{}, ErrBonusSource
+	}
+	if !expiresAt.After(time.Now()) {
+		return domain.BonusBucket{}, ErrBonusExpiry
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return domain.BonusBucket{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// Buckets change under the wallet lock, like the balance.
+	_, c, err := lockWallet(ctx, tx, userID, amount.Currency)
+	if err == sql.ErrNoRows {
+		return domain.BonusBucket{}, walletErr(ctx, tx, userID, amount.Currency)
+	}
+	if err != nil {
+		return domain.BonusBucket{}, err
+	}
+	if c.blocked {
+		return domain.BonusBucket{}, ErrBonusesDisabled
+	}
+
+	var key sql.NullString
+	if idempotencyKey != "" {
+		key = sql.NullString{String: idempotencyKey, Valid: true}
+	}
+	b, err := scanBonus(tx.QueryRowContext(ctx,
+		`insert into bonus_buckets(id, user_id, currency, amount, remaining, source, expires_at, idempotency_key)
+		 values ($1,$2,$3,$4,$4,$5,$6,$7)
+		 on conflict (user_id, idempotency_key) where idempotency_key is not null do nothing
+		 returning `+bonusColumns,
+		uuid.New(), userID, string(amount.Currency), amount.Amount, source, expiresAt, key,
+	))
+	if err == sql.ErrNoRows {
+		b, err = scanBonus(tx.QueryRowContext(ctx,
+			`select `+bonusColumns+` from bonus_buckets where user_id = $1 and idempotency_key = $2`,
+			userID, idempotencyKey,
+		))
+		if err != nil {
+			return domain.BonusBucket{}, err
+		}
+		if b.Amount != amount || b.Source != source || !b.ExpiresAt.Equal(expiresAt) {
+			return domain.BonusBucket{}, ErrBonusKeyReused
+		}
+		return b, nil
+	}
+	if err != nil {
+		return domain.BonusBucket{}, err
+	}
+	if err := insertBonusLedger(ctx, tx, b.ID, bonusGrant, amount.Amount, uuid.Nil); err != nil {
+		return domain.BonusBucket{}, err
+	}
+	if err := audit.Record(ctx, tx, audit.BonusGrant, audit.Target("account", userID), nil, b); err != nil {
+		return domain.BonusBucket{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return domain.BonusBucket{}, err
+	}
+	return b, nil
+}
+
+// Bonuses returns the unexpired, unspent buckets of all wallets of the
+// user, earliest expiry first.
+func (s *Store) Bonuses(ctx context.Context, userID string) ([]domain.BonusBucket, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`select `+bonusColumns+` from bonus_buckets
+		 where user_id = $1 and remaining > 0 and expires_at > now()
+		 order by expires_at, id`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []domain.BonusBucket{}
+	for rows.Next() {
+		b, err := scanBonus(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
+
+// bonusAvailable sums the unexpired bonus of a wallet locked by lockWallet.
+func bonusAvailable(ctx context.Context, tx *sql.Tx, userID string, currency money.Currency) (int64, error) {
+	var n int64
+	err := tx.QueryRowContext(ctx,
+		`select coalesce(sum(remaining), 0) from bonus_buckets
+		 where user_id = $1 and currency = $2 and remaining > 0 and expires_at > now()`,
+		userID, string(currency),
+	).Scan(&n)
+	return n, err
+}
+
+// spendBonus takes up to amount from the unexpired buckets of a wallet
+// locked by lockWallet, earliest expiry first, and returns how much it took.
+func spendBonus(ctx context.Context, tx *sql.Tx, orderID uuid.UUID, userID string, amount domain.Money) (int64, error) {
+	rows, err := tx.QueryContext(ctx,
+		`select id, remaining from bonus_buckets
+		 where user_id = $1 and currency = $2 and remaining > 0 and expires_at > now()
+		 order by expires_at, id
+		 for update`,
+		userID, string(amount.Currency),
+	)
+	if err != nil {
+		return 0, err
+	}
+	type take struct {
+		id uuid.UUID
+		n  int64
+	}
+	var takes []take
+	var taken int64
+	for rows.Next() && taken < amount.Amount {
+		var t take
+		if err := rows.Scan(&t.id, &t.n); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		t.n = min(t.n, amount.Amount-taken)
+		taken += t.n
+		takes = append(takes, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, t := range takes {
+		_, err := tx.ExecContext(ctx, `update bonus_buckets set remaining = remaining - $2 where id = $1`, t.id, t.n)
+		if err != nil {
+			return 0, err
+		}
+		if err := insertBonusLedger(ctx, tx, t.id, bonusSpend, -t.n, orderID); err != nil {
+			return 0, err
+		}
+	}
+	return taken, nil
+}
+
+// refundBonus gives amount back to the buckets the order was paid from,
+// latest expiry first. A bucket that expired meanwhile gets it back too and
+// the next ExpireBonuses takes it away.
+func refundBonus(ctx context.Context, tx *sql.Tx, orderID uuid.UUID, amount int64) error {
+	rows, err := tx.QueryContext(ctx,
+		`select l.bucket_id, -sum(l.amount) from bonus_ledger l join bonus_buckets b on b.id = l.bucket_id
+		 where l.order_id = $1 and l.kind in ($2, $3)
+		 group by l.bucket_id, b.expires_at
+		 having sum(l.amount) < 0
+		 order by b.expires_at desc, l.bucket_id`,
+		orderID, bonusSpend, bonusRefund,
+	)
+	if err != nil {
+		return err
+	}
+	type give struct {
+		id uuid.UUID
+		n  int64
+	}
+	var gives []give
+	left := amount
+	for rows.Next() && left > 0 {
+		var g give
+		if err := rows.Scan(&g.id, &g.n); err != nil {
+			rows.Close()
+			return err
+		}
+		g.n = min(g.n, left)
+		left -= g.n
+		gives = append(gives, g)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if left > 0 {
+		return ErrRefundExceeds
+	}
+
+	for _, g := range gives {
+		_, err := tx.ExecContext(ctx, `update bonus_buckets set remaining = remaining + $2 where id = $1`, g.id, g.n)
+		if err != nil {
+			return err
+		}
+		if err := insertBonusLedger(ctx, tx, g.id, bonusRefund, g.n, orderID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func insertBonusLedger(ctx context.Context, tx *sql.Tx, bucketID uuid.UUID, kind string, amount int64, orderID uuid.UUID) error {
+	_, err := tx.ExecContext(ctx,
+		`insert into bonus_ledger(bucket_id, kind, amount, order_id) values ($1,$2,$3,$4)`,
+		bucketID, kind, amount, uuid.NullUUID{UUID: orderID, Valid: orderID != uuid.Nil},
+	)
+	return err
+}
+
+// ExpireBonuses zeroes the buckets whose expiry passed by now and records
+// what they lost in the ledger. It returns the number of buckets expired.
+func (s *Store) ExpireBonuses(ctx context.Context, now time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx,
+		`with due as (
+		   select id, remaining from bonus_buckets
+		   where expires_at <= $1 and remaining > 0
+		   for update skip locked
+		 ), expired as (
+		   update bonus_buckets b set remaining = 0 from due where b.id = due.id
+		   returning b.id, due.remaining
+		 )
+		 insert into bonus_ledger(bucket_id, kind, amount)
+		 select id, $2, -remaining from expired`,
+		now, bonusExpire,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// RunBonusExpiry calls ExpireBonuses every interval until ctx is done.
+// Expired buckets can not be spent meanwhile; the job only settles them.
+func (s *Store) RunBonusExpiry(ctx context.Context, every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			n, err := s.ExpireBonuses(ctx, time.Now())
+			if err != nil {
+				log.Printf("bonus expiry: %v", err)
+				continue
+			}
+			if n > 0 {
+				log.Printf("bonus expiry: expired %d buckets", n)
+			}
+		}
+	}
+}
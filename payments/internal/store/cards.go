@@ -0,0 +1,452 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+
+	"payments/internal/audit"
+	"payments/internal/domain"
+	"payments/internal/money"
+	"payments/internal/provider"
+)
+
+var (
+	ErrCardDeclined     = errors.New("card declined")
+	ErrNotAwaitingCard  = errors.New("payment is not awaiting a card")
+	ErrNoCardCharge     = errors.New("no card charge")
+	ErrChargeKeyReused  = errors.New("idempotency key was already used with a different top-up")
+	ErrProviderMismatch = errors.New("webhook does not match the charge")
+)
+
+const cardChargeColumns = `id, kind, user_id, order_id, amount, currency, status, provider,
+	coalesce(provider_tx_id, ''), challenge_url, failure_code, created_at`
+
+func scanCardCharge(row *sql.Row) (domain.CardCharge, error) {
+	var c domain.CardCharge
+	var kind, status, cur string
+	var orderID uuid.NullUUID
+	var amt int64
+	err := row.Scan(&c.ID, &kind, &c.UserID, &orderID, &amt, &cur, &status, &c.Provider,
+		&c.ProviderTxID, &c.ChallengeURL, &c.FailureCode, &c.CreatedAt)
+	if err != nil {
+		return domain.CardCharge{}, err
+	}
+	c.Kind = domain.CardChargeKind(kind)
+	c.Status = domain.CardChargeStatus(status)
+	c.Amount = money.New(amt, money.Currency(cur))
+	if orderID.Valid {
+		c.OrderID = &orderID.UUID
+	}
+	return c, nil
+}
+
+// AwaitCardInTx records the payment of an order to be paid by card as
+// AWAITING_CARD; PayByCard charges it. Repeating a call for the same order
+// returns the first payment.
+func (s *Store) AwaitCardInTx(ctx context.Context, tx *sql.Tx, orderID uuid.UUID, userID string, amount domain.Money) (domain.Payment, error) {
+	if p, err := findPayment(ctx, tx, orderID); err != sql.ErrNoRows {
+		return p, err
+	}
+	p := domain.Payment{OrderID: orderID, UserID: userID, Amount: amount, Status: domain.PayAwaitingCard}
+	if err := insertPayment(ctx, tx, p); err != nil {
+		return domain.Payment{}, err
+	}
+	if err := audit.Record(ctx, tx, audit.PaymentCreate, audit.Target("payment", orderID), nil, p); err != nil {
+		return domain.Payment{}, err
+	}
+	return p, nil
+}
+
+func (s *Store) CardCharge(ctx context.Context, id uuid.UUID) (domain.CardCharge, error) {
+	c, err := scanCardCharge(s.db.QueryRowContext(ctx, `select `+cardChargeColumns+` from card_charges where id = $1`, id))
+	if err == sql.ErrNoRows {
+		return domain.CardCharge{}, ErrNoCardCharge
+	}
+	return c, err
+}
+
+// TopUpByCard charges the card and credits the wallet with amount. When the
+// card asks for a challenge the charge is returned as CHALLENGE and the
+// wallet is credited once the webhook reports it passed. A repeated call
+// with the same idempotency key returns the first charge, resuming it if
+// the provider could not be reached.
+func (s *Store) TopUpByCard(ctx context.Context, userID string, amount domain.Money, card provider.Card, idempotencyKey string) (domain.CardCharge, error) {
+	if !amount.IsPositive() {
+		return domain.CardCharge{}, errors.New("amount must be > 0")
+	}
+	var blocked bool
+	err := s.db.QueryRowContext(ctx,
+		`select blocked from accounts where user_id = $1 and currency = $2`, userID, string(amount.Currency),
+	).Scan(&blocked)
+	if err == sql.ErrNoRows {
+		return domain.CardCharge{}, walletErr(ctx, s.db, userID, amount.Currency)
+	}
+	if err != nil {
+		return domain.CardCharge{}, err
+	}
+	if blocked {
+		return domain.CardCharge{}, ErrAccountBlocked
+	}
+
+	var key sql.NullString
+	if idempotencyKey != "" {
+		key = sql.NullString{String: idempotencyKey, Valid: true}
+	}
+	c, err := s.insertCharge(ctx,
+		`insert into card_charges(id, kind, user_id, amount, currency, provider, status, idempotency_key)
+		 values ($1,$2,$3,$4,$5,$6,$7,$8)
+		 on conflict (user_id, idempotency_key) where idempotency_key is not null do nothing
+		 returning `+cardChargeColumns,
+		uuid.New(), string(domain.ChargeTopUp), userID, amount.Amount, string(amount.Currency),
+		s.cards.Name(), string(domain.ChargePending), key,
+	)
+	if err == sql.ErrNoRows {
+		c, err = scanCardCharge(s.db.QueryRowContext(ctx,
+			`select `+cardChargeColumns+` from card_charges where user_id = $1 and idempotency_key = $2`,
+			userID, idempotencyKey,
+		))
+		if err != nil {
+			return domain.CardCharge{}, err
+		}
+		if c.Kind != domain.ChargeTopUp || c.Amount != amount {
+			return domain.CardCharge{}, ErrChargeKeyReused
+		}
+	}
+	if err != nil {
+		return domain.CardCharge{}, err
+	}
+	return s.authorizeCharge(ctx, c, card)
+}
+
+// PayByCard charges the card for an order whose payment is AWAITING_CARD.
+// An approved charge settles the payment as SUCCESS and a declined one as
+// FAILED with CARD_DECLINED; either result goes to orders. An order is
+// charged once: calling again returns the same charge.
+func (s *Store) PayByCard(ctx context.Context, orderID uuid.UUID, card provider.Card) (domain.CardCharge, error) {
+	p, err := scanPayment(orderID, s.db.QueryRowContext(ctx,
+		`select `+paymentColumns+` from payments where order_id = $1`, orderID,
+	))
+	if err == sql.ErrNoRows {
+		return domain.CardCharge{}, ErrNoPayment
+	}
+	if err != nil {
+		return domain.CardCharge{}, err
+	}
+
+	c, err := scanCardCharge(s.db.QueryRowContext(ctx,
+		`select `+cardChargeColumns+` from card_charges where order_id = $1`, orderID,
+	))
+	if err == nil {
+		return s.authorizeCharge(ctx, c, card)
+	}
+	if err != sql.ErrNoRows {
+		return domain.CardCharge{}, err
+	}
+	if p.Status != domain.PayAwaitingCard {
+		return domain.CardCharge{}, fmt.Errorf("%w: it is %s", ErrNotAwaitingCard, p.Status)
+	}
+
+	// Freezes and blocks hold for cards as well as for the balance.
+	var frozen, blocked sql.NullBool
+	err = s.db.QueryRowContext(ctx,
+		`select bool_or(frozen), bool_or(blocked) from accounts where user_id = $1`, p.UserID,
+	).Scan(&frozen, &blocked)
+	if err != nil {
+		return domain.CardCharge{}, err
+	}
+	if blocked.Bool || frozen.Bool {
+		err := ErrAccountFrozen
+		if blocked.Bool {
+			err = ErrAccountBlocked
+		}
+		if derr := s.declineAwaiting(ctx, orderID, err); derr != nil {
+			return domain.CardCharge{}, derr
+		}
+		return domain.CardCharge{}, err
+	}
+
+	c, err = s.insertCharge(ctx,
+		`insert into card_charges(id, kind, user_id, order_id, amount, currency, provider, status)
+		 values ($1,$2,$3,$4,$5,$6,$7,$8)
+		 on conflict (order_id) where order_id is not null do nothing
+		 returning `+cardChargeColumns,
+		uuid.New(), string(domain.ChargePayment), p.UserID, orderID, p.Amount.Amount, string(p.Amount.Currency),
+		s.cards.Name(), string(domain.ChargePending),
+	)
+	if err == sql.ErrNoRows {
+		// A concurrent call got there first.
+		c, err = scanCardCharge(s.db.QueryRowContext(ctx,
+			`select `+cardChargeColumns+` from card_charges where order_id = $1`, orderID,
+		))
+	}
+	if err != nil {
+		return domain.CardCharge{}, err
+	}
+	return s.authorizeCharge(ctx, c, card)
+}
+
+// insertCharge inserts a new charge with insert and logs it. Like the
+// insert, it returns sql.ErrNoRows when the charge was there already.
+func (s *Store) insertCharge(ctx context.Context, insert string, args ...any) (domain.CardCharge, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return domain.CardCharge{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	c, err := scanCardCharge(tx.QueryRowContext(ctx, insert, args...))
+	if err != nil {
+		return domain.CardCharge{}, err
+	}
+	if err := audit.Record(ctx, tx, audit.CardChargeCreate, audit.Target("card_charge", c.ID), nil, c); err != nil {
+		return domain.CardCharge{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return domain.CardCharge{}, err
+	}
+	return c, nil
+}
+
+// authorizeCharge asks the provider to authorize a PENDING charge and acts
+// on the answer. Charges past PENDING are returned as they are.
+func (s *Store) authorizeCharge(ctx context.Context, c domain.CardCharge, card provider.Card) (domain.CardCharge, error) {
+	if c.Status != domain.ChargePending {
+		return c, nil
+	}
+	auth, err := s.cards.Authorize(ctx, provider.AuthorizeReq{Reference: c.ID.String(), Amount: c.Amount, Card: card})
+	if err != nil {
+		return c, err
+	}
+	switch auth.Status {
+	case provider.Authorized:
+		return s.captureCharge(ctx, c.ID, auth.ID)
+	case provider.Declined:
+		return s.failCharge(ctx, c.ID, auth.ID, auth.DeclineCode)
+	case provider.Challenged:
+		return s.challengeCharge(ctx, c, auth)
+	}
+	return c, fmt.Errorf("unknown authorization status %q", auth.Status)
+}
+
+// challengeCharge moves a PENDING charge to CHALLENGE until the webhook
+// settles it, unless the webhook has settled it already.
+func (s *Store) challengeCharge(ctx context.Context, c domain.CardCharge, auth provider.Authorization) (domain.CardCharge, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return domain.CardCharge{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	cc, err := scanCardCharge(tx.QueryRowContext(ctx,
+		`update card_charges set status = $2, provider_tx_id = $3, challenge_url = $4, updated_at = now()
+		 where id = $1 and status = $5 returning `+cardChargeColumns,
+		c.ID, string(domain.ChargeChallenge), auth.ID, auth.ChallengeURL, string(domain.ChargePending),
+	))
+	if err == sql.ErrNoRows {
+		return s.CardCharge(ctx, c.ID)
+	}
+	if err != nil {
+		return domain.CardCharge{}, err
+	}
+	if err := audit.Record(ctx, tx, audit.CardChargeUpdate, audit.Target("card_charge", c.ID), c, cc); err != nil {
+		return domain.CardCharge{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return domain.CardCharge{}, err
+	}
+	return cc, nil
+}
+
+// HandleProviderWebhook verifies a webhook call of the card gateway and
+// finishes the charge that waited for a challenge. Events for charges
+// already settled are acknowledged and ignored.
+func (s *Store) HandleProviderWebhook(ctx context.Context, header http.Header, body []byte) error {
+	ev, err := s.cards.VerifyWebhook(header, body)
+	if err != nil {
+		return err
+	}
+	id, err := uuid.Parse(ev.Reference)
+	if err != nil {
+		return ErrNoCardCharge
+	}
+	c, err := s.CardCharge(ctx, id)
+	if err != nil {
+		return err
+	}
+	if c.ProviderTxID != "" && c.ProviderTxID != ev.AuthorizationID {
+		return ErrProviderMismatch
+	}
+	switch ev.Type {
+	case provider.AuthorizationSucceeded:
+		_, err = s.captureCharge(ctx, id, ev.AuthorizationID)
+	case provider.AuthorizationFailed:
+		_, err = s.failCharge(ctx, id, ev.AuthorizationID, ev.DeclineCode)
+	}
+	return err
+}
+
+// captureCharge takes the authorized money and settles the charge: a
+// top-up credits the wallet, an order payment becomes SUCCESS and is
+// announced to orders. Capturing twice is a no-op at the provider, and the
+// charge row lock makes the settlement happen once.
+func (s *Store) captureCharge(ctx context.Context, id uuid.UUID, providerTxID string) (domain.CardCharge, error) {
+	c, err := s.CardCharge(ctx, id)
+	if err != nil {
+		return domain.CardCharge{}, err
+	}
+	if c.Status == domain.ChargeSucceeded || c.Status == domain.ChargeFailed {
+		return c, nil
+	}
+	if err := s.cards.Capture(ctx, providerTxID, c.Amount); err != nil {
+		return c, err
+	}
+
+	return s.settleCharge(ctx, id, func(tx *sql.Tx, c domain.CardCharge) (domain.CardCharge, error) {
+		c.Status = domain.ChargeSucceeded
+		c.ProviderTxID = providerTxID
+		if c.Kind == domain.ChargeTopUp {
+			_, err := tx.ExecContext(ctx,
+				`update accounts set balance = balance + $3 where user_id = $1 and currency = $2`,
+				c.UserID, string(c.Amount.Currency), c.Amount.Amount,
+			)
+			if err != nil {
+				return c, err
+			}
+			_, err = tx.ExecContext(ctx,
+				`insert into topups(user_id, amount, currency, provider, provider_tx_id) values ($1,$2,$3,$4,$5)`,
+				c.UserID, c.Amount.Amount, string(c.Amount.Currency), c.Provider, providerTxID,
+			)
+			return c, err
+		}
+		p, err := settleAwaiting(ctx, tx, *c.OrderID, func(p domain.Payment) domain.Payment {
+			p.Status = domain.PaySuccess
+			p.Provider = c.Provider
+			p.ProviderTxID = providerTxID
+			return p
+		})
+		if err == nil && p.Status != domain.PaySuccess {
+			err = fmt.Errorf("order %s was settled as %s while its card was charged", c.OrderID, p.Status)
+		}
+		return c, err
+	})
+}
+
+// failCharge records a declined charge; an order payment fails with
+// CARD_DECLINED and orders is told.
+func (s *Store) failCharge(ctx context.Context, id uuid.UUID, providerTxID, code string) (domain.CardCharge, error) {
+	return s.settleCharge(ctx, id, func(tx *sql.Tx, c domain.CardCharge) (domain.CardCharge, error) {
+		c.Status = domain.ChargeFailed
+		c.ProviderTxID = providerTxID
+		c.FailureCode = code
+		if c.Kind != domain.ChargePayment {
+			return c, nil
+		}
+		_, err := settleAwaiting(ctx, tx, *c.OrderID, func(p domain.Payment) domain.Payment {
+			p = declined(p, fmt.Errorf("%w: %s", ErrCardDeclined, code))
+			p.Provider = c.Provider
+			p.ProviderTxID = providerTxID
+			return p
+		})
+		return c, err
+	})
+}
+
+// settleCharge locks a charge and, unless it is settled already, lets
+// settle finish it and stores the outcome.
+func (s *Store) settleCharge(ctx context.Context, id uuid.UUID, settle func(*sql.Tx, domain.CardCharge) (domain.CardCharge, error)) (domain.CardCharge, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return domain.CardCharge{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	c, err := scanCardCharge(tx.QueryRowContext(ctx,
+		`select `+cardChargeColumns+` from card_charges where id = $1 for update`, id,
+	))
+	if err != nil {
+		return domain.CardCharge{}, err
+	}
+	if c.Status == domain.ChargeSucceeded || c.Status == domain.ChargeFailed {
+		return c, nil
+	}
+	var mc moneyChange
+	if c.Kind == domain.ChargeTopUp {
+		if err := mc.lock(ctx, tx, wallet{c.UserID, c.Amount.Currency}); err != nil {
+			return domain.CardCharge{}, err
+		}
+	}
+	prev := c
+	c, err = settle(tx, c)
+	if err != nil {
+		return domain.CardCharge{}, err
+	}
+	_, err = tx.ExecContext(ctx,
+		`update card_charges set status = $2, provider_tx_id = $3, failure_code = $4, updated_at = now() where id = $1`,
+		id, string(c.Status), c.ProviderTxID, c.FailureCode,
+	)
+	if err != nil {
+		return domain.CardCharge{}, err
+	}
+	if err := mc.record(ctx, tx, audit.CardChargeUpdate, audit.Target("card_charge", id), prev, c); err != nil {
+		return domain.CardCharge{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return domain.CardCharge{}, err
+	}
+	return c, nil
+}
+
+// settleAwaiting moves an AWAITING_CARD payment to what settle makes of it
+// and announces the result. A payment settled already is returned as is.
+// The payment is dated by the settlement, when the money moved.
+func settleAwaiting(ctx context.Context, tx *sql.Tx, orderID uuid.UUID, settle func(domain.Payment) domain.Payment) (domain.Payment, error) {
+	p, err := scanPayment(orderID, tx.QueryRowContext(ctx,
+		`select `+paymentColumns+` from payments where order_id = $1 for update`, orderID,
+	))
+	if err != nil {
+		return domain.Payment{}, err
+	}
+	if p.Status != domain.PayAwaitingCard {
+		return p, nil
+	}
+	prev := p
+	p = settle(p)
+	_, err = tx.ExecContext(ctx,
+		`update payments set status = $2, failure_reason = $3, failure_message = $4, provider = $5,
+		        provider_tx_id = nullif($6, ''), created_at = now()
+		 where order_id = $1`,
+		orderID, string(p.Status), p.FailureReason, p.FailureMessage, p.Provider, p.ProviderTxID,
+	)
+	if err != nil {
+		return domain.Payment{}, err
+	}
+	if err := InsertPaymentResultOutbox(ctx, tx, p); err != nil {
+		return domain.Payment{}, err
+	}
+	return p, audit.Record(ctx, tx, audit.PaymentSettle, audit.Target("payment", orderID), prev, p)
+}
+
+// declineAwaiting fails an AWAITING_CARD payment for err before any card
+// was charged.
+func (s *Store) declineAwaiting(ctx context.Context, orderID uuid.UUID, err error) error {
+	tx, txErr := s.db.BeginTx(ctx, nil)
+	if txErr != nil {
+		return txErr
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, txErr = settleAwaiting(ctx, tx, orderID, func(p domain.Payment) domain.Payment { return declined(p, err) })
+	if txErr != nil {
+		return txErr
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,281 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"payments/internal/audit"
+	"payments/internal/domain"
+	"payments/internal/money"
+)
+
+var (
+	ErrLimitExceeded  = errors.New("spending limit exceeded")
+	ErrAccountFrozen  = errors.New("account is frozen")
+	ErrAccountBlocked = errors.New("account is blocked")
+	ErrInvalidLimit   = errors.New("limits should be greater than 0")
+)
+
+// Limits are the spending limits of one wallet in its minor units; zero
+// means no limit. Periods are calendar ones in UTC, weeks start on Monday.
+type Limits struct {
+	Daily      int64 `json:"daily"`
+	Weekly     int64 `json:"weekly"`
+	Monthly    int64 `json:"monthly"`
+	MaxPayment int64 `json:"max_payment"`
+}
+
+// walletLimits are the limits of a wallet as the audit log keeps them.
+type walletLimits struct {
+	Currency money.Currency `json:"currency"`
+	Limits
+}
+
+// accountFlags are the freeze and block of a user's wallets as the audit
+// log keeps them.
+type accountFlags struct {
+	Frozen        bool   `json:"frozen"`
+	Blocked       bool   `json:"blocked"`
+	BlockedReason string `json:"blocked_reason,omitempty"`
+}
+
+// controls is what a payment is checked against, read with the wallet row.
+type controls struct {
+	Limits
+	frozen  bool
+	blocked bool
+}
+
+// lockWallet locks the wallet row for the rest of tx and returns its
+// balance and controls; sql.ErrNoRows if there is no such wallet.
+func lockWallet(ctx context.Context, tx *sql.Tx, userID string, currency money.Currency) (int64, controls, error) {
+	var bal int64
+	var c controls
+	err := tx.QueryRowContext(ctx,
+		`select balance, daily_limit, weekly_limit, monthly_limit, max_payment, frozen, blocked
+		 from accounts where user_id = $1 and currency = $2 for update`,
+		userID, string(currency),
+	).Scan(&bal, &c.Daily, &c.Weekly, &c.Monthly, &c.MaxPayment, &c.frozen, &c.blocked)
+	return bal, c, err
+}
+
+type periodStarts struct {
+	day, week, month time.Time
+}
+
+func periodsAt(now time.Time) periodStarts {
+	now = now.UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	return periodStarts{
+		day:   day,
+		week:  day.AddDate(0, 0, -(int(day.Weekday())+6)%7),
+		month: time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+// spent sums the successful payments of the wallet in the current day,
+// week and month, the user's shares of split payments included.
+func spent(ctx context.Context, q queryer, userID string, currency money.Currency, p periodStarts) (day, week, month int64, err error) {
+	since := p.week
+	if p.month.Before(since) {
+		since = p.month
+	}
+	err = q.QueryRowContext(ctx,
+		`select coalesce(sum(amount) filter (where created_at >= $3), 0),
+		        coalesce(sum(amount) filter (where created_at >= $4), 0),
+		        coalesce(sum(amount) filter (where created_at >= $5), 0)
+		 from (select amount, created_at from payments
+		        where user_id = $1 and currency = $2 and status = 'SUCCESS' and not split and created_at >= $6
+		       union all
+		       select amount, created_at from payment_shares
+		        where user_id = $1 and currency = $2 and status in ('HELD', 'CAPTURED') and created_at >= $6) paid`,
+		userID, string(currency), p.day, p.week, p.month, since,
+	).Scan(&day, &week, &month)
+	return day, week, month, err
+}
+
+// checkControls tells whether amount may be paid from the wallet. The
+// wallet row must be locked by tx (lockWallet) so that two payments cannot
+// both fit under a limit that only one of them does.
+func checkControls(ctx context.Context, tx *sql.Tx, userID string, amount domain.Money, c controls) error {
+	if c.blocked {
+		return ErrAccountBlocked
+	}
+	if c.frozen {
+		return ErrAccountFrozen
+	}
+	if c.MaxPayment > 0 && amount.Amount > c.MaxPayment {
+		return fmt.Errorf("%w: a single payment is limited to %s", ErrLimitExceeded, money.New(c.MaxPayment, amount.Currency))
+	}
+	if c.Daily == 0 && c.Weekly == 0 && c.Monthly == 0 {
+		return nil
+	}
+
+	day, week, month, err := spent(ctx, tx, userID, amount.Currency, periodsAt(time.Now()))
+	if err != nil {
+		return err
+	}
+	for _, l := range []struct {
+		name         string
+		limit, spent int64
+	}{
+		{"daily", c.Daily, day},
+		{"weekly", c.Weekly, week},
+		{"monthly", c.Monthly, month},
+	} {
+		if l.limit > 0 && l.spent+amount.Amount > l.limit {
+			return fmt.Errorf("%w: %s limit is %s, %s already spent",
+				ErrLimitExceeded, l.name, money.New(l.limit, amount.Currency), money.New(l.spent, amount.Currency))
+		}
+	}
+	return nil
+}
+
+// SetLimits replaces the spending limits of a wallet.
+func (s *Store) SetLimits(ctx context.Context, userID string, currency money.Currency, l Limits) (domain.AccountControls, error) {
+	if l.Daily < 0 || l.Weekly < 0 || l.Monthly < 0 || l.MaxPayment < 0 {
+		return domain.AccountControls{}, ErrInvalidLimit
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return domain.AccountControls{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, c, err := lockWallet(ctx, tx, userID, currency)
+	if err == sql.ErrNoRows {
+		return domain.AccountControls{}, walletErr(ctx, tx, userID, currency)
+	}
+	if err != nil {
+		return domain.AccountControls{}, err
+	}
+	_, err = tx.ExecContext(ctx,
+		`update accounts set daily_limit = $3, weekly_limit = $4, monthly_limit = $5, max_payment = $6
+		 where user_id = $1 and currency = $2`,
+		userID, string(currency), l.Daily, l.Weekly, l.Monthly, l.MaxPayment,
+	)
+	if err != nil {
+		return domain.AccountControls{}, err
+	}
+	err = audit.Record(ctx, tx, audit.AccountLimits, audit.Target("account", userID),
+		walletLimits{currency, c.Limits}, walletLimits{currency, l})
+	if err != nil {
+		return domain.AccountControls{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return domain.AccountControls{}, err
+	}
+	return s.Controls(ctx, userID, currency)
+}
+
+// SetFrozen freezes or unfreezes every wallet of the user. A frozen
+// account takes money in but pays and exchanges nothing.
+func (s *Store) SetFrozen(ctx context.Context, userID string, frozen bool) error {
+	action := audit.AccountUnfreeze
+	if frozen {
+		action = audit.AccountFreeze
+	}
+	return s.setFlags(ctx, action, userID, `frozen = $2`, frozen)
+}
+
+// SetBlocked blocks or unblocks every wallet of the user. A blocked account
+// can neither pay, exchange nor be topped up; refunds still reach it.
+func (s *Store) SetBlocked(ctx context.Context, userID string, blocked bool, reason string) error {
+	if len(reason) > 200 {
+		return ErrReasonLimit
+	}
+	action := audit.AccountBlock
+	if !blocked {
+		reason = ""
+		action = audit.AccountUnblock
+	}
+	return s.setFlags(ctx, action, userID, `blocked = $2, blocked_reason = $3`, blocked, reason)
+}
+
+func (s *Store) setFlags(ctx context.Context, action, userID, set string, args ...any) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.ExecContext(ctx, `select 1 from accounts where user_id = $1 order by currency for update`, userID)
+	if err != nil {
+		return err
+	}
+	if ra, _ := res.RowsAffected(); ra == 0 {
+		return ErrNoAccount
+	}
+	before, err := flagsOf(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `update accounts set `+set+` where user_id = $1`, append([]any{userID}, args...)...); err != nil {
+		return err
+	}
+	after, err := flagsOf(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+	if err := audit.Record(ctx, tx, action, audit.Target("account", userID), before, after); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func flagsOf(ctx context.Context, tx *sql.Tx, userID string) (accountFlags, error) {
+	var f accountFlags
+	err := tx.QueryRowContext(ctx,
+		`select bool_or(frozen), bool_or(blocked), coalesce(max(blocked_reason), '') from accounts where user_id = $1`,
+		userID,
+	).Scan(&f.Frozen, &f.Blocked, &f.BlockedReason)
+	return f, err
+}
+
+// Controls returns the limits and flags of a wallet with what was spent
+// against each limit so far.
+func (s *Store) Controls(ctx context.Context, userID string, currency money.Currency) (domain.AccountControls, error) {
+	var c controls
+	var reason string
+	err := s.db.QueryRowContext(ctx,
+		`select daily_limit, weekly_limit, monthly_limit, max_payment, frozen, blocked, blocked_reason
+		 from accounts where user_id = $1 and currency = $2`,
+		userID, string(currency),
+	).Scan(&c.Daily, &c.Weekly, &c.Monthly, &c.MaxPayment, &c.frozen, &c.blocked, &reason)
+	if err == sql.ErrNoRows {
+		return domain.AccountControls{}, walletErr(ctx, s.db, userID, currency)
+	}
+	if err != nil {
+		return domain.AccountControls{}, err
+	}
+	day, week, month, err := spent(ctx, s.db, userID, currency, periodsAt(time.Now()))
+	if err != nil {
+		return domain.AccountControls{}, err
+	}
+
+	limit := func(v int64) *domain.Money {
+		if v == 0 {
+			return nil
+		}
+		m := money.New(v, currency)
+		return &m
+	}
+	return domain.AccountControls{
+		UserID:         userID,
+		Currency:       currency,
+		DailyLimit:     limit(c.Daily),
+		WeeklyLimit:    limit(c.Weekly),
+		MonthlyLimit:   limit(c.Monthly),
+		MaxPayment:     limit(c.MaxPayment),
+		Frozen:         c.frozen,
+		Blocked:        c.blocked,
+		BlockedReason:  reason,
+		SpentToday:     money.New(day, currency),
+		SpentThisWeek:  money.New(week, currency),
+		SpentThisMonth: money.New(month, currency),
+	}, nil
+}
@@ -0,0 +1,133 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+
+	"payments/internal/audit"
+	"payments/internal/domain"
+	"payments/internal/money"
+)
+
+var (
+	ErrSameCurrency      = errors.New("from and to currencies should differ")
+	ErrExchangeKeyReused = errors.New("idempotency key was already used with a different exchange")
+)
+
+// Exchange debits from and credits to between two wallets of userID. The
+// caller converts the amounts; rate is recorded as given. The target wallet
+// is opened if the user does not have one yet.
+func (s *Store) Exchange(ctx context.Context, userID string, from, to domain.Money, rate, idempotencyKey string) (domain.Exchange, error) {
+	if !from.IsPositive() || !to.IsPositive() {
+		return domain.Exchange{}, errors.New("amount must be > 0")
+	}
+	if from.Currency == to.Currency {
+		return domain.Exchange{}, ErrSameCurrency
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return domain.Exchange{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// The source wallet row lock also serializes replays of one key.
+	bal, c, err := lockWallet(ctx, tx, userID, from.Currency)
+	if err == sql.ErrNoRows {
+		return domain.Exchange{}, walletErr(ctx, tx, userID, from.Currency)
+	}
+	if err != nil {
+		return domain.Exchange{}, err
+	}
+
+	if idempotencyKey != "" {
+		ex, err := replayExchange(ctx, tx, userID, idempotencyKey)
+		if err == nil {
+			if ex.From != from || ex.To.Currency != to.Currency {
+				return domain.Exchange{}, ErrExchangeKeyReused
+			}
+			return ex, nil
+		}
+		if err != sql.ErrNoRows {
+			return domain.Exchange{}, err
+		}
+	}
+
+	if c.blocked {
+		return domain.Exchange{}, ErrAccountBlocked
+	}
+	if c.frozen {
+		return domain.Exchange{}, ErrAccountFrozen
+	}
+	if bal < from.Amount {
+		return domain.Exchange{}, ErrNotEnoughMoney
+	}
+
+	var mc moneyChange
+	if err := mc.lock(ctx, tx, wallet{userID, from.Currency}, wallet{userID, to.Currency}); err != nil {
+		return domain.Exchange{}, err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`update accounts set balance = balance - $3 where user_id = $1 and currency = $2`,
+		userID, string(from.Currency), from.Amount,
+	)
+	if err != nil {
+		return domain.Exchange{}, err
+	}
+	_, err = tx.ExecContext(ctx,
+		`insert into accounts(user_id, currency, balance) values ($1, $2, $3)
+		 on conflict (user_id, currency) do update set balance = accounts.balance + excluded.balance`,
+		userID, string(to.Currency), to.Amount,
+	)
+	if err != nil {
+		return domain.Exchange{}, err
+	}
+
+	var key sql.NullString
+	if idempotencyKey != "" {
+		key = sql.NullString{String: idempotencyKey, Valid: true}
+	}
+	ex := domain.Exchange{ID: uuid.New(), UserID: userID, From: from, To: to, Rate: rate}
+	err = tx.QueryRowContext(ctx,
+		`insert into exchanges(id, user_id, from_amount, from_currency, to_amount, to_currency, rate, idempotency_key)
+		 values ($1,$2,$3,$4,$5,$6,$7,$8)
+		 returning created_at`,
+		ex.ID, userID, from.Amount, string(from.Currency), to.Amount, string(to.Currency), rate, key,
+	).Scan(&ex.CreatedAt)
+	if err != nil {
+		return domain.Exchange{}, err
+	}
+	if err := mc.record(ctx, tx, audit.CurrencyExchange, audit.Target("account", userID), nil, ex); err != nil {
+		return domain.Exchange{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return domain.Exchange{}, err
+	}
+	return ex, nil
+}
+
+func replayExchange(ctx context.Context, tx *sql.Tx, userID, key string) (domain.Exchange, error) {
+	ex := domain.Exchange{UserID: userID}
+	var fromAmt, toAmt int64
+	var fromCur, toCur string
+	err := tx.QueryRowContext(ctx,
+		`select id, from_amount, from_currency, to_amount, to_currency, rate::text, created_at from exchanges
+		 where user_id = $1 and idempotency_key = $2`,
+		userID, key,
+	).Scan(&ex.ID, &fromAmt, &fromCur, &toAmt, &toCur, &ex.Rate, &ex.CreatedAt)
+	if err != nil {
+		return domain.Exchange{}, err
+	}
+	ex.From = money.New(fromAmt, money.Currency(fromCur))
+	ex.To = money.New(toAmt, money.Currency(toCur))
+	return ex, nil
+}
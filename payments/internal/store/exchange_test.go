@@ -0,0 +1,19 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"payments/internal/money"
+)
+
+// TestExchangeSameCurrency needs no database: the currencies are checked
+// before a transaction is opened.
+func TestExchangeSameCurrency(t *testing.T) {
+	s := &Store{}
+	_, err := s.Exchange(context.Background(), "u1", money.New(10000, "RUB"), money.New(10000, "RUB"), "1", "")
+	if !errors.Is(err, ErrSameCurrency) {
+		t.Fatalf("Exchange RUB to RUB = %v, want ErrSameCurrency", err)
+	}
+}
@@ -0,0 +1,498 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"payments/internal/audit"
+	"payments/internal/domain"
+	"payments/internal/money"
+)
+
+var (
+	ErrNoPayment       = errors.New("no payment for order")
+	ErrNotRefundable   = errors.New("payment was not successful, nothing to refund")
+	ErrRefundExceeds   = errors.New("refund exceeds the captured amount")
+	ErrReasonLimit     = errors.New("reason should contain maximum of 200 symbols")
+	ErrRefundKeyReused = errors.New("idempotency key was already used with a different refund")
+	ErrRefundInFlight  = errors.New("a refund of the order to the card is in progress; repeat that call to finish it")
+	ErrNoPendingRefund = errors.New("no refund of the order is pending at the card gateway")
+	ErrRefundCancelled = errors.New("refund was cancelled by support")
+)
+
+// Statuses of a refund. Only a refund to a card is ever PENDING, and only a
+// PENDING one can become FAILED (see CancelRefund).
+const (
+	refundPending   = "PENDING"
+	refundSucceeded = "SUCCEEDED"
+	refundFailed    = "FAILED"
+)
+
+const RefundedTopic = "payments.refunded"
+
+type PaymentRefunded struct {
+	MessageID     uuid.UUID `json:"message_id"`
+	RefundID      uuid.UUID `json:"refund_id"`
+	OrderID       uuid.UUID `json:"order_id"`
+	UserID        string    `json:"user_id"`
+	Amount        int64     `json:"amount"`
+	RefundedTotal int64     `json:"refunded_total"`
+	Captured      int64     `json:"captured"`
+	Currency      string    `json:"currency"`
+	Reason        string    `json:"reason"`
+}
+
+// refundState is what the audit log keeps of the refunds of a payment.
+type refundState struct {
+	Refunded domain.Money   `json:"refunded"`
+	Refund   *domain.Refund `json:"refund,omitempty"`
+}
+
+type RefundResult struct {
+	Refund        domain.Refund
+	Captured      domain.Money
+	RefundedTotal domain.Money
+}
+
+// Refund gives back amount of a successful payment to the payer's balance,
+// or to the card through the provider when the payment was made by card,
+// on behalf of the back-office operator actor.
+// A split payment is refunded to its payers in proportion to their shares.
+// Of a payment partly made from bonus buckets the cash part is refunded
+// first and the rest goes back to the buckets.
+// A zero amount refunds the whole remainder. Several partial refunds are
+// allowed as long as their sum stays within the captured amount. The refund
+// is always in the payment's currency; a non-empty amount.Currency must match.
+//
+// A refund to a card is stored PENDING first, so that it counts against the
+// captured amount, then paid by the provider outside any transaction and
+// settled after. The provider's reference is the order and the refund's
+// number, so repeating the call resumes a refund the provider could not be
+// reached for without paying the card twice. Until it is settled, other
+// refunds of the order fail with ErrRefundInFlight, and a refund the
+// provider will not pay is given up with CancelRefund.
+func (s *Store) Refund(ctx context.Context, actor string, orderID uuid.UUID, amount domain.Money, reason, idempotencyKey string) (RefundResult, error) {
+	if actor == "" {
+		return RefundResult{}, ErrNoActor
+	}
+	ctx = audit.WithReason(ctx, reason)
+
+	if amount.Amount < 0 {
+		return RefundResult{}, errors.New("amount must be >= 0")
+	}
+	if len(reason) > 200 {
+		return RefundResult{}, ErrReasonLimit
+	}
+
+	res, card, err := s.startRefund(ctx, orderID, amount, reason, idempotencyKey)
+	if err != nil || card == nil {
+		return res, err
+	}
+	refundTxID, err := s.cards.Refund(ctx, card.authorizationID, res.Refund.Amount, refundReference(orderID, card.seq))
+	if err != nil {
+		return RefundResult{}, fmt.Errorf("refund to card: %w", err)
+	}
+	return s.settleCardRefund(ctx, orderID, res.Refund.ID, refundTxID)
+}
+
+// refundable is the payment row a refund is checked against.
+type refundable struct {
+	userID       string
+	currency     money.Currency
+	captured     int64
+	bonusPaid    int64
+	providerTxID string
+	split        bool
+}
+
+// lockRefundable locks the payment of orderID, which serializes the
+// refunds of one order.
+func lockRefundable(ctx context.Context, tx *sql.Tx, orderID uuid.UUID) (refundable, error) {
+	var p refundable
+	var cur, status string
+	err := tx.QueryRowContext(ctx,
+		`select user_id, amount, currency, status, coalesce(provider_tx_id, ''), bonus_amount, split from payments
+		 where order_id = $1 for update`, orderID,
+	).Scan(&p.userID, &p.captured, &cur, &status, &p.providerTxID, &p.bonusPaid, &p.split)
+	if err == sql.ErrNoRows {
+		return refundable{}, ErrNoPayment
+	}
+	if err != nil {
+		return refundable{}, err
+	}
+	if domain.PaymentStatus(status) != domain.PaySuccess {
+		return refundable{}, ErrNotRefundable
+	}
+	p.currency = money.Currency(cur)
+	return p, nil
+}
+
+// cardRefund is a PENDING refund still to be paid by the provider.
+type cardRefund struct {
+	authorizationID string
+	seq             int
+}
+
+// refundReference is the provider's idempotency key of the seq-th refund
+// of an order.
+func refundReference(orderID uuid.UUID, seq int) string {
+	return fmt.Sprintf("%s:refund:%d", orderID, seq)
+}
+
+// startRefund checks a refund against the payment and makes it: wholly
+// for a refund to the balance, and as a PENDING row returned with a
+// cardRefund for one to a card. A PENDING refund the call repeats is
+// returned for the provider again.
+func (s *Store) startRefund(ctx context.Context, orderID uuid.UUID, amount domain.Money, reason, idempotencyKey string) (RefundResult, *cardRefund, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return RefundResult{}, nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	p, err := lockRefundable(ctx, tx, orderID)
+	if err != nil {
+		return RefundResult{}, nil, err
+	}
+	currency := p.currency
+	if amount.Currency != "" && amount.Currency != currency {
+		return RefundResult{}, nil, fmt.Errorf("%w: payment is in %s", money.ErrCurrencyMismatch, currency)
+	}
+	amount.Currency = currency
+
+	if idempotencyKey != "" {
+		r, pending, err := replayRefund(ctx, tx, orderID, idempotencyKey, amount)
+		if err == nil {
+			r.Captured = money.New(p.captured, currency)
+			if pending != nil {
+				pending.authorizationID = p.providerTxID
+			}
+			return r, pending, nil
+		}
+		if err != sql.ErrNoRows {
+			return RefundResult{}, nil, err
+		}
+	}
+
+	// A keyless call resumes a keyless PENDING refund of the same amount,
+	// as it cannot be told from a retry of the call that made it.
+	var pendingID uuid.UUID
+	var pendingAmount int64
+	var pendingKey string
+	err = tx.QueryRowContext(ctx,
+		`select id, amount, coalesce(idempotency_key, '') from refunds where order_id = $1 and status = $2`, orderID, refundPending,
+	).Scan(&pendingID, &pendingAmount, &pendingKey)
+	if err == nil {
+		if idempotencyKey != "" || pendingKey != "" || amount.Amount != 0 && amount.Amount != pendingAmount {
+			return RefundResult{}, nil, ErrRefundInFlight
+		}
+		r, pending, err := loadRefund(ctx, tx, currency, `id = $1`, pendingID)
+		if err != nil {
+			return RefundResult{}, nil, err
+		}
+		pending.authorizationID = p.providerTxID
+		return RefundResult{Refund: r, Captured: money.New(p.captured, currency)}, pending, nil
+	}
+	if err != sql.ErrNoRows {
+		return RefundResult{}, nil, err
+	}
+
+	// A FAILED refund gave nothing back but keeps its number, which the
+	// provider may have seen.
+	var refunded, bonusRefunded int64
+	var seq int
+	err = tx.QueryRowContext(ctx,
+		`select coalesce(sum(amount) filter (where status <> $2), 0), coalesce(sum(bonus_amount) filter (where status <> $2), 0),
+		        coalesce(max(seq), 0) + 1
+		 from refunds where order_id = $1`, orderID, refundFailed,
+	).Scan(&refunded, &bonusRefunded, &seq)
+	if err != nil {
+		return RefundResult{}, nil, err
+	}
+	remaining := p.captured - refunded
+	if amount.Amount == 0 {
+		amount.Amount = remaining
+	}
+	if amount.Amount == 0 || amount.Amount > remaining {
+		return RefundResult{}, nil, ErrRefundExceeds
+	}
+
+	cash := min(amount.Amount, (p.captured-p.bonusPaid)-(refunded-bonusRefunded))
+	bonus := amount.Amount - cash
+
+	var mc moneyChange
+	if p.providerTxID == "" {
+		ws, err := payerWallets(ctx, tx, domain.Payment{OrderID: orderID, UserID: p.userID, Amount: amount, Split: p.split})
+		if err != nil {
+			return RefundResult{}, nil, err
+		}
+		if err := mc.lock(ctx, tx, ws...); err != nil {
+			return RefundResult{}, nil, err
+		}
+	}
+
+	var key sql.NullString
+	if idempotencyKey != "" {
+		key = sql.NullString{String: idempotencyKey, Valid: true}
+	}
+	status := refundSucceeded
+	if p.providerTxID != "" {
+		status = refundPending
+	}
+	r := domain.Refund{
+		ID:      uuid.New(),
+		OrderID: orderID,
+		UserID:  p.userID,
+		Amount:  amount,
+		Status:  status,
+		Reason:  reason,
+	}
+	if bonus > 0 {
+		b := money.New(bonus, currency)
+		r.Bonus = &b
+	}
+	err = tx.QueryRowContext(ctx,
+		`insert into refunds(id, order_id, seq, status, user_id, amount, reason, idempotency_key, bonus_amount)
+		 values ($1,$2,$3,$4,$5,$6,$7,$8,$9)
+		 returning created_at`,
+		r.ID, r.OrderID, seq, status, r.UserID, r.Amount.Amount, r.Reason, key, bonus,
+	).Scan(&r.CreatedAt)
+	if err != nil {
+		return RefundResult{}, nil, err
+	}
+
+	if p.providerTxID != "" {
+		if err := tx.Commit(); err != nil {
+			return RefundResult{}, nil, err
+		}
+		return RefundResult{Refund: r, Captured: money.New(p.captured, currency)}, &cardRefund{p.providerTxID, seq}, nil
+	}
+	if p.split {
+		if err := refundShares(ctx, tx, r.ID, orderID, amount); err != nil {
+			return RefundResult{}, nil, err
+		}
+	} else if cash > 0 {
+		res, err := tx.ExecContext(ctx,
+			`update accounts set balance = balance + $3 where user_id = $1 and currency = $2`,
+			p.userID, string(currency), cash,
+		)
+		if err != nil {
+			return RefundResult{}, nil, err
+		}
+		if ra, _ := res.RowsAffected(); ra == 0 {
+			return RefundResult{}, nil, walletErr(ctx, tx, p.userID, currency)
+		}
+	}
+	res, err := finishRefund(ctx, tx, mc, p, r, refunded)
+	if err != nil {
+		return RefundResult{}, nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return RefundResult{}, nil, err
+	}
+	return res, nil, nil
+}
+
+// settleCardRefund marks a PENDING refund paid by the provider as
+// refundTxID and finishes it. A refund settled meanwhile by a concurrent
+// call is returned as it is; one cancelled meanwhile fails with
+// ErrRefundCancelled naming what the provider paid.
+func (s *Store) settleCardRefund(ctx context.Context, orderID, refundID uuid.UUID, refundTxID string) (RefundResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return RefundResult{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	p, err := lockRefundable(ctx, tx, orderID)
+	if err != nil {
+		return RefundResult{}, err
+	}
+	r, pending, err := loadRefund(ctx, tx, p.currency, `id = $1`, refundID)
+	if err != nil {
+		return RefundResult{}, err
+	}
+	if r.Status == refundFailed {
+		return RefundResult{}, fmt.Errorf("%w, but the card gateway paid it as %s", ErrRefundCancelled, refundTxID)
+	}
+	var refunded int64
+	err = tx.QueryRowContext(ctx,
+		`select coalesce(sum(amount), 0) from refunds where order_id = $1 and status = $2`, orderID, refundSucceeded,
+	).Scan(&refunded)
+	if err != nil {
+		return RefundResult{}, err
+	}
+	if pending == nil {
+		return RefundResult{Refund: r, Captured: money.New(p.captured, p.currency), RefundedTotal: money.New(refunded, p.currency)}, nil
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`update refunds set status = $2, provider_tx_id = $3 where id = $1`, refundID, refundSucceeded, refundTxID,
+	)
+	if err != nil {
+		return RefundResult{}, err
+	}
+	r.Status = refundSucceeded
+	res, err := finishRefund(ctx, tx, moneyChange{}, p, r, refunded)
+	if err != nil {
+		return RefundResult{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return RefundResult{}, err
+	}
+	return res, nil
+}
+
+// finishRefund returns the bonus part of r to the buckets, announces r to
+// orders and logs it, refunded being what the payment had refunded before.
+func finishRefund(ctx context.Context, tx *sql.Tx, mc moneyChange, p refundable, r domain.Refund, refunded int64) (RefundResult, error) {
+	if r.Bonus != nil {
+		if err := refundBonus(ctx, tx, r.OrderID, r.Bonus.Amount); err != nil {
+			return RefundResult{}, err
+		}
+	}
+
+	total := refunded + r.Amount.Amount
+	ev := PaymentRefunded{
+		MessageID:     uuid.New(),
+		RefundID:      r.ID,
+		OrderID:       r.OrderID,
+		UserID:        r.UserID,
+		Amount:        r.Amount.Amount,
+		RefundedTotal: total,
+		Captured:      p.captured,
+		Currency:      string(p.currency),
+		Reason:        r.Reason,
+	}
+	payload, _ := json.Marshal(ev)
+	_, err := tx.ExecContext(ctx,
+		`insert into payments_outbox(message_id, topic, key, payload) values ($1,$2,$3,$4)`,
+		ev.MessageID, RefundedTopic, r.OrderID.String(), payload,
+	)
+	if err != nil {
+		return RefundResult{}, err
+	}
+	err = mc.record(ctx, tx, audit.PaymentRefund, audit.Target("payment", r.OrderID),
+		refundState{Refunded: money.New(refunded, p.currency)},
+		refundState{Refunded: money.New(total, p.currency), Refund: &r},
+	)
+	if err != nil {
+		return RefundResult{}, err
+	}
+	return RefundResult{Refund: r, Captured: money.New(p.captured, p.currency), RefundedTotal: money.New(total, p.currency)}, nil
+}
+
+// loadRefund locks and reads the refund matching where and, when it is
+// PENDING, its cardRefund without the authorization.
+func loadRefund(ctx context.Context, tx *sql.Tx, currency money.Currency, where string, args ...any) (domain.Refund, *cardRefund, error) {
+	var r domain.Refund
+	var amt, bonus int64
+	var seq int
+	err := tx.QueryRowContext(ctx,
+		`select id, order_id, user_id, amount, reason, bonus_amount, created_at, seq, status from refunds
+		 where `+where+` for update`, args...,
+	).Scan(&r.ID, &r.OrderID, &r.UserID, &amt, &r.Reason, &bonus, &r.CreatedAt, &seq, &r.Status)
+	if err != nil {
+		return domain.Refund{}, nil, err
+	}
+	r.Amount = money.New(amt, currency)
+	if bonus > 0 {
+		b := money.New(bonus, currency)
+		r.Bonus = &b
+	}
+	if r.Status == refundPending {
+		return r, &cardRefund{seq: seq}, nil
+	}
+	return r, nil, nil
+}
+
+// replayRefund returns the refund an earlier call made with the same key,
+// with its cardRefund if it is still PENDING, or sql.ErrNoRows if there is
+// none.
+func replayRefund(ctx context.Context, tx *sql.Tx, orderID uuid.UUID, key string, amount domain.Money) (RefundResult, *cardRefund, error) {
+	r, pending, err := loadRefund(ctx, tx, amount.Currency, `order_id = $1 and idempotency_key = $2`, orderID, key)
+	if err != nil {
+		return RefundResult{}, nil, err
+	}
+	if amount.Amount != 0 && amount != r.Amount {
+		return RefundResult{}, nil, ErrRefundKeyReused
+	}
+
+	var total int64
+	err = tx.QueryRowContext(ctx,
+		`select coalesce(sum(amount), 0) from refunds where order_id = $1 and status = $2`, orderID, refundSucceeded,
+	).Scan(&total)
+	if err != nil {
+		return RefundResult{}, nil, err
+	}
+	return RefundResult{Refund: r, RefundedTotal: money.New(total, amount.Currency)}, pending, nil
+}
+
+// CancelRefund gives up the PENDING refund of orderID to the card on behalf
+// of actor, for one the provider declined or never got: it becomes FAILED,
+// stops counting against the captured amount and the order can be refunded
+// again. The provider is not asked, so the operator makes sure it has not
+// paid the refund first; otherwise the card gets the money twice.
+func (s *Store) CancelRefund(ctx context.Context, actor string, orderID uuid.UUID, reason string) (domain.Refund, error) {
+	if err := checkAdmin(actor, reason); err != nil {
+		return domain.Refund{}, err
+	}
+	ctx = audit.WithReason(ctx, reason)
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return domain.Refund{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	p, err := lockRefundable(ctx, tx, orderID)
+	if err != nil {
+		return domain.Refund{}, err
+	}
+	r, _, err := loadRefund(ctx, tx, p.currency, `order_id = $1 and status = $2`, orderID, refundPending)
+	if err == sql.ErrNoRows {
+		return domain.Refund{}, ErrNoPendingRefund
+	}
+	if err != nil {
+		return domain.Refund{}, err
+	}
+	var refunded int64
+	err = tx.QueryRowContext(ctx,
+		`select coalesce(sum(amount), 0) from refunds where order_id = $1 and status = $2`, orderID, refundSucceeded,
+	).Scan(&refunded)
+	if err != nil {
+		return domain.Refund{}, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `update refunds set status = $2 where id = $1`, r.ID, refundFailed); err != nil {
+		return domain.Refund{}, err
+	}
+	before := r
+	r.Status = refundFailed
+	err = moneyChange{}.record(ctx, tx, audit.RefundCancel, audit.Target("payment", orderID),
+		refundState{Refunded: money.New(refunded, p.currency), Refund: &before},
+		refundState{Refunded: money.New(refunded, p.currency), Refund: &r},
+	)
+	if err != nil {
+		return domain.Refund{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return domain.Refund{}, err
+	}
+	return r, nil
+}
@@ -0,0 +1,205 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"payments/internal/audit"
+	"payments/internal/domain"
+	"payments/internal/money"
+)
+
+var (
+	ErrFraudDenied   = errors.New("payment denied by fraud rules")
+	ErrFraudRejected = errors.New("payment rejected on review")
+	ErrNotInReview   = errors.New("payment is not pending review")
+)
+
+// paymentHistory answers the fraud rules from the payments table, inside
+// the paying transaction.
+type paymentHistory struct {
+	q        queryer
+	userID   string
+	currency money.Currency
+}
+
+func (h paymentHistory) Count(ctx context.Context, since time.Time) (int, error) {
+	var n int
+	err := h.q.QueryRowContext(ctx,
+		`select count(*) from payments where user_id = $1 and created_at >= $2`, h.userID, since,
+	).Scan(&n)
+	return n, err
+}
+
+func (h paymentHistory) Average(ctx context.Context, since time.Time) (float64, int, error) {
+	var avg float64
+	var n int
+	err := h.q.QueryRowContext(ctx,
+		`select coalesce(avg(amount), 0)::float8, count(*) from payments
+		 where user_id = $1 and currency = $2 and status = 'SUCCESS' and created_at >= $3`,
+		h.userID, string(h.currency), since,
+	).Scan(&avg, &n)
+	return avg, n, err
+}
+
+// PendingReviews lists the payments held for review, oldest first.
+func (s *Store) PendingReviews(ctx context.Context, limit int) ([]domain.PendingReview, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx,
+		`select order_id, user_id, amount, currency, review_reason, created_at from payments
+		 where status = 'PENDING_REVIEW' order by created_at, order_id limit $1`, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []domain.PendingReview{}
+	for rows.Next() {
+		var r domain.PendingReview
+		var amt int64
+		var cur string
+		if err := rows.Scan(&r.OrderID, &r.UserID, &amt, &cur, &r.ReviewReason, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		r.Amount = money.New(amt, money.Currency(cur))
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// ApproveReview settles a payment held for review as if the fraud rules had
+// allowed it: the controls and the balance are checked again and the wallet
+// is debited, or the payment fails with the reason. The shares of a split
+// payment are already held and are captured as they are. Either way the
+// result goes to orders through the outbox.
+func (s *Store) ApproveReview(ctx context.Context, actor string, orderID uuid.UUID) (domain.Payment, error) {
+	return s.decideReview(ctx, actor, audit.ReviewApprove, orderID, func(tx *sql.Tx, p domain.Payment) (domain.Payment, error) {
+		if p.Split {
+			shares, err := settleShares(ctx, tx, orderID, true)
+			if err != nil {
+				return domain.Payment{}, err
+			}
+			p.Shares = shares
+			p.Status = domain.PaySuccess
+			return p, nil
+		}
+		bal, c, err := lockWallet(ctx, tx, p.UserID, p.Amount.Currency)
+		if err == sql.ErrNoRows {
+			return declined(p, walletErr(ctx, tx, p.UserID, p.Amount.Currency)), nil
+		}
+		if err != nil {
+			return domain.Payment{}, err
+		}
+		bonus, err := bonusAvailable(ctx, tx, p.UserID, p.Amount.Currency)
+		if err != nil {
+			return domain.Payment{}, err
+		}
+		if err := checkPayment(ctx, tx, p.UserID, p.Amount, bal+bonus, c); err != nil {
+			if FailureReason(err) == "" {
+				return domain.Payment{}, err
+			}
+			return declined(p, err), nil
+		}
+		p, err = spend(ctx, tx, p)
+		if err != nil {
+			return domain.Payment{}, err
+		}
+		p.Status = domain.PaySuccess
+		return p, nil
+	})
+}
+
+// RejectReview fails a payment held for review and releases the shares of
+// a split one.
+func (s *Store) RejectReview(ctx context.Context, actor string, orderID uuid.UUID, reason string) (domain.Payment, error) {
+	if len(reason) > 200 {
+		return domain.Payment{}, ErrReasonLimit
+	}
+	return s.decideReview(ctx, actor, audit.ReviewReject, orderID, func(tx *sql.Tx, p domain.Payment) (domain.Payment, error) {
+		if p.Split {
+			shares, err := settleShares(ctx, tx, orderID, false)
+			if err != nil {
+				return domain.Payment{}, err
+			}
+			p.Shares = shares
+		}
+		err := ErrFraudRejected
+		if reason != "" {
+			err = fmt.Errorf("%w: %s", ErrFraudRejected, reason)
+		}
+		return declined(p, err), nil
+	})
+}
+
+// decideReview locks a PENDING_REVIEW payment, lets decide settle it and
+// stores, announces and logs the outcome as action, along with the actor
+// who decided. The payment is dated by the decision, since that is when
+// money moves, if at all.
+func (s *Store) decideReview(ctx context.Context, actor, action string, orderID uuid.UUID, decide func(*sql.Tx, domain.Payment) (domain.Payment, error)) (domain.Payment, error) {
+	if actor == "" {
+		return domain.Payment{}, ErrNoActor
+	}
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return domain.Payment{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	p, err := scanPayment(orderID, tx.QueryRowContext(ctx,
+		`select `+paymentColumns+` from payments where order_id = $1 for update`, orderID,
+	))
+	if err == sql.ErrNoRows {
+		return domain.Payment{}, ErrNoPayment
+	}
+	if err != nil {
+		return domain.Payment{}, err
+	}
+	if p.Status != domain.PayPendingReview {
+		return p, ErrNotInReview
+	}
+
+	ws, err := payerWallets(ctx, tx, p)
+	if err != nil {
+		return domain.Payment{}, err
+	}
+	var mc moneyChange
+	if err := mc.lock(ctx, tx, ws...); err != nil {
+		return domain.Payment{}, err
+	}
+	prev := p
+	p, err = decide(tx, p)
+	if err != nil {
+		return domain.Payment{}, err
+	}
+	p.ReviewedBy = actor
+	_, err = tx.ExecContext(ctx,
+		`update payments set status = $2, failure_reason = $3, failure_message = $4, bonus_amount = $5,
+		        reviewed_by = $6, created_at = now()
+		 where order_id = $1`,
+		orderID, string(p.Status), p.FailureReason, p.FailureMessage, bonusAmount(p), actor,
+	)
+	if err != nil {
+		return domain.Payment{}, err
+	}
+	if err := InsertPaymentResultOutbox(ctx, tx, p); err != nil {
+		return domain.Payment{}, err
+	}
+	if err := mc.record(ctx, tx, action, audit.Target("payment", orderID), prev, p); err != nil {
+		return domain.Payment{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return domain.Payment{}, err
+	}
+	return p, nil
+}
@@ -0,0 +1,301 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"payments/internal/audit"
+	"payments/internal/domain"
+	"payments/internal/fraud"
+	"payments/internal/money"
+)
+
+var ErrInvalidSplit = errors.New("shares should be of different payers, greater than 0 and add up to the amount")
+
+// PaySplitInTx pays orderID from the wallets of several payers. Every
+// share is checked like a payment of its own (see PayInTx) and held off
+// its payer's cash balance; only when all of them are held are they
+// captured and the payment SUCCESS. The first payer that can not pay
+// releases the holds taken so far and fails the payment with their reason.
+// If the fraud rules send any share to review the holds stay and the
+// payment is PENDING_REVIEW until ApproveReview captures or RejectReview
+// releases them. Wallets are locked in payer order so that split payments
+// sharing payers do not deadlock. A zero Status means nothing was recorded.
+// Repeating a call for the same order returns the first payment.
+func (s *Store) PaySplitInTx(ctx context.Context, tx *sql.Tx, orderID uuid.UUID, userID string, amount domain.Money, shares []domain.PaymentShare) (domain.Payment, error) {
+	if p, err := findPayment(ctx, tx, orderID); err != sql.ErrNoRows {
+		return p, err
+	}
+	if err := checkShares(amount, shares); err != nil {
+		return domain.Payment{}, err
+	}
+
+	p := domain.Payment{OrderID: orderID, UserID: userID, Amount: amount, Split: true, Shares: slices.Clone(shares)}
+	slices.SortFunc(p.Shares, func(a, b domain.PaymentShare) int { return strings.Compare(a.UserID, b.UserID) })
+
+	var mc moneyChange
+	fail := func(i int, err error) (domain.Payment, error) {
+		if FailureReason(err) == "" {
+			return domain.Payment{}, err
+		}
+		payer := p.Shares[i].UserID
+		p.Shares = p.Shares[:i]
+		if err := releaseShares(ctx, tx, p.Shares); err != nil {
+			return domain.Payment{}, err
+		}
+		p := declined(p, fmt.Errorf("payer %s: %w", payer, err))
+		if err := insertPayment(ctx, tx, p); err != nil {
+			return domain.Payment{}, err
+		}
+		if err := insertShares(ctx, tx, orderID, p.Shares); err != nil {
+			return domain.Payment{}, err
+		}
+		if err := mc.record(ctx, tx, audit.PaymentCreate, audit.Target("payment", orderID), nil, p); err != nil {
+			return domain.Payment{}, err
+		}
+		return p, err
+	}
+
+	for i, sh := range p.Shares {
+		bal, c, err := lockWallet(ctx, tx, sh.UserID, amount.Currency)
+		if err == sql.ErrNoRows {
+			return fail(i, walletErr(ctx, tx, sh.UserID, amount.Currency))
+		}
+		if err != nil {
+			return domain.Payment{}, err
+		}
+		if err := mc.lock(ctx, tx, wallet{sh.UserID, amount.Currency}); err != nil {
+			return domain.Payment{}, err
+		}
+		if err := checkPayment(ctx, tx, sh.UserID, sh.Amount, bal, c); err != nil {
+			return fail(i, err)
+		}
+
+		d, err := s.fraud.Check(ctx, paymentHistory{q: tx, userID: sh.UserID, currency: amount.Currency}, sh.UserID, sh.Amount.Amount)
+		if err != nil {
+			return domain.Payment{}, err
+		}
+		switch d.Action {
+		case fraud.Deny:
+			return fail(i, fmt.Errorf("%w (%s)", ErrFraudDenied, d))
+		case fraud.Review:
+			if p.ReviewReason == "" {
+				p.ReviewReason = fmt.Sprintf("payer %s: %s", sh.UserID, d)
+			}
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`update accounts set balance = balance - $3 where user_id = $1 and currency = $2`,
+			sh.UserID, string(amount.Currency), sh.Amount.Amount,
+		)
+		if err != nil {
+			return domain.Payment{}, err
+		}
+		p.Shares[i].Status = domain.ShareHeld
+	}
+
+	if p.ReviewReason != "" {
+		p.Status = domain.PayPendingReview
+	} else {
+		p.Status = domain.PaySuccess
+		for i := range p.Shares {
+			p.Shares[i].Status = domain.ShareCaptured
+		}
+	}
+	if err := insertPayment(ctx, tx, p); err != nil {
+		return domain.Payment{}, err
+	}
+	if err := insertShares(ctx, tx, orderID, p.Shares); err != nil {
+		return domain.Payment{}, err
+	}
+	if err := mc.record(ctx, tx, audit.PaymentCreate, audit.Target("payment", orderID), nil, p); err != nil {
+		return domain.Payment{}, err
+	}
+	return p, nil
+}
+
+func checkShares(amount domain.Money, shares []domain.PaymentShare) error {
+	if len(shares) < 2 {
+		return ErrInvalidSplit
+	}
+	seen := make(map[string]bool, len(shares))
+	var sum int64
+	for _, sh := range shares {
+		if sh.UserID == "" || seen[sh.UserID] || !sh.Amount.IsPositive() || sh.Amount.Currency != amount.Currency {
+			return ErrInvalidSplit
+		}
+		seen[sh.UserID] = true
+		sum += sh.Amount.Amount
+	}
+	if sum != amount.Amount {
+		return ErrInvalidSplit
+	}
+	return nil
+}
+
+func insertShares(ctx context.Context, tx *sql.Tx, orderID uuid.UUID, shares []domain.PaymentShare) error {
+	for _, sh := range shares {
+		_, err := tx.ExecContext(ctx,
+			`insert into payment_shares(order_id, user_id, amount, currency, status, released_at)
+			 values ($1,$2,$3,$4,$5, case when $5 = 'RELEASED' then now() end)`,
+			orderID, sh.UserID, sh.Amount.Amount, string(sh.Amount.Currency), string(sh.Status),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loadShares(ctx context.Context, tx *sql.Tx, orderID uuid.UUID) ([]domain.PaymentShare, error) {
+	rows, err := tx.QueryContext(ctx,
+		`select user_id, amount, currency, status from payment_shares where order_id = $1 order by user_id`, orderID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []domain.PaymentShare
+	for rows.Next() {
+		var sh domain.PaymentShare
+		var amt int64
+		var cur, status string
+		if err := rows.Scan(&sh.UserID, &amt, &cur, &status); err != nil {
+			return nil, err
+		}
+		sh.Amount = money.New(amt, money.Currency(cur))
+		sh.Status = domain.ShareStatus(status)
+		out = append(out, sh)
+	}
+	return out, rows.Err()
+}
+
+// releaseShares gives held shares back to their payers' balances. Blocks
+// and freezes do not keep the money from them.
+func releaseShares(ctx context.Context, tx *sql.Tx, shares []domain.PaymentShare) error {
+	for i, sh := range shares {
+		_, err := tx.ExecContext(ctx,
+			`update accounts set balance = balance + $3 where user_id = $1 and currency = $2`,
+			sh.UserID, string(sh.Amount.Currency), sh.Amount.Amount,
+		)
+		if err != nil {
+			return err
+		}
+		shares[i].Status = domain.ShareReleased
+	}
+	return nil
+}
+
+// settleShares captures (or releases, if capture is false) the held shares
+// of a split payment whose row tx has locked, and returns them.
+func settleShares(ctx context.Context, tx *sql.Tx, orderID uuid.UUID, capture bool) ([]domain.PaymentShare, error) {
+	shares, err := loadShares(ctx, tx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	status := domain.ShareCaptured
+	if !capture {
+		status = domain.ShareReleased
+		if err := releaseShares(ctx, tx, shares); err != nil {
+			return nil, err
+		}
+	}
+	_, err = tx.ExecContext(ctx,
+		`update payment_shares set status = $2, released_at = case when $2 = 'RELEASED' then now() end
+		 where order_id = $1 and status = 'HELD'`,
+		orderID, string(status),
+	)
+	if err != nil {
+		return nil, err
+	}
+	for i := range shares {
+		shares[i].Status = status
+	}
+	return shares, nil
+}
+
+// refundShares gives amount of a split payment back to the payers in
+// proportion to their shares, never more to one than they paid and did not
+// get back yet; the minor units rounding leaves go to the first payers that
+// can take them.
+func refundShares(ctx context.Context, tx *sql.Tx, refundID, orderID uuid.UUID, amount domain.Money) error {
+	rows, err := tx.QueryContext(ctx,
+		`select user_id, amount, refunded from payment_shares
+		 where order_id = $1 and status = 'CAPTURED' order by user_id`, orderID,
+	)
+	if err != nil {
+		return err
+	}
+	type payer struct {
+		userID         string
+		paid, refunded int64
+	}
+	var payers []payer
+	var captured int64
+	for rows.Next() {
+		var p payer
+		if err := rows.Scan(&p.userID, &p.paid, &p.refunded); err != nil {
+			rows.Close()
+			return err
+		}
+		payers = append(payers, p)
+		captured += p.paid
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	parts := make([]int64, len(payers))
+	left := amount.Amount
+	for i, p := range payers {
+		parts[i] = min(amount.Amount*p.paid/captured, p.paid-p.refunded)
+		left -= parts[i]
+	}
+	for i, p := range payers {
+		extra := min(left, p.paid-p.refunded-parts[i])
+		parts[i] += extra
+		left -= extra
+	}
+	if left > 0 {
+		return ErrRefundExceeds
+	}
+
+	for i, p := range payers {
+		if parts[i] == 0 {
+			continue
+		}
+		_, err := tx.ExecContext(ctx,
+			`update payment_shares set refunded = refunded + $3 where order_id = $1 and user_id = $2`,
+			orderID, p.userID, parts[i],
+		)
+		if err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx,
+			`update accounts set balance = balance + $3 where user_id = $1 and currency = $2`,
+			p.userID, string(amount.Currency), parts[i],
+		)
+		if err != nil {
+			return err
+		}
+		if ra, _ := res.RowsAffected(); ra == 0 {
+			return walletErr(ctx, tx, p.userID, amount.Currency)
+		}
+		_, err = tx.ExecContext(ctx,
+			`insert into share_refunds(refund_id, user_id, amount, currency) values ($1,$2,$3,$4)`,
+			refundID, p.userID, parts[i], string(amount.Currency),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
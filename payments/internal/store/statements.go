@@ -0,0 +1,310 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"payments/internal/domain"
+	"payments/internal/money"
+)
+
+// MaxStatementRange bounds one statement so that it stays a page-able
+// document rather than a full history dump.
+const MaxStatementRange = 366 * 24 * time.Hour
+
+var ErrStatementRange = errors.New("statement period should be positive and at most 366 days")
+
+// ledgerSQL is every movement of wallet ($1 user, $2 currency) as
+// (created_at, kind, ref, detail, amount) with debits negative. Failed
+// payments never touched the balance and are left out, as are payments made
+// by card and their refunds; card top-ups name the provider. Payments and
+// refunds count only their cash part, bonus buckets are not the balance.
+// A withdrawal leaves when requested and comes back if it is rejected. The
+// payers of a split payment see their share leave when it is held, come back
+// if it is released, and their part of its refunds. Corrections by support
+// carry their reason.
+const ledgerSQL = `
+	select created_at, 'TOPUP' as kind, id::text as ref, provider as detail, amount
+	  from topups where user_id = $1 and currency = $2
+	union all
+	select created_at, 'PAYMENT', order_id::text, '', -(amount - bonus_amount)
+	  from payments where user_id = $1 and currency = $2 and status = 'SUCCESS' and provider = ''
+	   and not split and amount > bonus_amount
+	union all
+	select r.created_at, 'REFUND', r.order_id::text, r.reason, r.amount - r.bonus_amount
+	  from refunds r join payments p on p.order_id = r.order_id
+	 where r.user_id = $1 and p.currency = $2 and p.provider = '' and not p.split and r.amount > r.bonus_amount
+	union all
+	select created_at, 'PAYMENT_SHARE', order_id::text, '', -amount
+	  from payment_shares where user_id = $1 and currency = $2
+	union all
+	select released_at, 'PAYMENT_SHARE_RELEASED', order_id::text, '', amount
+	  from payment_shares where user_id = $1 and currency = $2 and released_at is not null
+	union all
+	select sr.created_at, 'REFUND', r.order_id::text, r.reason, sr.amount
+	  from share_refunds sr join refunds r on r.id = sr.refund_id
+	 where sr.user_id = $1 and sr.currency = $2
+	union all
+	select created_at, 'EXCHANGE_OUT', id::text, 'to ' || to_currency, -from_amount
+	  from exchanges where user_id = $1 and from_currency = $2
+	union all
+	select created_at, 'EXCHANGE_IN', id::text, 'from ' || from_currency, to_amount
+	  from exchanges where user_id = $1 and to_currency = $2
+	union all
+	select created_at, 'WITHDRAWAL', id::text, destination, -amount
+	  from withdrawals where user_id = $1 and currency = $2
+	union all
+	select returned_at, 'WITHDRAWAL_RETURNED', id::text, reason, amount
+	  from withdrawals where user_id = $1 and currency = $2 and returned_at is not null
+	union all
+	select created_at, 'ADJUSTMENT', id::text, reason, amount
+	  from balance_adjustments where user_id = $1 and currency = $2`
+
+// Statement returns the movements of the user's wallet in currency between
+// from (inclusive) and to (exclusive) with running balances. A calendar
+// month (UTC) that has a snapshot is served from it; otherwise the opening
+// balance starts from the latest snapshot before from, so only the tail of
+// the history is summed.
+func (s *Store) Statement(ctx context.Context, userID string, currency money.Currency, from, to time.Time) (domain.Statement, error) {
+	from, to = from.UTC(), to.UTC()
+	if !to.After(from) || to.Sub(from) > MaxStatementRange {
+		return domain.Statement{}, ErrStatementRange
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	// One snapshot of the database for the opening balance and the entries.
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return domain.Statement{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var one int
+	err = tx.QueryRowContext(ctx,
+		`select 1 from accounts where user_id = $1 and currency = $2`, userID, string(currency),
+	).Scan(&one)
+	if err == sql.ErrNoRows {
+		return domain.Statement{}, walletErr(ctx, tx, userID, currency)
+	}
+	if err != nil {
+		return domain.Statement{}, err
+	}
+
+	if isMonth(from, to) {
+		var b []byte
+		err := tx.QueryRowContext(ctx,
+			`select statement from statement_snapshots where user_id = $1 and currency = $2 and period = $3`,
+			userID, string(currency), from,
+		).Scan(&b)
+		if err == nil {
+			var st domain.Statement
+			err = json.Unmarshal(b, &st)
+			return st, err
+		}
+		if err != sql.ErrNoRows {
+			return domain.Statement{}, err
+		}
+	}
+	return statementTx(ctx, tx, userID, currency, from, to)
+}
+
+func statementTx(ctx context.Context, tx *sql.Tx, userID string, currency money.Currency, from, to time.Time) (domain.Statement, error) {
+	var opening int64
+	var since time.Time
+	err := tx.QueryRowContext(ctx,
+		`select closing, period_end from statement_snapshots
+		 where user_id = $1 and currency = $2 and period_end <= $3
+		 order by period desc limit 1`,
+		userID, string(currency), from,
+	).Scan(&opening, &since)
+	if err != nil && err != sql.ErrNoRows {
+		return domain.Statement{}, err
+	}
+
+	var tail int64
+	err = tx.QueryRowContext(ctx,
+		`select coalesce(sum(amount), 0) from (`+ledgerSQL+`) l where created_at >= $3 and created_at < $4`,
+		userID, string(currency), since, from,
+	).Scan(&tail)
+	if err != nil {
+		return domain.Statement{}, err
+	}
+
+	st := domain.Statement{
+		UserID:      userID,
+		Currency:    currency,
+		From:        from,
+		To:          to,
+		Opening:     money.New(opening+tail, currency),
+		Credits:     money.New(0, currency),
+		Debits:      money.New(0, currency),
+		Entries:     []domain.StatementEntry{},
+		GeneratedAt: time.Now().UTC(),
+	}
+
+	rows, err := tx.QueryContext(ctx,
+		`select created_at, kind, ref, detail, amount from (`+ledgerSQL+`) l
+		 where created_at >= $3 and created_at < $4
+		 order by created_at, kind, ref`,
+		userID, string(currency), from, to,
+	)
+	if err != nil {
+		return domain.Statement{}, err
+	}
+	defer rows.Close()
+
+	balance := st.Opening.Amount
+	for rows.Next() {
+		var e domain.StatementEntry
+		var kind string
+		var amt int64
+		if err := rows.Scan(&e.At, &kind, &e.Reference, &e.Detail, &amt); err != nil {
+			return domain.Statement{}, err
+		}
+		balance += amt
+		if amt > 0 {
+			st.Credits.Amount += amt
+		} else {
+			st.Debits.Amount -= amt
+		}
+		e.At = e.At.UTC()
+		e.Type = domain.StatementEntryType(kind)
+		e.Amount = money.New(amt, currency)
+		e.Balance = money.New(balance, currency)
+		st.Entries = append(st.Entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return domain.Statement{}, err
+	}
+	st.Closing = money.New(balance, currency)
+	return st, nil
+}
+
+// isMonth reports whether [from, to) is exactly one calendar month in UTC.
+func isMonth(from, to time.Time) bool {
+	return from.Equal(monthStart(from)) && to.Equal(from.AddDate(0, 1, 0))
+}
+
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// SnapshotStatements materializes the statement of every month that ended
+// before now for every wallet, starting after the wallet's latest snapshot
+// (or at its first movement). Months are filled in order, so each one opens
+// from the previous snapshot. It returns how many snapshots were written.
+func (s *Store) SnapshotStatements(ctx context.Context, now time.Time) (int, error) {
+	current := monthStart(now)
+
+	rows, err := s.db.QueryContext(ctx, `select user_id, currency from accounts order by user_id, currency`)
+	if err != nil {
+		return 0, err
+	}
+	type wallet struct {
+		userID   string
+		currency money.Currency
+	}
+	var wallets []wallet
+	for rows.Next() {
+		var w wallet
+		var cur string
+		if err := rows.Scan(&w.userID, &cur); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		w.currency = money.Currency(cur)
+		wallets = append(wallets, w)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	written := 0
+	for _, w := range wallets {
+		n, err := s.snapshotWallet(ctx, w.userID, w.currency, current)
+		written += n
+		if err != nil {
+			if ctx.Err() != nil {
+				return written, ctx.Err()
+			}
+			log.Printf("statement snapshot of %s/%s: %v", w.userID, w.currency, err)
+		}
+	}
+	return written, nil
+}
+
+func (s *Store) snapshotWallet(ctx context.Context, userID string, currency money.Currency, current time.Time) (int, error) {
+	var next sql.NullTime
+	err := s.db.QueryRowContext(ctx,
+		`select max(period_end) from statement_snapshots where user_id = $1 and currency = $2`,
+		userID, string(currency),
+	).Scan(&next)
+	if err != nil {
+		return 0, err
+	}
+	if !next.Valid {
+		err := s.db.QueryRowContext(ctx,
+			`select min(created_at) from (`+ledgerSQL+`) l`, userID, string(currency),
+		).Scan(&next)
+		if err != nil || !next.Valid {
+			return 0, err
+		}
+		next.Time = monthStart(next.Time)
+	}
+
+	written := 0
+	for p := next.Time.UTC(); p.Before(current); p = p.AddDate(0, 1, 0) {
+		st, err := s.Statement(ctx, userID, currency, p, p.AddDate(0, 1, 0))
+		if err != nil {
+			return written, err
+		}
+		b, err := json.Marshal(st)
+		if err != nil {
+			return written, err
+		}
+		_, err = s.db.ExecContext(ctx,
+			`insert into statement_snapshots(user_id, currency, period, period_end, opening, closing, statement)
+			 values ($1,$2,$3,$4,$5,$6,$7)
+			 on conflict (user_id, currency, period) do nothing`,
+			userID, string(currency), p, st.To, st.Opening.Amount, st.Closing.Amount, string(b),
+		)
+		if err != nil {
+			return written, err
+		}
+		written++
+	}
+	return written, nil
+}
+
+// RunStatementSnapshots calls SnapshotStatements every night at hour UTC
+// until ctx is done. Replicas may race; the inserts are idempotent.
+func (s *Store) RunStatementSnapshots(ctx context.Context, hour int) {
+	for {
+		now := time.Now().UTC()
+		next := time.Date(now.Year(), now.Month(), now.Day(), hour, 0, 0, 0, time.UTC)
+		if !next.After(now) {
+			next = next.AddDate(0, 0, 1)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+
+		n, err := s.SnapshotStatements(ctx, time.Now())
+		if err != nil {
+			log.Printf("statement snapshots: %v", err)
+		}
+		if n > 0 {
+			log.Printf("statement snapshots: %d written", n)
+		}
+	}
+}
@@ -5,88 +5,308 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
 
+	"payments/internal/audit"
 	"payments/internal/domain"
+	"payments/internal/fraud"
+	"payments/internal/money"
+	"payments/internal/payout"
+	"payments/internal/provider"
 )
 
 var (
 	ErrNoAccount      = errors.New("no account")
+	ErrNoWallet       = errors.New("no wallet")
 	ErrNotEnoughMoney = errors.New("not enough money")
 )
 
+// Failure reasons of a declined payment, stored with it and sent with FAILED
+// in payments.result.
+const (
+	ReasonNoAccount         = "NO_ACCOUNT"
+	ReasonNoWallet          = "NO_WALLET"
+	ReasonInsufficientFunds = "INSUFFICIENT_FUNDS"
+	ReasonLimitExceeded     = "LIMIT_EXCEEDED"
+	ReasonAccountFrozen     = "ACCOUNT_FROZEN"
+	ReasonAccountBlocked    = "ACCOUNT_BLOCKED"
+	ReasonFraudDenied       = "FRAUD_DENIED"
+	ReasonFraudRejected     = "FRAUD_REJECTED"
+	ReasonCardDeclined      = "CARD_DECLINED"
+	ReasonAdminCancelled    = "ADMIN_CANCELLED"
+)
+
+// FailureReason is the code of an error that declines a payment, or "" for
+// any other error.
+func FailureReason(err error) string {
+	switch {
+	case errors.Is(err, ErrNoAccount):
+		return ReasonNoAccount
+	case errors.Is(err, ErrNoWallet):
+		return ReasonNoWallet
+	case errors.Is(err, ErrNotEnoughMoney):
+		return ReasonInsufficientFunds
+	case errors.Is(err, ErrLimitExceeded):
+		return ReasonLimitExceeded
+	case errors.Is(err, ErrAccountFrozen):
+		return ReasonAccountFrozen
+	case errors.Is(err, ErrAccountBlocked):
+		return ReasonAccountBlocked
+	case errors.Is(err, ErrFraudDenied):
+		return ReasonFraudDenied
+	case errors.Is(err, ErrFraudRejected):
+		return ReasonFraudRejected
+	case errors.Is(err, ErrCardDeclined):
+		return ReasonCardDeclined
+	case errors.Is(err, ErrAdminCancelled):
+		return ReasonAdminCancelled
+	}
+	return ""
+}
+
 type Store struct {
-	db *sql.DB
+	db      *sql.DB
+	fraud   *fraud.Engine
+	cards   provider.Provider
+	payouts payout.Provider
 }
 
-func NewStore(db *sql.DB) *Store {
-	return &Store{db: db}
+func NewStore(db *sql.DB, screen *fraud.Engine, cards provider.Provider, payouts payout.Provider) *Store {
+	return &Store{db: db, fraud: screen, cards: cards, payouts: payouts}
 }
 
 type PaymentResult struct {
 	MessageID uuid.UUID `json:"message_id"`
 	OrderID   uuid.UUID `json:"order_id"`
 	Status    string    `json:"status"`
+	// Reason and Message are set on FAILED: a code (see FailureReason) and
+	// a text fit for the user.
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
 }
 
-func (s *Store) CreateAccount(userID string) {
-	_, _ = s.db.Exec(`insert into accounts(user_id, balance) values ($1, 0)
-					  on conflict (user_id) do nothing`, userID)
+func (s *Store) CreateAccount(ctx context.Context, userID string, currency money.Currency) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// A new wallet takes over the freeze and block of the user's others.
+	var f accountFlags
+	err = tx.QueryRowContext(ctx, `insert into accounts(user_id, currency, balance, frozen, blocked, blocked_reason)
+					  select $1, $2, 0, coalesce(bool_or(frozen), false), coalesce(bool_or(blocked), false),
+					         coalesce(max(blocked_reason), '')
+					  from accounts where user_id = $1
+					  on conflict (user_id, currency) do nothing
+					  returning frozen, blocked, blocked_reason`, userID, string(currency),
+	).Scan(&f.Frozen, &f.Blocked, &f.BlockedReason)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	mc := moneyChange{wallets: []wallet{{userID, currency}}}
+	if err := mc.record(ctx, tx, audit.AccountCreate, audit.Target("account", userID), nil, f); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// walletErr tells a user without any account from one who only lacks a
+// wallet in currency.
+func walletErr(ctx context.Context, q queryer, userID string, currency money.Currency) error {
+	var n int
+	if err := q.QueryRowContext(ctx, `select count(*) from accounts where user_id = $1`, userID).Scan(&n); err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoAccount
+	}
+	return fmt.Errorf("%w in %s", ErrNoWallet, currency)
+}
+
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
 }
 
-func (s *Store) TopUp(userID string, amount domain.Money) error {
-	if amount <= 0 {
+// TopUp credits the wallet in amount's currency and records the top-up.
+// Repeating a call with the same non-empty idempotencyKey is a no-op.
+func (s *Store) TopUp(ctx context.Context, userID string, amount domain.Money, idempotencyKey string) error {
+	if !amount.IsPositive() {
 		return errors.New("amount must be > 0")
 	}
 
-	res, err := s.db.Exec(`update accounts set balance = balance + $2 where user_id = $1`, userID, int64(amount))
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var mc moneyChange
+	if err := mc.lock(ctx, tx, wallet{userID, amount.Currency}); err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx,
+		`update accounts set balance = balance + $3 where user_id = $1 and currency = $2 and not blocked`,
+		userID, string(amount.Currency), amount.Amount,
+	)
 	if err != nil {
 		return err
 	}
 	ra, _ := res.RowsAffected()
 	if ra == 0 {
-		return ErrNoAccount
+		var blocked bool
+		err := tx.QueryRowContext(ctx,
+			`select blocked from accounts where user_id = $1 and currency = $2`, userID, string(amount.Currency),
+		).Scan(&blocked)
+		if err == nil && blocked {
+			return ErrAccountBlocked
+		}
+		return walletErr(ctx, tx, userID, amount.Currency)
 	}
-	return nil
+
+	var key sql.NullString
+	if idempotencyKey != "" {
+		key = sql.NullString{String: idempotencyKey, Valid: true}
+	}
+	res, err = tx.ExecContext(ctx,
+		`insert into topups(user_id, amount, currency, idempotency_key) values ($1,$2,$3,$4)
+		 on conflict (user_id, idempotency_key) where idempotency_key is not null do nothing`,
+		userID, amount.Amount, string(amount.Currency), key,
+	)
+	if err != nil {
+		return err
+	}
+	if ra, _ := res.RowsAffected(); ra == 0 {
+		// Already applied: drop the balance update above.
+		return nil
+	}
+	if err := mc.record(ctx, tx, audit.BalanceTopUp, audit.Target("account", userID), nil, amount); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func (s *Store) Balance(userID string) (domain.Money, error) {
+func (s *Store) Balance(ctx context.Context, userID string, currency money.Currency) (domain.Money, error) {
 	var b int64
-	err := s.db.QueryRow(`select balance from accounts where user_id = $1`, userID).Scan(&b)
+	err := s.db.QueryRowContext(ctx,
+		`select balance from accounts where user_id = $1 and currency = $2`, userID, string(currency),
+	).Scan(&b)
 	if err == sql.ErrNoRows {
-		return 0, ErrNoAccount
+		return domain.Money{}, walletErr(ctx, s.db, userID, currency)
+	}
+	if err != nil {
+		return domain.Money{}, err
 	}
+	return money.New(b, currency), nil
+}
+
+// Balances returns every wallet of the user ordered by currency.
+func (s *Store) Balances(ctx context.Context, userID string) ([]domain.Money, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`select currency, balance from accounts where user_id = $1 order by currency`, userID,
+	)
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []domain.Money
+	for rows.Next() {
+		var cur string
+		var b int64
+		if err := rows.Scan(&cur, &b); err != nil {
+			return nil, err
+		}
+		out = append(out, money.New(b, money.Currency(cur)))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
-	return domain.Money(b), nil
+	if len(out) == 0 {
+		return nil, ErrNoAccount
+	}
+	return out, nil
 }
 
-func InsertPaymentResultOutbox(ctx context.Context, tx *sql.Tx, orderID uuid.UUID, status domain.PaymentStatus) error {
+func InsertPaymentResultOutbox(ctx context.Context, tx *sql.Tx, p domain.Payment) error {
 	msgID := uuid.New()
 	ev := PaymentResult{
 		MessageID: msgID,
-		OrderID:   orderID,
-		Status:    string(status),
+		OrderID:   p.OrderID,
+		Status:    string(p.Status),
+		Reason:    p.FailureReason,
+		Message:   p.FailureMessage,
 	}
 	payload, _ := json.Marshal(ev)
 
 	_, err := tx.ExecContext(ctx,
 		`insert into payments_outbox(message_id, topic, key, payload) values ($1,$2,$3,$4)`,
-		msgID, "payments.result", orderID.String(), payload,
+		msgID, "payments.result", p.OrderID.String(), payload,
+	)
+	return err
+}
+
+func insertPayment(ctx context.Context, tx *sql.Tx, p domain.Payment) error {
+	_, err := tx.ExecContext(ctx,
+		`insert into payments(order_id, user_id, amount, currency, status, failure_reason, failure_message, review_reason,
+		                      bonus_amount, split)
+		 values ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)`,
+		p.OrderID, p.UserID, p.Amount.Amount, string(p.Amount.Currency), string(p.Status),
+		p.FailureReason, p.FailureMessage, p.ReviewReason, bonusAmount(p), p.Split,
 	)
 	return err
 }
 
+const paymentColumns = `user_id, amount, currency, status, failure_reason, failure_message, review_reason,
+	reviewed_by, provider, coalesce(provider_tx_id, ''), bonus_amount, split`
 
-func (s *Store) Pay(orderID uuid.UUID, userID string, amount domain.Money) (domain.Payment, error) {
-	if amount <= 0 {
+// findPayment returns the payment already made for orderID, shares
+// included, or sql.ErrNoRows.
+func findPayment(ctx context.Context, tx *sql.Tx, orderID uuid.UUID) (domain.Payment, error) {
+	p, err := scanPayment(orderID, tx.QueryRowContext(ctx,
+		`select `+paymentColumns+` from payments where order_id = $1`, orderID,
+	))
+	if err != nil || !p.Split {
+		return p, err
+	}
+	p.Shares, err = loadShares(ctx, tx, orderID)
+	return p, err
+}
+
+func scanPayment(orderID uuid.UUID, row *sql.Row) (domain.Payment, error) {
+	p := domain.Payment{OrderID: orderID}
+	var status, cur string
+	var amt, bonus int64
+	err := row.Scan(&p.UserID, &amt, &cur, &status, &p.FailureReason, &p.FailureMessage, &p.ReviewReason,
+		&p.ReviewedBy, &p.Provider, &p.ProviderTxID, &bonus, &p.Split)
+	if err != nil {
+		return domain.Payment{}, err
+	}
+	p.Amount = money.New(amt, money.Currency(cur))
+	if bonus > 0 {
+		b := money.New(bonus, p.Amount.Currency)
+		p.Bonus = &b
+	}
+	p.Status = domain.PaymentStatus(status)
+	return p, nil
+}
+
+func (s *Store) Pay(ctx context.Context, orderID uuid.UUID, userID string, amount domain.Money) (domain.Payment, error) {
+	if !amount.IsPositive() {
 		return domain.Payment{OrderID: orderID, UserID: userID, Amount: amount, Status: domain.PayFailed}, errors.New("amount must be > 0")
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	tx, err := s.db.BeginTx(ctx, nil)
@@ -95,139 +315,144 @@ func (s *Store) Pay(orderID uuid.UUID, userID string, amount domain.Money) (doma
 	}
 	defer func() { _ = tx.Rollback() }()
 
-	{
-		var status string
-		var uid string
-		var amt int64
-		err := tx.QueryRowContext(ctx,
-			`select user_id, amount, status from payments where order_id = $1`, orderID,
-		).Scan(&uid, &amt, &status)
+	if p, err := findPayment(ctx, tx, orderID); err != sql.ErrNoRows {
+		return p, err
+	}
 
-		if err == nil {
-			return domain.Payment{OrderID: orderID, UserID: uid, Amount: domain.Money(amt), Status: domain.PaymentStatus(status)}, nil
+	p, err := s.PayInTx(ctx, tx, orderID, userID, amount)
+	if p.Status == "" {
+		return domain.Payment{}, err
+	}
+	// A decline is recorded and announced like a success; a payment under
+	// review is announced once it is decided.
+	if p.Status.Settled() {
+		if err := InsertPaymentResultOutbox(ctx, tx, p); err != nil {
+			return domain.Payment{}, err
 		}
-		if err != sql.ErrNoRows {
+	}
+	if err := tx.Commit(); err != nil {
+		return domain.Payment{}, err
+	}
+	return p, err
+}
+
+// PayInTx debits the wallet for orderID, bonus buckets first (see spend),
+// and records the payment, or
+// records it as FAILED and returns why: a missing wallet, the account
+// controls, the balance or the fraud rules (see FailureReason). A payment
+// the rules send to review is recorded as PENDING_REVIEW without touching
+// the balance; ApproveReview settles it. All checks run under the wallet
+// row lock. A zero Status means nothing was recorded. Repeating a call for
+// the same order returns the first payment.
+func (s *Store) PayInTx(ctx context.Context, tx *sql.Tx, orderID uuid.UUID, userID string, amount domain.Money) (domain.Payment, error) {
+	if p, err := findPayment(ctx, tx, orderID); err != sql.ErrNoRows {
+		return p, err
+	}
+
+	p := domain.Payment{OrderID: orderID, UserID: userID, Amount: amount}
+	var mc moneyChange
+	decline := func(err error) (domain.Payment, error) {
+		if FailureReason(err) == "" {
+			return domain.Payment{}, err
+		}
+		p := declined(p, err)
+		if err := insertPayment(ctx, tx, p); err != nil {
 			return domain.Payment{}, err
 		}
+		if err := mc.record(ctx, tx, audit.PaymentCreate, audit.Target("payment", orderID), nil, p); err != nil {
+			return domain.Payment{}, err
+		}
+		return p, err
 	}
 
-	var bal int64
-	err = tx.QueryRowContext(ctx,
-		`select balance from accounts where user_id = $1 for update`, userID,
-	).Scan(&bal)
+	bal, c, err := lockWallet(ctx, tx, userID, amount.Currency)
 	if err == sql.ErrNoRows {
-		p := domain.Payment{OrderID: orderID, UserID: userID, Amount: amount, Status: domain.PayFailed}
-		_, _ = tx.ExecContext(ctx,
-			`insert into payments(order_id, user_id, amount, status) values ($1,$2,$3,$4)`,
-			orderID, userID, int64(amount), string(p.Status),
-		)
-		_ = InsertPaymentResultOutbox(ctx, tx, orderID, p.Status)
-		_ = tx.Commit()
-		return p, ErrNoAccount
+		return decline(walletErr(ctx, tx, userID, amount.Currency))
 	}
 	if err != nil {
 		return domain.Payment{}, err
 	}
-
-	if bal < int64(amount) {
-		p := domain.Payment{OrderID: orderID, UserID: userID, Amount: amount, Status: domain.PayFailed}
-		_, _ = tx.ExecContext(ctx,
-			`insert into payments(order_id, user_id, amount, status) values ($1,$2,$3,$4)`,
-			orderID, userID, int64(amount), string(p.Status),
-		)
-		_ = InsertPaymentResultOutbox(ctx, tx, orderID, p.Status)
-		_ = tx.Commit()
-		return p, ErrNotEnoughMoney
+	if err := mc.lock(ctx, tx, wallet{userID, amount.Currency}); err != nil {
+		return domain.Payment{}, err
 	}
-
-	_, err = tx.ExecContext(ctx,
-		`update accounts set balance = balance - $2 where user_id = $1`, userID, int64(amount),
-	)
+	bonus, err := bonusAvailable(ctx, tx, userID, amount.Currency)
 	if err != nil {
 		return domain.Payment{}, err
 	}
+	if err := checkPayment(ctx, tx, userID, amount, bal+bonus, c); err != nil {
+		return decline(err)
+	}
 
-	p := domain.Payment{OrderID: orderID, UserID: userID, Amount: amount, Status: domain.PaySuccess}
-	_, err = tx.ExecContext(ctx,
-		`insert into payments(order_id, user_id, amount, status) values ($1,$2,$3,$4)`,
-		orderID, userID, int64(amount), string(p.Status),
-	)
+	d, err := s.fraud.Check(ctx, paymentHistory{q: tx, userID: userID, currency: amount.Currency}, userID, amount.Amount)
 	if err != nil {
 		return domain.Payment{}, err
 	}
-	if err = InsertPaymentResultOutbox(ctx, tx, orderID, p.Status); err != nil {
+	switch d.Action {
+	case fraud.Deny:
+		return decline(fmt.Errorf("%w (%s)", ErrFraudDenied, d))
+	case fraud.Review:
+		p.Status = domain.PayPendingReview
+		p.ReviewReason = d.String()
+	default:
+		p, err = spend(ctx, tx, p)
+		if err != nil {
+			return domain.Payment{}, err
+		}
+		p.Status = domain.PaySuccess
+	}
+	if err := insertPayment(ctx, tx, p); err != nil {
 		return domain.Payment{}, err
 	}
-
-	if err := tx.Commit(); err != nil {
+	if err := mc.record(ctx, tx, audit.PaymentCreate, audit.Target("payment", orderID), nil, p); err != nil {
 		return domain.Payment{}, err
 	}
 	return p, nil
 }
 
-func PayInTx(ctx context.Context, tx *sql.Tx, orderID uuid.UUID, userID string, amount domain.Money) (domain.Payment, error) {
-	{
-		var status string
-		var uid string
-		var amt int64
-		err := tx.QueryRowContext(ctx,
-			`select user_id, amount, status from payments where order_id = $1`, orderID,
-		).Scan(&uid, &amt, &status)
-
-		if err == nil {
-			return domain.Payment{OrderID: orderID, UserID: uid, Amount: domain.Money(amt), Status: domain.PaymentStatus(status)}, nil
-		}
-		if err != sql.ErrNoRows {
-			return domain.Payment{}, err
-		}
+// checkPayment runs the account controls and the balance check against a
+// wallet locked by lockWallet; funds are its cash and unexpired bonus.
+func checkPayment(ctx context.Context, tx *sql.Tx, userID string, amount domain.Money, funds int64, c controls) error {
+	if err := checkControls(ctx, tx, userID, amount, c); err != nil {
+		return err
 	}
-
-	var bal int64
-	err := tx.QueryRowContext(ctx,
-		`select balance from accounts where user_id = $1 for update`, userID,
-	).Scan(&bal)
-	if err == sql.ErrNoRows {
-		p := domain.Payment{OrderID: orderID, UserID: userID, Amount: amount, Status: domain.PayFailed}
-		_, e := tx.ExecContext(ctx,
-			`insert into payments(order_id, user_id, amount, status) values ($1,$2,$3,$4)`,
-			orderID, userID, int64(amount), string(p.Status),
-		)
-		if e != nil {
-			return domain.Payment{}, e
-		}
-		return p, ErrNoAccount
+	if funds < amount.Amount {
+		return ErrNotEnoughMoney
 	}
+	return nil
+}
+
+// spend pays p from a wallet locked by lockWallet: bonus buckets first,
+// earliest expiry first, then the cash balance. p.Bonus is set to what the
+// buckets paid.
+func spend(ctx context.Context, tx *sql.Tx, p domain.Payment) (domain.Payment, error) {
+	bonus, err := spendBonus(ctx, tx, p.OrderID, p.UserID, p.Amount)
 	if err != nil {
 		return domain.Payment{}, err
 	}
-
-	if bal < int64(amount) {
-		p := domain.Payment{OrderID: orderID, UserID: userID, Amount: amount, Status: domain.PayFailed}
-		_, e := tx.ExecContext(ctx,
-			`insert into payments(order_id, user_id, amount, status) values ($1,$2,$3,$4)`,
-			orderID, userID, int64(amount), string(p.Status),
+	if bonus > 0 {
+		b := money.New(bonus, p.Amount.Currency)
+		p.Bonus = &b
+	}
+	if cash := p.Amount.Amount - bonus; cash > 0 {
+		_, err = tx.ExecContext(ctx,
+			`update accounts set balance = balance - $3 where user_id = $1 and currency = $2`,
+			p.UserID, string(p.Amount.Currency), cash,
 		)
-		if e != nil {
-			return domain.Payment{}, e
-		}
-		return p, ErrNotEnoughMoney
 	}
+	return p, err
+}
 
-	_, err = tx.ExecContext(ctx,
-		`update accounts set balance = balance - $2 where user_id = $1`,
-		userID, int64(amount),
-	)
-	if err != nil {
-		return domain.Payment{}, err
+func bonusAmount(p domain.Payment) int64 {
+	if p.Bonus == nil {
+		return 0
 	}
+	return p.Bonus.Amount
+}
 
-	p := domain.Payment{OrderID: orderID, UserID: userID, Amount: amount, Status: domain.PaySuccess}
-	_, err = tx.ExecContext(ctx,
-		`insert into payments(order_id, user_id, amount, status) values ($1,$2,$3,$4)`,
-		orderID, userID, int64(amount), string(p.Status),
-	)
-	if err != nil {
-		return domain.Payment{}, err
-	}
-	return p, nil
+// declined is p failed for err, a decline (see FailureReason).
+func declined(p domain.Payment, err error) domain.Payment {
+	p.Status = domain.PayFailed
+	p.FailureReason = FailureReason(err)
+	p.FailureMessage = err.Error()
+	return p
 }
@@ -0,0 +1,388 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"payments/internal/audit"
+	"payments/internal/domain"
+	"payments/internal/money"
+	"payments/internal/payout"
+)
+
+var (
+	ErrNoWithdrawal          = errors.New("no withdrawal")
+	ErrWithdrawalState       = errors.New("withdrawal can not go there from its status")
+	ErrDestination           = errors.New("destination should contain 1 to 64 symbols")
+	ErrWithdrawalKeyReused   = errors.New("idempotency key was already used with a different withdrawal")
+	ErrWithdrawalReasonLimit = errors.New("reason should contain 1 to 200 symbols")
+)
+
+const WithdrawalTopic = "payments.withdrawals"
+
+// WithdrawalEvent is sent for every status a withdrawal reaches.
+type WithdrawalEvent struct {
+	MessageID    uuid.UUID `json:"message_id"`
+	WithdrawalID uuid.UUID `json:"withdrawal_id"`
+	UserID       string    `json:"user_id"`
+	Amount       int64     `json:"amount"`
+	Currency     string    `json:"currency"`
+	Status       string    `json:"status"`
+	Reason       string    `json:"reason,omitempty"`
+	PayoutID     string    `json:"payout_id,omitempty"`
+}
+
+const withdrawalColumns = `id, user_id, amount, currency, destination, status, reason, provider,
+	coalesce(payout_id, ''), decided_by, created_at, updated_at`
+
+func scanWithdrawal(row rowScanner) (domain.Withdrawal, error) {
+	var w domain.Withdrawal
+	var amt int64
+	var cur, status string
+	err := row.Scan(&w.ID, &w.UserID, &amt, &cur, &w.Destination, &status, &w.Reason, &w.Provider,
+		&w.PayoutID, &w.DecidedBy, &w.CreatedAt, &w.UpdatedAt)
+	if err != nil {
+		return domain.Withdrawal{}, err
+	}
+	w.Amount = money.New(amt, money.Currency(cur))
+	w.Status = domain.WithdrawalStatus(status)
+	return w, nil
+}
+
+// RequestWithdrawal takes amount off the cash balance and holds it in a
+// REQUESTED withdrawal for an admin to decide. Bonus credit can not be
+// withdrawn. Repeating a call with the same non-empty idempotencyKey
+// returns the first withdrawal.
+func (s *Store) RequestWithdrawal(ctx context.Context, userID string, amount domain.Money, destination, idempotencyKey string) (domain.Withdrawal, error) {
+	if !amount.IsPositive() {
+		return domain.Withdrawal{}, errors.New("amount must be > 0")
+	}
+	if destination == "" || len(destination) > 64 {
+		return domain.Withdrawal{}, ErrDestination
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return domain.Withdrawal{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// The wallet row lock also serializes replays of one key.
+	bal, c, err := lockWallet(ctx, tx, userID, amount.Currency)
+	if err == sql.ErrNoRows {
+		return domain.Withdrawal{}, walletErr(ctx, tx, userID, amount.Currency)
+	}
+	if err != nil {
+		return domain.Withdrawal{}, err
+	}
+
+	if idempotencyKey != "" {
+		w, err := scanWithdrawal(tx.QueryRowContext(ctx,
+			`select `+withdrawalColumns+` from withdrawals where user_id = $1 and idempotency_key = $2`,
+			userID, idempotencyKey,
+		))
+		if err == nil {
+			if w.Amount != amount || w.Destination != destination {
+				return domain.Withdrawal{}, ErrWithdrawalKeyReused
+			}
+			return w, nil
+		}
+		if err != sql.ErrNoRows {
+			return domain.Withdrawal{}, err
+		}
+	}
+
+	if c.blocked {
+		return domain.Withdrawal{}, ErrAccountBlocked
+	}
+	if c.frozen {
+		return domain.Withdrawal{}, ErrAccountFrozen
+	}
+	if bal < amount.Amount {
+		return domain.Withdrawal{}, ErrNotEnoughMoney
+	}
+
+	var mc moneyChange
+	if err := mc.lock(ctx, tx, wallet{userID, amount.Currency}); err != nil {
+		return domain.Withdrawal{}, err
+	}
+	_, err = tx.ExecContext(ctx,
+		`update accounts set balance = balance - $3 where user_id = $1 and currency = $2`,
+		userID, string(amount.Currency), amount.Amount,
+	)
+	if err != nil {
+		return domain.Withdrawal{}, err
+	}
+	var key sql.NullString
+	if idempotencyKey != "" {
+		key = sql.NullString{String: idempotencyKey, Valid: true}
+	}
+	w, err := scanWithdrawal(tx.QueryRowContext(ctx,
+		`insert into withdrawals(id, user_id, amount, currency, destination, status, idempotency_key)
+		 values ($1,$2,$3,$4,$5,$6,$7)
+		 returning `+withdrawalColumns,
+		uuid.New(), userID, amount.Amount, string(amount.Currency), destination,
+		string(domain.WithdrawalRequested), key,
+	))
+	if err != nil {
+		return domain.Withdrawal{}, err
+	}
+	if err := insertWithdrawalEvent(ctx, tx, w); err != nil {
+		return domain.Withdrawal{}, err
+	}
+	if err := mc.record(ctx, tx, audit.WithdrawalRequest, audit.Target("withdrawal", w.ID), nil, w); err != nil {
+		return domain.Withdrawal{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return domain.Withdrawal{}, err
+	}
+	return w, nil
+}
+
+func (s *Store) Withdrawal(ctx context.Context, id uuid.UUID) (domain.Withdrawal, error) {
+	w, err := scanWithdrawal(s.db.QueryRowContext(ctx, `select `+withdrawalColumns+` from withdrawals where id = $1`, id))
+	if err == sql.ErrNoRows {
+		return domain.Withdrawal{}, ErrNoWithdrawal
+	}
+	return w, err
+}
+
+// Withdrawals lists withdrawals newest first, of userID (everyone's if
+// empty) in status (any if empty).
+func (s *Store) Withdrawals(ctx context.Context, userID string, status domain.WithdrawalStatus, limit int) ([]domain.Withdrawal, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`select `+withdrawalColumns+` from withdrawals
+		 where ($1 = '' or user_id = $1) and ($2 = '' or status = $2)
+		 order by created_at desc, id desc
+		 limit $3`,
+		userID, string(status), limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []domain.Withdrawal{}
+	for rows.Next() {
+		w, err := scanWithdrawal(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, w)
+	}
+	return out, rows.Err()
+}
+
+// HeldWithdrawals sums per currency what the user's withdrawals that are
+// neither paid out nor rejected hold.
+func (s *Store) HeldWithdrawals(ctx context.Context, userID string) (map[money.Currency]int64, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`select currency, sum(amount) from withdrawals
+		 where user_id = $1 and status in ($2, $3)
+		 group by currency`,
+		userID, string(domain.WithdrawalRequested), string(domain.WithdrawalApproved),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	held := map[money.Currency]int64{}
+	for rows.Next() {
+		var cur string
+		var n int64
+		if err := rows.Scan(&cur, &n); err != nil {
+			return nil, err
+		}
+		held[money.Currency(cur)] = n
+	}
+	return held, rows.Err()
+}
+
+// ApproveWithdrawal approves a REQUESTED withdrawal on behalf of actor and
+// sends it to the payout provider. A payout the provider could not take yet
+// leaves it APPROVED for RunPayouts to retry; the returned withdrawal is its
+// latest state either way.
+func (s *Store) ApproveWithdrawal(ctx context.Context, actor string, id uuid.UUID) (domain.Withdrawal, error) {
+	if actor == "" {
+		return domain.Withdrawal{}, ErrNoActor
+	}
+	w, err := s.moveWithdrawal(ctx, id, domain.WithdrawalRequested, func(w domain.Withdrawal) domain.Withdrawal {
+		w.Status = domain.WithdrawalApproved
+		w.Provider = s.payouts.Name()
+		w.DecidedBy = actor
+		return w
+	})
+	if err != nil {
+		return w, err
+	}
+	if err := s.payOut(ctx, w); err != nil {
+		log.Printf("withdrawal %s: payout will be retried: %v", id, err)
+	}
+	return s.Withdrawal(ctx, id)
+}
+
+// RejectWithdrawal rejects a REQUESTED withdrawal on behalf of actor and
+// gives the money back.
+func (s *Store) RejectWithdrawal(ctx context.Context, actor string, id uuid.UUID, reason string) (domain.Withdrawal, error) {
+	if actor == "" {
+		return domain.Withdrawal{}, ErrNoActor
+	}
+	if reason == "" || len(reason) > 200 {
+		return domain.Withdrawal{}, ErrWithdrawalReasonLimit
+	}
+	return s.moveWithdrawal(ctx, id, domain.WithdrawalRequested, func(w domain.Withdrawal) domain.Withdrawal {
+		w.Status = domain.WithdrawalRejected
+		w.Reason = reason
+		w.DecidedBy = actor
+		return w
+	})
+}
+
+// payOut sends an APPROVED withdrawal to the payout provider and records
+// the outcome: PAID_OUT, or REJECTED if the provider refused it for good.
+// Any other error leaves it APPROVED.
+func (s *Store) payOut(ctx context.Context, w domain.Withdrawal) error {
+	payoutID, err := s.payouts.Send(ctx, payout.Request{
+		Reference:   w.ID.String(),
+		Amount:      w.Amount,
+		Destination: w.Destination,
+	})
+	if errors.Is(err, payout.ErrRejected) {
+		reason := err.Error()
+		_, err = s.moveWithdrawal(ctx, w.ID, domain.WithdrawalApproved, func(w domain.Withdrawal) domain.Withdrawal {
+			w.Status = domain.WithdrawalRejected
+			w.Reason = reason
+			return w
+		})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	_, err = s.moveWithdrawal(ctx, w.ID, domain.WithdrawalApproved, func(w domain.Withdrawal) domain.Withdrawal {
+		w.Status = domain.WithdrawalPaidOut
+		w.PayoutID = payoutID
+		return w
+	})
+	return err
+}
+
+// moveWithdrawal locks a withdrawal in status from, lets move change it and
+// stores and announces the result. A rejection gives the money back.
+func (s *Store) moveWithdrawal(ctx context.Context, id uuid.UUID, from domain.WithdrawalStatus, move func(domain.Withdrawal) domain.Withdrawal) (domain.Withdrawal, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return domain.Withdrawal{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	w, err := scanWithdrawal(tx.QueryRowContext(ctx,
+		`select `+withdrawalColumns+` from withdrawals where id = $1 for update`, id,
+	))
+	if err == sql.ErrNoRows {
+		return domain.Withdrawal{}, ErrNoWithdrawal
+	}
+	if err != nil {
+		return domain.Withdrawal{}, err
+	}
+	if w.Status != from {
+		return w, fmt.Errorf("%w: it is %s", ErrWithdrawalState, w.Status)
+	}
+
+	prev := w
+	w = move(w)
+	var mc moneyChange
+	if w.Status == domain.WithdrawalRejected {
+		if err := mc.lock(ctx, tx, wallet{w.UserID, w.Amount.Currency}); err != nil {
+			return domain.Withdrawal{}, err
+		}
+	}
+	err = tx.QueryRowContext(ctx,
+		`update withdrawals set status = $2, reason = $3, provider = $4, payout_id = nullif($5, ''), decided_by = $7,
+		        updated_at = now(), returned_at = case when $2 = $6 then now() end
+		 where id = $1
+		 returning updated_at`,
+		id, string(w.Status), w.Reason, w.Provider, w.PayoutID, string(domain.WithdrawalRejected), w.DecidedBy,
+	).Scan(&w.UpdatedAt)
+	if err != nil {
+		return domain.Withdrawal{}, err
+	}
+	if w.Status == domain.WithdrawalRejected {
+		// Blocks and freezes do not keep the user's own money from them.
+		_, err := tx.ExecContext(ctx,
+			`update accounts set balance = balance + $3 where user_id = $1 and currency = $2`,
+			w.UserID, string(w.Amount.Currency), w.Amount.Amount,
+		)
+		if err != nil {
+			return domain.Withdrawal{}, err
+		}
+	}
+	if err := insertWithdrawalEvent(ctx, tx, w); err != nil {
+		return domain.Withdrawal{}, err
+	}
+	if err := mc.record(ctx, tx, audit.WithdrawalStatus, audit.Target("withdrawal", id), prev, w); err != nil {
+		return domain.Withdrawal{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return domain.Withdrawal{}, err
+	}
+	return w, nil
+}
+
+func insertWithdrawalEvent(ctx context.Context, tx *sql.Tx, w domain.Withdrawal) error {
+	ev := WithdrawalEvent{
+		MessageID:    uuid.New(),
+		WithdrawalID: w.ID,
+		UserID:       w.UserID,
+		Amount:       w.Amount.Amount,
+		Currency:     string(w.Amount.Currency),
+		Status:       string(w.Status),
+		Reason:       w.Reason,
+		PayoutID:     w.PayoutID,
+	}
+	payload, _ := json.Marshal(ev)
+	_, err := tx.ExecContext(ctx,
+		`insert into payments_outbox(message_id, topic, key, payload) values ($1,$2,$3,$4)`,
+		ev.MessageID, WithdrawalTopic, w.ID.String(), payload,
+	)
+	return err
+}
+
+// RunPayouts sends APPROVED withdrawals to the payout provider again every
+// interval until ctx is done. The provider dedupes by withdrawal id, so
+// racing ApproveWithdrawal or another replica pays out once.
+func (s *Store) RunPayouts(ctx context.Context, every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			ws, err := s.Withdrawals(ctx, "", domain.WithdrawalApproved, 100)
+			if err != nil {
+				log.Printf("payouts: %v", err)
+				continue
+			}
+			for _, w := range ws {
+				if err := s.payOut(ctx, w); err != nil && !errors.Is(err, ErrWithdrawalState) {
+					log.Printf("payouts: withdrawal %s: %v", w.ID, err)
+				}
+			}
+		}
+	}
+}
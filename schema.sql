@@ -1,13 +1,155 @@
 -- ORDERS
-create table if not exists orders (
+create table if not exists subscriptions (
   id uuid primary key,
   user_id text not null,
+  items jsonb not null default '[]', -- template lines, empty for a plain amount
   amount bigint not null check (amount > 0),
+  currency char(3) not null,
+  description text not null default '',
+  category text not null default '',
+  address_id uuid null,
+  schedule text not null, -- cron (UTC) or @every <duration>
+  status text not null check (status in ('ACTIVE', 'PAUSED', 'CANCELLED')),
+  failures int not null default 0, -- failed payments in a row
+  max_failures int not null default 3,
+  next_run_at timestamptz not null,
+  last_order_id uuid null,
+  paused_reason text not null default '',
+  created_at timestamptz not null default now()
+);
+
+create index if not exists subscriptions_due_idx on subscriptions (next_run_at) where status = 'ACTIVE';
+create index if not exists subscriptions_user_idx on subscriptions (user_id, created_at desc);
+
+create table if not exists orders (
+  id uuid primary key,
+  user_id text not null,
+  amount bigint not null check (amount > 0), -- minor units of currency
+  currency char(3) not null default 'RUB',
   description text not null check (char_length(description) <= 200),
   status text not null,
+  idempotency_key text null,
+  refunded_amount bigint not null default 0,
+  original_amount bigint null, -- amount before discount
+  discount bigint not null default 0,
+  promo_code text null,
+  shipping_cost bigint not null default 0,
+  shipping_address jsonb null, -- copy of the address at order time
+  tax_amount bigint null, -- included in amount; null for orders before tax
+  tax jsonb null, -- net/tax/gross per line
+  subscription_id uuid null references subscriptions(id), -- set when placed by a subscription
+  cancellation_reason text null, -- code from payments.result when the payment failed
+  cancellation_message text null,
+  payment_method text not null default 'balance', -- balance or card
+  payers jsonb null, -- [{user_id, share, amount}] of a split order
+  created_at timestamptz not null default now()
+);
+
+create unique index if not exists orders_user_idempotency_idx on orders (user_id, idempotency_key) where idempotency_key is not null;
+
+-- keyset pagination for /list: (user_id, created_at, id)
+create index if not exists orders_user_created_idx on orders (user_id, created_at desc, id desc);
+
+-- back-office search by status, e.g. orders stuck in NEW
+create index if not exists orders_status_created_idx on orders (status, created_at desc);
+
+create table if not exists carts (
+  user_id text primary key,
+  currency char(3) not null,
+  updated_at timestamptz not null default now()
+);
+
+create index if not exists carts_updated_idx on carts (updated_at);
+
+create table if not exists cart_items (
+  user_id text not null references carts(user_id) on delete cascade,
+  sku text not null,
+  name text not null default '',
+  unit_price bigint not null check (unit_price > 0),
+  quantity int not null check (quantity > 0),
+  category text not null default '',
+  added_at timestamptz not null default now(),
+  primary key (user_id, sku)
+);
+
+create table if not exists promotions (
+  code text primary key,
+  kind text not null check (kind in ('PERCENT', 'FIXED')),
+  percent int not null default 0 check (percent between 0 and 99),
+  amount_off bigint not null default 0 check (amount_off >= 0),
+  currency char(3) null, -- set when amount_off or min_order is
+  min_order bigint not null default 0,
+  per_user_limit int not null default 0, -- 0 = unlimited
+  total_limit int not null default 0,
+  first_order_only boolean not null default false,
+  starts_at timestamptz null,
+  expires_at timestamptz null,
+  active boolean not null default true,
   created_at timestamptz not null default now()
 );
 
+-- a redemption counts against the limits until the order is cancelled
+create table if not exists promo_redemptions (
+  order_id uuid primary key references orders(id),
+  code text not null references promotions(code),
+  user_id text not null,
+  discount bigint not null,
+  created_at timestamptz not null default now(),
+  released_at timestamptz null
+);
+
+create index if not exists promo_redemptions_code_user_idx on promo_redemptions (code, user_id) where released_at is null;
+
+create table if not exists addresses (
+  id uuid primary key,
+  user_id text not null,
+  recipient text not null,
+  line1 text not null,
+  line2 text not null default '',
+  city text not null,
+  region text not null default '',
+  postal_code text not null,
+  country char(2) not null,
+  phone text not null default '',
+  is_default boolean not null default false,
+  created_at timestamptz not null default now(),
+  deleted_at timestamptz null
+);
+
+create index if not exists addresses_user_idx on addresses (user_id) where deleted_at is null;
+
+-- warehouse progress of paid orders that ship somewhere
+create table if not exists fulfilments (
+  order_id uuid primary key references orders(id),
+  status text not null check (status in ('PENDING', 'PICKING', 'SHIPPED', 'DELIVERED')),
+  carrier text not null default '',
+  tracking_number text not null default '',
+  created_at timestamptz not null default now(),
+  picked_at timestamptz null,
+  shipped_at timestamptz null,
+  delivered_at timestamptz null
+);
+
+create index if not exists fulfilments_status_idx on fulfilments (status, created_at);
+
+-- one row per year; its lock makes invoice numbers gapless
+create table if not exists invoice_counters (
+  year int primary key,
+  last int not null
+);
+
+create table if not exists invoices (
+  number text primary key, -- INV-<year>-<seq>
+  order_id uuid not null unique references orders(id),
+  year int not null,
+  seq int not null,
+  issued_at timestamptz not null,
+  data jsonb not null,
+  html text not null,
+  pdf bytea not null,
+  unique (year, seq)
+);
+
 create table if not exists orders_outbox (
   id bigserial primary key,
   message_id uuid not null unique,
@@ -15,28 +157,272 @@ create table if not exists orders_outbox (
   key text not null,
   payload jsonb not null,
   created_at timestamptz not null default now(),
-  published_at timestamptz null
+  published_at timestamptz null,
+  -- set once the row's webhook deliveries are created
+  fanned_out_at timestamptz null
 );
 
+create index if not exists orders_outbox_pending_idx on orders_outbox (id) where published_at is null;
+create index if not exists orders_outbox_fanout_idx on orders_outbox (id) where fanned_out_at is null;
+
+create table if not exists webhook_endpoints (
+  id uuid primary key,
+  url text not null,
+  secret text not null,
+  event_types text[] not null default '{}',
+  active boolean not null default true,
+  created_at timestamptz not null default now()
+);
+
+create table if not exists webhook_deliveries (
+  id bigserial primary key,
+  endpoint_id uuid not null references webhook_endpoints(id),
+  event_id uuid not null,
+  event_type text not null,
+  payload jsonb not null,
+  status text not null default 'PENDING',
+  attempts int not null default 0,
+  next_attempt_at timestamptz not null default now(),
+  response_code int null,
+  last_error text null,
+  created_at timestamptz not null default now(),
+  delivered_at timestamptz null,
+  unique (endpoint_id, event_id)
+);
+
+create index if not exists webhook_deliveries_due_idx on webhook_deliveries (next_attempt_at) where status = 'PENDING';
+
 -- PAYMENTS
+-- one wallet per (user, currency); balances are in minor units
 create table if not exists accounts (
-  user_id text primary key,
-  balance bigint not null check (balance >= 0)
+  user_id text not null,
+  currency char(3) not null default 'RUB',
+  balance bigint not null check (balance >= 0),
+  -- spending limits in minor units, 0 = no limit
+  daily_limit bigint not null default 0 check (daily_limit >= 0),
+  weekly_limit bigint not null default 0 check (weekly_limit >= 0),
+  monthly_limit bigint not null default 0 check (monthly_limit >= 0),
+  max_payment bigint not null default 0 check (max_payment >= 0),
+  frozen boolean not null default false,
+  blocked boolean not null default false,
+  blocked_reason text not null default '' check (char_length(blocked_reason) <= 200),
+  primary key (user_id, currency)
 );
 
+-- Promotional credit of a wallet. It is spent before the cash balance,
+-- earliest expiry first, and whatever remains at expires_at is expired.
+create table if not exists bonus_buckets (
+  id uuid primary key,
+  user_id text not null,
+  currency char(3) not null,
+  amount bigint not null check (amount > 0), -- granted
+  remaining bigint not null check (remaining >= 0),
+  source text not null check (char_length(source) between 1 and 100), -- campaign
+  expires_at timestamptz not null,
+  idempotency_key text null,
+  created_at timestamptz not null default now(),
+  foreign key (user_id, currency) references accounts(user_id, currency)
+);
+
+create index if not exists bonus_buckets_wallet_idx on bonus_buckets (user_id, currency, expires_at) where remaining > 0;
+create index if not exists bonus_buckets_expiry_idx on bonus_buckets (expires_at) where remaining > 0;
+create unique index if not exists bonus_buckets_user_idempotency_idx on bonus_buckets (user_id, idempotency_key) where idempotency_key is not null;
+
+-- Every movement of a bonus bucket: GRANT and REFUND add, SPEND and EXPIRE
+-- take (negative amount). SPEND and REFUND name the order.
+create table if not exists bonus_ledger (
+  id bigserial primary key,
+  bucket_id uuid not null references bonus_buckets(id),
+  kind text not null,
+  amount bigint not null,
+  order_id uuid null,
+  created_at timestamptz not null default now()
+);
+
+create index if not exists bonus_ledger_bucket_idx on bonus_ledger (bucket_id);
+create index if not exists bonus_ledger_order_idx on bonus_ledger (order_id) where order_id is not null;
+
+create table if not exists topups (
+  id bigserial primary key,
+  user_id text not null,
+  amount bigint not null check (amount > 0),
+  currency char(3) not null default 'RUB',
+  idempotency_key text null,
+  provider text not null default '', -- card gateway of a top-up by card
+  provider_tx_id text null,
+  created_at timestamptz not null default now()
+);
+
+create unique index if not exists topups_user_idempotency_idx on topups (user_id, idempotency_key) where idempotency_key is not null;
+
 create table if not exists payments_inbox (
   message_id uuid primary key,
   received_at timestamptz not null default now()
 );
 
+create index if not exists payments_inbox_received_idx on payments_inbox (received_at);
+
 create table if not exists payments (
   order_id uuid primary key,
   user_id text not null,
   amount bigint not null check (amount > 0),
+  currency char(3) not null default 'RUB',
   status text not null,
+  failure_reason text not null default '',
+  failure_message text not null default '',
+  review_reason text not null default '', -- fraud rule that held the payment for review
+  reviewed_by text not null default '', -- operator who approved or rejected it on review
+  provider text not null default '', -- card gateway of a payment by card, '' for the balance
+  provider_tx_id text null,
+  bonus_amount bigint not null default 0, -- part of amount paid from bonus buckets
+  split boolean not null default false, -- paid by the payers in payment_shares, not user_id
+  created_at timestamptz not null default now()
+);
+
+-- One payer's part of a split payment. The share is taken off the payer's
+-- balance as a hold (HELD) and CAPTURED once every payer's share is held;
+-- if any payer fails, the holds are RELEASED back to the balances.
+create table if not exists payment_shares (
+  order_id uuid not null references payments(order_id),
+  user_id text not null,
+  amount bigint not null check (amount > 0),
+  currency char(3) not null,
+  status text not null, -- HELD, CAPTURED, RELEASED
+  refunded bigint not null default 0,
+  created_at timestamptz not null default now(),
+  released_at timestamptz null,
+  primary key (order_id, user_id)
+);
+
+create index if not exists payment_shares_user_created_idx on payment_shares (user_id, created_at);
+
+-- Money taken from a card to top up a wallet (TOPUP) or pay an order (PAYMENT).
+create table if not exists card_charges (
+  id uuid primary key,
+  kind text not null,
+  user_id text not null,
+  order_id uuid null references payments(order_id),
+  amount bigint not null check (amount > 0),
+  currency char(3) not null,
+  status text not null, -- PENDING, CHALLENGE, SUCCEEDED, FAILED
+  provider text not null,
+  provider_tx_id text null,
+  challenge_url text not null default '',
+  failure_code text not null default '',
+  idempotency_key text null,
+  created_at timestamptz not null default now(),
+  updated_at timestamptz not null default now()
+);
+
+create unique index if not exists card_charges_order_idx on card_charges (order_id) where order_id is not null;
+create unique index if not exists card_charges_user_idempotency_idx on card_charges (user_id, idempotency_key) where idempotency_key is not null;
+
+create table if not exists refunds (
+  id uuid primary key,
+  order_id uuid not null references payments(order_id),
+  seq int not null, -- number of the refund within the order; with order_id, the card gateway's reference
+  status text not null default 'SUCCEEDED', -- PENDING while the card gateway has not paid it back, FAILED if support gave it up
+  user_id text not null,
+  amount bigint not null check (amount > 0),
+  reason text not null check (char_length(reason) <= 200),
+  idempotency_key text null,
+  provider_tx_id text null, -- refund id at the card gateway for payments by card
+  bonus_amount bigint not null default 0, -- part of amount returned to bonus buckets
   created_at timestamptz not null default now()
 );
 
+create index if not exists refunds_order_idx on refunds (order_id);
+create unique index if not exists refunds_order_seq_idx on refunds (order_id, seq);
+create unique index if not exists refunds_order_idempotency_idx on refunds (order_id, idempotency_key) where idempotency_key is not null;
+
+-- How a refund of a split payment went back to the payers.
+create table if not exists share_refunds (
+  refund_id uuid not null references refunds(id),
+  user_id text not null,
+  amount bigint not null check (amount > 0),
+  currency char(3) not null,
+  created_at timestamptz not null default now(),
+  primary key (refund_id, user_id)
+);
+
+create index if not exists share_refunds_user_created_idx on share_refunds (user_id, created_at);
+
+create table if not exists exchanges (
+  id uuid primary key,
+  user_id text not null,
+  from_amount bigint not null check (from_amount > 0),
+  from_currency char(3) not null,
+  to_amount bigint not null check (to_amount > 0),
+  to_currency char(3) not null,
+  rate numeric not null,
+  idempotency_key text null,
+  created_at timestamptz not null default now()
+);
+
+create unique index if not exists exchanges_user_idempotency_idx on exchanges (user_id, idempotency_key) where idempotency_key is not null;
+
+-- Money taken out of a wallet: REQUESTED -> APPROVED -> PAID_OUT, or
+-- REJECTED, which gives it back. The amount leaves the balance on request.
+create table if not exists withdrawals (
+  id uuid primary key,
+  user_id text not null,
+  amount bigint not null check (amount > 0),
+  currency char(3) not null,
+  destination text not null check (char_length(destination) between 1 and 64),
+  status text not null,
+  reason text not null default '' check (char_length(reason) <= 200),
+  provider text not null default '',
+  payout_id text null,
+  idempotency_key text null,
+  created_at timestamptz not null default now(),
+  updated_at timestamptz not null default now(),
+  returned_at timestamptz null, -- when a rejection gave the money back
+  decided_by text not null default '', -- operator who approved or rejected it
+  foreign key (user_id, currency) references accounts(user_id, currency)
+);
+
+create unique index if not exists withdrawals_user_idempotency_idx on withdrawals (user_id, idempotency_key) where idempotency_key is not null;
+create index if not exists withdrawals_status_idx on withdrawals (status, created_at) where status in ('REQUESTED', 'APPROVED');
+
+-- Manual corrections of a wallet balance made from the back office; amount
+-- is signed, debits negative.
+create table if not exists balance_adjustments (
+  id uuid primary key,
+  user_id text not null,
+  currency char(3) not null,
+  amount bigint not null check (amount <> 0),
+  reason text not null check (char_length(reason) between 1 and 200),
+  actor text not null,
+  idempotency_key text null,
+  created_at timestamptz not null default now(),
+  foreign key (user_id, currency) references accounts(user_id, currency)
+);
+
+create unique index if not exists balance_adjustments_user_idempotency_idx on balance_adjustments (user_id, idempotency_key) where idempotency_key is not null;
+create index if not exists balance_adjustments_user_created_idx on balance_adjustments (user_id, created_at);
+
+-- statements read a wallet's movements by user and time
+create index if not exists topups_user_created_idx on topups (user_id, created_at);
+create index if not exists payments_user_created_idx on payments (user_id, created_at);
+create index if not exists payments_pending_review_idx on payments (created_at) where status = 'PENDING_REVIEW';
+create index if not exists refunds_user_created_idx on refunds (user_id, created_at);
+create index if not exists exchanges_user_created_idx on exchanges (user_id, created_at);
+create index if not exists withdrawals_user_created_idx on withdrawals (user_id, created_at);
+
+-- one materialized statement per wallet and closed calendar month (UTC);
+-- later statements open from the latest one instead of summing all history
+create table if not exists statement_snapshots (
+  user_id text not null,
+  currency char(3) not null,
+  period timestamptz not null,
+  period_end timestamptz not null,
+  opening bigint not null,
+  closing bigint not null,
+  statement jsonb not null,
+  created_at timestamptz not null default now(),
+  primary key (user_id, currency, period)
+);
+
 create table if not exists payments_outbox (
   id bigserial primary key,
   message_id uuid not null unique,
@@ -46,3 +432,67 @@ create table if not exists payments_outbox (
   created_at timestamptz not null default now(),
   published_at timestamptz null
 );
+
+create index if not exists payments_outbox_pending_idx on payments_outbox (id) where published_at is null;
+
+-- AUDIT
+-- Append-only log of every change in each service, written in the change's
+-- transaction. hash is the SHA-256 of the entry's fields and prev_hash, the
+-- hash of the entry of the same target before, so editing history breaks
+-- that target's chain (checked by /auditverify). before/after are json, not
+-- jsonb, to keep the text hashed. Back-office changes carry the operator's
+-- reason.
+create sequence if not exists orders_audit_log_seq;
+
+create table if not exists orders_audit_log (
+  seq bigint primary key, -- from orders_audit_log_seq; may have gaps
+  created_at timestamptz not null,
+  actor text not null, -- api, admin:<name>, grpc, kafka:<topic>, job:<name>, system
+  action text not null,
+  target text not null, -- <kind>:<id>, e.g. order:<uuid>
+  reason text not null default '', -- why, for back-office changes
+  before json,
+  after json,
+  request_id text not null default '',
+  source_ip text not null default '',
+  prev_hash text not null,
+  hash text not null unique
+);
+
+create index if not exists orders_audit_log_target_idx on orders_audit_log (target, seq);
+create index if not exists orders_audit_log_actor_idx on orders_audit_log (actor, seq);
+
+create sequence if not exists payments_audit_log_seq;
+
+create table if not exists payments_audit_log (
+  seq bigint primary key, -- from payments_audit_log_seq; may have gaps
+  created_at timestamptz not null,
+  actor text not null,
+  action text not null,
+  target text not null, -- <kind>:<id>, e.g. account:<user_id>
+  reason text not null default '', -- why, for back-office changes
+  before json,
+  after json,
+  request_id text not null default '',
+  source_ip text not null default '',
+  prev_hash text not null,
+  hash text not null unique
+);
+
+create index if not exists payments_audit_log_target_idx on payments_audit_log (target, seq);
+create index if not exists payments_audit_log_actor_idx on payments_audit_log (actor, seq);
+
+create or replace function audit_log_append_only() returns trigger
+language plpgsql as $$
+begin
+  raise exception '% is append-only', tg_table_name;
+end;
+$$;
+
+create or replace trigger orders_audit_log_append_only
+  before update or delete or truncate on orders_audit_log
+  for each statement execute function audit_log_append_only();
+
+create or replace trigger payments_audit_log_append_only
+  before update or delete or truncate on payments_audit_log
+  for each statement execute function audit_log_append_only();